@@ -0,0 +1,113 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// Without wimgapi.dll, wimctl reads WIM files with the pure-Go wim package.
+
+func readImages(wimPath string) ([]wimgapi.ImageInfo, error) {
+	f, err := wim.Open(wimPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Images(), nil
+}
+
+func readSummary(wimPath string) (wimSummary, error) {
+	f, err := wim.Open(wimPath)
+	if err != nil {
+		return wimSummary{}, err
+	}
+	defer f.Close()
+
+	info := f.Info()
+	return wimSummary{
+		Path:        wimPath,
+		GUID:        info.GUID.String(),
+		Compression: info.Compression.String(),
+		ImageCount:  info.ImageCount,
+		BootIndex:   info.BootIndex,
+		PartNumber:  int(info.PartNumber),
+		TotalParts:  int(info.TotalParts),
+		Images:      f.Images(),
+	}, nil
+}
+
+// applyImage uses the pure-Go extractor, reporting its progress as
+// WIMGAPI messages.
+func applyImage(wimPath string, index int, target string, opts wim.ApplyOptions, progress wimgapi.ProgressFunc) ([]wim.ApplyLoss, error) {
+	f, err := wim.Open(wimPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := f.Image(index)
+	if err != nil {
+		return nil, err
+	}
+	opts.Progress = func(done, total int) error {
+		if done == 1 {
+			progress(wimgapi.ProgressEvent{MessageID: wimgapi.WIMMessageSetRange, LParam: uintptr(total)})
+		}
+		if progress(wimgapi.ProgressEvent{MessageID: wimgapi.WIMMessageSetPos, WParam: uintptr(done)}) {
+			return errCancelled
+		}
+		return nil
+	}
+	losses, err := img.Apply(target, opts)
+	if err != nil {
+		return losses, err
+	}
+	progress(wimgapi.ProgressEvent{MessageID: wimgapi.WIMMessageDone})
+	return losses, nil
+}
+
+// captureImage uses the pure-Go writer, reporting the entries it has
+// captured as WIMGAPI messages. The total is not known until the end.
+func captureImage(sourceDir, wimPath string, cfg *wimgapi.CaptureConfig, wopts wim.WriterOptions, progress wimgapi.ProgressFunc) error {
+	if _, err := os.Stat(sourceDir); err != nil {
+		return err
+	}
+	report := wopts.Progress
+	wopts.Progress = func(p wim.WriterProgress) error {
+		if report != nil {
+			if err := report(p); err != nil {
+				return err
+			}
+		}
+		if progress(wimgapi.ProgressEvent{MessageID: wimgapi.WIMMessageSetPos, WParam: uintptr(p.Files)}) {
+			return errCancelled
+		}
+		return nil
+	}
+	w, err := wim.Create(wimPath, wopts)
+	if err != nil {
+		return err
+	}
+	// WIMGAPI fixes links on capture unless told not to.
+	err = w.AddImage(wim.NewDirSource(sourceDir), wim.ImageOptions{Config: cfg, RPFix: true})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(wimPath)
+		return err
+	}
+	progress(wimgapi.ProgressEvent{MessageID: wimgapi.WIMMessageDone})
+	return nil
+}
+
+// platformExitCode has nothing to add: errors from the wim package are
+// mapped by exitCode on every platform.
+func platformExitCode(err error) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,136 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+	"golang.org/x/sys/windows"
+)
+
+// progressBuffer keeps a slow terminal from stalling WIMGAPI worker threads.
+const progressBuffer = 256
+
+func readImages(wimPath string) ([]wimgapi.ImageInfo, error) {
+	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Images()
+}
+
+func readSummary(wimPath string) (wimSummary, error) {
+	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{})
+	if err != nil {
+		return wimSummary{}, err
+	}
+	defer f.Close()
+
+	attrs, err := f.Attributes()
+	if err != nil {
+		return wimSummary{}, err
+	}
+	images, err := f.Images()
+	if err != nil {
+		return wimSummary{}, err
+	}
+	return wimSummary{
+		Path:        attrs.Path,
+		GUID:        attrs.GUID.String(),
+		Compression: attrs.CompressionType.String(),
+		ImageCount:  attrs.ImageCount,
+		BootIndex:   attrs.BootIndex,
+		PartNumber:  int(attrs.PartNumber),
+		TotalParts:  int(attrs.TotalParts),
+		Images:      images,
+	}, nil
+}
+
+// applyImage uses WIMGAPI, which applies attributes, security descriptors
+// and alternate data streams natively.
+func applyImage(wimPath string, index int, target string, opts wim.ApplyOptions, progress wimgapi.ProgressFunc) ([]wim.ApplyLoss, error) {
+	if opts.Attributes || opts.Security || opts.Streams {
+		return nil, fmt.Errorf("apply --attributes, --security and --streams: %w", errUnsupported)
+	}
+	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := f.LoadImage(index)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	var flags wimgapi.ApplyFlags
+	if opts.NoRPFix {
+		flags |= wimgapi.ApplyNoRPFix
+	}
+	return nil, img.Apply(target, wimgapi.ApplyOptions{
+		Flags:          flags,
+		Progress:       progress,
+		ProgressBuffer: progressBuffer,
+	})
+}
+
+// captureImage uses WIMGAPI, which always picks its own chunk size and
+// compression level and cannot write solid resources.
+func captureImage(sourceDir, wimPath string, cfg *wimgapi.CaptureConfig, wopts wim.WriterOptions, progress wimgapi.ProgressFunc) error {
+	if wopts.ChunkSize != 0 {
+		return fmt.Errorf("capture --chunk-size: %w", errUnsupported)
+	}
+	if wopts.Level != 0 {
+		return fmt.Errorf("capture --level: %w", errUnsupported)
+	}
+	if wopts.Solid {
+		return fmt.Errorf("capture --solid: %w", errUnsupported)
+	}
+	if wopts.Concurrency != 0 || wopts.MemoryLimit != 0 {
+		return fmt.Errorf("capture --concurrency and --memory-limit: %w", errUnsupported)
+	}
+	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{
+		DesiredAccess:       windows.GENERIC_READ | windows.GENERIC_WRITE,
+		CreationDisposition: wimgapi.WIMCreateAlways,
+		CompressionType:     wopts.Compression,
+	})
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	img, err := f.Capture(sourceDir, wimgapi.CaptureOptions{
+		Config:         cfg,
+		Progress:       progress,
+		ProgressBuffer: progressBuffer,
+	})
+	if err != nil {
+		return err
+	}
+	return img.Close()
+}
+
+func platformExitCode(err error) (int, bool) {
+	var werr *wimgapi.Error
+	if !errors.As(err, &werr) {
+		return 0, false
+	}
+	switch windows.Errno(werr.Code) {
+	case windows.ERROR_FILE_NOT_FOUND, windows.ERROR_PATH_NOT_FOUND:
+		return exitNotFound, true
+	case windows.ERROR_ACCESS_DENIED, windows.ERROR_PRIVILEGE_NOT_HELD:
+		return exitAccessDenied, true
+	case windows.ERROR_CANCELLED, windows.ERROR_REQUEST_ABORTED:
+		return exitCancelled, true
+	case windows.ERROR_BAD_FORMAT, windows.ERROR_INVALID_DATA, windows.ERROR_FILE_CORRUPT:
+		return exitInvalidImage, true
+	default:
+		return exitError, true
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+)
+
+// dir and cat read image metadata with the pure-Go wim package on every
+// platform; WIMGAPI has no API for browsing an image without mounting it.
+
+type dentryJSON struct {
+	Path           string       `json:"path"`
+	Size           uint64       `json:"size"`
+	Attributes     uint32       `json:"attributes"`
+	Mode           string       `json:"mode"`
+	CreationTime   time.Time    `json:"creationTime,omitzero"`
+	LastAccessTime time.Time    `json:"lastAccessTime,omitzero"`
+	LastWriteTime  time.Time    `json:"lastWriteTime,omitzero"`
+	SHA1           string       `json:"sha1,omitempty"`
+	ReparseTag     uint32       `json:"reparseTag,omitempty"`
+	Security       string       `json:"security,omitempty"` // SDDL
+	Streams        []streamJSON `json:"streams,omitempty"`
+}
+
+type streamJSON struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	SHA1 string `json:"sha1,omitempty"`
+}
+
+func newDentryJSON(img *wim.Image, d *wim.Dentry) dentryJSON {
+	out := dentryJSON{
+		Path:           d.Path(),
+		Size:           d.Size(),
+		Attributes:     d.Attributes,
+		Mode:           wim.FormatAttributes(d.Attributes),
+		CreationTime:   d.CreationTime,
+		LastAccessTime: d.LastAccessTime,
+		LastWriteTime:  d.LastWriteTime,
+		SHA1:           hashString(d.Hash()),
+		ReparseTag:     d.ReparseTag,
+	}
+	if sd, err := img.Security(d); err == nil && sd != nil {
+		out.Security = sd.SDDL()
+	}
+	for _, s := range d.Streams {
+		out.Streams = append(out.Streams, streamJSON{Name: s.Name, Size: s.Size, SHA1: hashString(s.Hash)})
+	}
+	return out
+}
+
+func hashString(h wim.Hash) string {
+	if h.IsZero() {
+		return ""
+	}
+	return h.String()
+}
+
+// openImage opens the WIM at wimPath and parses the metadata of image index.
+// The caller closes the returned file.
+func openImage(wimPath, index string) (*wim.File, *wim.Image, error) {
+	idx, err := parseIndex(index)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := wim.Open(wimPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := f.Image(idx)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, img, nil
+}
+
+func runDir(args []string) error {
+	flags := flag.NewFlagSet("dir", flag.ContinueOnError)
+	recursive := flags.Bool("recursive", false, "")
+	long := flags.Bool("long", false, "")
+	asJSON := flags.Bool("json", false, "")
+	pos, err := parseArgs(flags, args, 2, 3)
+	if err != nil {
+		return err
+	}
+	f, img, err := openImage(pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	base := img.Root()
+	if len(pos) == 3 {
+		if base, err = img.Lookup(pos[2]); err != nil {
+			return err
+		}
+	}
+
+	var entries []*wim.Dentry
+	var collect func(d *wim.Dentry)
+	collect = func(d *wim.Dentry) {
+		for _, c := range d.Children {
+			entries = append(entries, c)
+			if *recursive {
+				collect(c)
+			}
+		}
+	}
+	if base.IsDir() {
+		collect(base)
+	} else {
+		entries = append(entries, base)
+	}
+
+	if *asJSON {
+		out := make([]dentryJSON, 0, len(entries))
+		for _, d := range entries {
+			out = append(out, newDentryJSON(img, d))
+		}
+		return writeJSON(out)
+	}
+	if !*long {
+		for _, d := range entries {
+			fmt.Println(d.Path())
+		}
+		return nil
+	}
+	for _, d := range entries {
+		sha := hashString(d.Hash())
+		if sha == "" {
+			sha = "-"
+		}
+		fmt.Printf("%s %14d %-20s %-40s %s\n", wim.FormatAttributes(d.Attributes), d.Size(), formatTime(d.LastWriteTime), sha, d.Path())
+		for _, s := range d.Streams {
+			fmt.Printf("%6s %14d %-20s %-40s %s:%s\n", "", s.Size, "", hashString(s.Hash), d.Path(), s.Name)
+		}
+	}
+	return nil
+}
+
+func runCat(args []string) error {
+	flags := flag.NewFlagSet("cat", flag.ContinueOnError)
+	stream := flags.String("stream", "", "")
+	offset := flags.Int64("offset", 0, "")
+	length := flags.Int64("length", -1, "")
+	pos, err := parseArgs(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	if *offset < 0 {
+		return fmt.Errorf("cat: invalid --offset %d", *offset)
+	}
+	f, img, err := openImage(pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := img.Lookup(pos[2])
+	if err != nil {
+		return err
+	}
+	if d.IsDir() && *stream == "" {
+		return fmt.Errorf("cat: %s is a directory", pos[2])
+	}
+	r, err := img.OpenStream(d, *stream)
+	if err != nil {
+		return err
+	}
+	if *length >= 0 {
+		_, err = io.Copy(os.Stdout, io.NewSectionReader(r, *offset, *length))
+		return err
+	}
+	if _, err := r.Seek(*offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(os.Stdout, r)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+)
+
+type diffJSON struct {
+	Old          string       `json:"old"`
+	New          string       `json:"new"`
+	Added        int          `json:"added"`
+	Removed      int          `json:"removed"`
+	Modified     int          `json:"modified"`
+	BytesAdded   uint64       `json:"bytesAdded"`
+	BytesRemoved uint64       `json:"bytesRemoved"`
+	Changes      []changeJSON `json:"changes"`
+}
+
+type changeJSON struct {
+	Path      string             `json:"path"`
+	Kind      string             `json:"kind"`
+	Changed   string             `json:"changed,omitempty"`
+	SizeDelta int64              `json:"sizeDelta"`
+	Old       *dentryJSON        `json:"old,omitempty"`
+	New       *dentryJSON        `json:"new,omitempty"`
+	Streams   []streamChangeJSON `json:"streams,omitempty"`
+}
+
+type streamChangeJSON struct {
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	SizeDelta int64  `json:"sizeDelta"`
+}
+
+func newDiffJSON(oldName, newName string, a, b *wim.Image, res *wim.DiffResult) diffJSON {
+	out := diffJSON{
+		Old:          oldName,
+		New:          newName,
+		Added:        res.Added,
+		Removed:      res.Removed,
+		Modified:     res.Modified,
+		BytesAdded:   res.BytesAdded,
+		BytesRemoved: res.BytesRemoved,
+		Changes:      make([]changeJSON, 0, len(res.Changes)),
+	}
+	for _, c := range res.Changes {
+		cj := changeJSON{Path: c.Path, Kind: c.Kind.String(), Changed: wim.FormatChanges(c.What), SizeDelta: c.SizeDelta}
+		if c.Old != nil {
+			d := newDentryJSON(a, c.Old)
+			cj.Old = &d
+		}
+		if c.New != nil {
+			d := newDentryJSON(b, c.New)
+			cj.New = &d
+		}
+		for _, s := range c.Streams {
+			cj.Streams = append(cj.Streams, streamChangeJSON{Name: s.Name, Kind: s.Kind.String(), SizeDelta: s.SizeDelta})
+		}
+		out.Changes = append(out.Changes, cj)
+	}
+	return out
+}
+
+// runDiff compares two images: `diff <wim> <index-a> <index-b>` within one
+// WIM, or `diff <wim-a> <index-a> <wim-b> <index-b>` across two.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := flags.String("format", "text", "")
+	var opts wim.DiffOptions
+	flags.BoolVar(&opts.IgnoreTimestamps, "ignore-times", false, "")
+	flags.BoolVar(&opts.IgnoreAttributes, "ignore-attributes", false, "")
+	flags.BoolVar(&opts.IgnoreSecurity, "ignore-security", false, "")
+	flags.BoolVar(&opts.IgnoreStreams, "ignore-streams", false, "")
+	pos, err := parseArgs(flags, args, 3, 4)
+	if err != nil {
+		return err
+	}
+	if len(pos) == 3 {
+		pos = []string{pos[0], pos[1], pos[0], pos[2]}
+	}
+	switch *format {
+	case "text", "json", "unified":
+	default:
+		return usageError{"diff: --format must be text, json or unified"}
+	}
+
+	fa, a, err := openImage(pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	defer fa.Close()
+	fb, b, err := openImage(pos[2], pos[3])
+	if err != nil {
+		return err
+	}
+	defer fb.Close()
+
+	res := wim.Diff(a, b, opts)
+	oldName, newName := pos[0]+"#"+pos[1], pos[2]+"#"+pos[3]
+	switch *format {
+	case "json":
+		return writeJSON(newDiffJSON(oldName, newName, a, b, res))
+	case "unified":
+		writeUnifiedDiff(os.Stdout, oldName, newName, res)
+	default:
+		writeTextDiff(os.Stdout, res)
+	}
+	return nil
+}
+
+func writeTextDiff(w io.Writer, res *wim.DiffResult) {
+	for _, c := range res.Changes {
+		switch c.Kind {
+		case wim.Added:
+			fmt.Fprintf(w, "A  %s  %+d\n", c.Path, c.SizeDelta)
+		case wim.Removed:
+			fmt.Fprintf(w, "D  %s  %+d\n", c.Path, c.SizeDelta)
+		case wim.Modified:
+			fmt.Fprintf(w, "M  %s  %+d  (%s)\n", c.Path, c.SizeDelta, wim.FormatChanges(c.What))
+		}
+		for _, s := range c.Streams {
+			fmt.Fprintf(w, "%c  %s:%s  %+d\n", kindLetter(s.Kind, 'A', 'D', 'M'), c.Path, s.Name, s.SizeDelta)
+		}
+	}
+}
+
+// kindLetter returns the letter that marks a change of the given kind.
+func kindLetter(k wim.ChangeKind, added, removed, modified byte) byte {
+	switch k {
+	case wim.Added:
+		return added
+	case wim.Removed:
+		return removed
+	default:
+		return modified
+	}
+}
+
+// writeUnifiedDiff prints changes under ---/+++ headers with a one-line
+// summary, in the spirit of `diff -u --stat`.
+func writeUnifiedDiff(w io.Writer, oldName, newName string, res *wim.DiffResult) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	for _, c := range res.Changes {
+		switch c.Kind {
+		case wim.Added:
+			fmt.Fprintf(w, "+%s\n", c.Path)
+		case wim.Removed:
+			fmt.Fprintf(w, "-%s\n", c.Path)
+		case wim.Modified:
+			fmt.Fprintf(w, "~%s (%s)\n", c.Path, wim.FormatChanges(c.What))
+		}
+		for _, s := range c.Streams {
+			fmt.Fprintf(w, "%c%s:%s\n", kindLetter(s.Kind, '+', '-', '~'), c.Path, s.Name)
+		}
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d modified, +%d -%d bytes\n",
+		res.Added, res.Removed, res.Modified, res.BytesAdded, res.BytesRemoved)
+}
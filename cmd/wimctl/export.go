@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// runExport copies one image into a new WIM with the pure-Go writer,
+// recompressing it with the chosen format and chunk size.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	wflags := addWriterFlags(flags)
+	name := flags.String("name", "", "")
+	pos, err := parseArgs(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	wopts, err := wflags.options()
+	if err != nil {
+		return err
+	}
+	f, img, err := openImage(pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info := img.Info()
+	opts := wim.ImageOptions{Name: info.Name, Description: info.Description, Flags: info.Flags}
+	if *name != "" {
+		opts.Name = *name
+	}
+	w, err := wim.Create(pos[2], wopts)
+	if err != nil {
+		return err
+	}
+	err = w.AddImage(wim.NewImageSource(img), opts)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(pos[2])
+	}
+	return err
+}
+
+// writerFlags are the pure-Go writer's flags, shared by capture and
+// export.
+type writerFlags struct {
+	compress    *string
+	chunkSize   *uint
+	level       *int
+	solid       *bool
+	concurrency *int
+	memoryLimit *uint // MiB
+}
+
+func addWriterFlags(flags *flag.FlagSet) *writerFlags {
+	return &writerFlags{
+		compress:    flags.String("compress", "none", ""),
+		chunkSize:   flags.Uint("chunk-size", 0, ""),
+		level:       flags.Int("level", 0, ""),
+		solid:       flags.Bool("solid", false, ""),
+		concurrency: flags.Int("concurrency", 0, ""),
+		memoryLimit: flags.Uint("memory-limit", 0, ""),
+	}
+}
+
+// options checks the flags and turns them into WriterOptions. Zero picks
+// the default chunk size, level, concurrency and memory limit.
+func (f *writerFlags) options() (wim.WriterOptions, error) {
+	c, err := wimgapi.ParseCompression(*f.compress)
+	if err != nil {
+		return wim.WriterOptions{}, usageError{err.Error()}
+	}
+	if level := *f.level; level < 0 || level > 9 {
+		return wim.WriterOptions{}, usageError{"level must be between 1 and 9"}
+	}
+	if *f.solid && c == wimgapi.CompressNone {
+		return wim.WriterOptions{}, usageError{"--solid needs --compress"}
+	}
+	if *f.concurrency < 0 {
+		return wim.WriterOptions{}, usageError{"concurrency must not be negative"}
+	}
+	if *f.memoryLimit > 1<<40 {
+		return wim.WriterOptions{}, usageError{"memory limit too large"}
+	}
+	opts := wim.WriterOptions{
+		Compression: c,
+		Level:       *f.level,
+		Solid:       *f.solid,
+		Concurrency: *f.concurrency,
+		MemoryLimit: int64(*f.memoryLimit) << 20,
+	}
+	chunkSize := *f.chunkSize
+	if chunkSize == 0 {
+		return opts, nil
+	}
+	if chunkSize > 1<<31 {
+		return wim.WriterOptions{}, usageError{"chunk size too large"}
+	}
+	if err := c.ValidateChunkSize(uint32(chunkSize)); err != nil {
+		return wim.WriterOptions{}, usageError{err.Error()}
+	}
+	opts.ChunkSize = uint32(chunkSize)
+	return opts, nil
+}
@@ -1,132 +1,404 @@
-//go:build windows
-
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/signal"
 	"strconv"
+	"text/tabwriter"
+	"time"
 
+	"github.com/ghp3000/go-wimgapi/wim"
 	"github.com/ghp3000/go-wimgapi/wimgapi"
-	"golang.org/x/sys/windows"
 )
 
+// Exit codes. Failures reported by WIMGAPI are mapped from *wimgapi.Error.Code.
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitUsage        = 2
+	exitNotFound     = 3
+	exitAccessDenied = 4
+	exitCancelled    = 5
+	exitUnsupported  = 6
+	exitInvalidImage = 7
+)
+
+var (
+	errUnsupported = errors.New("not supported on this platform")
+	errCancelled   = errors.New("operation cancelled")
+	// errImageNotFound is reported for an index the WIM does not contain.
+	errImageNotFound = errors.New("no such image")
+)
+
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
 func main() {
-	if len(os.Args) < 3 {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) < 1 {
 		usage()
-		os.Exit(2)
+		return exitUsage
 	}
 
-	cmd := os.Args[1]
-	switch cmd {
+	var err error
+	switch cmd, rest := args[0], args[1:]; cmd {
 	case "list":
-		if len(os.Args) != 3 {
-			usage()
-			os.Exit(2)
-		}
-		if err := runList(os.Args[2]); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
-		}
+		err = runList(rest)
+	case "info":
+		err = runInfo(rest)
 	case "apply":
-		if len(os.Args) != 5 {
-			usage()
-			os.Exit(2)
-		}
-		idx, err := strconv.Atoi(os.Args[3])
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "error: index must be an integer")
-			os.Exit(2)
-		}
-		if err := runApply(os.Args[2], idx, os.Args[4]); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
-		}
+		err = runApply(rest)
 	case "capture":
-		if len(os.Args) != 4 {
-			usage()
-			os.Exit(2)
-		}
-		if err := runCapture(os.Args[2], os.Args[3]); err != nil {
-			fmt.Fprintln(os.Stderr, "error:", err)
-			os.Exit(1)
-		}
+		err = runCapture(rest)
+	case "dir":
+		err = runDir(rest)
+	case "cat":
+		err = runCat(rest)
+	case "diff":
+		err = runDiff(rest)
+	case "export":
+		err = runExport(rest)
+	case "export-tar":
+		err = runExportTar(rest)
+	case "help", "-h", "--help":
+		usage()
+		return exitOK
 	default:
 		usage()
-		os.Exit(2)
+		return exitUsage
+	}
+	if err == nil {
+		return exitOK
+	}
+
+	fmt.Fprintln(os.Stderr, "error:", err)
+	var uerr usageError
+	if errors.As(err, &uerr) {
+		usage()
+		return exitUsage
 	}
+	return exitCode(err)
 }
 
-func runList(wimPath string) error {
-	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{})
+func exitCode(err error) int {
+	if code, ok := platformExitCode(err); ok {
+		return code
+	}
+	switch {
+	case errors.Is(err, errCancelled), errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.Is(err, errUnsupported):
+		return exitUnsupported
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, errImageNotFound), errors.Is(err, wim.ErrImageNotFound):
+		return exitNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return exitAccessDenied
+	case errors.Is(err, wim.ErrNotWIM), errors.Is(err, wim.ErrUnsupportedVersion), errors.Is(err, wim.ErrSpanned):
+		return exitInvalidImage
+	default:
+		return exitError
+	}
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments and checks the positional count.
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	var pos []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, usageError{fmt.Sprintf("%s: %v", flags.Name(), err)}
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		pos = append(pos, args[0])
+		args = args[1:]
+	}
+	if len(pos) < min || len(pos) > max {
+		return nil, usageError{fmt.Sprintf("%s: wrong number of arguments", flags.Name())}
+	}
+	return pos, nil
+}
+
+func parseIndex(s string) (int, error) {
+	idx, err := strconv.Atoi(s)
+	if err != nil || idx < 1 {
+		return 0, usageError{"index must be an integer >= 1"}
+	}
+	return idx, nil
+}
+
+func runList(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+	pos, err := parseArgs(flags, args, 1, 1)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	images, err := f.Images()
+	images, err := readImages(pos[0])
 	if err != nil {
 		return err
 	}
+	if *asJSON {
+		out := make([]imageJSON, 0, len(images))
+		for _, img := range images {
+			out = append(out, newImageJSON(img))
+		}
+		return writeJSON(out)
+	}
 	for _, img := range images {
 		fmt.Printf("#%d\t%s\t%s\t%s\t%s\n", img.Index, img.Name, img.Description, img.Flags, img.Architecture)
 	}
 	return nil
 }
 
-func runApply(wimPath string, index int, target string) error {
-	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{})
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+	pos, err := parseArgs(flags, args, 1, 2)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	index := 0
+	if len(pos) == 2 {
+		if index, err = parseIndex(pos[1]); err != nil {
+			return err
+		}
+	}
 
-	img, err := f.LoadImage(index)
+	sum, err := readSummary(pos[0])
 	if err != nil {
 		return err
 	}
-	defer img.Close()
-
-	applied := false
-	err = img.Apply(target, wimgapi.ApplyOptions{
-		Progress: func(evt wimgapi.ProgressEvent) bool {
-			if evt.MessageID == wimgapi.WIMMessageDone {
-				applied = true
+	if index > 0 {
+		var selected []wimgapi.ImageInfo
+		for _, img := range sum.Images {
+			if img.Index == index {
+				selected = append(selected, img)
 			}
-			return false
-		},
+		}
+		if len(selected) == 0 {
+			return fmt.Errorf("image %d: %w", index, errImageNotFound)
+		}
+		sum.Images = selected
+	}
+
+	if *asJSON {
+		return writeJSON(newSummaryJSON(sum))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path:\t%s\n", sum.Path)
+	fmt.Fprintf(tw, "GUID:\t%s\n", sum.GUID)
+	fmt.Fprintf(tw, "Compression:\t%s\n", sum.Compression)
+	fmt.Fprintf(tw, "Part:\t%d/%d\n", sum.PartNumber, sum.TotalParts)
+	fmt.Fprintf(tw, "Images:\t%d\n", sum.ImageCount)
+	fmt.Fprintf(tw, "Boot index:\t%d\n", sum.BootIndex)
+	for _, img := range sum.Images {
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "Index:\t%d\n", img.Index)
+		fmt.Fprintf(tw, "Name:\t%s\n", img.Name)
+		fmt.Fprintf(tw, "Description:\t%s\n", img.Description)
+		fmt.Fprintf(tw, "Flags:\t%s\n", img.Flags)
+		fmt.Fprintf(tw, "Architecture:\t%s\n", img.Architecture)
+		fmt.Fprintf(tw, "Version:\t%s\n", img.Version)
+		fmt.Fprintf(tw, "Edition:\t%s\n", img.EditionID)
+		fmt.Fprintf(tw, "Directories:\t%d\n", img.DirCount)
+		fmt.Fprintf(tw, "Files:\t%d\n", img.FileCount)
+		fmt.Fprintf(tw, "Total bytes:\t%d\n", img.TotalBytes)
+		fmt.Fprintf(tw, "Hard link bytes:\t%d\n", img.HardLinkBytes)
+		fmt.Fprintf(tw, "Created:\t%s\n", formatTime(img.CreationTime))
+		fmt.Fprintf(tw, "Modified:\t%s\n", formatTime(img.ModificationTime))
+	}
+	return tw.Flush()
+}
+
+func runApply(args []string) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+	noProgress := flags.Bool("no-progress", false, "")
+	noRPFix := flags.Bool("no-rp-fix", false, "")
+	attributes := flags.Bool("attributes", false, "")
+	security := flags.Bool("security", false, "")
+	streams := flags.Bool("streams", false, "")
+	pos, err := parseArgs(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	index, err := parseIndex(pos[1])
+	if err != nil {
+		return err
+	}
+	opts := wim.ApplyOptions{NoRPFix: *noRPFix, Attributes: *attributes, Security: *security, Streams: *streams}
+
+	res := operationJSON{Operation: "apply", WIM: pos[0], Index: index, Target: pos[2]}
+	var losses []wim.ApplyLoss
+	err = runWithProgress("apply", !*noProgress, &res, func(progress wimgapi.ProgressFunc) error {
+		losses, err = applyImage(pos[0], index, pos[2], opts, progress)
+		return err
 	})
 	if err != nil {
 		return err
 	}
-	if !applied {
+	if !res.Done {
 		return errors.New("apply finished without done callback")
 	}
+	res.Warnings += len(losses)
+	for _, l := range losses {
+		res.Losses = append(res.Losses, lossJSON{Path: l.Path, Kind: l.Kind.String(), Detail: l.Detail})
+	}
+	if *asJSON {
+		return writeJSON(res)
+	}
+	for _, l := range losses {
+		fmt.Fprintln(os.Stderr, "not applied:", l)
+	}
 	return nil
 }
 
-func runCapture(sourceDir, wimPath string) error {
-	f, err := wimgapi.Open(wimPath, wimgapi.OpenOptions{
-		DesiredAccess:       windows.GENERIC_READ | windows.GENERIC_WRITE,
-		CreationDisposition: wimgapi.WIMCreateAlways,
-	})
+func runCapture(args []string) error {
+	flags := flag.NewFlagSet("capture", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+	noProgress := flags.Bool("no-progress", false, "")
+	configPath := flags.String("config", "", "")
+	defaults := flags.Bool("default-exclusions", false, "")
+	wflags := addWriterFlags(flags)
+	pos, err := parseArgs(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	cfg, err := captureConfig(*configPath, *defaults)
+	if err != nil {
+		return err
+	}
+	wopts, err := wflags.options()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	img, err := f.Capture(sourceDir, wimgapi.CaptureOptions{})
+	res := operationJSON{Operation: "capture", Source: pos[0], WIM: pos[1]}
+	wopts.Progress = func(p wim.WriterProgress) error {
+		res.Workers = newWorkersJSON(p)
+		return nil
+	}
+	err = runWithProgress("capture", !*noProgress, &res, func(progress wimgapi.ProgressFunc) error {
+		return captureImage(pos[0], pos[1], cfg, wopts, progress)
+	})
 	if err != nil {
 		return err
 	}
-	defer img.Close()
+	if *asJSON {
+		return writeJSON(res)
+	}
 	return nil
 }
 
+// captureConfig loads the --config file, adding DISM's default exclusions
+// when asked. It returns nil when neither is given.
+func captureConfig(path string, defaults bool) (*wimgapi.CaptureConfig, error) {
+	var cfg *wimgapi.CaptureConfig
+	if defaults {
+		cfg = wimgapi.DefaultCaptureConfig()
+	}
+	if path == "" {
+		return cfg, nil
+	}
+	file, err := wimgapi.ReadCaptureConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		return file, nil
+	}
+	cfg.ExclusionList = append(cfg.ExclusionList, file.ExclusionList...)
+	cfg.ExclusionException = append(cfg.ExclusionException, file.ExclusionException...)
+	cfg.CompressionExclusionList = append(cfg.CompressionExclusionList, file.CompressionExclusionList...)
+	return cfg, nil
+}
+
+// runWithProgress runs op with a ProgressFunc that draws a progress bar,
+// collects counters into res and cancels the operation on Ctrl+C.
+func runWithProgress(label string, showBar bool, res *operationJSON, op func(wimgapi.ProgressFunc) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	bar := newProgressBar(os.Stderr, label, showBar && isTerminal(os.Stderr))
+	start := time.Now()
+	err := op(func(evt wimgapi.ProgressEvent) bool {
+		ev := bar.update(evt)
+		switch ev.MessageID {
+		case wimgapi.WIMMessageError:
+			res.Errors++
+		case wimgapi.WIMMessageWarning:
+			res.Warnings++
+		case wimgapi.WIMMessageDone:
+			res.Done = true
+		}
+		res.Files = ev.Current
+		return ctx.Err() != nil
+	})
+	bar.finish()
+	res.Seconds = time.Since(start).Seconds()
+
+	if ctx.Err() != nil {
+		return errCancelled
+	}
+	return err
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+func writeJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
 func usage() {
 	fmt.Fprintln(os.Stderr, "usage:")
-	fmt.Fprintln(os.Stderr, "  wimctl list <path-to-wim>")
-	fmt.Fprintln(os.Stderr, "  wimctl apply <path-to-wim> <index> <target-dir>")
-	fmt.Fprintln(os.Stderr, "  wimctl capture <source-dir> <path-to-wim>")
+	fmt.Fprintln(os.Stderr, "  wimctl list <path-to-wim> [--json]")
+	fmt.Fprintln(os.Stderr, "  wimctl info <path-to-wim> [index] [--json]")
+	fmt.Fprintln(os.Stderr, "  wimctl apply <path-to-wim> <index> <target-dir> [--no-rp-fix] [--attributes] [--security] [--streams] [--json] [--no-progress]")
+	fmt.Fprintln(os.Stderr, "  wimctl capture <source-dir> <path-to-wim> [--config WimScript.ini] [--default-exclusions]")
+	fmt.Fprintln(os.Stderr, "             [--compress none|xpress|lzx|lzms] [--chunk-size N] [--level 1-9] [--solid]")
+	fmt.Fprintln(os.Stderr, "             [--concurrency N] [--memory-limit MiB]")
+	fmt.Fprintln(os.Stderr, "             [--json] [--no-progress]")
+	fmt.Fprintln(os.Stderr, "  wimctl dir <path-to-wim> <index> [path] [--recursive] [--long] [--json]")
+	fmt.Fprintln(os.Stderr, "  wimctl cat <path-to-wim> <index> <path> [--stream name] [--offset n] [--length n]")
+	fmt.Fprintln(os.Stderr, "  wimctl diff <wim-a> <index-a> [wim-b] <index-b> [--format text|json|unified]")
+	fmt.Fprintln(os.Stderr, "             [--ignore-times] [--ignore-attributes] [--ignore-security] [--ignore-streams]")
+	fmt.Fprintln(os.Stderr, "  wimctl export <src-wim> <index> <dst-wim> [--compress none|xpress|lzx|lzms] [--chunk-size N]")
+	fmt.Fprintln(os.Stderr, "             [--level 1-9] [--solid] [--concurrency N] [--memory-limit MiB] [--name NAME]")
+	fmt.Fprintln(os.Stderr, "  wimctl export-tar <path-to-wim> <index> <out.tar|-> [--security] [--streams]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "exit codes: 0 ok, 1 error, 2 usage, 3 not found, 4 access denied,")
+	fmt.Fprintln(os.Stderr, "            5 cancelled, 6 unsupported, 7 invalid image")
 }
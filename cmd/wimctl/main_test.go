@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestParseArgsInterleavedFlags(t *testing.T) {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "")
+	pos, err := parseArgs(flags, []string{"x.wim", "--json", "1", "out"}, 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !*asJSON || len(pos) != 3 || pos[1] != "1" || pos[2] != "out" {
+		t.Fatalf("json=%v pos=%q", *asJSON, pos)
+	}
+
+	_, err = parseArgs(flag.NewFlagSet("list", flag.ContinueOnError), []string{"a", "b"}, 1, 1)
+	var uerr usageError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("err = %v want usageError", err)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("boom"), exitError},
+		{fmt.Errorf("open: %w", fs.ErrNotExist), exitNotFound},
+		{fmt.Errorf("image 3: %w", errImageNotFound), exitNotFound},
+		{fs.ErrPermission, exitAccessDenied},
+		{errCancelled, exitCancelled},
+		{fmt.Errorf("apply: %w", errUnsupported), exitUnsupported},
+		{fmt.Errorf("image 2: %w", wim.ErrImageNotFound), exitNotFound},
+		{wim.ErrNotWIM, exitInvalidImage},
+	}
+	for _, c := range cases {
+		if got := exitCode(c.err); got != c.want {
+			t.Errorf("exitCode(%v) = %d want %d", c.err, got, c.want)
+		}
+	}
+}
+
+func TestCaptureCancelRemovesWIM(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("capture goes through WIMGAPI on Windows")
+	}
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wimPath := filepath.Join(t.TempDir(), "out.wim")
+	cancel := func(wimgapi.ProgressEvent) bool { return true }
+	err := captureImage(src, wimPath, nil, wim.WriterOptions{Compression: wimgapi.CompressXPRESS}, cancel)
+	if !errors.Is(err, errCancelled) {
+		t.Fatalf("captureImage = %v, want %v", err, errCancelled)
+	}
+	if _, err := os.Stat(wimPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("WIM left behind: %v", err)
+	}
+}
+
+func TestRenderProgressLine(t *testing.T) {
+	got := renderProgressLine("apply", wimgapi.DecodedProgressEvent{Current: 5, Total: 10, Percent: 50})
+	want := "apply [###############...............]  50.0% 5/10"
+	if got != want {
+		t.Fatalf("line = %q want %q", got, want)
+	}
+	if got := renderProgressLine("capture", wimgapi.DecodedProgressEvent{Current: 7}); got != "capture: 7 files" {
+		t.Fatalf("line without total = %q", got)
+	}
+}
+
+func TestWriteUnifiedDiff(t *testing.T) {
+	res := &wim.DiffResult{
+		Changes: []wim.Change{
+			{Path: "a/new.txt", Kind: wim.Added, SizeDelta: 4},
+			{Path: "a/old.txt", Kind: wim.Removed, SizeDelta: -2},
+			{Path: "a/hosts", Kind: wim.Modified, What: wim.ChangedContent | wim.ChangedSecurity | wim.ChangedStreams, SizeDelta: 1,
+				Streams: []wim.StreamChange{{Name: "Zone.Identifier", Kind: wim.Added, SizeDelta: 26}}},
+		},
+		Added: 1, Removed: 1, Modified: 1, BytesAdded: 5, BytesRemoved: 2,
+	}
+	var buf strings.Builder
+	writeUnifiedDiff(&buf, "old.wim#1", "new.wim#1", res)
+	want := "--- old.wim#1\n+++ new.wim#1\n+a/new.txt\n-a/old.txt\n~a/hosts (content,security,streams)\n+a/hosts:Zone.Identifier\n" +
+		"1 added, 1 removed, 1 modified, +5 -2 bytes\n"
+	if buf.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// wimSummary is what `wimctl info` reports, independent of the backend.
+type wimSummary struct {
+	Path        string
+	GUID        string
+	Compression string
+	ImageCount  int
+	BootIndex   int
+	PartNumber  int
+	TotalParts  int
+	Images      []wimgapi.ImageInfo
+}
+
+type imageJSON struct {
+	Index              int       `json:"index"`
+	Name               string    `json:"name"`
+	Description        string    `json:"description,omitempty"`
+	Flags              string    `json:"flags,omitempty"`
+	Architecture       string    `json:"architecture,omitempty"`
+	DisplayName        string    `json:"displayName,omitempty"`
+	DisplayDescription string    `json:"displayDescription,omitempty"`
+	EditionID          string    `json:"editionId,omitempty"`
+	Version            string    `json:"version,omitempty"`
+	DirCount           uint64    `json:"dirCount"`
+	FileCount          uint64    `json:"fileCount"`
+	TotalBytes         uint64    `json:"totalBytes"`
+	HardLinkBytes      uint64    `json:"hardLinkBytes"`
+	CreationTime       time.Time `json:"creationTime,omitzero"`
+	ModificationTime   time.Time `json:"modificationTime,omitzero"`
+}
+
+func newImageJSON(img wimgapi.ImageInfo) imageJSON {
+	return imageJSON{
+		Index:              img.Index,
+		Name:               img.Name,
+		Description:        img.Description,
+		Flags:              img.Flags,
+		Architecture:       img.Architecture,
+		DisplayName:        img.DisplayName,
+		DisplayDescription: img.DisplayDescription,
+		EditionID:          img.EditionID,
+		Version:            img.Version,
+		DirCount:           img.DirCount,
+		FileCount:          img.FileCount,
+		TotalBytes:         img.TotalBytes,
+		HardLinkBytes:      img.HardLinkBytes,
+		CreationTime:       img.CreationTime,
+		ModificationTime:   img.ModificationTime,
+	}
+}
+
+type summaryJSON struct {
+	Path        string      `json:"path"`
+	GUID        string      `json:"guid"`
+	Compression string      `json:"compression"`
+	ImageCount  int         `json:"imageCount"`
+	BootIndex   int         `json:"bootIndex"`
+	PartNumber  int         `json:"partNumber"`
+	TotalParts  int         `json:"totalParts"`
+	Images      []imageJSON `json:"images"`
+}
+
+func newSummaryJSON(sum wimSummary) summaryJSON {
+	out := summaryJSON{
+		Path:        sum.Path,
+		GUID:        sum.GUID,
+		Compression: sum.Compression,
+		ImageCount:  sum.ImageCount,
+		BootIndex:   sum.BootIndex,
+		PartNumber:  sum.PartNumber,
+		TotalParts:  sum.TotalParts,
+		Images:      make([]imageJSON, 0, len(sum.Images)),
+	}
+	for _, img := range sum.Images {
+		out.Images = append(out.Images, newImageJSON(img))
+	}
+	return out
+}
+
+// operationJSON is the --json result of apply and capture.
+type operationJSON struct {
+	Operation string  `json:"operation"`
+	WIM       string  `json:"wim"`
+	Index     int     `json:"index,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Target    string  `json:"target,omitempty"`
+	Files     uint64  `json:"files"`
+	Warnings  int     `json:"warnings"`
+	Errors    int     `json:"errors"`
+	Done      bool    `json:"done"`
+	Seconds   float64 `json:"seconds"`
+	// Workers is reported by the pure-Go writer only.
+	Workers []workerJSON `json:"workers,omitempty"`
+	// Losses is what the pure-Go apply could not represent.
+	Losses []lossJSON `json:"losses,omitempty"`
+}
+
+// lossJSON is one wim.ApplyLoss.
+type lossJSON struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// workerJSON is one compression worker of the pure-Go writer.
+type workerJSON struct {
+	Chunks      uint64  `json:"chunks"`
+	Bytes       uint64  `json:"bytes"`
+	BusySeconds float64 `json:"busySeconds"`
+	BytesPerSec float64 `json:"bytesPerSec"`
+}
+
+func newWorkersJSON(p wim.WriterProgress) []workerJSON {
+	var out []workerJSON
+	for _, w := range p.Workers {
+		out = append(out, workerJSON{
+			Chunks:      w.Chunks,
+			Bytes:       w.Bytes,
+			BusySeconds: w.Busy.Seconds(),
+			BytesPerSec: w.BytesPerSec(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+const (
+	progressBarWidth   = 30
+	progressBarRefresh = 100 * time.Millisecond
+)
+
+// progressBar renders ProgressDecoder state as a single, redrawn line.
+// Warnings and errors are printed above it.
+type progressBar struct {
+	w       io.Writer
+	label   string
+	enabled bool
+	decoder *wimgapi.ProgressDecoder
+	last    time.Time
+	lineLen int
+	state   wimgapi.DecodedProgressEvent
+}
+
+func newProgressBar(w io.Writer, label string, enabled bool) *progressBar {
+	return &progressBar{
+		w:       w,
+		label:   label,
+		enabled: enabled,
+		decoder: wimgapi.NewProgressDecoder(),
+	}
+}
+
+func (b *progressBar) update(evt wimgapi.ProgressEvent) wimgapi.DecodedProgressEvent {
+	ev := b.decoder.Decode(evt)
+	b.state = ev
+	if !b.enabled {
+		return ev
+	}
+
+	switch ev.MessageID {
+	case wimgapi.WIMMessageError, wimgapi.WIMMessageWarning, wimgapi.WIMMessageRetry:
+		b.clear()
+		if evt.Path != "" {
+			fmt.Fprintf(b.w, "%s %s\n", ev.Summary, evt.Path)
+		} else {
+			fmt.Fprintln(b.w, ev.Summary)
+		}
+		b.draw()
+	case wimgapi.WIMMessageDone:
+		b.draw()
+	default:
+		if now := time.Now(); now.Sub(b.last) >= progressBarRefresh {
+			b.last = now
+			b.draw()
+		}
+	}
+	return ev
+}
+
+func (b *progressBar) finish() {
+	if !b.enabled || b.lineLen == 0 {
+		return
+	}
+	b.draw()
+	fmt.Fprintln(b.w)
+	b.lineLen = 0
+}
+
+func (b *progressBar) draw() {
+	line := renderProgressLine(b.label, b.state)
+	pad := ""
+	if n := b.lineLen - len(line); n > 0 {
+		pad = strings.Repeat(" ", n)
+	}
+	fmt.Fprintf(b.w, "\r%s%s", line, pad)
+	b.lineLen = len(line)
+}
+
+func (b *progressBar) clear() {
+	if b.lineLen > 0 {
+		fmt.Fprintf(b.w, "\r%s\r", strings.Repeat(" ", b.lineLen))
+		b.lineLen = 0
+	}
+}
+
+func renderProgressLine(label string, ev wimgapi.DecodedProgressEvent) string {
+	if ev.Total == 0 {
+		return fmt.Sprintf("%s: %d files", label, ev.Current)
+	}
+	pct := min(ev.Percent, 100)
+	filled := int(pct / 100 * progressBarWidth)
+	return fmt.Sprintf("%s [%s%s] %5.1f%% %d/%d",
+		label,
+		strings.Repeat("#", filled),
+		strings.Repeat(".", progressBarWidth-filled),
+		pct, ev.Current, ev.Total)
+}
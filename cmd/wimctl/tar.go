@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/ghp3000/go-wimgapi/wim"
+)
+
+// runExportTar writes an image as a tar archive to a file, or to stdout
+// when the output is "-".
+func runExportTar(args []string) error {
+	flags := flag.NewFlagSet("export-tar", flag.ContinueOnError)
+	var opts wim.TarOptions
+	flags.BoolVar(&opts.Security, "security", false, "")
+	flags.BoolVar(&opts.Streams, "streams", false, "")
+	pos, err := parseArgs(flags, args, 3, 3)
+	if err != nil {
+		return err
+	}
+	f, img, err := openImage(pos[0], pos[1])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = os.Stdout
+	if pos[2] != "-" {
+		out, err := os.Create(pos[2])
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
+	bw := bufio.NewWriterSize(w, 1<<20)
+	if err := img.WriteTar(bw, opts); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	if out, ok := w.(*os.File); ok && out != os.Stdout {
+		return out.Close()
+	}
+	return nil
+}
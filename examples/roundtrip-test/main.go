@@ -3,25 +3,16 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 
+	"github.com/ghp3000/go-wimgapi/wim"
 	"github.com/ghp3000/go-wimgapi/wimgapi"
 	"golang.org/x/sys/windows"
 )
 
-type treeEntry struct {
-	IsDir  bool
-	Size   int64
-	SHA256 string
-}
-
 func main() {
 	source := filepath.Clean(filepath.Join("examples", "testdata"))
 	if _, err := os.Stat(source); err != nil {
@@ -65,8 +56,8 @@ func main() {
 	}
 	fmt.Printf("applied: %s -> %s\n", wimPath, restoreDir)
 
-	if err := compareTrees(absSource, restoreDir); err != nil {
-		fail("tree compare failed: %v", err)
+	if err := verifyTrees(wimPath, absSource, restoreDir); err != nil {
+		fail("tree verify failed: %v", err)
 	}
 
 	fmt.Println("roundtrip test passed")
@@ -192,76 +183,32 @@ func verifyAndApply(wimPath, restoreDir, tempDir string) error {
 	return nil
 }
 
-func compareTrees(sourceDir, targetDir string) error {
-	src, err := collectTree(sourceDir)
+// verifyTrees checks the captured image against both the source directory
+// and the applied copy.
+func verifyTrees(wimPath string, dirs ...string) error {
+	f, err := wim.Open(wimPath)
 	if err != nil {
 		return err
 	}
-	dst, err := collectTree(targetDir)
+	defer f.Close()
+	img, err := f.Image(1)
 	if err != nil {
 		return err
 	}
 
-	if len(src) != len(dst) {
-		return fmt.Errorf("entry count mismatch: src=%d dst=%d", len(src), len(dst))
-	}
-
-	for p, a := range src {
-		b, ok := dst[p]
-		if !ok {
-			return fmt.Errorf("missing entry in target: %s", p)
-		}
-		if a != b {
-			return fmt.Errorf("entry mismatch for %s: src=%+v dst=%+v", p, a, b)
-		}
-	}
-	return nil
-}
-
-func collectTree(root string) (map[string]treeEntry, error) {
-	m := make(map[string]treeEntry)
-	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		rel, err := filepath.Rel(root, path)
+	for _, dir := range dirs {
+		mismatches, err := wim.VerifyAgainstDir(img, dir, wim.VerifyOptions{Streams: true})
 		if err != nil {
 			return err
 		}
-		if rel == "." {
-			return nil
+		for _, m := range mismatches {
+			fmt.Printf("[verify] %s: %s\n", dir, m)
 		}
-		key := filepath.ToSlash(rel)
-		info, err := d.Info()
-		if err != nil {
-			return err
+		if len(mismatches) > 0 {
+			return fmt.Errorf("%s: %d mismatches", dir, len(mismatches))
 		}
-		if d.IsDir() {
-			m[key] = treeEntry{IsDir: true}
-			return nil
-		}
-		sum, err := fileSHA256(path)
-		if err != nil {
-			return err
-		}
-		m[key] = treeEntry{IsDir: false, Size: info.Size(), SHA256: sum}
-		return nil
-	})
-	return m, err
-}
-
-func fileSHA256(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
 	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(h.Sum(nil)), nil
+	return nil
 }
 
 func fail(format string, args ...any) {
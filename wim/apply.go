@@ -0,0 +1,356 @@
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// maxXattrSize is the largest extended attribute value Linux accepts.
+const maxXattrSize = 64 << 10
+
+// ApplyOptions controls Image.Apply.
+type ApplyOptions struct {
+	// NoRPFix creates links to their stored targets, like
+	// WIM_FLAG_NO_RP_FIX, instead of pointing links RP fix made relative
+	// to the image root back into dir.
+	NoRPFix bool
+	// Attributes, Security and Streams keep what a POSIX file system has
+	// no place for in the extended attributes ntfs-3g uses, which
+	// NewDirSource reads back: Windows attributes and creation times as
+	// system.ntfs_attrib_be and system.ntfs_crtime_be, security
+	// descriptors as system.ntfs_acl and alternate data streams as
+	// user.<name>. Where the file system refuses system.*, as ext4 does,
+	// the metadata goes to user.ntfs_attrib_be, user.ntfs_crtime_be and
+	// user.ntfs_acl instead. They are only available on Linux; elsewhere,
+	// or where the file system refuses both, they are reported as losses.
+	Attributes bool
+	Security   bool
+	Streams    bool
+	// Progress, if set, is called after each entry with the number of
+	// entries applied and the total. An error from it stops Apply.
+	Progress func(done, total int) error
+}
+
+// LossKind classifies something Apply could not represent on the target
+// file system.
+type LossKind int
+
+const (
+	LossHardLink     LossKind = iota // written as a copy
+	LossReparsePoint                 // written as a plain file, or a link out of the image
+	LossTimes                        // timestamps not set
+	LossAttributes                   // attributes or creation time not stored
+	LossSecurity                     // security descriptor not stored
+	LossStream                       // alternate data stream not stored
+)
+
+func (k LossKind) String() string {
+	switch k {
+	case LossHardLink:
+		return "hard link"
+	case LossReparsePoint:
+		return "reparse point"
+	case LossTimes:
+		return "times"
+	case LossAttributes:
+		return "attributes"
+	case LossSecurity:
+		return "security"
+	case LossStream:
+		return "stream"
+	default:
+		return "unknown"
+	}
+}
+
+// ApplyLoss is one thing Apply could not represent.
+type ApplyLoss struct {
+	Path   string // slash-separated, relative to the image root
+	Kind   LossKind
+	Detail string
+}
+
+func (l ApplyLoss) String() string {
+	if l.Detail == "" {
+		return fmt.Sprintf("%s: %s", l.Path, l.Kind)
+	}
+	return fmt.Sprintf("%s: %s: %s", l.Path, l.Kind, l.Detail)
+}
+
+// Apply extracts the image into dir, which is created if needed, without
+// needing WIMGAPI. Files, directories and their timestamps are written;
+// read-only files lose their write permission. Hard links are recreated,
+// or written as copies where the file system refuses them. Symbolic links
+// and junctions become symbolic links, and other reparse points, such as
+// WOF or deduplicated files, become files with their data. Existing files
+// in the way are replaced. Everything that could not be represented comes
+// back as losses; the error is only for failures that stop the apply.
+func (img *Image) Apply(dir string, opts ApplyOptions) ([]ApplyLoss, error) {
+	type entry struct {
+		path   string
+		d      *Dentry
+		linkTo string // first path of d's hard link group
+	}
+	var entries []entry
+	var blobs []Stream
+	links := make(map[uint64]string)
+	err := img.Walk(func(path string, d *Dentry) error {
+		if path == "" {
+			return nil
+		}
+		if !validName(d.Name) {
+			return fmt.Errorf("wim: apply %s: invalid file name", path)
+		}
+		if g := d.HardLinkGroup; g != 0 && !d.IsDir() {
+			if first, ok := links[g]; ok {
+				entries = append(entries, entry{path, d, first})
+				blobs = append(blobs, Stream{})
+				return nil
+			}
+			links[g] = path
+		}
+		entries = append(entries, entry{path, d, ""})
+		blobs = append(blobs, d.data)
+		if isLinkTag(d) && d.IsDir() {
+			// Nothing is applied through a link.
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	a := &applier{img: img, opts: opts}
+	pf := newPrefetcher(img.f, blobs)
+	for i, e := range entries {
+		pf.advance(i)
+		target := filepath.Join(dir, filepath.FromSlash(e.path))
+		first := ""
+		if e.linkTo != "" {
+			first = filepath.Join(dir, filepath.FromSlash(e.linkTo))
+		}
+		if err := a.entry(target, e.path, e.d, first); err != nil {
+			return a.losses, fmt.Errorf("wim: apply %s: %w", e.path, err)
+		}
+		if opts.Progress != nil {
+			if err := opts.Progress(i+1, len(entries)); err != nil {
+				return a.losses, err
+			}
+		}
+	}
+	// Creating a directory's contents changes its times, so they are set
+	// last, children before parents.
+	for i := len(entries) - 1; i >= 0; i-- {
+		if e := entries[i]; e.d.IsDir() && !isLinkTag(e.d) {
+			a.times(filepath.Join(dir, filepath.FromSlash(e.path)), e.path, e.d)
+		}
+	}
+	return a.losses, nil
+}
+
+type applier struct {
+	img    *Image
+	opts   ApplyOptions
+	losses []ApplyLoss
+}
+
+func (a *applier) add(path string, kind LossKind, format string, args ...any) {
+	a.losses = append(a.losses, ApplyLoss{Path: path, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+// entry applies d at target. first, if set, is the applied path of an
+// earlier link to the same file.
+func (a *applier) entry(target, path string, d *Dentry, first string) error {
+	if first != "" {
+		err := hardLink(first, target)
+		if err == nil {
+			return nil
+		}
+		a.add(path, LossHardLink, "copied: %v", err)
+	}
+	switch {
+	case isLinkTag(d):
+		if err := a.symlink(target, path, d); err != nil {
+			return err
+		}
+	case d.IsDir():
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+		if err := os.Mkdir(target, 0o755); err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+	default:
+		if err := a.file(target, d); err != nil {
+			return err
+		}
+		if d.Attributes&AttrReparsePoint != 0 {
+			a.add(path, LossReparsePoint, "%s written as a regular file", reparseTagName(d.ReparseTag))
+		}
+	}
+
+	a.xattrs(target, path, d)
+	if !d.IsDir() && !isLinkTag(d) && d.Attributes&AttrReadOnly != 0 {
+		if err := os.Chmod(target, 0o444); err != nil {
+			return err
+		}
+	}
+	if !d.IsDir() || isLinkTag(d) {
+		a.times(target, path, d)
+	}
+	return nil
+}
+
+func (a *applier) symlink(target, path string, d *Dentry) error {
+	rp, err := a.img.ReparsePoint(d)
+	if err != nil {
+		return err
+	}
+	link := rp.Target()
+	if !a.opts.NoRPFix {
+		link = a.img.posixLinkTarget(path, d, rp)
+	}
+	if len(link) >= 2 && link[1] == ':' {
+		a.add(path, LossReparsePoint, "target %s is outside the image", link)
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	return os.Symlink(filepath.FromSlash(link), target)
+}
+
+func (a *applier) file(target string, d *Dentry) error {
+	r, err := a.img.OpenStream(d, "")
+	if err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// xattrs stores the metadata the options ask for in extended attributes.
+func (a *applier) xattrs(target, path string, d *Dentry) {
+	if a.opts.Attributes {
+		attrs := binary.BigEndian.AppendUint32(nil, d.Attributes)
+		if err := setNTFSXattr(target, xattrNTFSAttrib, attrs); err != nil {
+			a.add(path, LossAttributes, "%v", err)
+		} else if !d.CreationTime.IsZero() {
+			crtime := binary.BigEndian.AppendUint64(nil, wimgapi.TimeToFileTime(d.CreationTime))
+			if err := setNTFSXattr(target, xattrNTFSCrtime, crtime); err != nil {
+				a.add(path, LossAttributes, "creation time: %v", err)
+			}
+		}
+	}
+	if a.opts.Security {
+		if sd := a.img.SecurityDescriptor(d.SecurityID); sd != nil {
+			if err := setNTFSXattr(target, xattrNTFSACL, sd); err != nil {
+				a.add(path, LossSecurity, "%v", err)
+			}
+		}
+	}
+	if a.opts.Streams {
+		for _, s := range d.Streams {
+			if err := a.stream(target, d, s); err != nil {
+				a.add(path+":"+s.Name, LossStream, "%v", err)
+			}
+		}
+	}
+}
+
+// setNTFSXattr stores value in the system.* attribute name, or in its
+// user.* fallback where the file system refuses the first.
+func setNTFSXattr(path, name string, value []byte) error {
+	err := setLiveXattr(path, name, value)
+	if err != nil && setLiveXattr(path, userXattr(name), value) == nil {
+		return nil
+	}
+	return err
+}
+
+func (a *applier) stream(target string, d *Dentry, s Stream) error {
+	if isUserXattrFallback(xattrStreamPrefix + s.Name) {
+		return fmt.Errorf("the name is taken by the %s%s fallback of NTFS metadata", xattrStreamPrefix, s.Name)
+	}
+	if s.Size > maxXattrSize {
+		return fmt.Errorf("%d bytes do not fit an extended attribute", s.Size)
+	}
+	r, err := a.img.OpenStream(d, s.Name)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return setLiveXattr(target, xattrStreamPrefix+s.Name, data)
+}
+
+func (a *applier) times(target, path string, d *Dentry) {
+	if err := setLiveTimes(target, d.LastAccessTime, d.LastWriteTime); err != nil {
+		a.add(path, LossTimes, "%v", err)
+	}
+}
+
+// hardLink links target to the existing file first.
+func hardLink(first, target string) error {
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	return os.Link(first, target)
+}
+
+// isLinkTag reports whether d is a symbolic link or junction.
+func isLinkTag(d *Dentry) bool {
+	return d.Attributes&AttrReparsePoint != 0 &&
+		(d.ReparseTag == ReparseTagSymlink || d.ReparseTag == ReparseTagMountPoint)
+}
+
+func reparseTagName(tag uint32) string {
+	switch tag {
+	case ReparseTagWOF:
+		return "WOF file"
+	case ReparseTagDedup:
+		return "deduplicated file"
+	default:
+		return fmt.Sprintf("reparse point %#x", tag)
+	}
+}
+
+// removeExisting removes a file or link at path, or an empty directory,
+// so that a new one can be created without following links in the way.
+func removeExisting(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// validName reports whether name can be created in a directory without
+// reaching outside it.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
@@ -0,0 +1,23 @@
+package wim
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func setLiveXattr(path, name string, value []byte) error {
+	return unix.Lsetxattr(path, name, value, 0)
+}
+
+// setLiveTimes sets the access and modification times of path, or of the
+// link itself for a symbolic link. Zero times are left alone.
+func setLiveTimes(path string, atime, mtime time.Time) error {
+	ts := func(t time.Time) unix.Timespec {
+		if t.IsZero() {
+			return unix.Timespec{Nsec: unix.UTIME_OMIT}
+		}
+		return unix.NsecToTimespec(t.UnixNano())
+	}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts(atime), ts(mtime)}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package wim
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+var errXattrUnsupported = errors.New("extended attributes are not available on this platform")
+
+func setLiveXattr(path, name string, value []byte) error {
+	return errXattrUnsupported
+}
+
+// setLiveTimes sets the access and modification times of path. Zero times
+// are left alone. Symbolic links keep the times they were created with.
+func setLiveTimes(path string, atime, mtime time.Time) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		return errors.New("the times of symbolic links cannot be set on this platform")
+	}
+	return os.Chtimes(path, atime, mtime)
+}
@@ -0,0 +1,236 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestApply(t *testing.T) {
+	wof := testFile("compact.dll", "uncompressed content")
+	wof.attr |= AttrReparsePoint
+	wof.reparseTag = ReparseTagWOF
+	wof.reparse = []byte{1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0}
+	img := openTestImage(t, testDir("",
+		testDir("Windows",
+			testDir("System32", wof, testFile("empty.ini", "")),
+			testFile("win.ini", "[fonts]\n"),
+		),
+		testDir("Users"),
+		testSymlink("sys", `Windows\System32`, true),
+	))
+
+	out := t.TempDir()
+	// Files in the way are replaced.
+	if err := os.WriteFile(filepath.Join(out, "sys"), []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	losses, err := img.Apply(out, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 1 || losses[0].Path != "Windows/System32/compact.dll" || losses[0].Kind != LossReparsePoint {
+		t.Errorf("losses %v", losses)
+	}
+	for name, want := range map[string]string{
+		"Windows/win.ini":              "[fonts]\n",
+		"Windows/System32/compact.dll": "uncompressed content",
+		"Windows/System32/empty.ini":   "",
+	} {
+		data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v", name, data, err)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(out, "Users")); err != nil || !fi.IsDir() {
+		t.Errorf("Users: %v", err)
+	}
+	win, _ := img.Lookup("Windows")
+	for _, name := range []string{"Windows", "Windows/win.ini"} {
+		if fi, err := os.Stat(filepath.Join(out, filepath.FromSlash(name))); err != nil || !fi.ModTime().Equal(win.LastWriteTime) {
+			t.Errorf("%s modified at %v, want %v", name, fi.ModTime(), win.LastWriteTime)
+		}
+	}
+	if runtime.GOOS != "windows" {
+		if target, err := os.Readlink(filepath.Join(out, "sys")); err != nil || target != "Windows/System32" {
+			t.Errorf("sys -> %q, %v", target, err)
+		}
+	}
+}
+
+func TestApplyInvalidName(t *testing.T) {
+	img := openTestImage(t, testDir("", testDir("..", testFile("escape", "x"))))
+	out := filepath.Join(t.TempDir(), "out")
+	if _, err := img.Apply(out, ApplyOptions{}); err == nil {
+		t.Fatal("applied a directory named ..")
+	}
+	if _, err := os.Stat(filepath.Join(out, "..", "escape")); err == nil {
+		t.Fatal("file written outside the target directory")
+	}
+}
+
+func TestApplyReadOnlyAndLinks(t *testing.T) {
+	ro := testFile("ro.txt", "read only")
+	ro.attr |= AttrReadOnly
+	a, b := testFile("a", "linked"), testFile("b", "linked")
+	a.linkGroup, b.linkGroup = 3, 3
+	img := openTestImage(t, testDir("", ro, testDir("d", a), b,
+		testSymlink("abs", `C:\Windows`, false)))
+
+	out := t.TempDir()
+	losses, err := img.Apply(out, ApplyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		if len(losses) != 1 || losses[0].Path != "abs" || losses[0].Kind != LossReparsePoint {
+			t.Errorf("losses %v", losses)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(out, "ro.txt")); err != nil || fi.Mode().Perm()&0o222 != 0 {
+		t.Errorf("ro.txt: %v, %v", fi.Mode(), err)
+	}
+	fa, err := os.Stat(filepath.Join(out, "d", "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fb, err := os.Stat(filepath.Join(out, "b")); err != nil || !os.SameFile(fa, fb) {
+		t.Errorf("b is not a link to d/a: %v", err)
+	}
+
+	// Applying again replaces everything, read-only files included.
+	if _, err := img.Apply(out, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestApplyXattrs applies with every extended attribute option; whatever
+// the file system takes must read back through NewDirSource, and whatever
+// it refuses must be reported. On Linux, file systems that take user.*
+// attributes, as ext4 and tmpfs do, must keep all of it.
+func TestApplyXattrs(t *testing.T) {
+	sddl := knownDescriptors[0].sddl
+	crtime := time.Date(2021, 6, 1, 8, 30, 0, 100, time.UTC)
+	raw := writeTestTar(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: map[string]string{
+			PAXAttributes:                       string(binary.BigEndian.AppendUint32(nil, AttrArchive|AttrHidden|AttrSystem)),
+			PAXCreationTime:                     string(binary.BigEndian.AppendUint64(nil, wimgapi.TimeToFileTime(crtime))),
+			PAXSecuritySDDL:                     sddl,
+			PAXStreamPrefix + "Zone.Identifier": "ZoneId=3",
+		}},
+	}, map[string]string{"etc/hosts": "127.0.0.1 localhost\n"})
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := img.Lookup("etc/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd := img.SecurityDescriptor(hosts.SecurityID)
+	if parsed, err := ParseSecurityDescriptor(sd); err != nil || parsed.SDDL() != sddl {
+		t.Fatalf("fixture security descriptor %x, %v", sd, err)
+	}
+
+	out := t.TempDir()
+	losses, err := img.Apply(out, ApplyOptions{Attributes: true, Security: true, Streams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost := make(map[LossKind]bool)
+	for _, l := range losses {
+		lost[l.Kind] = true
+	}
+	if runtime.GOOS != "linux" && (!lost[LossAttributes] || !lost[LossSecurity] || !lost[LossStream]) {
+		t.Fatalf("losses %v", losses)
+	}
+	mismatches, err := VerifyAgainstDir(img, out, VerifyOptions{Security: !lost[LossSecurity], Streams: !lost[LossStream]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		if m.Kind == MismatchAttributes && lost[LossAttributes] {
+			continue
+		}
+		t.Error(m)
+	}
+	t.Logf("losses: %v", losses)
+
+	if runtime.GOOS != "linux" {
+		return
+	}
+	probe := filepath.Join(t.TempDir(), "probe")
+	if err := os.WriteFile(probe, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := setLiveXattr(probe, "user.probe", []byte("1")); err != nil {
+		t.Skipf("no user extended attributes here: %v", err)
+	}
+	if len(losses) != 0 {
+		t.Fatalf("losses %v", losses)
+	}
+	path := filepath.Join(out, "etc", "hosts")
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attrs, mask, err := liveAttributes(path, fi); err != nil || attrs != hosts.Attributes || mask != nativeAttrMask {
+		t.Errorf("attributes %s (mask %#x), %v; want %s", FormatAttributes(attrs), mask, err, FormatAttributes(hosts.Attributes))
+	}
+	if got, err := liveSecurity(path); err != nil || !bytes.Equal(got, sd) {
+		t.Errorf("security descriptor %x, %v", got, err)
+	}
+	if created, _ := liveTimes(path, fi); !created.Equal(crtime) {
+		t.Errorf("creation time %v, want %v", created, crtime)
+	}
+	if names, err := liveStreamNames(path); err != nil || !slices.Equal(names, []string{"Zone.Identifier"}) {
+		t.Errorf("streams %q, %v", names, err)
+	}
+	r, err := openLiveStream(path, "Zone.Identifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(r); string(data) != "ZoneId=3" {
+		t.Errorf("stream = %q", data)
+	}
+}
+
+func TestApplyStreamNamedLikeMetadata(t *testing.T) {
+	raw := writeTestTar(t, []*tar.Header{
+		{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: map[string]string{
+			PAXStreamPrefix + "ntfs_acl": "not a descriptor",
+		}},
+	}, map[string]string{"a.txt": "data"})
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	losses, err := img.Apply(out, ApplyOptions{Streams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(losses) != 1 || losses[0].Path != "a.txt:ntfs_acl" || losses[0].Kind != LossStream {
+		t.Fatalf("losses %v", losses)
+	}
+}
@@ -0,0 +1,120 @@
+package wim
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const blobEntrySize = 50
+
+// Hash is the SHA-1 digest WIM uses to identify stream contents. The zero
+// Hash stands for an empty stream.
+type Hash [20]byte
+
+func (h Hash) IsZero() bool {
+	return h == Hash{}
+}
+
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+type blobEntry struct {
+	res        resourceHeader
+	partNumber uint16
+	refCount   uint32
+	hash       Hash
+	// solid is the solid resource holding the blob; res.Offset is then
+	// the blob's offset in its uncompressed contents.
+	solid *solidResource
+}
+
+func (e *blobEntry) put(b []byte) {
+	e.res.put(b[0:24])
+	binary.LittleEndian.PutUint16(b[24:26], e.partNumber)
+	binary.LittleEndian.PutUint32(b[26:30], e.refCount)
+	copy(b[30:50], e.hash[:])
+}
+
+func (f *File) readBlobTable() error {
+	res := f.hdr.LookupTable
+	if res.UncompressedSize == 0 {
+		return nil
+	}
+	raw, err := f.readResource(res)
+	if err != nil {
+		return fmt.Errorf("wim: read blob table: %w", err)
+	}
+
+	f.blobs = make(map[Hash]*blobEntry, len(raw)/blobEntrySize)
+	// A run of solid entries lists solid resources and the blobs in them.
+	// Blob offsets count from the start of the run's first resource.
+	var run []*solidResource
+	var runBlobs []*blobEntry
+	endRun := func() error {
+		for _, e := range runBlobs {
+			if err := assignSolid(e, run); err != nil {
+				return err
+			}
+			if _, dup := f.blobs[e.hash]; !dup {
+				f.blobs[e.hash] = e
+			}
+		}
+		run, runBlobs = nil, nil
+		return nil
+	}
+	for off := 0; off+blobEntrySize <= len(raw); off += blobEntrySize {
+		b := raw[off : off+blobEntrySize]
+		e := &blobEntry{
+			res:        parseResourceHeader(b[0:24]),
+			partNumber: binary.LittleEndian.Uint16(b[24:26]),
+			refCount:   binary.LittleEndian.Uint32(b[26:30]),
+		}
+		copy(e.hash[:], b[30:50])
+
+		if e.res.Flags&resFlagSolid != 0 {
+			if e.res.UncompressedSize == solidResourceMagic {
+				s, err := f.readSolidHeader(e.res)
+				if err != nil {
+					return err
+				}
+				run = append(run, s)
+			} else {
+				runBlobs = append(runBlobs, e)
+			}
+			continue
+		}
+		if err := endRun(); err != nil {
+			return err
+		}
+		if e.res.Flags&resFlagMetadata != 0 {
+			f.metadata = append(f.metadata, e)
+			continue
+		}
+		if _, dup := f.blobs[e.hash]; !dup {
+			f.blobs[e.hash] = e
+		}
+	}
+	return endRun()
+}
+
+// assignSolid finds the resource of run that holds e.
+func assignSolid(e *blobEntry, run []*solidResource) error {
+	if e.res.Flags&resFlagMetadata != 0 {
+		return ErrSolidResource
+	}
+	off, size := e.res.Offset, e.res.UncompressedSize
+	for _, s := range run {
+		if off < s.size {
+			if size > s.size-off {
+				break
+			}
+			e.solid = s
+			e.res.Offset = off
+			return nil
+		}
+		off -= s.size
+	}
+	return fmt.Errorf("wim: blob %s lies outside its solid resources", e.hash)
+}
@@ -0,0 +1,189 @@
+package wim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// imageBuilder assembles the dentry tree of an image being captured.
+// Entries may arrive in any order; missing parent directories are created
+// as needed and take their metadata from a later entry if one comes.
+type imageBuilder struct {
+	w        *Writer
+	config   *wimgapi.CaptureConfig
+	rpfix    bool
+	root     *Dentry
+	security [][]byte
+	sdIndex  map[string]int32
+	nextLink uint64
+}
+
+func newImageBuilder(w *Writer) *imageBuilder {
+	now := time.Now().UTC()
+	return &imageBuilder{
+		w: w,
+		root: &Dentry{
+			Attributes:     AttrDirectory,
+			SecurityID:     -1,
+			CreationTime:   now,
+			LastAccessTime: now,
+			LastWriteTime:  now,
+		},
+		sdIndex: make(map[string]int32),
+	}
+}
+
+// securityID returns the index of sd in the image's security data, adding
+// it if needed. A nil descriptor is -1.
+func (b *imageBuilder) securityID(sd []byte) int32 {
+	if len(sd) == 0 {
+		return -1
+	}
+	if id, ok := b.sdIndex[string(sd)]; ok {
+		return id
+	}
+	id := int32(len(b.security))
+	b.security = append(b.security, sd)
+	b.sdIndex[string(sd)] = id
+	return id
+}
+
+// dir returns the directory at path, creating missing components.
+func (b *imageBuilder) dir(path string) (*Dentry, error) {
+	d := b.root
+	for _, name := range splitPath(path) {
+		next := d.Child(name)
+		if next == nil {
+			next = &Dentry{
+				Name:           name,
+				Attributes:     AttrDirectory,
+				SecurityID:     -1,
+				CreationTime:   d.CreationTime,
+				LastAccessTime: d.LastAccessTime,
+				LastWriteTime:  d.LastWriteTime,
+				Parent:         d,
+			}
+			d.Children = append(d.Children, next)
+		} else if !next.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", next.Path())
+		}
+		d = next
+	}
+	return d, nil
+}
+
+// add places d at path. An entry for an existing directory updates its
+// metadata; an existing file is replaced.
+func (b *imageBuilder) add(path string, d *Dentry) error {
+	parts := splitPath(path)
+	if len(parts) == 0 {
+		if !d.IsDir() {
+			return fmt.Errorf("root must be a directory")
+		}
+		b.root.setMetadata(d)
+		return nil
+	}
+	parent, err := b.dir(strings.Join(parts[:len(parts)-1], "/"))
+	if err != nil {
+		return err
+	}
+	d.Name = parts[len(parts)-1]
+	if old := parent.Child(d.Name); old != nil {
+		if old.IsDir() && d.IsDir() {
+			old.setMetadata(d)
+			return nil
+		}
+		parent.removeChild(old)
+	}
+	d.Parent = parent
+	parent.Children = append(parent.Children, d)
+	return nil
+}
+
+// link adds path as a hard link to the existing entry at target.
+func (b *imageBuilder) link(path, target string) error {
+	t, err := b.lookup(target)
+	if err != nil {
+		return err
+	}
+	if t.IsDir() {
+		return fmt.Errorf("hard link to directory %s", target)
+	}
+	if t.HardLinkGroup == 0 {
+		b.nextLink++
+		t.HardLinkGroup = b.nextLink
+	}
+	// The data is stored once, but each link references it.
+	b.w.reuseBlob(t.data.Hash)
+	for _, s := range t.Streams {
+		b.w.reuseBlob(s.Hash)
+	}
+	d := *t
+	d.Parent, d.Children = nil, nil
+	return b.add(path, &d)
+}
+
+func (b *imageBuilder) lookup(path string) (*Dentry, error) {
+	d := b.root
+	for _, name := range splitPath(path) {
+		if d = d.Child(name); d == nil {
+			return nil, fmt.Errorf("%s: no such entry", path)
+		}
+	}
+	return d, nil
+}
+
+// setMetadata copies everything but the name and tree links from src.
+func (d *Dentry) setMetadata(src *Dentry) {
+	d.ShortName = src.ShortName
+	d.Attributes = src.Attributes
+	d.SecurityID = src.SecurityID
+	d.CreationTime = src.CreationTime
+	d.LastAccessTime = src.LastAccessTime
+	d.LastWriteTime = src.LastWriteTime
+	d.ReparseTag = src.ReparseTag
+	d.ReparseNotFixed = src.ReparseNotFixed
+	d.Streams = src.Streams
+	d.data = src.data
+	d.reparse = src.reparse
+}
+
+func (d *Dentry) removeChild(c *Dentry) {
+	for i, x := range d.Children {
+		if x == c {
+			d.Children = append(d.Children[:i], d.Children[i+1:]...)
+			return
+		}
+	}
+}
+
+// stats counts the image's contents for its XML entry. Every link to a
+// file counts toward TotalBytes; links after the first also count toward
+// HardLinkBytes.
+func (b *imageBuilder) stats() wimgapi.ImageInfo {
+	var info wimgapi.ImageInfo
+	seen := make(map[uint64]bool)
+	var walk func(d *Dentry)
+	walk = func(d *Dentry) {
+		for _, c := range d.Children {
+			if c.IsDir() {
+				info.DirCount++
+				walk(c)
+				continue
+			}
+			info.FileCount++
+			info.TotalBytes += c.Size()
+			if c.HardLinkGroup != 0 {
+				if seen[c.HardLinkGroup] {
+					info.HardLinkBytes += c.Size()
+				}
+				seen[c.HardLinkGroup] = true
+			}
+		}
+	}
+	walk(b.root)
+	return info
+}
@@ -0,0 +1,184 @@
+package wim
+
+import (
+	"container/list"
+	"runtime"
+	"sync"
+)
+
+// DefaultCacheSize is the size of the chunk cache a File gets when
+// OpenOptions.Cache is nil. It holds two 64 MiB solid chunks.
+const DefaultCacheSize = 128 << 20
+
+// ChunkCache keeps recently decompressed chunks, least recently used
+// first out once it holds more than its size, and decompresses chunks
+// ahead of readers on up to GOMAXPROCS goroutines. Files that share a
+// cache share its memory and its goroutines.
+type ChunkCache struct {
+	mu      sync.Mutex
+	max     int64
+	size    int64
+	entries map[chunkKey]*list.Element
+	lru     list.List // of *cachedChunk, most recent first
+	slots   chan struct{}
+}
+
+type chunkKey struct {
+	f     *File
+	res   uint64 // offset of the resource
+	chunk int
+}
+
+// cachedChunk is a chunk that is decompressed once ready is closed.
+type cachedChunk struct {
+	key   chunkKey
+	size  int64
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// NewChunkCache returns a cache that holds about maxBytes of chunks. It
+// always keeps the latest chunk, however large.
+func NewChunkCache(maxBytes int64) *ChunkCache {
+	return &ChunkCache{
+		max:     maxBytes,
+		entries: make(map[chunkKey]*list.Element),
+		slots:   make(chan struct{}, runtime.GOMAXPROCS(0)),
+	}
+}
+
+// get returns a chunk, calling load on this goroutine to decompress it
+// unless it is cached or already being decompressed. Failures are not
+// cached.
+func (c *ChunkCache) get(key chunkKey, size int64, load func() ([]byte, error)) ([]byte, error) {
+	e, created := c.entry(key, size)
+	if created {
+		c.fill(e, load)
+	} else {
+		<-e.ready
+	}
+	return e.data, e.err
+}
+
+// prefetch starts decompressing a chunk in the background unless it is
+// cached or already being decompressed.
+func (c *ChunkCache) prefetch(key chunkKey, size int64, load func() ([]byte, error)) {
+	e, created := c.entry(key, size)
+	if !created {
+		return
+	}
+	go func() {
+		c.slots <- struct{}{}
+		defer func() { <-c.slots }()
+		c.fill(e, load)
+	}()
+}
+
+func (c *ChunkCache) fill(e *cachedChunk, load func() ([]byte, error)) {
+	e.data, e.err = load()
+	close(e.ready)
+	if e.err != nil {
+		c.mu.Lock()
+		c.removeLocked(e.key, e)
+		c.mu.Unlock()
+	}
+}
+
+// entry returns the entry for key, creating it if there is none and
+// evicting old entries to make room.
+func (c *ChunkCache) entry(key chunkKey, size int64) (e *cachedChunk, created bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(el)
+		return el.Value.(*cachedChunk), false
+	}
+	e = &cachedChunk{key: key, size: size, ready: make(chan struct{})}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += size
+	for c.size > c.max && c.lru.Len() > 1 {
+		old := c.lru.Back().Value.(*cachedChunk)
+		c.removeLocked(old.key, old)
+	}
+	return e, true
+}
+
+// removeLocked drops e, if it is still the entry for key. Readers that
+// already have its data keep it.
+func (c *ChunkCache) removeLocked(key chunkKey, e *cachedChunk) {
+	el, ok := c.entries[key]
+	if !ok || el.Value != e {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, key)
+	c.size -= e.size
+}
+
+// forget drops the chunks of f.
+func (c *ChunkCache) forget(f *File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, el := range c.entries {
+		if key.f == f {
+			c.removeLocked(key, el.Value.(*cachedChunk))
+		}
+	}
+}
+
+// prefetchBlob starts decompressing the first chunks of blob h in the
+// background, so that opening it later finds them cached.
+func (f *File) prefetchBlob(h Hash) {
+	e, ok := f.blobs[h]
+	if !ok || f.readAhead < 0 || (e.solid == nil && !e.res.compressed()) {
+		return
+	}
+	go func() {
+		var r *chunkReader
+		var err error
+		if e.solid != nil {
+			r, err = newSolidChunkReader(f, e.solid)
+			if err == nil && e.res.Offset < e.solid.size && e.res.UncompressedSize > 0 {
+				r.next = int(e.res.Offset / r.chunkSize)
+				r.last = int((min(e.res.Offset+e.res.UncompressedSize, e.solid.size) - 1) / r.chunkSize)
+			}
+		} else {
+			r, err = newChunkReader(f, e.res)
+		}
+		if err != nil || r.next > r.last {
+			return // reading it reports the error
+		}
+		// The first chunk counts against read-ahead too.
+		r.readAhead(r.next - 1)
+	}()
+}
+
+// prefetcher keeps the blobs of the next few files of a list decompressing
+// while the current one is read, within half the cache.
+type prefetcher struct {
+	f      *File
+	blobs  []Stream // in the order they are read
+	next   int      // first not prefetched yet
+	budget int64
+}
+
+func newPrefetcher(f *File, blobs []Stream) *prefetcher {
+	return &prefetcher{f: f, blobs: blobs, budget: f.cache.max / 2}
+}
+
+// advance is called before blob i is read.
+func (p *prefetcher) advance(i int) {
+	var ahead int64
+	end := min(len(p.blobs), i+1+max(p.f.readAhead, 0))
+	for j := i + 1; j < end; j++ {
+		ahead += int64(p.blobs[j].Size)
+		if ahead > p.budget {
+			break
+		}
+		if j >= p.next {
+			p.f.prefetchBlob(p.blobs[j].Hash)
+			p.next = j + 1
+		}
+	}
+}
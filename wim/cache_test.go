@@ -0,0 +1,271 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestChunkCacheLRU(t *testing.T) {
+	c := NewChunkCache(100)
+	loads := 0
+	get := func(chunk int) []byte {
+		t.Helper()
+		data, err := c.get(chunkKey{chunk: chunk}, 40, func() ([]byte, error) {
+			loads++
+			return []byte{byte(chunk)}, nil
+		})
+		if err != nil || data[0] != byte(chunk) {
+			t.Fatalf("chunk %d: %v %v", chunk, data, err)
+		}
+		return data
+	}
+	get(1)
+	get(2)
+	get(1) // 2 is now the oldest
+	get(3)
+	if loads != 3 {
+		t.Fatalf("%d loads, want 3", loads)
+	}
+	get(1)
+	get(2)
+	if loads != 4 {
+		t.Fatalf("%d loads, want 4: the wrong chunk was evicted", loads)
+	}
+	if c.size != 80 || c.lru.Len() != 2 {
+		t.Fatalf("size %d with %d chunks", c.size, c.lru.Len())
+	}
+
+	// Failures are retried.
+	fail := errors.New("bad chunk")
+	for range 2 {
+		if _, err := c.get(chunkKey{chunk: 9}, 1, func() ([]byte, error) { return nil, fail }); err != fail {
+			t.Fatalf("err = %v", err)
+		}
+	}
+	if _, ok := c.entries[chunkKey{chunk: 9}]; ok {
+		t.Fatal("failure cached")
+	}
+}
+
+func TestChunkCacheConcurrentLoad(t *testing.T) {
+	c := NewChunkCache(1 << 20)
+	var mu sync.Mutex
+	loads := 0
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.get(chunkKey{chunk: 1}, 10, func() ([]byte, error) {
+				mu.Lock()
+				loads++
+				mu.Unlock()
+				return make([]byte, 10), nil
+			})
+		}()
+	}
+	wg.Wait()
+	c.prefetch(chunkKey{chunk: 1}, 10, func() ([]byte, error) {
+		t.Error("prefetch reloaded a cached chunk")
+		return nil, nil
+	})
+	if loads != 1 {
+		t.Fatalf("chunk loaded %d times", loads)
+	}
+}
+
+// writeCacheTestWIM captures fsys into a new XPRESS WIM and returns its
+// path.
+func writeCacheTestWIM(t testing.TB, fsys fstest.MapFS) string {
+	t.Helper()
+	return writeTestWIM(t, WriterOptions{Compression: wimgapi.CompressXPRESS}, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func cacheTestFS(files, size int) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := range files {
+		var b bytes.Buffer
+		for j := 0; b.Len() < size; j++ {
+			fmt.Fprintf(&b, "file %d line %d\n", i, j)
+		}
+		fsys[fmt.Sprintf("f%03d.txt", i)] = &fstest.MapFile{Data: b.Bytes()[:size]}
+	}
+	return fsys
+}
+
+func TestFileReadAheadAndSharedCache(t *testing.T) {
+	fsys := cacheTestFS(2, 10*defaultChunk+5000)
+	path := writeCacheTestWIM(t, fsys)
+	cache := NewChunkCache(1 << 30)
+	open := func(readAhead int) *File {
+		f, err := OpenWith(path, OpenOptions{Cache: cache, ReadAhead: readAhead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	// chunks counts the cached chunks of f's file data, leaving out its
+	// metadata.
+	chunks := func(f *File) int {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		n := 0
+		for key := range cache.entries {
+			if key.f == f && key.res != f.metadata[0].res.Offset {
+				n++
+			}
+		}
+		return n
+	}
+
+	a, b := open(3), open(-1)
+	imgA, err := a.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := imgA.Open("f000.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := chunks(a); n != 4 { // the chunk read and three ahead
+		t.Errorf("%d chunks cached after the first read, want 4", n)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || len(rest)+1 != len(fsys["f000.txt"].Data) {
+		t.Fatalf("read %d bytes: %v", len(rest)+1, err)
+	}
+
+	imgB, err := b.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t)(imgB.Open("f001.txt")); got != string(fsys["f001.txt"].Data) {
+		t.Fatal("f001.txt differs")
+	}
+	if n := chunks(b); n != 11 {
+		t.Errorf("b has %d chunks cached, want 11", n)
+	}
+	a.Close()
+	if n := chunks(a); n != 0 {
+		t.Errorf("%d chunks of a closed file cached", n)
+	}
+	if n := chunks(b); n != 11 {
+		t.Errorf("closing a dropped chunks of b: %d left", n)
+	}
+	b.Close()
+}
+
+func TestWriteTarPrefetch(t *testing.T) {
+	fsys := cacheTestFS(40, 3000)
+	path := writeCacheTestWIM(t, fsys)
+	for _, readAhead := range []int{-1, 1, 8} {
+		f, err := OpenWith(path, OpenOptions{ReadAhead: readAhead})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := f.Image(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Read concurrently as well, so the race detector sees readers
+		// and prefetching together.
+		var wg sync.WaitGroup
+		for name := range fsys {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r, err := img.Open(name)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if data, err := io.ReadAll(r); err != nil || !bytes.Equal(data, fsys[name].Data) {
+					t.Errorf("%s: %v", name, err)
+				}
+			}()
+		}
+		var buf bytes.Buffer
+		if err := img.WriteTar(&buf, TarOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		wg.Wait()
+		got := readTarFiles(t, buf.Bytes())
+		for name, file := range fsys {
+			if got[name] != string(file.Data) {
+				t.Errorf("read ahead %d: %s differs in the tar", readAhead, name)
+			}
+		}
+		f.Close()
+	}
+}
+
+// BenchmarkWriteTar exports many small files and a few large ones; compare
+// read-ahead off and on to see the speedup on several cores.
+func BenchmarkWriteTar(b *testing.B) {
+	fsys := cacheTestFS(200, 20000)
+	for name, file := range cacheTestFS(4, 1<<20) {
+		fsys["big/"+name] = file
+	}
+	path := writeCacheTestWIM(b, fsys)
+	for _, readAhead := range []int{-1, 0} {
+		b.Run(fmt.Sprintf("readahead%d", readAhead), func(b *testing.B) {
+			var total int64
+			for _, file := range fsys {
+				total += int64(len(file.Data))
+			}
+			b.SetBytes(total)
+			for b.Loop() {
+				f, err := OpenWith(path, OpenOptions{ReadAhead: readAhead})
+				if err != nil {
+					b.Fatal(err)
+				}
+				img, err := f.Image(1)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := img.WriteTar(io.Discard, TarOptions{}); err != nil {
+					b.Fatal(err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+// readTarFiles returns the contents of the regular files in a tar.
+func readTarFiles(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[hdr.Name] = string(b)
+		}
+	}
+}
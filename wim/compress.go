@@ -0,0 +1,47 @@
+package wim
+
+import "github.com/ghp3000/go-wimgapi/wimgapi"
+
+// chunkCompressor compresses one chunk of src into dst, which is one byte
+// shorter than src, and returns the compressed length. It returns 0 when
+// the chunk doesn't shrink; the writer then stores it as is. A
+// chunkCompressor keeps scratch state and is not safe for concurrent use.
+type chunkCompressor func(dst, src []byte) int
+
+// compressors builds a chunkCompressor for a chunk size and effort level,
+// for each format the writer can produce.
+var compressors = map[wimgapi.Compression]func(chunkSize, level int) chunkCompressor{
+	wimgapi.CompressXPRESS: newXpressCompressor,
+	wimgapi.CompressLZX:    newLZXCompressor,
+	wimgapi.CompressLZMS:   newLZMSCompressor,
+}
+
+const (
+	minLevel     = 1
+	maxLevel     = 9
+	defaultLevel = 6
+)
+
+// levelParams tunes the match search for an effort level: how many hash
+// chain candidates to try, the length that ends the search early, and
+// whether to defer a match when the next position has a longer one.
+// Encoders that support it replace lazy parsing with optimalPasses rounds
+// of cost-based parsing.
+type levelParams struct {
+	maxChain      int
+	niceLen       int
+	lazy          bool
+	optimalPasses int
+}
+
+var compressionLevels = [maxLevel + 1]levelParams{
+	1: {4, 16, false, 0},
+	2: {8, 24, false, 0},
+	3: {16, 32, false, 0},
+	4: {16, 32, true, 0},
+	5: {32, 64, true, 0},
+	6: {64, 128, true, 0},
+	7: {128, 256, true, 0},
+	8: {256, 258, true, 2},
+	9: {1024, 258, true, 3},
+}
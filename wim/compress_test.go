@@ -0,0 +1,177 @@
+package wim
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// compressTestInputs covers text, runs, random bytes and long matches.
+func compressTestInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	noise := make([]byte, 4096)
+	rng.Read(noise)
+	var text bytes.Buffer
+	for i := 0; text.Len() < 1<<16; i++ {
+		fmt.Fprintf(&text, "line %d: the quick brown fox jumps over the lazy dog %d\n", i, i%17)
+	}
+	mixed := make([]byte, 0, 40000)
+	for len(mixed) < 40000 {
+		mixed = append(mixed, noise[:rng.Intn(300)]...)
+		mixed = append(mixed, bytes.Repeat([]byte{byte(rng.Intn(4))}, rng.Intn(2000))...)
+	}
+	return map[string][]byte{
+		"byte":  {'x'},
+		"text":  text.Bytes()[:1<<16],
+		"zeros": make([]byte, 1<<16),
+		"noise": noise,
+		"mixed": mixed,
+		"long":  append(append([]byte("head"), bytes.Repeat([]byte("0123456789"), 3000)...), noise[:100]...),
+	}
+}
+
+// chunkRoundTrip compresses src and checks that decompress gives it back.
+// It returns the compressed size, or len(src) when stored.
+func chunkRoundTrip(t testing.TB, decompress func(dst, src []byte) error, compress chunkCompressor, src []byte) int {
+	t.Helper()
+	if len(src) == 0 {
+		return 0
+	}
+	dst := make([]byte, len(src)-1)
+	n := compress(dst, src)
+	if n == 0 {
+		return len(src)
+	}
+	got := make([]byte, len(src))
+	if err := decompress(got, dst[:n]); err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if !bytes.Equal(got, src) {
+		t.Fatalf("round trip of %d bytes differs", len(src))
+	}
+	return n
+}
+
+// compressCodec is a compressor under test with its decompressor and the
+// chunk sizes and levels to round-trip at; nil levels means every level.
+type compressCodec struct {
+	c          wimgapi.Compression
+	decompress func(chunkSize int) func(dst, src []byte) error
+	chunkSizes []int
+	levels     []int
+}
+
+var compressCodecs = []compressCodec{
+	{wimgapi.CompressXPRESS, func(int) func(dst, src []byte) error { return xpressDecompress }, []int{1 << 16}, nil},
+	{wimgapi.CompressLZX, lzxDecompressor, []int{1 << 15, 1 << 16, 1 << 21}, nil},
+	{wimgapi.CompressLZMS, func(int) func(dst, src []byte) error { return lzmsDecompress }, []int{1 << 17}, []int{minLevel, defaultLevel, maxLevel}},
+}
+
+// TestCompressRoundTrip round-trips compressTestInputs through every
+// compressor and expects the redundant inputs to shrink to an eighth and
+// noise to be left stored.
+func TestCompressRoundTrip(t *testing.T) {
+	inputs := compressTestInputs()
+	inputs["calls"] = lzxCallInput(30000)
+	for _, codec := range compressCodecs {
+		levels := codec.levels
+		if levels == nil {
+			for level := minLevel; level <= maxLevel; level++ {
+				levels = append(levels, level)
+			}
+		}
+		for _, chunkSize := range codec.chunkSizes {
+			decompress := codec.decompress(chunkSize)
+			for _, level := range levels {
+				compress := compressors[codec.c](chunkSize, level)
+				for name, src := range inputs {
+					src = src[:min(len(src), chunkSize)]
+					n := chunkRoundTrip(t, decompress, compress, src)
+					switch name {
+					case "zeros", "text", "long":
+						if n > len(src)/8 {
+							t.Errorf("%s/%d/level %d: %s compressed to %d of %d bytes", codec.c, chunkSize, level, name, n, len(src))
+						}
+					case "noise":
+						if n != len(src) {
+							t.Errorf("%s/%d/level %d: noise compressed to %d bytes", codec.c, chunkSize, level, n)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// fuzzCompress round-trips fuzzed chunks through the compressor for c at
+// a fuzzed level and its first chunk size in compressCodecs.
+func fuzzCompress(f *testing.F, c wimgapi.Compression) {
+	i := slices.IndexFunc(compressCodecs, func(codec compressCodec) bool { return codec.c == c })
+	codec := compressCodecs[i]
+	chunkSize := codec.chunkSizes[0]
+	for _, src := range compressTestInputs() {
+		f.Add(src[:min(len(src), 4096)], uint8(defaultLevel))
+	}
+	f.Add(lzxCallInput(2000), uint8(maxLevel))
+	f.Fuzz(func(t *testing.T, src []byte, level uint8) {
+		src = src[:min(len(src), chunkSize)]
+		chunkRoundTrip(t, codec.decompress(chunkSize), compressors[c](chunkSize, minLevel+int(level)%maxLevel), src)
+	})
+}
+
+// BenchmarkCompress compresses the examples/testdata corpus, which is
+// mostly random, and a synthetic one of text, x86-like code and runs, in
+// 32 KiB chunks. ratio is the compressed size as a fraction of the input.
+func BenchmarkCompress(b *testing.B) {
+	var testdata []byte
+	err := filepath.WalkDir("../examples/testdata", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		testdata = append(testdata, data...)
+		return err
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	inputs := compressTestInputs()
+	synthetic := slices.Concat(inputs["text"], lzxCallInput(1<<16), inputs["mixed"], inputs["long"])
+	corpora := []struct {
+		name string
+		data []byte
+	}{{"testdata", testdata}, {"synthetic", synthetic}}
+
+	const chunkSize = 32 << 10
+	for _, corpus := range corpora {
+		for _, c := range []wimgapi.Compression{wimgapi.CompressXPRESS, wimgapi.CompressLZX, wimgapi.CompressLZMS} {
+			for _, level := range []int{minLevel, defaultLevel, maxLevel} {
+				b.Run(fmt.Sprintf("%s/%s/level%d", corpus.name, c, level), func(b *testing.B) {
+					compress := compressors[c](chunkSize, level)
+					dst := make([]byte, chunkSize)
+					b.SetBytes(int64(len(corpus.data)))
+					var total int
+					for b.Loop() {
+						total = 0
+						for off := 0; off < len(corpus.data); off += chunkSize {
+							chunk := corpus.data[off:min(off+chunkSize, len(corpus.data))]
+							n := compress(dst[:len(chunk)-1], chunk)
+							if n == 0 {
+								n = len(chunk)
+							}
+							total += n
+						}
+					}
+					b.ReportMetric(float64(total)/float64(len(corpus.data)), "ratio")
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package wim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// Windows file attributes stored in dentries.
+const (
+	AttrReadOnly          = 0x00000001
+	AttrHidden            = 0x00000002
+	AttrSystem            = 0x00000004
+	AttrDirectory         = 0x00000010
+	AttrArchive           = 0x00000020
+	AttrDevice            = 0x00000040
+	AttrNormal            = 0x00000080
+	AttrTemporary         = 0x00000100
+	AttrSparseFile        = 0x00000200
+	AttrReparsePoint      = 0x00000400
+	AttrCompressed        = 0x00000800
+	AttrOffline           = 0x00001000
+	AttrNotContentIndexed = 0x00002000
+	AttrEncrypted         = 0x00004000
+)
+
+// FormatAttributes renders attributes like PowerShell's Mode column:
+// d(irectory) a(rchive) r(ead-only) h(idden) s(ystem) l(reparse point),
+// with '-' for each flag that is clear.
+func FormatAttributes(attr uint32) string {
+	const letters = "darhsl"
+	flags := [...]uint32{AttrDirectory, AttrArchive, AttrReadOnly, AttrHidden, AttrSystem, AttrReparsePoint}
+	b := []byte("------")
+	for i, f := range flags {
+		if attr&f != 0 {
+			b[i] = letters[i]
+		}
+	}
+	return string(b)
+}
+
+const (
+	dentryDiskSize      = 102
+	streamEntryDiskSize = 38
+	maxDentryDepth      = 1024
+)
+
+// Stream is one data stream of a dentry. Name is empty for the unnamed
+// (default) data stream.
+type Stream struct {
+	Name string
+	Hash Hash
+	Size uint64
+}
+
+// Dentry is a file or directory in an image.
+type Dentry struct {
+	Name           string
+	ShortName      string
+	Attributes     uint32
+	SecurityID     int32
+	CreationTime   time.Time
+	LastAccessTime time.Time
+	LastWriteTime  time.Time
+	ReparseTag     uint32
+	// ReparseNotFixed marks an absolute link that RP fix left as it was
+	// because it points outside the captured tree.
+	ReparseNotFixed bool
+	HardLinkGroup   uint64
+	// Streams holds the named (alternate) data streams.
+	Streams  []Stream
+	Parent   *Dentry
+	Children []*Dentry
+
+	data    Stream
+	reparse Stream
+}
+
+func (d *Dentry) IsDir() bool {
+	return d.Attributes&AttrDirectory != 0
+}
+
+// Size is the length of the unnamed data stream.
+func (d *Dentry) Size() uint64 {
+	return d.data.Size
+}
+
+// Hash is the SHA-1 of the unnamed data stream; zero when it is empty.
+func (d *Dentry) Hash() Hash {
+	return d.data.Hash
+}
+
+// Path returns the slash-separated path from the image root; the root itself
+// is "".
+func (d *Dentry) Path() string {
+	var parts []string
+	for p := d; p != nil && p.Parent != nil; p = p.Parent {
+		parts = append(parts, p.Name)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(parts, "/")
+}
+
+// Child returns the child with the given name, preferring an exact match and
+// falling back to a case-insensitive one like Windows does.
+func (d *Dentry) Child(name string) *Dentry {
+	var fold *Dentry
+	for _, c := range d.Children {
+		if c.Name == name {
+			return c
+		}
+		if fold == nil && strings.EqualFold(c.Name, name) {
+			fold = c
+		}
+	}
+	return fold
+}
+
+// Stream returns the stream with the given name; "" is the unnamed stream.
+func (d *Dentry) Stream(name string) (Stream, bool) {
+	if name == "" {
+		return d.data, true
+	}
+	for _, s := range d.Streams {
+		if s.Name == name || strings.EqualFold(s.Name, name) {
+			return s, true
+		}
+	}
+	return Stream{}, false
+}
+
+// metadataParser decodes the dentry tree of a metadata resource.
+type metadataParser struct {
+	buf   []byte
+	blobs map[Hash]*blobEntry
+	seen  map[uint64]bool
+}
+
+func (p *metadataParser) errorf(off uint64, format string, args ...any) error {
+	return fmt.Errorf("wim: corrupt metadata at offset %d: %s", off, fmt.Sprintf(format, args...))
+}
+
+// readDir reads the sibling list starting at off.
+func (p *metadataParser) readDir(parent *Dentry, off uint64, depth int) error {
+	if depth > maxDentryDepth {
+		return p.errorf(off, "directory tree too deep")
+	}
+	if p.seen[off] {
+		return p.errorf(off, "directory cycle")
+	}
+	p.seen[off] = true
+
+	for {
+		d, next, err := p.readDentry(off)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			return nil
+		}
+		d.Parent = parent
+		parent.Children = append(parent.Children, d.Dentry)
+		if sub := d.subdir; sub != 0 {
+			if err := p.readDir(d.Dentry, sub, depth+1); err != nil {
+				return err
+			}
+		}
+		off = next
+	}
+}
+
+// dentryOnDisk carries the fields needed only while parsing.
+type dentryOnDisk struct {
+	*Dentry
+	subdir uint64
+}
+
+// readDentry parses the dentry at off. It returns nil at an end-of-directory
+// marker, and the offset of the next sibling otherwise.
+func (p *metadataParser) readDentry(off uint64) (*dentryOnDisk, uint64, error) {
+	buf := p.buf
+	if off+8 > uint64(len(buf)) {
+		return nil, 0, p.errorf(off, "dentry out of bounds")
+	}
+	length := binary.LittleEndian.Uint64(buf[off:])
+	if length == 0 {
+		return nil, 0, nil
+	}
+	if length < dentryDiskSize || off+length > uint64(len(buf)) {
+		return nil, 0, p.errorf(off, "bad dentry length %d", length)
+	}
+	b := buf[off : off+length]
+
+	d := &Dentry{
+		Attributes:     binary.LittleEndian.Uint32(b[8:12]),
+		SecurityID:     int32(binary.LittleEndian.Uint32(b[12:16])),
+		CreationTime:   wimgapi.FileTimeToTime(binary.LittleEndian.Uint64(b[40:48])),
+		LastAccessTime: wimgapi.FileTimeToTime(binary.LittleEndian.Uint64(b[48:56])),
+		LastWriteTime:  wimgapi.FileTimeToTime(binary.LittleEndian.Uint64(b[56:64])),
+	}
+	subdir := binary.LittleEndian.Uint64(b[16:24])
+	var defaultHash Hash
+	copy(defaultHash[:], b[64:84])
+	if d.Attributes&AttrReparsePoint != 0 {
+		d.ReparseTag = binary.LittleEndian.Uint32(b[88:92])
+		d.ReparseNotFixed = binary.LittleEndian.Uint16(b[94:96])&reparseFlagNotFixed != 0
+	} else {
+		d.HardLinkGroup = binary.LittleEndian.Uint64(b[88:96])
+	}
+	numStreams := int(binary.LittleEndian.Uint16(b[96:98]))
+	shortLen := int(binary.LittleEndian.Uint16(b[98:100]))
+	nameLen := int(binary.LittleEndian.Uint16(b[100:102]))
+
+	pos := dentryDiskSize
+	if nameLen > 0 {
+		if pos+nameLen+2 > len(b) {
+			return nil, 0, p.errorf(off, "name exceeds dentry")
+		}
+		d.Name = wimgapi.DecodeUTF16Bytes(b[pos : pos+nameLen])
+		pos += nameLen + 2
+	}
+	if shortLen > 0 {
+		if pos+shortLen+2 > len(b) {
+			return nil, 0, p.errorf(off, "short name exceeds dentry")
+		}
+		d.ShortName = wimgapi.DecodeUTF16Bytes(b[pos : pos+shortLen])
+	}
+
+	// Extra stream entries follow the dentry at the next 8-byte boundary.
+	next := off + align8(length)
+	streams := []Stream{{Hash: defaultHash}}
+	for i := 0; i < numStreams; i++ {
+		if next+streamEntryDiskSize > uint64(len(buf)) {
+			return nil, 0, p.errorf(next, "stream entry out of bounds")
+		}
+		elen := binary.LittleEndian.Uint64(buf[next:])
+		if elen < streamEntryDiskSize || next+elen > uint64(len(buf)) {
+			return nil, 0, p.errorf(next, "bad stream entry length %d", elen)
+		}
+		e := buf[next : next+elen]
+		var s Stream
+		copy(s.Hash[:], e[16:36])
+		if n := int(binary.LittleEndian.Uint16(e[36:38])); n > 0 {
+			if streamEntryDiskSize+n > len(e) {
+				return nil, 0, p.errorf(next, "stream name exceeds entry")
+			}
+			s.Name = wimgapi.DecodeUTF16Bytes(e[streamEntryDiskSize : streamEntryDiskSize+n])
+		}
+		streams = append(streams, s)
+		next += align8(elen)
+	}
+	p.assignStreams(d, streams)
+
+	if subdir != 0 && d.Attributes&AttrDirectory == 0 {
+		subdir = 0
+	}
+	return &dentryOnDisk{Dentry: d, subdir: subdir}, next, nil
+}
+
+// assignStreams sorts the default stream and extra entries into the data,
+// reparse and named streams. The on-disk format does not label them, so
+// this follows the same rules as WIMGAPI: the first unnamed stream with
+// content of a reparse point is its reparse data, the next unnamed one is
+// the file data.
+func (p *metadataParser) assignStreams(d *Dentry, streams []Stream) {
+	var haveData, haveReparse bool
+	for i, s := range streams {
+		s.Size = p.blobSize(s.Hash)
+		switch {
+		case s.Name != "":
+			d.Streams = append(d.Streams, s)
+		case i != 0 || !s.Hash.IsZero():
+			if d.Attributes&AttrReparsePoint != 0 && !haveReparse {
+				d.reparse = s
+				haveReparse = true
+			} else if !haveData {
+				d.data = s
+				haveData = true
+			}
+		}
+	}
+}
+
+func (p *metadataParser) blobSize(h Hash) uint64 {
+	if h.IsZero() {
+		return 0
+	}
+	if e, ok := p.blobs[h]; ok {
+		return e.res.UncompressedSize
+	}
+	return 0
+}
+
+func align8(n uint64) uint64 {
+	return (n + 7) &^ 7
+}
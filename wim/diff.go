@@ -0,0 +1,249 @@
+package wim
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// ChangeKind classifies an entry in a Diff.
+type ChangeKind int
+
+const (
+	Added ChangeKind = iota
+	Removed
+	Modified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Modified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// What a Modified change touched.
+const (
+	ChangedContent = 1 << iota
+	ChangedAttributes
+	ChangedSecurity
+	ChangedTimestamps
+	ChangedStreams
+	ChangedReparse // reparse tag or data, such as a link target
+)
+
+// DiffOptions controls which differences count as modifications. Last
+// access times are never compared.
+type DiffOptions struct {
+	IgnoreAttributes bool
+	IgnoreSecurity   bool
+	IgnoreTimestamps bool
+	IgnoreStreams    bool
+}
+
+// Change is one differing path. Old is nil for Added entries and New is nil
+// for Removed ones. SizeDelta counts the unnamed data stream; named streams
+// that were added, removed or modified are listed in Streams.
+type Change struct {
+	Path      string
+	Kind      ChangeKind
+	What      int // Changed* flags, for Modified
+	Old, New  *Dentry
+	SizeDelta int64
+	Streams   []StreamChange
+}
+
+// StreamChange is a differing named data stream of a path.
+type StreamChange struct {
+	Name      string
+	Kind      ChangeKind
+	SizeDelta int64
+}
+
+// DiffResult lists changes in tree order along with totals.
+type DiffResult struct {
+	Changes      []Change
+	Added        int
+	Removed      int
+	Modified     int
+	BytesAdded   uint64 // data in added files and streams plus growth of modified ones
+	BytesRemoved uint64 // data in removed files and streams plus shrinkage of modified ones
+}
+
+// Diff compares the dentry trees of a and b by path. Names match
+// case-insensitively, as on Windows, and file contents and named streams
+// are compared by SHA-1, as is reparse data. A path that changes between
+// file and directory is reported as removed and added.
+func Diff(a, b *Image, opts DiffOptions) *DiffResult {
+	d := &differ{a: a, b: b, opts: opts, res: &DiffResult{}}
+	d.dir(a.Root(), b.Root(), "")
+	return d.res
+}
+
+type differ struct {
+	a, b *Image
+	opts DiffOptions
+	res  *DiffResult
+}
+
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+func (d *differ) dir(old, new *Dentry, path string) {
+	matched := make(map[*Dentry]bool, len(new.Children))
+	for _, o := range old.Children {
+		p := joinPath(path, o.Name)
+		n := new.Child(o.Name)
+		if n == nil || matched[n] {
+			d.removeTree(o, p)
+			continue
+		}
+		matched[n] = true
+		if o.IsDir() != n.IsDir() {
+			d.removeTree(o, p)
+			d.addTree(n, p)
+			continue
+		}
+		d.compare(o, n, p)
+		if o.IsDir() {
+			d.dir(o, n, p)
+		}
+	}
+	for _, n := range new.Children {
+		if !matched[n] {
+			d.addTree(n, joinPath(path, n.Name))
+		}
+	}
+}
+
+func (d *differ) compare(o, n *Dentry, path string) {
+	var what int
+	if o.Hash() != n.Hash() || o.Size() != n.Size() {
+		what |= ChangedContent
+	}
+	if o.ReparseTag != n.ReparseTag || o.reparse.Hash != n.reparse.Hash {
+		what |= ChangedReparse
+	}
+	if !d.opts.IgnoreAttributes && o.Attributes != n.Attributes {
+		what |= ChangedAttributes
+	}
+	if !d.opts.IgnoreSecurity && !bytes.Equal(d.a.SecurityDescriptor(o.SecurityID), d.b.SecurityDescriptor(n.SecurityID)) {
+		what |= ChangedSecurity
+	}
+	if !d.opts.IgnoreTimestamps && (!o.CreationTime.Equal(n.CreationTime) || !o.LastWriteTime.Equal(n.LastWriteTime)) {
+		what |= ChangedTimestamps
+	}
+	var streams []StreamChange
+	if !d.opts.IgnoreStreams {
+		streams = diffStreams(o.Streams, n.Streams)
+		if len(streams) > 0 {
+			what |= ChangedStreams
+		}
+	}
+	if what == 0 {
+		return
+	}
+	delta := int64(n.Size()) - int64(o.Size())
+	d.count(delta)
+	for _, s := range streams {
+		d.count(s.SizeDelta)
+	}
+	d.res.Modified++
+	d.res.Changes = append(d.res.Changes, Change{Path: path, Kind: Modified, What: what, Old: o, New: n, SizeDelta: delta, Streams: streams})
+}
+
+// count adds a size change to the byte totals.
+func (d *differ) count(delta int64) {
+	if delta > 0 {
+		d.res.BytesAdded += uint64(delta)
+	} else {
+		d.res.BytesRemoved += uint64(-delta)
+	}
+}
+
+// diffStreams matches named streams case-insensitively, like NTFS does,
+// and compares them by SHA-1.
+func diffStreams(old, new []Stream) []StreamChange {
+	var changes []StreamChange
+	matched := make([]bool, len(new))
+	for _, o := range old {
+		i := slices.IndexFunc(new, func(n Stream) bool { return strings.EqualFold(n.Name, o.Name) })
+		if i < 0 || matched[i] {
+			changes = append(changes, StreamChange{Name: o.Name, Kind: Removed, SizeDelta: -int64(o.Size)})
+			continue
+		}
+		matched[i] = true
+		if n := new[i]; n.Hash != o.Hash || n.Size != o.Size {
+			changes = append(changes, StreamChange{Name: n.Name, Kind: Modified, SizeDelta: int64(n.Size) - int64(o.Size)})
+		}
+	}
+	for i, n := range new {
+		if !matched[i] {
+			changes = append(changes, StreamChange{Name: n.Name, Kind: Added, SizeDelta: int64(n.Size)})
+		}
+	}
+	return changes
+}
+
+func (d *differ) addTree(n *Dentry, path string) {
+	d.res.Added++
+	d.res.BytesAdded += n.Size()
+	var streams []StreamChange
+	if !d.opts.IgnoreStreams {
+		streams = diffStreams(nil, n.Streams)
+		for _, s := range streams {
+			d.count(s.SizeDelta)
+		}
+	}
+	d.res.Changes = append(d.res.Changes, Change{Path: path, Kind: Added, New: n, SizeDelta: int64(n.Size()), Streams: streams})
+	for _, c := range n.Children {
+		d.addTree(c, joinPath(path, c.Name))
+	}
+}
+
+func (d *differ) removeTree(o *Dentry, path string) {
+	d.res.Removed++
+	d.res.BytesRemoved += o.Size()
+	var streams []StreamChange
+	if !d.opts.IgnoreStreams {
+		streams = diffStreams(o.Streams, nil)
+		for _, s := range streams {
+			d.count(s.SizeDelta)
+		}
+	}
+	d.res.Changes = append(d.res.Changes, Change{Path: path, Kind: Removed, Old: o, SizeDelta: -int64(o.Size()), Streams: streams})
+	for _, c := range o.Children {
+		d.removeTree(c, joinPath(path, c.Name))
+	}
+}
+
+// FormatChanges names the Changed* flags in what, comma-separated.
+func FormatChanges(what int) string {
+	var parts []string
+	for _, f := range []struct {
+		bit  int
+		name string
+	}{
+		{ChangedContent, "content"},
+		{ChangedAttributes, "attributes"},
+		{ChangedSecurity, "security"},
+		{ChangedTimestamps, "timestamps"},
+		{ChangedStreams, "streams"},
+		{ChangedReparse, "reparse"},
+	} {
+		if what&f.bit != 0 {
+			parts = append(parts, f.name)
+		}
+	}
+	return strings.Join(parts, ",")
+}
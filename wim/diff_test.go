@@ -0,0 +1,146 @@
+package wim
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	oldHosts := testFile("hosts", "127.0.0.1 localhost\n")
+	a := openTestImage(t, testDir("",
+		testDir("Windows", oldHosts, testFile("old.log", "12345"), testFile("same.txt", "same")),
+		testDir("Temp", testFile("x.tmp", "xx")),
+		testFile("swap", "file"),
+	))
+	newHosts := testFile("HOSTS", "127.0.0.1 localhost\n::1 localhost\n")
+	hidden := testFile("same.txt", "same")
+	hidden.attr |= AttrHidden
+	b := openTestImage(t, testDir("",
+		testDir("windows", newHosts, hidden, testFile("new.dll", "MZ")),
+		testDir("swap", testFile("inner", "i")),
+	))
+
+	res := Diff(a, b, DiffOptions{})
+	type row struct {
+		path string
+		kind ChangeKind
+		what int
+	}
+	want := []row{
+		{"Windows/hosts", Modified, ChangedContent},
+		{"Windows/old.log", Removed, 0},
+		{"Windows/same.txt", Modified, ChangedAttributes},
+		{"Windows/new.dll", Added, 0},
+		{"Temp", Removed, 0},
+		{"Temp/x.tmp", Removed, 0},
+		{"swap", Removed, 0},
+		{"swap", Added, 0},
+		{"swap/inner", Added, 0},
+	}
+	if len(res.Changes) != len(want) {
+		t.Fatalf("got %d changes: %+v", len(res.Changes), res.Changes)
+	}
+	for i, c := range res.Changes {
+		if got := (row{c.Path, c.Kind, c.What}); got != want[i] {
+			t.Errorf("change %d = %+v want %+v", i, got, want[i])
+		}
+	}
+	if res.Added != 3 || res.Removed != 4 || res.Modified != 2 {
+		t.Fatalf("counts = %d/%d/%d", res.Added, res.Removed, res.Modified)
+	}
+	// +14 hosts, +2 new.dll, +1 inner; -5 old.log, -2 x.tmp, -4 swap.
+	if res.BytesAdded != 17 || res.BytesRemoved != 11 {
+		t.Fatalf("bytes = +%d -%d", res.BytesAdded, res.BytesRemoved)
+	}
+
+	res = Diff(a, b, DiffOptions{IgnoreAttributes: true})
+	for _, c := range res.Changes {
+		if c.Path == "Windows/same.txt" {
+			t.Fatal("attribute change reported with IgnoreAttributes")
+		}
+	}
+	if n := len(Diff(a, a, DiffOptions{}).Changes); n != 0 {
+		t.Fatalf("self diff has %d changes", n)
+	}
+}
+
+func TestDiffReparse(t *testing.T) {
+	a := openTestImage(t, testDir("", testSymlink("lib", "usr/lib", true), testSymlink("bin", "usr/bin", true)))
+	b := openTestImage(t, testDir("", testSymlink("lib", "usr/lib64", true), testSymlink("bin", "usr/bin", true)))
+	res := Diff(a, b, DiffOptions{})
+	if len(res.Changes) != 1 || res.Changes[0].Path != "lib" || res.Changes[0].What != ChangedReparse {
+		t.Fatalf("changes = %+v", res.Changes)
+	}
+}
+
+func TestFormatChanges(t *testing.T) {
+	if got := FormatChanges(ChangedContent | ChangedTimestamps); got != "content,timestamps" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestDiffStreams(t *testing.T) {
+	withStreams := func(name string, streams map[string]string) *testNode {
+		n := testFile(name, "data")
+		n.streams = map[string][]byte{}
+		for k, v := range streams {
+			n.streams[k] = []byte(v)
+		}
+		return n
+	}
+	a := openTestImage(t, testDir("",
+		withStreams("doc.txt", map[string]string{"Zone.Identifier": "ZoneId=3", "old": "gone"}),
+		withStreams("removed.txt", map[string]string{"meta": "abc"}),
+	))
+	b := openTestImage(t, testDir("",
+		withStreams("doc.txt", map[string]string{"zone.identifier": "ZoneId=4!", "new": "hi"}),
+		withStreams("added.txt", map[string]string{"meta": "abcdef"}),
+	))
+
+	res := Diff(a, b, DiffOptions{})
+	var doc, added, removed *Change
+	for i, c := range res.Changes {
+		switch c.Path {
+		case "doc.txt":
+			doc = &res.Changes[i]
+		case "added.txt":
+			added = &res.Changes[i]
+		case "removed.txt":
+			removed = &res.Changes[i]
+		}
+	}
+	if doc == nil || doc.What != ChangedStreams {
+		t.Fatalf("doc.txt change = %+v", doc)
+	}
+	want := []StreamChange{
+		{Name: "zone.identifier", Kind: Modified, SizeDelta: 1},
+		{Name: "old", Kind: Removed, SizeDelta: -4},
+		{Name: "new", Kind: Added, SizeDelta: 2},
+	}
+	if len(doc.Streams) != len(want) {
+		t.Fatalf("streams = %+v", doc.Streams)
+	}
+	for _, w := range want {
+		found := false
+		for _, s := range doc.Streams {
+			found = found || s == w
+		}
+		if !found {
+			t.Errorf("missing %+v in %+v", w, doc.Streams)
+		}
+	}
+	if added == nil || len(added.Streams) != 1 || added.Streams[0].Kind != Added {
+		t.Fatalf("added.txt change = %+v", added)
+	}
+	if removed == nil || len(removed.Streams) != 1 || removed.Streams[0].Kind != Removed {
+		t.Fatalf("removed.txt change = %+v", removed)
+	}
+	// +1 zone, +2 new, +4 added.txt, +6 meta; -4 old, -4 removed.txt, -3 meta.
+	if res.BytesAdded != 13 || res.BytesRemoved != 11 {
+		t.Fatalf("bytes = +%d -%d", res.BytesAdded, res.BytesRemoved)
+	}
+
+	res = Diff(a, b, DiffOptions{IgnoreStreams: true})
+	for _, c := range res.Changes {
+		if c.Path == "doc.txt" || len(c.Streams) > 0 {
+			t.Fatalf("stream change reported with IgnoreStreams: %+v", c)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package wim
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+type dirSource struct {
+	dir string
+}
+
+// fileID identifies a file with several hard links: device and inode, or
+// volume serial number and file index on Windows.
+type fileID struct {
+	dev, ino uint64
+}
+
+// NewDirSource captures the directory tree at dir. Attributes, creation
+// times, security descriptors and alternate data streams are read where
+// the platform exposes them, as VerifyAgainstDir does; otherwise attributes
+// come from the file mode. Reparse points such as symbolic links are
+// captured, not followed. Files with several hard links are captured once
+// and linked from their other paths. Device nodes, FIFOs and sockets are
+// skipped.
+func NewDirSource(dir string) CaptureSource {
+	return &dirSource{dir: dir}
+}
+
+func (s *dirSource) Walk(fn func(e *SourceEntry) error) error {
+	links := make(map[fileID]string)
+	return filepath.WalkDir(s.dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() && fi.Mode()&fs.ModeSymlink == 0 && !isLiveReparsePoint(fi) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+
+		e := &SourceEntry{LastWriteTime: fi.ModTime()}
+		if rel != "." {
+			e.Path = filepath.ToSlash(rel)
+		}
+		if fi.Mode().IsRegular() {
+			if id, ok := liveFileID(p, fi); ok {
+				if first, ok := links[id]; ok {
+					e.LinkTo = first
+				} else {
+					links[id] = e.Path
+				}
+			}
+		}
+		attrs, mask, err := liveAttributes(p, fi)
+		if err != nil {
+			return err
+		}
+		if mask == posixAttrMask {
+			attrs = modeAttributes(fi.Mode())
+		}
+		e.Attributes = attrs
+		e.CreationTime, e.LastAccessTime = liveTimes(p, fi)
+		if e.Security, err = liveSecurity(p); err != nil {
+			return err
+		}
+		if e.Streams, err = liveStreamNames(p); err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSymlink != 0 || isLiveReparsePoint(fi) {
+			if e.ReparseTag, e.ReparseData, err = liveReparsePoint(p); err != nil {
+				return err
+			}
+			if e.ReparseTag == ReparseTagSymlink && mask == posixAttrMask {
+				e.Attributes = AttrArchive
+				if st, err := os.Stat(p); err == nil && st.IsDir() {
+					e.Attributes = AttrDirectory
+				}
+			}
+			if fi.IsDir() {
+				// Don't descend into a directory reparse point.
+				if err := fn(e); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+		}
+		return skipDirOnly(fn(e), de.IsDir())
+	})
+}
+
+func (s *dirSource) Open(e *SourceEntry, stream string) (io.ReadCloser, error) {
+	p := filepath.Join(s.dir, filepath.FromSlash(e.Path))
+	if stream != "" {
+		return openLiveStream(p, stream)
+	}
+	if e.ReparseTag&reparseTagNameSurrogate != 0 {
+		// Links have no data of their own; opening one would follow it.
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return os.Open(p)
+}
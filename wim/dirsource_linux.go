@@ -0,0 +1,78 @@
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+	"golang.org/x/sys/unix"
+)
+
+// liveTimes returns the creation and last access times of path. The
+// creation time is ntfs-3g's when it offers one, else the birth time if the
+// file system records it, else the modification time.
+func liveTimes(path string, fi fs.FileInfo) (created, accessed time.Time) {
+	created, accessed = fi.ModTime(), fi.ModTime()
+	var stx unix.Statx_t
+	if unix.Statx(unix.AT_FDCWD, path, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_ATIME|unix.STATX_BTIME, &stx) == nil {
+		if stx.Mask&unix.STATX_ATIME != 0 {
+			accessed = time.Unix(stx.Atime.Sec, int64(stx.Atime.Nsec))
+		}
+		if stx.Mask&unix.STATX_BTIME != 0 {
+			created = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+		}
+	}
+	if b, err := getNTFSXattr(path, xattrNTFSCrtime); err == nil && len(b) == 8 {
+		created = wimgapi.FileTimeToTime(binary.BigEndian.Uint64(b))
+	}
+	return created, accessed
+}
+
+// liveStreamNames lists the alternate data streams ntfs-3g exposes as
+// user.* extended attributes, leaving out the fallbacks for NTFS metadata.
+func liveStreamNames(path string) ([]string, error) {
+	var buf []byte
+	for {
+		n, err := unix.Llistxattr(path, nil)
+		if xattrUnavailable(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = make([]byte, n)
+		n, err = unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+		break
+	}
+	var names []string
+	for _, attr := range strings.Split(string(buf), "\x00") {
+		if name, ok := strings.CutPrefix(attr, xattrStreamPrefix); ok && name != "" && !isUserXattrFallback(attr) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func isLiveReparsePoint(fi fs.FileInfo) bool {
+	return false
+}
+
+// liveReparsePoint describes the symbolic link at path as a Windows one.
+func liveReparsePoint(path string) (uint32, []byte, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ReparseTagSymlink, symlinkReparseData(target), nil
+}
@@ -0,0 +1,29 @@
+//go:build !windows && !linux
+
+package wim
+
+import (
+	"io/fs"
+	"os"
+	"time"
+)
+
+func liveTimes(path string, fi fs.FileInfo) (created, accessed time.Time) {
+	return fi.ModTime(), fi.ModTime()
+}
+
+func liveStreamNames(path string) ([]string, error) {
+	return nil, nil
+}
+
+func isLiveReparsePoint(fi fs.FileInfo) bool {
+	return false
+}
+
+func liveReparsePoint(path string) (uint32, []byte, error) {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ReparseTagSymlink, symlinkReparseData(target), nil
+}
@@ -0,0 +1,102 @@
+//go:build windows
+
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modKernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = modKernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modKernel32.NewProc("FindNextStreamW")
+)
+
+func liveTimes(path string, fi fs.FileInfo) (created, accessed time.Time) {
+	if d, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, d.CreationTime.Nanoseconds()), time.Unix(0, d.LastAccessTime.Nanoseconds())
+	}
+	return fi.ModTime(), fi.ModTime()
+}
+
+// win32FindStreamData is WIN32_FIND_STREAM_DATA.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// liveStreamNames lists the named $DATA streams of path.
+func liveStreamNames(path string) ([]string, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data win32FindStreamData
+	h, _, e := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(h) == windows.InvalidHandle {
+		if errors.Is(e, windows.ERROR_HANDLE_EOF) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("FindFirstStreamW %s: %w", path, e)
+	}
+	defer windows.FindClose(windows.Handle(h))
+
+	var names []string
+	for {
+		// Names look like ":name:$DATA"; the unnamed stream is "::$DATA".
+		name := windows.UTF16ToString(data.StreamName[:])
+		if n, ok := strings.CutSuffix(strings.TrimPrefix(name, ":"), ":$DATA"); ok && n != "" {
+			names = append(names, n)
+		}
+		r, _, e := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if r == 0 {
+			if errors.Is(e, windows.ERROR_HANDLE_EOF) {
+				return names, nil
+			}
+			return nil, fmt.Errorf("FindNextStreamW %s: %w", path, e)
+		}
+	}
+}
+
+func isLiveReparsePoint(fi fs.FileInfo) bool {
+	d, ok := fi.Sys().(*syscall.Win32FileAttributeData)
+	return ok && d.FileAttributes&windows.FILE_ATTRIBUTE_REPARSE_POINT != 0
+}
+
+// liveReparsePoint reads the reparse point of path as stored in a WIM:
+// its tag, and its data without the 8-byte header.
+func liveReparsePoint(path string) (uint32, []byte, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, nil, err
+	}
+	h, err := windows.CreateFile(p, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_OPEN_REPARSE_POINT|windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]byte, windows.MAXIMUM_REPARSE_DATA_BUFFER_SIZE)
+	var n uint32
+	if err := windows.DeviceIoControl(h, windows.FSCTL_GET_REPARSE_POINT, nil, 0, &buf[0], uint32(len(buf)), &n, nil); err != nil {
+		return 0, nil, err
+	}
+	if n < 8 {
+		return 0, nil, fmt.Errorf("%s: short reparse data", path)
+	}
+	size := int(binary.LittleEndian.Uint16(buf[4:6]))
+	if 8+size > int(n) {
+		return 0, nil, fmt.Errorf("%s: short reparse data", path)
+	}
+	return binary.LittleEndian.Uint32(buf[0:4]), buf[8 : 8+size], nil
+}
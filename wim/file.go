@@ -0,0 +1,175 @@
+// Package wim reads WIM files in pure Go, without wimgapi.dll, so WIM
+// tooling can run on any operating system.
+package wim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// File is an open WIM file. It is safe for concurrent use by multiple
+// goroutines once opened.
+type File struct {
+	r      io.ReaderAt
+	closer io.Closer
+	hdr    header
+	images []wimgapi.ImageInfo
+
+	blobs    map[Hash]*blobEntry
+	metadata []*blobEntry // one per image, in image order
+
+	cache     *ChunkCache
+	readAhead int // chunks
+}
+
+// OpenOptions tunes how a File reads resources.
+type OpenOptions struct {
+	// Cache holds decompressed chunks and may be shared by several Files;
+	// nil gives the File a cache of DefaultCacheSize of its own.
+	Cache *ChunkCache
+	// ReadAhead is how many chunks a stream decompresses in the background
+	// past the one being read; zero picks GOMAXPROCS and a negative value
+	// turns read-ahead off. It never takes more than half the cache.
+	ReadAhead int
+}
+
+type GUID [16]byte
+
+// String formats g like windows.GUID.String.
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}",
+		binary.LittleEndian.Uint32(g[0:4]),
+		binary.LittleEndian.Uint16(g[4:6]),
+		binary.LittleEndian.Uint16(g[6:8]),
+		g[8:10], g[10:16])
+}
+
+// Info describes a WIM file as a whole.
+type Info struct {
+	Version     uint32
+	Flags       uint32
+	ChunkSize   uint32
+	GUID        GUID
+	PartNumber  uint16
+	TotalParts  uint16
+	ImageCount  int
+	BootIndex   int
+	Compression wimgapi.Compression
+}
+
+func Open(path string) (*File, error) {
+	return OpenWith(path, OpenOptions{})
+}
+
+// OpenWith is Open with options.
+func OpenWith(path string, opts OpenOptions) (*File, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := NewFileWith(fh, opts)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+	f.closer = fh
+	return f, nil
+}
+
+// NewFile reads the WIM header, blob table and XML data from r.
+func NewFile(r io.ReaderAt) (*File, error) {
+	return NewFileWith(r, OpenOptions{})
+}
+
+// NewFileWith is NewFile with options.
+func NewFileWith(r io.ReaderAt, opts OpenOptions) (*File, error) {
+	buf := make([]byte, headerSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		if err == io.EOF {
+			return nil, ErrNotWIM
+		}
+		return nil, err
+	}
+	hdr, err := parseHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.TotalParts > 1 {
+		return nil, ErrSpanned
+	}
+
+	f := &File{r: r, hdr: hdr, cache: opts.Cache, readAhead: opts.ReadAhead}
+	if f.cache == nil {
+		f.cache = NewChunkCache(DefaultCacheSize)
+	}
+	if f.readAhead == 0 {
+		f.readAhead = runtime.GOMAXPROCS(0)
+	}
+	if err := f.readBlobTable(); err != nil {
+		return nil, err
+	}
+	if err := f.readXML(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) Close() error {
+	f.cache.forget(f)
+	if f.closer == nil {
+		return nil
+	}
+	return f.closer.Close()
+}
+
+func (f *File) Info() Info {
+	return Info{
+		Version:     f.hdr.Version,
+		Flags:       f.hdr.Flags,
+		ChunkSize:   f.hdr.ChunkSize,
+		GUID:        f.hdr.GUID,
+		PartNumber:  f.hdr.PartNumber,
+		TotalParts:  f.hdr.TotalParts,
+		ImageCount:  int(f.hdr.ImageCount),
+		BootIndex:   int(f.hdr.BootIndex),
+		Compression: f.hdr.compression(),
+	}
+}
+
+func (f *File) ImageCount() int {
+	return int(f.hdr.ImageCount)
+}
+
+// Images returns the per-image metadata recorded in the WIM's XML data.
+func (f *File) Images() []wimgapi.ImageInfo {
+	out := make([]wimgapi.ImageInfo, len(f.images))
+	copy(out, f.images)
+	return out
+}
+
+func (f *File) readXML() error {
+	res := f.hdr.XMLData
+	if res.Size == 0 {
+		return nil
+	}
+	if res.compressed() {
+		return fmt.Errorf("wim: compressed XML data is not supported")
+	}
+	raw := make([]byte, res.Size)
+	if _, err := f.r.ReadAt(raw, int64(res.Offset)); err != nil {
+		return fmt.Errorf("wim: read XML data: %w", err)
+	}
+
+	images, err := wimgapi.ParseImageInfoXML(strings.TrimSpace(wimgapi.DecodeUTF16Bytes(raw)))
+	if err != nil {
+		return fmt.Errorf("wim: parse XML data: %w", err)
+	}
+	f.images = images
+	return nil
+}
@@ -0,0 +1,71 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func encodeUTF16XML(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2+2*len(u))
+	binary.LittleEndian.PutUint16(b, 0xFEFF)
+	for i, v := range u {
+		binary.LittleEndian.PutUint16(b[2+2*i:], v)
+	}
+	return b
+}
+
+// headerOnlyWIM builds a WIM with a header and XML data but no images.
+func headerOnlyWIM(xmlText string, imageCount uint32) []byte {
+	xmlData := encodeUTF16XML(xmlText)
+	hdr := header{
+		Version:    wimVersion,
+		Flags:      FlagCompression | FlagCompressLZX,
+		ChunkSize:  defaultChunk,
+		PartNumber: 1,
+		TotalParts: 1,
+		ImageCount: imageCount,
+		XMLData: resourceHeader{
+			Size:             uint64(len(xmlData)),
+			Offset:           headerSize,
+			UncompressedSize: uint64(len(xmlData)),
+		},
+	}
+	buf := make([]byte, headerSize)
+	hdr.put(buf)
+	return append(buf, xmlData...)
+}
+
+func TestNewFileReadsHeaderAndXML(t *testing.T) {
+	data := headerOnlyWIM(`<WIM><IMAGE INDEX="1"><NAME>Base</NAME></IMAGE><IMAGE INDEX="2"><NAME>Pro</NAME></IMAGE></WIM>`, 2)
+	f, err := NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := f.Info()
+	if info.ImageCount != 2 || info.Compression != wimgapi.CompressLZX || info.ChunkSize != defaultChunk {
+		t.Fatalf("info = %+v", info)
+	}
+	images := f.Images()
+	if len(images) != 2 || images[1].Name != "Pro" {
+		t.Fatalf("images = %+v", images)
+	}
+}
+
+func TestNewFileRejectsNonWIM(t *testing.T) {
+	_, err := NewFile(bytes.NewReader([]byte("not a wim")))
+	if !errors.Is(err, ErrNotWIM) {
+		t.Fatalf("err = %v want ErrNotWIM", err)
+	}
+
+	data := headerOnlyWIM("<WIM/>", 0)
+	binary.LittleEndian.PutUint32(data[12:16], 0x1234)
+	if _, err := NewFile(bytes.NewReader(data)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("err = %v want ErrUnsupportedVersion", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package wim
+
+import "io/fs"
+
+func liveFileID(path string, fi fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
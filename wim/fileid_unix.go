@@ -0,0 +1,18 @@
+//go:build unix
+
+package wim
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// liveFileID identifies the file behind fi when it has more than one
+// hard link.
+func liveFileID(path string, fi fs.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, true
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package wim
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/windows"
+)
+
+// liveFileID identifies the file at path by volume and file index when it
+// has more than one hard link.
+func liveFileID(path string, fi fs.FileInfo) (fileID, bool) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return fileID{}, false
+	}
+	h, err := windows.CreateFile(p, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS|windows.FILE_FLAG_OPEN_REPARSE_POINT, 0)
+	if err != nil {
+		return fileID{}, false
+	}
+	defer windows.CloseHandle(h)
+	var info windows.ByHandleFileInformation
+	if windows.GetFileInformationByHandle(h, &info) != nil || info.NumberOfLinks < 2 {
+		return fileID{}, false
+	}
+	return fileID{uint64(info.VolumeSerialNumber), uint64(info.FileIndexHigh)<<32 | uint64(info.FileIndexLow)}, true
+}
@@ -0,0 +1,124 @@
+package wim
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// FS returns the image as an fs.FS. A name like "dir/file:name" opens a
+// named data stream, which is a regular file whatever its dentry is. The
+// files implement io.ReaderAt and io.Seeker, and the Sys method of their
+// FileInfo returns the *Dentry, whose Streams lists the named streams.
+func (img *Image) FS() fs.FS {
+	return imageFS{img}
+}
+
+type imageFS struct {
+	img *Image
+}
+
+func (fsys imageFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	file, stream, named := cutStream(name)
+	d := fsys.img.root
+	if file != "." {
+		// Unlike Lookup, only / separates names in an fs.FS.
+		for _, elem := range strings.Split(file, "/") {
+			if d = d.Child(elem); d == nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+	base := d.Name
+	if d == fsys.img.root {
+		base = "."
+	}
+	if d.IsDir() && !named {
+		return &fsDir{info: fileInfo{name: base, d: d}, path: name}, nil
+	}
+	s, ok := d.Stream(stream)
+	if !ok || (named && stream == "") {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	r, err := fsys.img.f.openBlob(s.Hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if named {
+		base += ":" + s.Name
+	}
+	return &fsFile{StreamReader: r, info: fileInfo{name: base, d: d, stream: named, size: int64(s.Size)}}, nil
+}
+
+type fsFile struct {
+	*StreamReader
+	info fileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+type fsDir struct {
+	info fileInfo
+	path string
+	off  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.path, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	children := d.info.d.Children[d.off:]
+	if n > 0 {
+		if len(children) == 0 {
+			return nil, io.EOF
+		}
+		children = children[:min(n, len(children))]
+	}
+	entries := make([]fs.DirEntry, len(children))
+	for i, c := range children {
+		entries[i] = fs.FileInfoToDirEntry(fileInfo{name: c.Name, d: c, size: int64(c.Size())})
+	}
+	d.off += len(children)
+	return entries, nil
+}
+
+// fileInfo describes a dentry, or one of its named streams.
+type fileInfo struct {
+	name   string
+	d      *Dentry
+	stream bool
+	size   int64
+}
+
+func (fi fileInfo) Name() string       { return fi.name }
+func (fi fileInfo) ModTime() time.Time { return fi.d.LastWriteTime }
+func (fi fileInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi fileInfo) Sys() any           { return fi.d }
+
+func (fi fileInfo) Size() int64 {
+	if fi.IsDir() {
+		return 0
+	}
+	return fi.size
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(0o644)
+	if !fi.stream && fi.d.IsDir() {
+		mode = fs.ModeDir | 0o755
+	}
+	if fi.d.Attributes&AttrReadOnly != 0 {
+		mode &^= 0o222
+	}
+	return mode
+}
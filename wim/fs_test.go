@@ -0,0 +1,58 @@
+package wim
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImageFS(t *testing.T) {
+	img := testImage(t)
+	fsys := img.FS()
+	if err := fstest.TestFS(fsys, "Windows/System32/drivers/etc/hosts", "bootmgr", "empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	const zone = "Windows/System32/drivers/etc/hosts:Zone.Identifier"
+	data, err := fs.ReadFile(fsys, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[ZoneTransfer]\r\nZoneId=3\r\n" {
+		t.Fatalf("stream = %q", data)
+	}
+	fi, err := fs.Stat(fsys, zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "hosts:Zone.Identifier" || fi.Size() != int64(len(data)) || !fi.Mode().IsRegular() {
+		t.Fatalf("stat = %s %d %v", fi.Name(), fi.Size(), fi.Mode())
+	}
+	if d := fi.Sys().(*Dentry); len(d.Streams) != 1 {
+		t.Fatalf("streams = %+v", d.Streams)
+	}
+	if got := readAll(t)(img.Open(zone)); got != string(data) {
+		t.Fatalf("Image.Open stream = %q", got)
+	}
+
+	// Capturing the FS keeps the stream.
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	copied, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t)(copied.Open(zone)); got != string(data) {
+		t.Fatalf("captured stream = %q", got)
+	}
+
+	for _, name := range []string{"bootmgr:missing", "bootmgr:", "Windows/missing"} {
+		if _, err := fsys.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("open %s: err = %v want fs.ErrNotExist", name, err)
+		}
+	}
+}
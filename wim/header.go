@@ -0,0 +1,175 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+const (
+	headerSize   = 208
+	reshdrSize   = 24
+	wimVersion   = 0x10D00
+	solidVersion = 0xE00
+	defaultChunk = 32768
+)
+
+var wimMagic = [8]byte{'M', 'S', 'W', 'I', 'M', 0, 0, 0}
+
+// Header flags.
+const (
+	FlagCompression     = 0x00000002
+	FlagReadOnly        = 0x00000004
+	FlagSpanned         = 0x00000008
+	FlagResourceOnly    = 0x00000010
+	FlagMetadataOnly    = 0x00000020
+	FlagWriteInProgress = 0x00000040
+	FlagRPFix           = 0x00000080
+	FlagCompressXPRESS  = 0x00020000
+	FlagCompressLZX     = 0x00040000
+	FlagCompressLZMS    = 0x00080000
+	FlagCompressXPRESS2 = 0x00200000
+)
+
+// Resource header flags.
+const (
+	resFlagFree       = 0x01
+	resFlagMetadata   = 0x02
+	resFlagCompressed = 0x04
+	resFlagSpanned    = 0x08
+	resFlagSolid      = 0x10
+)
+
+var (
+	ErrNotWIM             = errors.New("wim: not a WIM file")
+	ErrUnsupportedVersion = errors.New("wim: unsupported WIM version")
+	ErrSpanned            = errors.New("wim: spanned (split) WIMs are not supported")
+	ErrUnsupportedFormat  = errors.New("wim: unsupported compression format")
+)
+
+// resourceHeader locates a resource inside the WIM file.
+type resourceHeader struct {
+	Size             uint64 // stored size, 56 bits on disk
+	Flags            uint8
+	Offset           uint64
+	UncompressedSize uint64
+}
+
+func parseResourceHeader(b []byte) resourceHeader {
+	v := binary.LittleEndian.Uint64(b[0:8])
+	return resourceHeader{
+		Size:             v & 0x00FFFFFFFFFFFFFF,
+		Flags:            uint8(v >> 56),
+		Offset:           binary.LittleEndian.Uint64(b[8:16]),
+		UncompressedSize: binary.LittleEndian.Uint64(b[16:24]),
+	}
+}
+
+func (h resourceHeader) put(b []byte) {
+	binary.LittleEndian.PutUint64(b[0:8], h.Size&0x00FFFFFFFFFFFFFF|uint64(h.Flags)<<56)
+	binary.LittleEndian.PutUint64(b[8:16], h.Offset)
+	binary.LittleEndian.PutUint64(b[16:24], h.UncompressedSize)
+}
+
+func (h resourceHeader) compressed() bool {
+	return h.Flags&resFlagCompressed != 0
+}
+
+type header struct {
+	Version      uint32
+	Flags        uint32
+	ChunkSize    uint32
+	GUID         GUID
+	PartNumber   uint16
+	TotalParts   uint16
+	ImageCount   uint32
+	LookupTable  resourceHeader
+	XMLData      resourceHeader
+	BootMetadata resourceHeader
+	BootIndex    uint32
+	Integrity    resourceHeader
+}
+
+func parseHeader(b []byte) (header, error) {
+	if len(b) < headerSize || !bytes.Equal(b[:8], wimMagic[:]) {
+		return header{}, ErrNotWIM
+	}
+	if size := binary.LittleEndian.Uint32(b[8:12]); size != headerSize {
+		return header{}, fmt.Errorf("wim: unexpected header size %d", size)
+	}
+
+	var h header
+	h.Version = binary.LittleEndian.Uint32(b[12:16])
+	h.Flags = binary.LittleEndian.Uint32(b[16:20])
+	h.ChunkSize = binary.LittleEndian.Uint32(b[20:24])
+	copy(h.GUID[:], b[24:40])
+	h.PartNumber = binary.LittleEndian.Uint16(b[40:42])
+	h.TotalParts = binary.LittleEndian.Uint16(b[42:44])
+	h.ImageCount = binary.LittleEndian.Uint32(b[44:48])
+	h.LookupTable = parseResourceHeader(b[48:72])
+	h.XMLData = parseResourceHeader(b[72:96])
+	h.BootMetadata = parseResourceHeader(b[96:120])
+	h.BootIndex = binary.LittleEndian.Uint32(b[120:124])
+	h.Integrity = parseResourceHeader(b[124:148])
+
+	if h.Version != wimVersion && h.Version != solidVersion {
+		return header{}, fmt.Errorf("%w: 0x%X", ErrUnsupportedVersion, h.Version)
+	}
+	if h.ChunkSize == 0 {
+		h.ChunkSize = defaultChunk
+	}
+	return h, nil
+}
+
+func (h header) put(b []byte) {
+	copy(b[0:8], wimMagic[:])
+	binary.LittleEndian.PutUint32(b[8:12], headerSize)
+	binary.LittleEndian.PutUint32(b[12:16], h.Version)
+	binary.LittleEndian.PutUint32(b[16:20], h.Flags)
+	binary.LittleEndian.PutUint32(b[20:24], h.ChunkSize)
+	copy(b[24:40], h.GUID[:])
+	binary.LittleEndian.PutUint16(b[40:42], h.PartNumber)
+	binary.LittleEndian.PutUint16(b[42:44], h.TotalParts)
+	binary.LittleEndian.PutUint32(b[44:48], h.ImageCount)
+	h.LookupTable.put(b[48:72])
+	h.XMLData.put(b[72:96])
+	h.BootMetadata.put(b[96:120])
+	binary.LittleEndian.PutUint32(b[120:124], h.BootIndex)
+	h.Integrity.put(b[124:148])
+}
+
+// compressUnknown stands for header flags that name no known format.
+const compressUnknown = wimgapi.Compression(0xFFFFFFFF)
+
+func (h header) compression() wimgapi.Compression {
+	if h.Flags&FlagCompression == 0 {
+		return wimgapi.CompressNone
+	}
+	switch {
+	case h.Flags&FlagCompressLZMS != 0:
+		return wimgapi.CompressLZMS
+	case h.Flags&FlagCompressLZX != 0:
+		return wimgapi.CompressLZX
+	case h.Flags&FlagCompressXPRESS != 0, h.Flags&FlagCompressXPRESS2 != 0:
+		return wimgapi.CompressXPRESS
+	default:
+		return compressUnknown
+	}
+}
+
+// compressionFlags are the header flags that select c.
+func compressionFlags(c wimgapi.Compression) uint32 {
+	switch c {
+	case wimgapi.CompressXPRESS:
+		return FlagCompression | FlagCompressXPRESS
+	case wimgapi.CompressLZX:
+		return FlagCompression | FlagCompressLZX
+	case wimgapi.CompressLZMS:
+		return FlagCompression | FlagCompressLZMS
+	default:
+		return 0
+	}
+}
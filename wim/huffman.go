@@ -0,0 +1,213 @@
+package wim
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+var errCorruptChunk = errors.New("wim: corrupt compressed chunk")
+
+const huffmanTableBits = 10
+
+// huffmanDecoder decodes canonical Huffman codes read MSB-first, the
+// convention shared by XPRESS and LZX. Codes no longer than
+// huffmanTableBits resolve through a single table lookup; longer ones fall
+// back to a canonical walk.
+type huffmanDecoder struct {
+	width     uint // bits passed to decode
+	tableBits uint
+	maxLen    uint
+	table     []uint16 // symbol<<5 | length, 0 = no code
+	first     [33]uint32
+	count     [33]uint32
+	offset    [33]uint32
+	sorted    []uint16
+}
+
+// init builds the decoder from per-symbol code lengths. width is the number
+// of look-ahead bits the caller supplies to decode and must be >= the longest
+// code. Incomplete codes are accepted; over-subscribed ones are not.
+func (h *huffmanDecoder) init(lens []uint8, width uint) error {
+	h.width = width
+	h.maxLen = 0
+	h.count = [33]uint32{}
+	for _, l := range lens {
+		if uint(l) > width {
+			return errCorruptChunk
+		}
+		h.count[l]++
+		if uint(l) > h.maxLen {
+			h.maxLen = uint(l)
+		}
+	}
+	h.count[0] = 0
+
+	var code uint32
+	var left uint32 = 1
+	var idx uint32
+	for l := uint(1); l <= h.maxLen; l++ {
+		code <<= 1
+		left <<= 1
+		if h.count[l] > left {
+			return errCorruptChunk
+		}
+		left -= h.count[l]
+		h.first[l] = code
+		h.offset[l] = idx
+		code += h.count[l]
+		idx += h.count[l]
+	}
+
+	if cap(h.sorted) < int(idx) {
+		h.sorted = make([]uint16, idx)
+	}
+	h.sorted = h.sorted[:idx]
+	var next [33]uint32
+	copy(next[:], h.offset[:])
+	for sym, l := range lens {
+		if l != 0 {
+			h.sorted[next[l]] = uint16(sym)
+			next[l]++
+		}
+	}
+
+	h.tableBits = min(h.maxLen, huffmanTableBits)
+	size := 1 << h.tableBits
+	if cap(h.table) < size {
+		h.table = make([]uint16, size)
+	}
+	h.table = h.table[:size]
+	clear(h.table)
+	for l := uint(1); l <= h.tableBits; l++ {
+		for i := uint32(0); i < h.count[l]; i++ {
+			sym := h.sorted[h.offset[l]+i]
+			start := (h.first[l] + i) << (h.tableBits - l)
+			end := start + 1<<(h.tableBits-l)
+			entry := sym<<5 | uint16(l)
+			for j := start; j < end; j++ {
+				h.table[j] = entry
+			}
+		}
+	}
+	return nil
+}
+
+// decode returns the symbol whose code prefixes v, the next h.width bits of
+// input right-aligned, and the code length. ok is false for unassigned codes.
+func (h *huffmanDecoder) decode(v uint32) (sym uint16, n uint, ok bool) {
+	if h.maxLen == 0 {
+		return 0, 0, false
+	}
+	if e := h.table[v>>(h.width-h.tableBits)]; e != 0 {
+		return e >> 5, uint(e & 31), true
+	}
+	for l := h.tableBits + 1; l <= h.maxLen; l++ {
+		code := v >> (h.width - l)
+		if d := code - h.first[l]; d < h.count[l] {
+			return h.sorted[h.offset[l]+d], l, true
+		}
+	}
+	return 0, 0, false
+}
+
+// huffmanLengths sets lens to Huffman code lengths for freqs, none longer
+// than maxLen. Unused symbols get no code, except that with fewer than two
+// used symbols the first two get 1-bit codes: decoders in Windows expect a
+// complete code.
+func huffmanLengths(freqs []uint32, lens []uint8, maxLen int) {
+	clear(lens)
+	syms := make([]int, 0, len(freqs))
+	for sym, f := range freqs {
+		if f != 0 {
+			syms = append(syms, sym)
+		}
+	}
+	if len(syms) < 2 {
+		lens[0], lens[1] = 1, 1
+		if len(syms) == 1 && syms[0] > 1 {
+			lens[1], lens[syms[0]] = 0, 1
+		}
+		return
+	}
+	slices.SortStableFunc(syms, func(a, b int) int { return cmp.Compare(freqs[a], freqs[b]) })
+
+	// Two-queue construction: leaves are taken in frequency order and
+	// internal nodes are created in nondecreasing weight order.
+	n := len(syms)
+	weight := make([]uint64, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, sym := range syms {
+		weight[i] = uint64(freqs[sym])
+	}
+	leaf, inner := 0, n
+	pick := func(k int) int {
+		if leaf < n && (inner >= k || weight[leaf] <= weight[inner]) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for k := n; k < 2*n-1; k++ {
+		a, b := pick(k), pick(k)
+		weight[k] = weight[a] + weight[b]
+		parent[a], parent[b] = k, k
+	}
+	depth := make([]int, 2*n-1)
+	var count [33]int
+	for k := 2*n - 3; k >= 0; k-- {
+		depth[k] = depth[parent[k]] + 1
+		if k < n {
+			count[min(depth[k], maxLen)]++
+		}
+	}
+
+	// Clamping lengths to maxLen over-subscribes the code; move leaves down
+	// from shorter lengths until the Kraft sum fits again.
+	total := 0
+	for l := 1; l <= maxLen; l++ {
+		total += count[l] << (maxLen - l)
+	}
+	for total > 1<<maxLen {
+		count[maxLen]--
+		for l := maxLen - 1; l > 0; l-- {
+			if count[l] != 0 {
+				count[l]--
+				count[l+1] += 2
+				break
+			}
+		}
+		total--
+	}
+
+	// The rarest symbols get the longest codes.
+	i := 0
+	for l := maxLen; l > 0; l-- {
+		for ; count[l] > 0; count[l]-- {
+			lens[syms[i]] = uint8(l)
+			i++
+		}
+	}
+}
+
+// huffmanCodes assigns the canonical codes for lens: shorter codes first
+// and, within a length, in symbol order, matching huffmanDecoder.
+func huffmanCodes(lens []uint8, codes []uint32) {
+	var count, next [33]uint32
+	for _, l := range lens {
+		count[l]++
+	}
+	count[0] = 0
+	var code uint32
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for sym, l := range lens {
+		if l != 0 {
+			codes[sym] = next[l]
+			next[l]++
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package wim
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestHuffmanLengths(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	fib := make([]uint32, 40) // Fibonacci weights give the deepest trees
+	fib[0], fib[1] = 1, 1
+	for i := 2; i < len(fib); i++ {
+		fib[i] = fib[i-1] + fib[i-2]
+	}
+	random := make([]uint32, 512)
+	for i := range random {
+		if rng.Intn(3) > 0 {
+			random[i] = uint32(rng.Intn(1000))
+		}
+	}
+	tests := map[string][]uint32{
+		"none":      make([]uint32, 8),
+		"one":       {0, 0, 5, 0},
+		"first":     {9, 0, 0},
+		"two":       {1, 1000},
+		"fibonacci": fib,
+		"random":    random,
+	}
+	for name, freqs := range tests {
+		for _, maxLen := range []int{9, 15, 16} {
+			lens := make([]uint8, len(freqs))
+			huffmanLengths(freqs, lens, maxLen)
+
+			used, kraft := 0, 0
+			for sym, l := range lens {
+				if int(l) > maxLen {
+					t.Fatalf("%s/%d: symbol %d has length %d", name, maxLen, sym, l)
+				}
+				if freqs[sym] != 0 && l == 0 {
+					t.Fatalf("%s/%d: used symbol %d has no code", name, maxLen, sym)
+				}
+				if l != 0 {
+					used++
+					kraft += 1 << (maxLen - int(l))
+				}
+			}
+			if kraft != 1<<maxLen {
+				t.Errorf("%s/%d: code is not complete (%d/%d)", name, maxLen, kraft, 1<<maxLen)
+			}
+
+			var h huffmanDecoder
+			if err := h.init(lens, uint(maxLen)); err != nil {
+				t.Fatalf("%s/%d: %v", name, maxLen, err)
+			}
+			codes := make([]uint32, len(lens))
+			huffmanCodes(lens, codes)
+			for sym, l := range lens {
+				if l == 0 {
+					continue
+				}
+				got, n, ok := h.decode(codes[sym] << (maxLen - int(l)))
+				if !ok || int(got) != sym || n != uint(l) {
+					t.Fatalf("%s/%d: code for %d decodes as %d/%d", name, maxLen, sym, got, n)
+				}
+			}
+		}
+	}
+}
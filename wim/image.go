@@ -0,0 +1,214 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+var (
+	ErrImageNotFound = errors.New("wim: no such image")
+	ErrBlobNotFound  = errors.New("wim: stream not in blob table")
+)
+
+// Image is the parsed metadata resource of one image: its dentry tree and
+// security descriptors.
+type Image struct {
+	f        *File
+	index    int
+	root     *Dentry
+	security [][]byte
+}
+
+// Image parses the metadata of the image with the given 1-based index.
+func (f *File) Image(index int) (*Image, error) {
+	if index < 1 || index > len(f.metadata) {
+		return nil, fmt.Errorf("image %d: %w", index, ErrImageNotFound)
+	}
+	buf, err := f.readResource(f.metadata[index-1].res)
+	if err != nil {
+		return nil, fmt.Errorf("wim: image %d metadata: %w", index, err)
+	}
+
+	security, rootOff, err := parseSecurityData(buf)
+	if err != nil {
+		return nil, err
+	}
+	p := &metadataParser{buf: buf, blobs: f.blobs, seen: make(map[uint64]bool)}
+	root, _, err := p.readDentry(rootOff)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, p.errorf(rootOff, "missing root directory")
+	}
+	root.Name = ""
+	if root.subdir != 0 {
+		if err := p.readDir(root.Dentry, root.subdir, 1); err != nil {
+			return nil, err
+		}
+	}
+	return &Image{f: f, index: index, root: root.Dentry, security: security}, nil
+}
+
+// parseSecurityData splits the security data at the start of a metadata
+// resource into descriptors and returns the offset of the root dentry.
+func parseSecurityData(buf []byte) ([][]byte, uint64, error) {
+	if len(buf) < 8 {
+		return nil, 0, errors.New("wim: metadata resource too short")
+	}
+	total := uint64(binary.LittleEndian.Uint32(buf[0:4]))
+	count := uint64(binary.LittleEndian.Uint32(buf[4:8]))
+	if total == 0 {
+		total = 8
+	}
+	if total > uint64(len(buf)) || 8+count*8 > total {
+		return nil, 0, fmt.Errorf("wim: corrupt security data (length %d, %d entries)", total, count)
+	}
+
+	sds := make([][]byte, count)
+	off := 8 + count*8
+	for i := range sds {
+		size := binary.LittleEndian.Uint64(buf[8+i*8:])
+		if size > total-off {
+			return nil, 0, fmt.Errorf("wim: security descriptor %d exceeds security data", i)
+		}
+		sds[i] = buf[off : off+size]
+		off += size
+	}
+	return sds, align8(total), nil
+}
+
+func (img *Image) Index() int {
+	return img.index
+}
+
+func (img *Image) Root() *Dentry {
+	return img.root
+}
+
+// Info returns the image's entry in the WIM's XML data.
+func (img *Image) Info() wimgapi.ImageInfo {
+	for _, info := range img.f.images {
+		if info.Index == img.index {
+			return info
+		}
+	}
+	return wimgapi.ImageInfo{Index: img.index}
+}
+
+// SecurityDescriptor returns the raw self-relative descriptor for a dentry's
+// SecurityID, or nil if it has none.
+func (img *Image) SecurityDescriptor(id int32) []byte {
+	if id < 0 || int(id) >= len(img.security) {
+		return nil
+	}
+	return img.security[id]
+}
+
+// Lookup finds the dentry at path. Both / and \ separate components, and
+// leading separators are ignored.
+func (img *Image) Lookup(path string) (*Dentry, error) {
+	d := img.root
+	for _, name := range splitPath(path) {
+		next := d.Child(name)
+		if next == nil {
+			return nil, &fs.PathError{Op: "lookup", Path: path, Err: fs.ErrNotExist}
+		}
+		d = next
+	}
+	return d, nil
+}
+
+func splitPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' })
+}
+
+// Walk calls fn for every dentry below and including the root, parents
+// before children. Returning fs.SkipDir from fn skips a directory's
+// contents.
+func (img *Image) Walk(fn func(path string, d *Dentry) error) error {
+	err := walkDentry(img.root, "", fn)
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDentry(d *Dentry, path string, fn func(string, *Dentry) error) error {
+	if err := fn(path, d); err != nil {
+		if err == fs.SkipDir && d.IsDir() {
+			return nil
+		}
+		return err
+	}
+	for _, c := range d.Children {
+		p := c.Name
+		if path != "" {
+			p = path + "/" + c.Name
+		}
+		if err := walkDentry(c, p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Open returns the unnamed data stream of the file at path, or with a
+// path like "file:name" its named stream.
+func (img *Image) Open(path string) (*StreamReader, error) {
+	file, stream, named := cutStream(path)
+	if named && stream == "" {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+	}
+	d, err := img.Lookup(file)
+	if err != nil {
+		return nil, err
+	}
+	if d.IsDir() && !named {
+		return nil, &fs.PathError{Op: "open", Path: path, Err: errors.New("is a directory")}
+	}
+	return img.OpenStream(d, stream)
+}
+
+// cutStream splits "dir/file:name" into the file path and the stream
+// name. Windows does not allow ':' in file names, so the first one in the
+// last component starts the stream name.
+func cutStream(path string) (file, stream string, named bool) {
+	base := strings.LastIndexAny(path, `/\`) + 1
+	i := strings.IndexByte(path[base:], ':')
+	if i < 0 {
+		return path, "", false
+	}
+	return path[:base+i], path[base+i+1:], true
+}
+
+// OpenStream returns the contents of d's stream with the given name; "" is
+// the unnamed data stream.
+func (img *Image) OpenStream(d *Dentry, name string) (*StreamReader, error) {
+	s, ok := d.Stream(name)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: d.Path() + ":" + name, Err: fs.ErrNotExist}
+	}
+	return img.f.openBlob(s.Hash)
+}
+
+// openBlob returns the contents of the blob with hash h.
+func (f *File) openBlob(h Hash) (*StreamReader, error) {
+	if h.IsZero() {
+		return &StreamReader{ra: bytes.NewReader(nil)}, nil
+	}
+	e, ok := f.blobs[h]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, h)
+	}
+	if e.solid != nil {
+		return f.openSolid(e.solid, e.res.Offset, e.res.UncompressedSize)
+	}
+	return f.openResource(e.res)
+}
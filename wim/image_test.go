@@ -0,0 +1,324 @@
+package wim
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+type testNode struct {
+	name       string
+	attr       uint32
+	data       []byte
+	streams    map[string][]byte
+	children   []*testNode
+	linkGroup  uint64
+	reparseTag uint32
+	reparse    []byte
+}
+
+func testDir(name string, children ...*testNode) *testNode {
+	return &testNode{name: name, attr: AttrDirectory, children: children}
+}
+
+func testFile(name, data string) *testNode {
+	return &testNode{name: name, attr: AttrArchive, data: []byte(data)}
+}
+
+// testSymlink builds a symlink reparse point as WIM stores it.
+func testSymlink(name, target string, relative bool) *testNode {
+	sub := utf16Bytes(target)
+	data := make([]byte, 12, 12+2*len(sub))
+	binary.LittleEndian.PutUint16(data[0:], 0)
+	binary.LittleEndian.PutUint16(data[2:], uint16(len(sub)))
+	binary.LittleEndian.PutUint16(data[4:], uint16(len(sub)))
+	binary.LittleEndian.PutUint16(data[6:], uint16(len(sub)))
+	if relative {
+		binary.LittleEndian.PutUint32(data[8:], symlinkFlagRelative)
+	}
+	data = append(data, sub...)
+	data = append(data, sub...)
+	return &testNode{name: name, attr: AttrArchive | AttrReparsePoint, reparseTag: ReparseTagSymlink, reparse: data}
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// testWIMBuilder lays out an uncompressed WIM: header, blobs, metadata,
+// blob table, XML.
+type testWIMBuilder struct {
+	buf   []byte
+	table []byte
+	seen  map[Hash]bool
+}
+
+func (b *testWIMBuilder) addResource(data []byte, flags uint8) Hash {
+	h := Hash(sha1.Sum(data))
+	if flags&resFlagMetadata == 0 {
+		if len(data) == 0 || b.seen[h] {
+			return h
+		}
+		b.seen[h] = true
+	}
+	res := resourceHeader{Size: uint64(len(data)), Flags: flags, Offset: uint64(len(b.buf)), UncompressedSize: uint64(len(data))}
+	b.buf = append(b.buf, data...)
+	entry := make([]byte, blobEntrySize)
+	res.put(entry)
+	binary.LittleEndian.PutUint16(entry[24:], 1)
+	binary.LittleEndian.PutUint32(entry[26:], 1)
+	copy(entry[30:], h[:])
+	b.table = append(b.table, entry...)
+	return h
+}
+
+func (b *testWIMBuilder) dentry(n *testNode) []byte {
+	name := utf16Bytes(n.name)
+	length := dentryDiskSize
+	if len(name) > 0 {
+		length += len(name) + 2
+	}
+	d := make([]byte, align8(uint64(length)))
+	binary.LittleEndian.PutUint64(d[0:], uint64(length))
+	binary.LittleEndian.PutUint32(d[8:], n.attr)
+	binary.LittleEndian.PutUint32(d[12:], 0)
+	for i := 0; i < 3; i++ {
+		binary.LittleEndian.PutUint64(d[40+8*i:], 133500000000000000+uint64(i))
+	}
+	numStreams := len(n.streams)
+	if n.reparseTag != 0 {
+		// The default hash holds the reparse data; file data, if any,
+		// moves to an unnamed extra stream.
+		h := b.addResource(n.reparse, 0)
+		copy(d[64:84], h[:])
+		binary.LittleEndian.PutUint32(d[88:], n.reparseTag)
+		if len(n.data) > 0 {
+			numStreams++
+		}
+	} else {
+		h := b.addResource(n.data, 0)
+		if len(n.data) > 0 {
+			copy(d[64:84], h[:])
+		}
+		binary.LittleEndian.PutUint64(d[88:], n.linkGroup)
+	}
+	binary.LittleEndian.PutUint16(d[96:], uint16(numStreams))
+	binary.LittleEndian.PutUint16(d[100:], uint16(len(name)))
+	copy(d[dentryDiskSize:], name)
+
+	names := make([]string, 0, len(n.streams))
+	for k := range n.streams {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	if n.reparseTag != 0 && len(n.data) > 0 {
+		names = append([]string{""}, names...)
+	}
+	for _, k := range names {
+		sname := utf16Bytes(k)
+		elen := streamEntryDiskSize
+		if len(sname) > 0 {
+			elen += len(sname) + 2
+		}
+		e := make([]byte, align8(uint64(elen)))
+		binary.LittleEndian.PutUint64(e[0:], uint64(elen))
+		data := n.streams[k]
+		if k == "" {
+			data = n.data
+		}
+		sh := b.addResource(data, 0)
+		copy(e[16:36], sh[:])
+		binary.LittleEndian.PutUint16(e[36:], uint16(len(sname)))
+		copy(e[streamEntryDiskSize:], sname)
+		d = append(d, e...)
+	}
+	return d
+}
+
+func (b *testWIMBuilder) metadata(root *testNode, sd []byte) []byte {
+	total := 8 + 8 + len(sd)
+	md := binary.LittleEndian.AppendUint32(nil, uint32(total))
+	md = binary.LittleEndian.AppendUint32(md, 1)
+	md = binary.LittleEndian.AppendUint64(md, uint64(len(sd)))
+	md = append(md, sd...)
+	md = append(md, make([]byte, int(align8(uint64(total)))-total)...)
+
+	type pending struct {
+		n   *testNode
+		off int
+	}
+	queue := []pending{{root, len(md)}}
+	md = append(md, b.dentry(root)...)
+	md = append(md, make([]byte, 8)...)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if len(p.n.children) == 0 {
+			continue
+		}
+		binary.LittleEndian.PutUint64(md[p.off+16:], uint64(len(md)))
+		for _, c := range p.n.children {
+			queue = append(queue, pending{c, len(md)})
+			md = append(md, b.dentry(c)...)
+		}
+		md = append(md, make([]byte, 8)...)
+	}
+	return md
+}
+
+func buildTestWIM(xmlText string, roots ...*testNode) []byte {
+	b := &testWIMBuilder{buf: make([]byte, headerSize), seen: make(map[Hash]bool)}
+	sd := []byte{1, 0, 4, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	var mds [][]byte
+	for _, root := range roots {
+		mds = append(mds, b.metadata(root, sd))
+	}
+	for _, md := range mds {
+		b.addResource(md, resFlagMetadata)
+	}
+
+	hdr := header{
+		Version:    wimVersion,
+		ChunkSize:  defaultChunk,
+		PartNumber: 1,
+		TotalParts: 1,
+		ImageCount: uint32(len(roots)),
+	}
+	hdr.LookupTable = resourceHeader{Size: uint64(len(b.table)), Offset: uint64(len(b.buf)), UncompressedSize: uint64(len(b.table))}
+	b.buf = append(b.buf, b.table...)
+	xmlData := encodeUTF16XML(xmlText)
+	hdr.XMLData = resourceHeader{Size: uint64(len(xmlData)), Offset: uint64(len(b.buf)), UncompressedSize: uint64(len(xmlData))}
+	b.buf = append(b.buf, xmlData...)
+	hdr.put(b.buf)
+	return b.buf
+}
+
+func testImage(t *testing.T) *Image {
+	t.Helper()
+	hosts := testFile("hosts", "127.0.0.1 localhost\n")
+	hosts.streams = map[string][]byte{"Zone.Identifier": []byte("[ZoneTransfer]\r\nZoneId=3\r\n")}
+	root := testDir("",
+		testDir("Windows",
+			testDir("System32",
+				testDir("drivers", testDir("etc", hosts)),
+				testFile("kernel32.dll", "MZ kernel"),
+			),
+			testFile("win.ini", "; for 16-bit app support\r\n"),
+		),
+		testFile("bootmgr", "MZ bootmgr"),
+		testFile("empty.txt", ""),
+	)
+	return openTestImage(t, root)
+}
+
+func openTestImage(t *testing.T, root *testNode) *Image {
+	t.Helper()
+	data := buildTestWIM(`<WIM><IMAGE INDEX="1"><NAME>Test</NAME></IMAGE></WIM>`, root)
+	f, err := NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// readAll returns a function that reads an (io.Reader, error) pair to a
+// string, failing t on error.
+func readAll(t *testing.T) func(io.Reader, error) string {
+	return func(r io.Reader, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+}
+
+func TestImageLookupAndOpen(t *testing.T) {
+	img := testImage(t)
+	read := readAll(t)
+	if img.Info().Name != "Test" {
+		t.Fatalf("info = %+v", img.Info())
+	}
+
+	d, err := img.Lookup(`\windows\SYSTEM32\drivers\etc\hosts`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Path() != "Windows/System32/drivers/etc/hosts" || d.Size() != 20 {
+		t.Fatalf("path %q size %d", d.Path(), d.Size())
+	}
+	if d.CreationTime.IsZero() || d.LastWriteTime.Before(d.CreationTime) {
+		t.Fatalf("times = %v %v", d.CreationTime, d.LastWriteTime)
+	}
+	if got := read(img.Open("Windows/System32/drivers/etc/hosts")); got != "127.0.0.1 localhost\n" {
+		t.Fatalf("hosts = %q", got)
+	}
+	if len(d.Streams) != 1 || d.Streams[0].Name != "Zone.Identifier" {
+		t.Fatalf("streams = %+v", d.Streams)
+	}
+	if got := read(img.OpenStream(d, "zone.identifier")); got != "[ZoneTransfer]\r\nZoneId=3\r\n" {
+		t.Fatalf("stream = %q", got)
+	}
+	if got := read(img.Open("empty.txt")); got != "" {
+		t.Fatalf("empty = %q", got)
+	}
+	if len(img.SecurityDescriptor(0)) != 20 || img.SecurityDescriptor(-1) != nil {
+		t.Fatal("unexpected security descriptors")
+	}
+
+	if _, err := img.Lookup("Windows/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("err = %v want fs.ErrNotExist", err)
+	}
+	if _, err := img.Open("Windows"); err == nil {
+		t.Fatal("opening a directory succeeded")
+	}
+	if _, err := img.f.Image(2); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("err = %v want ErrImageNotFound", err)
+	}
+}
+
+func TestImageWalk(t *testing.T) {
+	img := testImage(t)
+	var paths []string
+	err := img.Walk(func(path string, d *Dentry) error {
+		if d.Name == "System32" {
+			return fs.SkipDir
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"", "Windows", "Windows/win.ini", "bootmgr", "empty.txt"}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("paths = %q\nwant %q", paths, want)
+	}
+}
+
+func TestFormatAttributes(t *testing.T) {
+	if got := FormatAttributes(AttrDirectory | AttrHidden | AttrSystem); got != "d--hs-" {
+		t.Fatalf("got %q", got)
+	}
+	if got := FormatAttributes(AttrArchive | AttrReparsePoint); got != "-a---l" {
+		t.Fatalf("got %q", got)
+	}
+}
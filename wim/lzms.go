@@ -0,0 +1,654 @@
+package wim
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+const (
+	lzmsNumLiteralSyms    = 256
+	lzmsNumLengthSyms     = 54
+	lzmsNumDeltaPowerSyms = 8
+	lzmsMaxOffsetSlots    = 799
+	lzmsMaxCodeLen        = 15
+	lzmsNumReps           = 3
+
+	lzmsProbBits    = 6
+	lzmsProbDenom   = 1 << lzmsProbBits
+	lzmsInitialProb = 48
+	// lzmsInitialRecent holds 16 ones among the 64 remembered bits, which
+	// matches lzmsInitialProb.
+	lzmsInitialRecent = 0x0000000055555555
+
+	lzmsLiteralRebuild     = 1024
+	lzmsLZOffsetRebuild    = 1024
+	lzmsLengthRebuild      = 512
+	lzmsDeltaOffsetRebuild = 1024
+	lzmsDeltaPowerRebuild  = 512
+
+	lzmsX86IDWindow       = 65535
+	lzmsX86MaxTranslation = 1023
+)
+
+// The offset and length slot tables are stored as run lengths of slots
+// whose bases grow by 1, 2, 4, ... bytes, followed by the end of the last
+// slot.
+var (
+	lzmsOffsetSlotRuns = [...]uint8{
+		9, 0, 9, 7, 10, 15, 15, 20, 20, 30, 33, 40, 42, 45, 60, 73, 80, 85, 95, 105, 6,
+	}
+	lzmsLengthSlotRuns = [...]uint8{
+		27, 4, 6, 4, 5, 2, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1,
+	}
+
+	lzmsOffsetSlotBase  = lzmsSlotBases(lzmsOffsetSlotRuns[:], lzmsMaxOffsetSlots, 0x7FFFFFFF)
+	lzmsLengthSlotBase  = lzmsSlotBases(lzmsLengthSlotRuns[:], lzmsNumLengthSyms, 0x400108AB)
+	lzmsExtraOffsetBits = lzmsExtraBits(lzmsOffsetSlotBase)
+	lzmsExtraLengthBits = lzmsExtraBits(lzmsLengthSlotBase)
+)
+
+func lzmsSlotBases(runs []uint8, numSlots int, end uint32) []uint32 {
+	bases := make([]uint32, 0, numSlots+1)
+	var base uint32
+	for i, run := range runs {
+		for range run {
+			base += 1 << i
+			bases = append(bases, base)
+		}
+	}
+	if len(bases) != numSlots {
+		panic("wim: bad LZMS slot table")
+	}
+	return append(bases, end)
+}
+
+func lzmsExtraBits(bases []uint32) []uint8 {
+	extra := make([]uint8, len(bases)-1)
+	for i := range extra {
+		extra[i] = uint8(bits.Len32(bases[i+1]-bases[i]) - 1)
+	}
+	return extra
+}
+
+// lzmsSlot returns the slot of bases (which ends with the end of the last
+// slot) that v falls in.
+func lzmsSlot(bases []uint32, v uint32) int {
+	i, found := slices.BinarySearch(bases[:len(bases)-1], v)
+	if !found {
+		i--
+	}
+	return i
+}
+
+// lzmsNumOffsetSlots returns how many offset slots a chunk of size bytes
+// can use.
+func lzmsNumOffsetSlots(size int) int {
+	if size < 2 {
+		return 0
+	}
+	return 1 + lzmsSlot(lzmsOffsetSlotBase, uint32(size-1))
+}
+
+// lzmsProb adapts the probability of a 0 bit to the last 64 bits coded
+// with it.
+type lzmsProb struct {
+	zeros  uint32
+	recent uint64
+}
+
+// get returns the probability of a 0 bit out of lzmsProbDenom, never 0%
+// or 100%.
+func (p *lzmsProb) get() uint32 {
+	switch p.zeros {
+	case 0:
+		return 1
+	case lzmsProbDenom:
+		return lzmsProbDenom - 1
+	}
+	return p.zeros
+}
+
+func (p *lzmsProb) update(bit uint32) {
+	p.zeros += uint32(p.recent>>(lzmsProbDenom-1)) - bit
+	p.recent = p.recent<<1 | uint64(bit)
+}
+
+// lzmsModel is one kind of range-coded decision. Its state, the last few
+// decisions of the kind, selects the probability to use.
+type lzmsModel struct {
+	probs [64]lzmsProb
+	mask  uint32
+	state uint32
+}
+
+func (m *lzmsModel) reset(numStates uint32) {
+	m.mask = numStates - 1
+	m.state = 0
+	for i := range m.probs[:numStates] {
+		m.probs[i] = lzmsProb{zeros: lzmsInitialProb, recent: lzmsInitialRecent}
+	}
+}
+
+func (m *lzmsModel) prob() *lzmsProb {
+	return &m.probs[m.state]
+}
+
+func (m *lzmsModel) update(bit uint32) {
+	m.probs[m.state].update(bit)
+	m.state = (m.state<<1 | bit) & m.mask
+}
+
+// lzmsModels are the decisions that select between literals, LZ matches
+// and delta matches, and between explicit and repeated offsets.
+type lzmsModels struct {
+	main, match, lz, delta lzmsModel
+	lzRep, deltaRep        [lzmsNumReps - 1]lzmsModel
+}
+
+func (m *lzmsModels) reset() {
+	m.main.reset(16)
+	m.match.reset(32)
+	m.lz.reset(64)
+	m.delta.reset(64)
+	for i := range m.lzRep {
+		m.lzRep[i].reset(64)
+		m.deltaRep[i].reset(64)
+	}
+}
+
+// lzmsCode is an adaptive Huffman code. Encoder and decoder rebuild it
+// from the symbol counts every rebuildFreq symbols, so it is never stored.
+type lzmsCode struct {
+	freqs        []uint32
+	lens         []uint8
+	codes        []uint32
+	rebuildFreq  int
+	untilRebuild int
+}
+
+func (c *lzmsCode) reset(numSyms, rebuildFreq int) {
+	c.freqs = slices.Grow(c.freqs[:0], numSyms)[:numSyms]
+	c.lens = slices.Grow(c.lens[:0], numSyms)[:numSyms]
+	c.codes = slices.Grow(c.codes[:0], numSyms)[:numSyms]
+	for i := range c.freqs {
+		c.freqs[i] = 1
+	}
+	c.rebuildFreq = rebuildFreq
+	c.rebuild()
+}
+
+// rebuild makes the code for the current counts, then halves them so the
+// code follows changes in the data.
+func (c *lzmsCode) rebuild() {
+	lzmsHuffmanCode(c.freqs, c.lens, c.codes)
+	for i, f := range c.freqs {
+		c.freqs[i] = f>>1 + 1
+	}
+	c.untilRebuild = c.rebuildFreq
+}
+
+// count records a coded symbol and reports whether the code was rebuilt.
+func (c *lzmsCode) count(sym int) bool {
+	c.freqs[sym]++
+	c.untilRebuild--
+	if c.untilRebuild == 0 {
+		c.rebuild()
+		return true
+	}
+	return false
+}
+
+// lzmsHuffmanCode builds the length-limited canonical code for freqs.
+// Since LZMS never stores its codes, this must match Microsoft's
+// construction exactly: symbols are sorted by frequency then value, leaves
+// win ties against internal nodes, and lengths over lzmsMaxCodeLen are
+// cut by moving nodes to the deepest length that still has leaves.
+func lzmsHuffmanCode(freqs []uint32, lens []uint8, codes []uint32) {
+	const symBits = 10
+	const symMask = 1<<symBits - 1
+
+	a := codes[:0]
+	for sym, f := range freqs {
+		lens[sym] = 0
+		if f != 0 {
+			a = append(a, f<<symBits|uint32(sym))
+		}
+	}
+	n := len(a)
+	switch n {
+	case 0:
+		return
+	case 1:
+		// A complete code needs two codewords.
+		sym := int(a[0] & symMask)
+		other := max(sym, 1)
+		lens[0] = 1
+		codes[0] = 0
+		if other < len(lens) {
+			lens[other] = 1
+			codes[other] = 1
+		}
+		return
+	}
+	slices.Sort(a)
+
+	// Build the internal nodes in place: a[e] becomes the e'th node, and
+	// every consumed entry's upper bits are overwritten with its parent.
+	i, b, e := 0, 0, 0
+	for n-e > 1 {
+		var pick [2]int
+		for k := range pick {
+			if i != n && (b == e || a[i]>>symBits <= a[b]>>symBits) {
+				pick[k] = i
+				i++
+			} else {
+				pick[k] = b
+				b++
+			}
+		}
+		m, o := pick[0], pick[1]
+		freq := a[m]&^symMask + a[o]&^symMask
+		a[m] = a[m]&symMask | uint32(e)<<symBits
+		a[o] = a[o]&symMask | uint32(e)<<symBits
+		a[e] = a[e]&symMask | freq
+		e++
+	}
+
+	// Walk the nodes root first, turning parent links into depths and
+	// counting leaves per length.
+	var lenCounts [lzmsMaxCodeLen + 2]uint32
+	lenCounts[1] = 2
+	root := n - 2
+	a[root] &= symMask
+	for node := root - 1; node >= 0; node-- {
+		depth := a[a[node]>>symBits]>>symBits + 1
+		a[node] = a[node]&symMask | depth<<symBits
+		l := depth
+		if l >= lzmsMaxCodeLen {
+			l = lzmsMaxCodeLen - 1
+			for lenCounts[l] == 0 {
+				l--
+			}
+		}
+		lenCounts[l]--
+		lenCounts[l+1] += 2
+	}
+
+	// The rarest symbols get the longest codes.
+	k := 0
+	for l := lzmsMaxCodeLen; l >= 1; l-- {
+		for range lenCounts[l] {
+			lens[a[k]&symMask] = uint8(l)
+			k++
+		}
+	}
+	huffmanCodes(lens, codes)
+}
+
+// lzmsX86Filter turns the relative addresses of likely x86 instructions
+// into absolute ones, which compress better, or back when undo is set.
+// Translation applies only within lzmsX86MaxTranslation bytes of an
+// instruction that looks genuine: one whose target was referenced shortly
+// before. lastTarget is scratch space for 65536 entries.
+func lzmsX86Filter(data []byte, lastTarget []int32, undo bool) {
+	if len(data) <= 17 {
+		return
+	}
+	for i := range lastTarget[:1<<16] {
+		lastTarget[i] = -lzmsX86IDWindow - 1
+	}
+	// A sentinel opcode near the end stops the scan; no translation
+	// reaches the last 16 bytes.
+	tail := len(data) - 16
+	saved := data[tail+8]
+	data[tail+8] = 0xE8
+	lastX86 := -lzmsX86MaxTranslation - 1
+
+	// The first byte is never an opcode.
+	i := 0
+	for {
+		i++
+		for !lzmsX86Opcode[data[i]] {
+			i++
+		}
+		if i >= tail {
+			break
+		}
+
+		maxTrans := lzmsX86MaxTranslation
+		n := 0 // opcode bytes before the address
+		switch data[i] {
+		case 0x48:
+			switch {
+			case data[i+1] == 0x8B && (data[i+2] == 0x05 || data[i+2] == 0x0D):
+				n = 3 // RIP-relative mov
+			case data[i+1] == 0x8D && data[i+2]&7 == 5:
+				n = 3 // RIP-relative lea
+			}
+		case 0x4C:
+			if data[i+1] == 0x8D && data[i+2]&7 == 5 {
+				n = 3 // RIP-relative lea
+			}
+		case 0xE8:
+			// Calls are common in other data too; demand more evidence.
+			n = 1
+			maxTrans /= 2
+		case 0xE9:
+			i += 4 // skip a relative jump
+		case 0xF0:
+			if data[i+1] == 0x83 && data[i+2] == 0x05 {
+				n = 3 // lock add
+			}
+		case 0xFF:
+			if data[i+1] == 0x15 {
+				n = 2 // indirect call
+			}
+		}
+		if n == 0 {
+			continue
+		}
+
+		addr := data[i+n:]
+		var target uint16
+		if undo {
+			if i-lastX86 <= maxTrans {
+				binary.LittleEndian.PutUint32(addr, binary.LittleEndian.Uint32(addr)-uint32(i))
+			}
+			target = uint16(i) + binary.LittleEndian.Uint16(addr)
+		} else {
+			target = uint16(i) + binary.LittleEndian.Uint16(addr)
+			if i-lastX86 <= maxTrans {
+				binary.LittleEndian.PutUint32(addr, binary.LittleEndian.Uint32(addr)+uint32(i))
+			}
+		}
+		i += n + 3
+		if i-int(lastTarget[target]) <= lzmsX86IDWindow {
+			lastX86 = i
+		}
+		lastTarget[target] = int32(i)
+	}
+	data[tail+8] = saved
+}
+
+var lzmsX86Opcode = [256]bool{0x48: true, 0x4C: true, 0xE8: true, 0xE9: true, 0xF0: true, 0xFF: true}
+
+// lzmsRangeDecoder reads the range-coded decisions from the front of a
+// chunk.
+type lzmsRangeDecoder struct {
+	src  []byte
+	pos  int
+	rng  uint32
+	code uint32
+}
+
+func (d *lzmsRangeDecoder) init(src []byte) {
+	d.src = src
+	d.pos = 4
+	d.rng = 0xFFFFFFFF
+	d.code = uint32(binary.LittleEndian.Uint16(src))<<16 | uint32(binary.LittleEndian.Uint16(src[2:]))
+}
+
+func (d *lzmsRangeDecoder) decode(m *lzmsModel) uint32 {
+	if d.rng <= 0xFFFF {
+		d.rng <<= 16
+		d.code <<= 16
+		if d.pos+2 <= len(d.src) {
+			d.code |= uint32(binary.LittleEndian.Uint16(d.src[d.pos:]))
+			d.pos += 2
+		}
+	}
+	bound := (d.rng >> lzmsProbBits) * m.prob().get()
+	var bit uint32
+	if d.code < bound {
+		d.rng = bound
+	} else {
+		d.rng -= bound
+		d.code -= bound
+		bit = 1
+	}
+	m.update(bit)
+	return bit
+}
+
+// lzmsBitReader reads the Huffman symbols and extra bits, which are stored
+// in 16-bit little-endian words from the end of a chunk backwards, MSB
+// first. Past the start it reads zeros.
+type lzmsBitReader struct {
+	src  []byte
+	pos  int // byte offset of the last word read
+	buf  uint64
+	left uint
+}
+
+func (r *lzmsBitReader) ensure(n uint) {
+	for r.left < n {
+		var w uint64
+		if r.pos >= 2 {
+			r.pos -= 2
+			w = uint64(binary.LittleEndian.Uint16(r.src[r.pos:]))
+		}
+		r.buf |= w << (48 - r.left)
+		r.left += 16
+	}
+}
+
+func (r *lzmsBitReader) read(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	r.ensure(n)
+	v := uint32(r.buf >> (64 - n))
+	r.buf <<= n
+	r.left -= n
+	return v
+}
+
+// lzmsDecodeCode pairs an adaptive code with its decoding tables.
+type lzmsDecodeCode struct {
+	lzmsCode
+	dec huffmanDecoder
+}
+
+func (c *lzmsDecodeCode) reset(numSyms, rebuildFreq int) {
+	c.lzmsCode.reset(numSyms, rebuildFreq)
+	c.dec.init(c.lens, lzmsMaxCodeLen)
+}
+
+func (c *lzmsDecodeCode) decode(r *lzmsBitReader) (int, error) {
+	r.ensure(lzmsMaxCodeLen)
+	sym, n, ok := c.dec.decode(uint32(r.buf >> (64 - lzmsMaxCodeLen)))
+	if !ok {
+		return 0, errCorruptChunk
+	}
+	r.buf <<= n
+	r.left -= n
+	if c.count(int(sym)) {
+		c.dec.init(c.lens, lzmsMaxCodeLen)
+	}
+	return int(sym), nil
+}
+
+type lzmsDecoder struct {
+	rd          lzmsRangeDecoder
+	br          lzmsBitReader
+	models      lzmsModels
+	literal     lzmsDecodeCode
+	lzOffset    lzmsDecodeCode
+	length      lzmsDecodeCode
+	deltaOffset lzmsDecodeCode
+	deltaPower  lzmsDecodeCode
+	lastTarget  [1 << 16]int32
+}
+
+var lzmsDecoders = sync.Pool{New: func() any { return new(lzmsDecoder) }}
+
+// lzmsDecompress decodes one LZMS chunk into dst, which must have exactly
+// the chunk's uncompressed size.
+func lzmsDecompress(dst, src []byte) error {
+	if len(src) < 4 || len(src)%2 != 0 {
+		return errCorruptChunk
+	}
+	d := lzmsDecoders.Get().(*lzmsDecoder)
+	defer lzmsDecoders.Put(d)
+	if err := d.decode(dst, src); err != nil {
+		return err
+	}
+	lzmsX86Filter(dst, d.lastTarget[:], true)
+	return nil
+}
+
+func (d *lzmsDecoder) decode(dst, src []byte) error {
+	d.rd.init(src)
+	d.br = lzmsBitReader{src: src, pos: len(src)}
+	d.models.reset()
+	numOffsetSlots := lzmsNumOffsetSlots(len(dst))
+	d.literal.reset(lzmsNumLiteralSyms, lzmsLiteralRebuild)
+	d.lzOffset.reset(numOffsetSlots, lzmsLZOffsetRebuild)
+	d.length.reset(lzmsNumLengthSyms, lzmsLengthRebuild)
+	d.deltaOffset.reset(numOffsetSlots, lzmsDeltaOffsetRebuild)
+	d.deltaPower.reset(lzmsNumDeltaPowerSyms, lzmsDeltaPowerRebuild)
+
+	// A match's offset joins the recent queues only after the next item,
+	// so an item right after a match cannot repeat its offset.
+	var lzRecent [lzmsNumReps + 1]uint32
+	var deltaRecent [lzmsNumReps + 1]uint64
+	for i := range lzRecent {
+		lzRecent[i] = uint32(i + 1)
+		deltaRecent[i] = uint64(i + 1)
+	}
+	var lzPending uint32
+	var deltaPending uint64
+	lzPendingEnd, deltaPendingEnd := -1, -1
+
+	m := &d.models
+	out := 0
+	for out < len(dst) {
+		if d.rd.decode(&m.main) == 0 {
+			sym, err := d.literal.decode(&d.br)
+			if err != nil {
+				return err
+			}
+			dst[out] = byte(sym)
+			out++
+			continue
+		}
+
+		if d.rd.decode(&m.match) == 0 {
+			if lzPending != 0 && out != lzPendingEnd {
+				copy(lzRecent[1:], lzRecent[:lzmsNumReps])
+				lzRecent[0] = lzPending
+				lzPending = 0
+			}
+			var offset uint32
+			if d.rd.decode(&m.lz) == 0 {
+				o, err := d.readOffset(&d.lzOffset)
+				if err != nil {
+					return err
+				}
+				// Only the pending insertion shifts the queue.
+				offset = o
+			} else {
+				rep := d.readRep(&m.lzRep)
+				offset = lzRecent[rep]
+				copy(lzRecent[rep:], lzRecent[rep+1:])
+			}
+			if lzPending != 0 {
+				copy(lzRecent[1:], lzRecent[:lzmsNumReps])
+				lzRecent[0] = lzPending
+			}
+			lzPending = offset
+
+			length, err := d.readLength()
+			if err != nil {
+				return err
+			}
+			if length > len(dst)-out || int(offset) > out {
+				return errCorruptChunk
+			}
+			copyMatch(dst, out, int(offset), length)
+			out += length
+			lzPendingEnd = out
+			continue
+		}
+
+		if deltaPending != 0 && out != deltaPendingEnd {
+			copy(deltaRecent[1:], deltaRecent[:lzmsNumReps])
+			deltaRecent[0] = deltaPending
+			deltaPending = 0
+		}
+		var pair uint64
+		if d.rd.decode(&m.delta) == 0 {
+			power, err := d.deltaPower.decode(&d.br)
+			if err != nil {
+				return err
+			}
+			raw, err := d.readOffset(&d.deltaOffset)
+			if err != nil {
+				return err
+			}
+			pair = uint64(power)<<32 | uint64(raw)
+		} else {
+			rep := d.readRep(&m.deltaRep)
+			pair = deltaRecent[rep]
+			copy(deltaRecent[rep:], deltaRecent[rep+1:])
+		}
+		if deltaPending != 0 {
+			copy(deltaRecent[1:], deltaRecent[:lzmsNumReps])
+			deltaRecent[0] = deltaPending
+		}
+		deltaPending = pair
+
+		length, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		// A delta match adds the difference between the bytes span and
+		// 2*span back (span = 2^power) to the byte span back.
+		power := uint(pair >> 32)
+		raw := uint64(uint32(pair))
+		if power >= 32 {
+			return errCorruptChunk
+		}
+		span := uint64(1) << power
+		offset := span + raw<<power
+		if length > len(dst)-out || offset > uint64(out) {
+			return errCorruptChunk
+		}
+		o1, o2, o := int(span), int(raw<<power), int(offset)
+		for range length {
+			dst[out] = dst[out-o1] + dst[out-o2] - dst[out-o]
+			out++
+		}
+		deltaPendingEnd = out
+	}
+	return nil
+}
+
+// readRep decodes which of the first three recent offsets a repeat match
+// uses.
+func (d *lzmsDecoder) readRep(models *[lzmsNumReps - 1]lzmsModel) int {
+	for i := range models {
+		if d.rd.decode(&models[i]) == 0 {
+			return i
+		}
+	}
+	return lzmsNumReps - 1
+}
+
+func (d *lzmsDecoder) readOffset(c *lzmsDecodeCode) (uint32, error) {
+	slot, err := c.decode(&d.br)
+	if err != nil {
+		return 0, err
+	}
+	return lzmsOffsetSlotBase[slot] + d.br.read(uint(lzmsExtraOffsetBits[slot])), nil
+}
+
+func (d *lzmsDecoder) readLength() (int, error) {
+	slot, err := d.length.decode(&d.br)
+	if err != nil {
+		return 0, err
+	}
+	return int(lzmsLengthSlotBase[slot] + d.br.read(uint(lzmsExtraLengthBits[slot]))), nil
+}
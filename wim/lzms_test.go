@@ -0,0 +1,145 @@
+package wim
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestLZMSSlots(t *testing.T) {
+	// The last slots end where the format says they do.
+	if n := len(lzmsLengthSlotBase); n != lzmsNumLengthSyms+1 {
+		t.Fatalf("%d length slots", n)
+	}
+	if base, extra := lzmsLengthSlotBase[53], lzmsExtraLengthBits[53]; base != 67755 || extra != 30 {
+		t.Errorf("length slot 53 = %d+%d bits", base, extra)
+	}
+	if base, extra := lzmsOffsetSlotBase[798], lzmsExtraOffsetBits[798]; base != 106685605 || extra != 30 {
+		t.Errorf("offset slot 798 = %d+%d bits", base, extra)
+	}
+	for _, v := range []uint32{1, 2, 100, 67755, 1 << 20} {
+		slot := lzmsSlot(lzmsOffsetSlotBase, v)
+		if lzmsOffsetSlotBase[slot] > v || lzmsOffsetSlotBase[slot+1] <= v {
+			t.Errorf("offset %d in slot %d", v, slot)
+		}
+	}
+}
+
+func TestLZMSHuffmanCode(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	for _, n := range []int{2, 8, 54, 256, 799} {
+		freqs := make([]uint32, n)
+		for i := range freqs {
+			// Skewed enough to hit the 15-bit limit.
+			freqs[i] = uint32(rng.Intn(2)) << uint(rng.Intn(24))
+		}
+		freqs[0], freqs[n-1] = 1, 1
+		lens := make([]uint8, n)
+		codes := make([]uint32, n)
+		lzmsHuffmanCode(freqs, lens, codes)
+		var kraft uint64
+		for i, l := range lens {
+			if freqs[i] == 0 {
+				continue
+			}
+			if l == 0 || l > lzmsMaxCodeLen {
+				t.Fatalf("%d symbols: length %d", n, l)
+			}
+			kraft += 1 << (lzmsMaxCodeLen - l)
+		}
+		if kraft != 1<<lzmsMaxCodeLen {
+			t.Errorf("%d symbols: code is not complete", n)
+		}
+	}
+}
+
+func TestLZMSX86FilterRoundTrip(t *testing.T) {
+	data := lzxCallInput(1 << 16)
+	want := bytes.Clone(data)
+	lastTarget := make([]int32, 1<<16)
+	lzmsX86Filter(data, lastTarget, false)
+	if bytes.Equal(data, want) {
+		t.Fatal("filter changed nothing")
+	}
+	lzmsX86Filter(data, lastTarget, true)
+	if !bytes.Equal(data, want) {
+		t.Fatal("undo did not restore the input")
+	}
+}
+
+func TestLZMSCompressSolid(t *testing.T) {
+	// Solid chunks are large; matches reach back across all of one.
+	var big []byte
+	for name, src := range compressTestInputs() {
+		if name != "noise" {
+			big = append(big, src...)
+		}
+	}
+	big = append(big, big...)
+	chunkRoundTrip(t, lzmsDecompress, newLZMSCompressor(1<<20, defaultLevel), big)
+}
+
+// TestLZMSRecentOffsets decodes a stream written decision by decision,
+// without the encoder's queue, and checks it against output worked out by
+// hand from the format: an explicit offset joins the recent offsets only
+// when the next match inserts it, without shifting them itself.
+func TestLZMSRecentOffsets(t *testing.T) {
+	type item struct {
+		lit    byte
+		offset uint32 // explicit match
+		rep    int    // repeat match of recent offset rep-1
+		length int
+	}
+	items := []item{
+		{lit: 'a'}, {lit: 'b'}, {lit: 'c'}, {lit: 'd'}, {lit: 'e'}, {lit: 'f'}, {lit: 'g'}, {lit: 'h'},
+		{offset: 5, length: 3}, // "def"; the queue is [1 2 3 4], 5 pending
+		{lit: 'X'},
+		{offset: 7, length: 2}, // "fg"; inserts 5: [5 1 2 3], 7 pending
+		{lit: 'Y'},
+		{rep: 3, length: 3}, // inserts 7: [7 5 1 2]; the third is 1, "YYY"
+		{lit: 'Z'},
+	}
+	want := []byte("abcdefghdefXfgYYYYZ")
+
+	c := makeLZMSCompressor(1<<15, defaultLevel)
+	c.begin(1<<10, len(want))
+	for _, it := range items {
+		if it.length == 0 {
+			c.putLiteral(it.lit)
+			continue
+		}
+		c.rc.encode(&c.models.main, 1)
+		c.rc.encode(&c.models.match, 0)
+		if it.offset != 0 {
+			c.putLZOffset(it.offset)
+		} else {
+			c.putLZRep(it.rep - 1)
+		}
+		c.putLength(it.length)
+	}
+	buf := make([]byte, 1<<10)
+	n := c.finish(buf)
+	got := make([]byte, len(want))
+	if err := lzmsDecompress(got, buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decoded %q, want %q", got, want)
+	}
+
+	// The encoder keeps the same queue.
+	c.begin(1<<10, len(want))
+	c.putMatch(8, 3, 5)
+	c.putLiteral('X')
+	c.putMatch(12, 2, 7)
+	c.putLiteral('Y')
+	if q := c.reps(15); q != [lzmsNumReps]uint32{7, 5, 1} {
+		t.Errorf("encoder repeat offsets %v, want [7 5 1]", q)
+	}
+}
+
+func FuzzLZMSCompress(f *testing.F) {
+	fuzzCompress(f, wimgapi.CompressLZMS)
+}
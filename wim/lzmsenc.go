@@ -0,0 +1,334 @@
+package wim
+
+import "encoding/binary"
+
+const (
+	// lzmsMaxWindow bounds how far back the LZMS encoder looks for matches,
+	// which keeps its memory use flat for the large chunks of solid
+	// resources.
+	lzmsMaxWindow = 1 << 22
+	// lzmsFarOffset is the distance past which a minimum-length match
+	// costs more than its literals.
+	lzmsFarOffset = 1 << 14
+)
+
+// lzmsRangeEncoder writes the range-coded decisions. The first word it
+// produces is always zero and is not stored.
+type lzmsRangeEncoder struct {
+	low       uint64
+	rng       uint32
+	cache     uint16
+	cacheSize int
+	started   bool
+	out       []uint16
+}
+
+func (e *lzmsRangeEncoder) reset() {
+	*e = lzmsRangeEncoder{rng: 0xFFFFFFFF, cacheSize: 1, out: e.out[:0]}
+}
+
+func (e *lzmsRangeEncoder) encode(m *lzmsModel, bit uint32) {
+	bound := (e.rng >> lzmsProbBits) * m.prob().get()
+	if bit == 0 {
+		e.rng = bound
+	} else {
+		e.low += uint64(bound)
+		e.rng -= bound
+	}
+	m.update(bit)
+	if e.rng <= 0xFFFF {
+		e.rng <<= 16
+		e.shiftLow()
+	}
+}
+
+// shiftLow moves the top 16 bits of low out, holding back a run of 0xFFFF
+// words until it is known whether a carry reaches them.
+func (e *lzmsRangeEncoder) shiftLow() {
+	if uint32(e.low) < 0xFFFF0000 || e.low>>32 != 0 {
+		carry := uint16(e.low >> 32)
+		for ; e.cacheSize > 0; e.cacheSize-- {
+			if e.started {
+				e.out = append(e.out, e.cache+carry)
+			}
+			e.started = true
+			e.cache = 0xFFFF
+		}
+		e.cache = uint16(e.low >> 16)
+	}
+	e.cacheSize++
+	e.low = e.low & 0xFFFF << 16
+}
+
+func (e *lzmsRangeEncoder) flush() {
+	for range 4 {
+		e.shiftLow()
+	}
+}
+
+// lzmsBitWriter collects the Huffman symbols and extra bits in the order
+// the decoder reads them; they are stored last word first.
+type lzmsBitWriter struct {
+	out   []uint16
+	bits  uint64
+	count uint
+}
+
+func (w *lzmsBitWriter) put(v uint32, n uint) {
+	w.bits = w.bits<<n | uint64(v)
+	w.count += n
+	for w.count >= 16 {
+		w.count -= 16
+		w.out = append(w.out, uint16(w.bits>>w.count))
+	}
+}
+
+func (w *lzmsBitWriter) flush() {
+	if w.count > 0 {
+		w.out = append(w.out, uint16(w.bits<<(16-w.count)))
+		w.count = 0
+	}
+}
+
+// lzmsCompressor encodes chunks as LZMS: x86-filtered, parsed greedily or
+// lazily, with literals and LZ matches. Delta matches, which suit tables
+// of evenly spaced values, are not used.
+type lzmsCompressor struct {
+	params     levelParams
+	chunkSize  int
+	mf         *matchFinder
+	lastTarget []int32
+
+	rc       lzmsRangeEncoder
+	bw       lzmsBitWriter
+	limit    int // words the output may take
+	models   lzmsModels
+	literal  lzmsCode
+	lzOffset lzmsCode
+	length   lzmsCode
+
+	recent     [lzmsNumReps + 1]uint32
+	pending    uint32
+	pendingEnd int
+}
+
+func newLZMSCompressor(chunkSize, level int) chunkCompressor {
+	return makeLZMSCompressor(chunkSize, level).compress
+}
+
+func makeLZMSCompressor(chunkSize, level int) *lzmsCompressor {
+	return &lzmsCompressor{
+		params:     compressionLevels[level],
+		chunkSize:  chunkSize,
+		lastTarget: make([]int32, 1<<16),
+	}
+}
+
+// compress filters src in place while it works and restores it before
+// returning.
+func (c *lzmsCompressor) compress(dst, src []byte) int {
+	if len(src) < 4 || len(dst) < 4 {
+		return 0
+	}
+	if c.mf == nil {
+		window := min(c.chunkSize, lzmsMaxWindow)
+		c.mf = newMatchFinder(window, c.params.maxChain, c.params.niceLen, window-1)
+	}
+	lzmsX86Filter(src, c.lastTarget, false)
+	n := c.encode(dst, src)
+	lzmsX86Filter(src, c.lastTarget, true)
+	return n
+}
+
+func (c *lzmsCompressor) encode(dst, buf []byte) int {
+	c.begin(len(dst), len(buf))
+	c.mf.reset()
+
+	if !c.parse(buf) {
+		return 0
+	}
+	return c.finish(dst)
+}
+
+// begin resets the coders and models to code n bytes into dstLen.
+func (c *lzmsCompressor) begin(dstLen, n int) {
+	c.rc.reset()
+	c.bw = lzmsBitWriter{out: c.bw.out[:0]}
+	c.limit = dstLen / 2
+	c.models.reset()
+	numOffsetSlots := lzmsNumOffsetSlots(n)
+	c.literal.reset(lzmsNumLiteralSyms, lzmsLiteralRebuild)
+	c.lzOffset.reset(numOffsetSlots, lzmsLZOffsetRebuild)
+	c.length.reset(lzmsNumLengthSyms, lzmsLengthRebuild)
+	for i := range c.recent {
+		c.recent[i] = uint32(i + 1)
+	}
+	c.pending, c.pendingEnd = 0, -1
+}
+
+// finish flushes both streams into dst: the range coder's words forward
+// from the start, the bit writer's backward from the end.
+func (c *lzmsCompressor) finish(dst []byte) int {
+	c.rc.flush()
+	c.bw.flush()
+	words := len(c.rc.out) + len(c.bw.out)
+	if words > c.limit {
+		return 0
+	}
+	for i, w := range c.rc.out {
+		binary.LittleEndian.PutUint16(dst[2*i:], w)
+	}
+	for i, w := range c.bw.out {
+		binary.LittleEndian.PutUint16(dst[2*(words-1-i):], w)
+	}
+	return 2 * words
+}
+
+// parse codes buf greedily or, when lazy, defers a match by one byte if
+// the next position has a longer one. It gives up once the output cannot
+// fit.
+func (c *lzmsCompressor) parse(buf []byte) bool {
+	pos := 0
+	cur := c.bestMatch(buf, pos)
+	for pos < len(buf) {
+		if len(c.rc.out)+len(c.bw.out) > c.limit {
+			return false
+		}
+		if cur.length == 0 {
+			c.putLiteral(buf[pos])
+			pos++
+			if pos < len(buf) {
+				cur = c.bestMatch(buf, pos)
+			}
+			continue
+		}
+		next := pos + 1 // first position not yet inserted
+		if c.params.lazy && int(cur.length) < c.mf.niceLen && pos+1 < len(buf) {
+			if m := c.bestMatch(buf, pos+1); m.length > cur.length {
+				c.putLiteral(buf[pos])
+				pos++
+				cur = m
+				continue
+			}
+			next++
+		}
+		c.putMatch(pos, int(cur.length), uint32(cur.offset))
+		end := pos + int(cur.length)
+		for ; next < end; next++ {
+			c.mf.insert(buf, next)
+		}
+		pos = end
+		if pos < len(buf) {
+			cur = c.bestMatch(buf, pos)
+		}
+	}
+	return true
+}
+
+// bestMatch inserts pos and returns the match to take there: the longest
+// one, unless a recent offset, which is much cheaper to code, covers
+// nearly as much.
+func (c *lzmsCompressor) bestMatch(buf []byte, pos int) lzMatch {
+	length, offset := c.mf.find(buf, pos, len(buf)-pos)
+	best := lzMatch{length: int32(length), offset: int32(offset)}
+	if length == matchMinLen && offset > lzmsFarOffset {
+		best = lzMatch{}
+	}
+	var rep lzMatch
+	for _, r := range c.reps(pos) {
+		if n := lzxMatchLen(buf, pos, int(r), len(buf)-pos); n > int(rep.length) {
+			rep = lzMatch{length: int32(n), offset: int32(r)}
+		}
+	}
+	if rep.length >= 2 && rep.length+1 >= best.length {
+		return rep
+	}
+	return best
+}
+
+// reps returns the offsets a match at pos can repeat.
+func (c *lzmsCompressor) reps(pos int) [lzmsNumReps]uint32 {
+	var q [lzmsNumReps]uint32
+	if c.pending != 0 && pos != c.pendingEnd {
+		q[0] = c.pending
+		copy(q[1:], c.recent[:])
+		return q
+	}
+	copy(q[:], c.recent[:])
+	return q
+}
+
+func (c *lzmsCompressor) putLiteral(b byte) {
+	c.rc.encode(&c.models.main, 0)
+	c.putSymbol(&c.literal, int(b))
+}
+
+// putMatch codes an LZ match, updating the recent offsets the way the
+// decoder does.
+func (c *lzmsCompressor) putMatch(pos, length int, offset uint32) {
+	m := &c.models
+	c.rc.encode(&m.main, 1)
+	c.rc.encode(&m.match, 0)
+	if c.pending != 0 && pos != c.pendingEnd {
+		copy(c.recent[1:], c.recent[:lzmsNumReps])
+		c.recent[0] = c.pending
+		c.pending = 0
+	}
+	rep := -1
+	for i, r := range c.recent[:lzmsNumReps] {
+		if r == offset {
+			rep = i
+			break
+		}
+	}
+	if rep < 0 {
+		// An explicit offset leaves the queue alone; only the pending
+		// insertion below shifts it.
+		c.putLZOffset(offset)
+	} else {
+		c.putLZRep(rep)
+		copy(c.recent[rep:], c.recent[rep+1:])
+	}
+	if c.pending != 0 {
+		copy(c.recent[1:], c.recent[:lzmsNumReps])
+		c.recent[0] = c.pending
+	}
+	c.pending = offset
+	c.pendingEnd = pos + length
+	c.putLength(length)
+}
+
+func (c *lzmsCompressor) putLZOffset(offset uint32) {
+	c.rc.encode(&c.models.lz, 0)
+	slot := lzmsSlot(lzmsOffsetSlotBase, offset)
+	c.putSymbol(&c.lzOffset, slot)
+	c.bw.put(offset-lzmsOffsetSlotBase[slot], uint(lzmsExtraOffsetBits[slot]))
+}
+
+func (c *lzmsCompressor) putLZRep(rep int) {
+	m := &c.models
+	c.rc.encode(&m.lz, 1)
+	for i := range m.lzRep {
+		bit := uint32(0)
+		if rep > i {
+			bit = 1
+		}
+		c.rc.encode(&m.lzRep[i], bit)
+		if bit == 0 {
+			break
+		}
+	}
+}
+
+func (c *lzmsCompressor) putLength(length int) {
+	slot := lzmsSlot(lzmsLengthSlotBase, uint32(length))
+	c.putSymbol(&c.length, slot)
+	if extra := lzmsExtraLengthBits[slot]; extra > 0 {
+		c.bw.put(uint32(length)-lzmsLengthSlotBase[slot], uint(extra))
+	}
+}
+
+func (c *lzmsCompressor) putSymbol(code *lzmsCode, sym int) {
+	c.bw.put(code.codes[sym], uint(code.lens[sym]))
+	code.count(sym)
+}
@@ -0,0 +1,408 @@
+package wim
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	lzxNumChars          = 256
+	lzxMinMatchLen       = 2
+	lzxNumPrimaryLens    = 7
+	lzxLenCodeSymbols    = 249
+	lzxPreCodeSymbols    = 20
+	lzxAlignedSymbols    = 8
+	lzxAlignedOffsetBits = 3
+	lzxMaxCodeLen        = 16
+	lzxMaxPreCodeLen     = 15
+	lzxMaxAlignedLen     = 7
+	lzxOffsetAdjustment  = 2
+	lzxNumRecentOffsets  = 3
+	lzxDefaultBlockSize  = 32768
+	lzxMaxWindowOrder    = 21
+	lzxMinWindowOrder    = 15
+
+	lzxBlockVerbatim     = 1
+	lzxBlockAligned      = 2
+	lzxBlockUncompressed = 3
+
+	// lzxE8FileSize is the translation size WIM uses for x86 call
+	// preprocessing, regardless of the real data size.
+	lzxE8FileSize = 12000000
+)
+
+var lzxOffsetSlotBase = [...]uint32{
+	0, 1, 2, 3, 4, 6, 8, 12,
+	16, 24, 32, 48, 64, 96, 128, 192,
+	256, 384, 512, 768, 1024, 1536, 2048, 3072,
+	4096, 6144, 8192, 12288, 16384, 24576, 32768, 49152,
+	65536, 98304, 131072, 196608, 262144, 393216, 524288, 655360,
+	786432, 917504, 1048576, 1179648, 1310720, 1441792, 1572864, 1703936,
+	1835008, 1966080, 2097152,
+}
+
+var lzxExtraOffsetBits = [...]uint8{
+	0, 0, 0, 0, 1, 1, 2, 2,
+	3, 3, 4, 4, 5, 5, 6, 6,
+	7, 7, 8, 8, 9, 9, 10, 10,
+	11, 11, 12, 12, 13, 13, 14, 14,
+	15, 15, 16, 16, 17, 17, 17, 17,
+	17, 17, 17, 17, 17, 17, 17, 17,
+	17, 17, 17,
+}
+
+// lzxNumOffsetSlots returns how many offset slots a window of the given size
+// needs.
+func lzxNumOffsetSlots(windowSize int) int {
+	n := 0
+	for n < len(lzxOffsetSlotBase) && lzxOffsetSlotBase[n] < uint32(windowSize) {
+		n++
+	}
+	return n
+}
+
+// lzxWindowOrder returns log2 of the LZX window used for chunks of the given
+// size, or 0 if the size is not a supported power of two.
+func lzxWindowOrder(chunkSize int) uint {
+	if chunkSize <= 0 || chunkSize&(chunkSize-1) != 0 {
+		return 0
+	}
+	order := uint(bits.TrailingZeros(uint(chunkSize)))
+	if order < lzxMinWindowOrder || order > lzxMaxWindowOrder {
+		return 0
+	}
+	return order
+}
+
+// lzxBitReader reads 16-bit little-endian words MSB first and refills one
+// word at a time, which is what the uncompressed-block alignment rule is
+// defined against.
+type lzxBitReader struct {
+	src  []byte
+	pos  int
+	buf  uint32
+	left uint
+}
+
+func (r *lzxBitReader) ensure(n uint) {
+	if r.left >= n {
+		return
+	}
+	var w uint32
+	if r.pos+2 <= len(r.src) {
+		w = uint32(binary.LittleEndian.Uint16(r.src[r.pos:]))
+	}
+	r.pos += 2
+	r.buf |= w << (16 - r.left)
+	r.left += 16
+}
+
+func (r *lzxBitReader) peek(n uint) uint32 {
+	return r.buf >> (32 - n)
+}
+
+func (r *lzxBitReader) consume(n uint) {
+	r.buf <<= n
+	r.left -= n
+}
+
+func (r *lzxBitReader) read(n uint) uint32 {
+	if n == 0 {
+		return 0
+	}
+	if n > 16 {
+		hi := r.read(n - 16)
+		return hi<<16 | r.read(16)
+	}
+	r.ensure(n)
+	v := r.peek(n)
+	r.consume(n)
+	return v
+}
+
+func (r *lzxBitReader) overrun() bool {
+	return r.pos > len(r.src)+4
+}
+
+// alignToBytes pads the bit position to the next 16-bit boundary (a full
+// 16 bits if already aligned) and returns the byte offset after the padding.
+func (r *lzxBitReader) alignToBytes() int {
+	bitPos := r.pos*8 - int(r.left)
+	bitPos += 16 - bitPos%16
+	return bitPos / 8
+}
+
+func (r *lzxBitReader) reset(pos int) {
+	r.pos = pos
+	r.buf = 0
+	r.left = 0
+}
+
+func (r *lzxBitReader) decode(h *huffmanDecoder) (int, error) {
+	r.ensure(lzxMaxCodeLen)
+	sym, n, ok := h.decode(r.peek(lzxMaxCodeLen))
+	if !ok {
+		return 0, errCorruptChunk
+	}
+	r.consume(n)
+	return int(sym), nil
+}
+
+type lzxDecoder struct {
+	numMainSyms int
+	mainLens    []uint8
+	lenLens     [lzxLenCodeSymbols]uint8
+	main        huffmanDecoder
+	length      huffmanDecoder
+	aligned     huffmanDecoder
+	pre         huffmanDecoder
+}
+
+// lzxDecompress decodes one WIM LZX chunk into dst. chunkSize is the WIM
+// chunk size, which fixes the LZX window.
+func lzxDecompress(dst, src []byte, chunkSize int) error {
+	order := lzxWindowOrder(chunkSize)
+	if order == 0 || len(dst) > chunkSize {
+		return errCorruptChunk
+	}
+	d := &lzxDecoder{numMainSyms: lzxNumChars + lzxNumOffsetSlots(chunkSize)*8}
+	d.mainLens = make([]uint8, d.numMainSyms)
+
+	r := &lzxBitReader{src: src}
+	recent := [lzxNumRecentOffsets]uint32{1, 1, 1}
+	out := 0
+	for out < len(dst) {
+		r.ensure(4)
+		blockType := r.read(3)
+		var blockSize int
+		if r.read(1) == 1 {
+			blockSize = lzxDefaultBlockSize
+		} else {
+			blockSize = int(r.read(16))
+			if order >= 16 {
+				blockSize = blockSize<<8 | int(r.read(8))
+			}
+		}
+		if blockSize == 0 || blockSize > len(dst)-out {
+			return errCorruptChunk
+		}
+
+		switch blockType {
+		case lzxBlockVerbatim, lzxBlockAligned:
+			if blockType == lzxBlockAligned {
+				var alignedLens [lzxAlignedSymbols]uint8
+				for i := range alignedLens {
+					alignedLens[i] = uint8(r.read(3))
+				}
+				if err := d.aligned.init(alignedLens[:], lzxMaxCodeLen); err != nil {
+					return err
+				}
+			}
+			if err := d.readLens(r, d.mainLens[:lzxNumChars]); err != nil {
+				return err
+			}
+			if err := d.readLens(r, d.mainLens[lzxNumChars:]); err != nil {
+				return err
+			}
+			if err := d.main.init(d.mainLens, lzxMaxCodeLen); err != nil {
+				return err
+			}
+			if err := d.readLens(r, d.lenLens[:]); err != nil {
+				return err
+			}
+			if err := d.length.init(d.lenLens[:], lzxMaxCodeLen); err != nil {
+				return err
+			}
+			n, err := d.decodeBlock(r, dst, out, blockSize, blockType == lzxBlockAligned, &recent)
+			if err != nil {
+				return err
+			}
+			out += n
+		case lzxBlockUncompressed:
+			pos := r.alignToBytes()
+			if pos+12 > len(src) {
+				return errCorruptChunk
+			}
+			for i := range recent {
+				recent[i] = binary.LittleEndian.Uint32(src[pos+4*i:])
+			}
+			pos += 12
+			if pos+blockSize > len(src) {
+				return errCorruptChunk
+			}
+			copy(dst[out:], src[pos:pos+blockSize])
+			out += blockSize
+			pos += blockSize
+			if blockSize&1 != 0 {
+				pos++
+			}
+			r.reset(pos)
+		default:
+			return errCorruptChunk
+		}
+		if r.overrun() {
+			return errCorruptChunk
+		}
+	}
+
+	lzxUndoE8(dst)
+	return nil
+}
+
+// readLens reads code lengths coded as deltas against lens through a
+// pretree, updating lens in place.
+func (d *lzxDecoder) readLens(r *lzxBitReader, lens []uint8) error {
+	var preLens [lzxPreCodeSymbols]uint8
+	for i := range preLens {
+		preLens[i] = uint8(r.read(4))
+	}
+	if err := d.pre.init(preLens[:], lzxMaxCodeLen); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(lens); {
+		presym, err := r.decode(&d.pre)
+		if err != nil {
+			return err
+		}
+		switch {
+		case presym < 17:
+			lens[i] = uint8((int(lens[i]) - presym + 17) % 17)
+			i++
+		case presym == 17, presym == 18:
+			var run int
+			if presym == 17 {
+				run = 4 + int(r.read(4))
+			} else {
+				run = 20 + int(r.read(5))
+			}
+			for ; run > 0 && i < len(lens); run-- {
+				lens[i] = 0
+				i++
+			}
+		default:
+			run := 4 + int(r.read(1))
+			presym, err = r.decode(&d.pre)
+			if err != nil {
+				return err
+			}
+			if presym > 16 {
+				return errCorruptChunk
+			}
+			l := uint8((int(lens[i]) - presym + 17) % 17)
+			for ; run > 0 && i < len(lens); run-- {
+				lens[i] = l
+				i++
+			}
+		}
+	}
+	return nil
+}
+
+func (d *lzxDecoder) decodeBlock(r *lzxBitReader, dst []byte, out, size int, aligned bool, recent *[lzxNumRecentOffsets]uint32) (int, error) {
+	start := out
+	end := out + size
+	for out < end {
+		sym, err := r.decode(&d.main)
+		if err != nil {
+			return 0, err
+		}
+		if sym < lzxNumChars {
+			dst[out] = byte(sym)
+			out++
+			continue
+		}
+
+		sym -= lzxNumChars
+		length := sym & 7
+		slot := sym >> 3
+		if length == lzxNumPrimaryLens {
+			l, err := r.decode(&d.length)
+			if err != nil {
+				return 0, err
+			}
+			length += l
+		}
+		length += lzxMinMatchLen
+
+		var offset uint32
+		switch slot {
+		case 0:
+			offset = recent[0]
+		case 1:
+			offset = recent[1]
+			recent[1] = recent[0]
+			recent[0] = offset
+		case 2:
+			offset = recent[2]
+			recent[2] = recent[0]
+			recent[0] = offset
+		default:
+			extra := uint(lzxExtraOffsetBits[slot])
+			formatted := lzxOffsetSlotBase[slot]
+			if aligned && extra >= lzxAlignedOffsetBits {
+				formatted += r.read(extra-lzxAlignedOffsetBits) << lzxAlignedOffsetBits
+				a, err := r.decode(&d.aligned)
+				if err != nil {
+					return 0, err
+				}
+				formatted += uint32(a)
+			} else {
+				formatted += r.read(extra)
+			}
+			offset = formatted - lzxOffsetAdjustment
+			recent[2] = recent[1]
+			recent[1] = recent[0]
+			recent[0] = offset
+		}
+
+		if offset == 0 || int(offset) > out || length > end-out {
+			return 0, errCorruptChunk
+		}
+		copyMatch(dst, out, int(offset), length)
+		out += length
+	}
+	return out - start, nil
+}
+
+// lzxUndoE8 reverses the x86 CALL translation applied before compression.
+func lzxUndoE8(data []byte) {
+	lzxE8Filter(data, func(target []byte, pos int32) {
+		abs := int32(binary.LittleEndian.Uint32(target))
+		if abs >= 0 {
+			if abs < lzxE8FileSize {
+				binary.LittleEndian.PutUint32(target, uint32(abs-pos))
+			}
+		} else if abs >= -pos {
+			binary.LittleEndian.PutUint32(target, uint32(abs+lzxE8FileSize))
+		}
+	})
+}
+
+// lzxDoE8 applies the x86 CALL translation; it is the inverse of lzxUndoE8.
+func lzxDoE8(data []byte) {
+	lzxE8Filter(data, func(target []byte, pos int32) {
+		rel := int32(binary.LittleEndian.Uint32(target))
+		if rel >= -pos && rel < lzxE8FileSize {
+			if rel < lzxE8FileSize-pos {
+				binary.LittleEndian.PutUint32(target, uint32(rel+pos))
+			} else {
+				binary.LittleEndian.PutUint32(target, uint32(rel-lzxE8FileSize))
+			}
+		}
+	})
+}
+
+func lzxE8Filter(data []byte, fn func(target []byte, pos int32)) {
+	if len(data) <= 10 {
+		return
+	}
+	for i := 0; i < len(data)-10; {
+		if data[i] != 0xE8 {
+			i++
+			continue
+		}
+		fn(data[i+1:i+5], int32(i))
+		i += 5
+	}
+}
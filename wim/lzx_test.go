@@ -0,0 +1,227 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// putTestLens codes lens against all-zero previous lengths with a flat 5-bit
+// pretree.
+func putTestLens(w *lzxBitWriter, lens []uint8) {
+	for i := 0; i < lzxPreCodeSymbols; i++ {
+		w.put(5, 4)
+	}
+	for _, l := range lens {
+		w.put(uint32((17-int(l))%17), 5)
+	}
+}
+
+type lzxOp struct {
+	lit    byte
+	offset int
+	rep    int // repeat match of recent offset rep-1
+	length int
+}
+
+// lzxFixedEncode encodes ops as a single verbatim block in which every main
+// symbol has a 9-bit code and every length symbol an 8-bit code.
+func lzxFixedEncode(ops []lzxOp, size, chunkSize int) []byte {
+	numMain := lzxNumChars + lzxNumOffsetSlots(chunkSize)*8
+	var w lzxBitWriter
+	w.put(lzxBlockVerbatim, 3)
+	if size == lzxDefaultBlockSize {
+		w.put(1, 1)
+	} else {
+		w.put(0, 1)
+		w.put(uint32(size), 16)
+	}
+	putTestLens(&w, bytes.Repeat([]byte{9}, lzxNumChars))
+	putTestLens(&w, bytes.Repeat([]byte{9}, numMain-lzxNumChars))
+	putTestLens(&w, bytes.Repeat([]byte{8}, lzxLenCodeSymbols))
+
+	for _, op := range ops {
+		l := op.length - lzxMinMatchLen
+		switch {
+		case op.rep != 0:
+			w.put(uint32(lzxNumChars+(op.rep-1)*8+min(l, lzxNumPrimaryLens)), 9)
+			if l >= lzxNumPrimaryLens {
+				w.put(uint32(l-lzxNumPrimaryLens), 8)
+			}
+			continue
+		case op.offset == 0:
+			w.put(uint32(op.lit), 9)
+			continue
+		}
+		formatted := uint32(op.offset + lzxOffsetAdjustment)
+		slot := 0
+		for lzxOffsetSlotBase[slot+1] <= formatted {
+			slot++
+		}
+		w.put(uint32(lzxNumChars+slot*8+min(l, lzxNumPrimaryLens)), 9)
+		if l >= lzxNumPrimaryLens {
+			w.put(uint32(l-lzxNumPrimaryLens), 8)
+		}
+		w.put(formatted-lzxOffsetSlotBase[slot], uint(lzxExtraOffsetBits[slot]))
+	}
+	return w.flush()
+}
+
+func TestLZXDecompressVerbatim(t *testing.T) {
+	want := []byte("\xe8\x10\x00\x00\x00 call target; ")
+	head := len(want)
+	for _, m := range []struct{ offset, length int }{{5, 12}, {20, 200}, {1, 40}} {
+		for i := 0; i < m.length; i++ {
+			want = append(want, want[len(want)-m.offset])
+		}
+	}
+	want = append(want, "tail padding...."...)
+
+	// Compress the E8-translated form, as the encoder would.
+	translated := bytes.Clone(want)
+	lzxDoE8(translated)
+	var ops []lzxOp
+	for _, c := range translated[:head] {
+		ops = append(ops, lzxOp{lit: c})
+	}
+	ops = append(ops, lzxOp{offset: 5, length: 12}, lzxOp{offset: 20, length: 200}, lzxOp{offset: 1, length: 40})
+	for _, c := range translated[len(translated)-16:] {
+		ops = append(ops, lzxOp{lit: c})
+	}
+
+	src := lzxFixedEncode(ops, len(want), defaultChunk)
+	got := make([]byte, len(want))
+	if err := lzxDecompress(got, src, defaultChunk); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+// TestLZXRecentOffsets checks repeat matches against output worked out by
+// hand from the format: an explicit offset pushes R0 and R1 down, and a
+// repeat of R1 or R2 swaps it with R0.
+func TestLZXRecentOffsets(t *testing.T) {
+	var ops []lzxOp
+	lits := func(s string) {
+		for _, c := range []byte(s) {
+			ops = append(ops, lzxOp{lit: c})
+		}
+	}
+	lits("abcdefgh")
+	ops = append(ops, lzxOp{offset: 5, length: 3}) // "def", R = 5 1 1
+	lits("X")
+	ops = append(ops, lzxOp{offset: 7, length: 3}) // "fgh", R = 7 5 1
+	lits("Y")
+	ops = append(ops,
+		lzxOp{rep: 2, length: 4}, // R1 = 5: "Xfgh", R = 5 7 1
+		lzxOp{rep: 1, length: 3}, // R0 = 5: "YXf"
+		lzxOp{rep: 3, length: 3}, // R2 = 1: "fff", R = 1 7 5
+		lzxOp{rep: 3, length: 3}, // R2 = 5: "Xff", R = 5 7 1
+	)
+	lits("Z")
+	want := "abcdefghdefXfghYXfghYXffffXffZ"
+
+	src := lzxFixedEncode(ops, len(want), defaultChunk)
+	got := make([]byte, len(want))
+	if err := lzxDecompress(got, src, defaultChunk); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestLZXDecompressUncompressedBlock(t *testing.T) {
+	want := []byte("stored verbatim, odd length")
+	var w lzxBitWriter
+	w.put(lzxBlockUncompressed, 3)
+	w.put(0, 1)
+	w.put(uint32(len(want)), 16)
+	src := w.flush()
+	// 20 header bits pad to 32; the recent offsets follow.
+	src = append(src, make([]byte, 12)...)
+	binary.LittleEndian.PutUint32(src[len(src)-12:], 1)
+	src = append(src, want...)
+
+	got := make([]byte, len(want))
+	if err := lzxDecompress(got, src, defaultChunk); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestLZXE8RoundTrip(t *testing.T) {
+	data := make([]byte, 4096)
+	for i := 0; i+5 <= len(data); i += 37 {
+		data[i] = 0xE8
+		binary.LittleEndian.PutUint32(data[i+1:], uint32(int32(i*13-2000)))
+	}
+	orig := bytes.Clone(data)
+	lzxDoE8(data)
+	if bytes.Equal(data, orig) {
+		t.Fatal("lzxDoE8 changed nothing")
+	}
+	lzxUndoE8(data)
+	if !bytes.Equal(data, orig) {
+		t.Fatal("E8 translation did not round-trip")
+	}
+}
+
+// lzxDecompressor adapts lzxDecompress to a fixed chunk size.
+func lzxDecompressor(chunkSize int) func(dst, src []byte) error {
+	return func(dst, src []byte) error {
+		return lzxDecompress(dst, src, chunkSize)
+	}
+}
+
+// lzxCallInput looks like x86 code: E8 calls to a few targets between
+// runs of filler, so that E8 translation makes the targets repeat.
+func lzxCallInput(size int) []byte {
+	rng := rand.New(rand.NewSource(3))
+	var b []byte
+	for len(b) < size {
+		b = append(b, 0xE8)
+		b = binary.LittleEndian.AppendUint32(b, uint32(0x4000+rng.Intn(4)*0x100-len(b)))
+		b = append(b, "\x8b\x45\xfc\x89\x04\x24"[:rng.Intn(6)]...)
+	}
+	return b[:size]
+}
+
+func TestLZXCompressOptimalParse(t *testing.T) {
+	src := compressTestInputs()["text"][:1<<15]
+	decompress := lzxDecompressor(1 << 15)
+	lazy := chunkRoundTrip(t, decompress, newLZXCompressor(1<<15, defaultLevel), src)
+	optimal := chunkRoundTrip(t, decompress, newLZXCompressor(1<<15, maxLevel), src)
+	if optimal > lazy {
+		t.Errorf("optimal parse gave %d bytes, lazy %d", optimal, lazy)
+	}
+}
+
+func TestLZXCompressSplitsBlocks(t *testing.T) {
+	// Text followed by calls: the two halves want different codes.
+	src := append(compressTestInputs()["text"][:1<<15], lzxCallInput(1<<15)...)
+	compress := makeLZXCompressor(1<<16, defaultLevel)
+	dst := make([]byte, len(src)-1)
+	n := compress.compress(dst, src)
+	if n == 0 {
+		t.Fatal("not compressed")
+	}
+	if len(compress.blocks) < 2 {
+		t.Errorf("%d blocks", len(compress.blocks))
+	}
+	got := make([]byte, len(src))
+	if err := lzxDecompress(got, dst[:n], 1<<16); err != nil || !bytes.Equal(got, src) {
+		t.Fatalf("round trip: %v", err)
+	}
+}
+
+func FuzzLZXCompress(f *testing.F) {
+	fuzzCompress(f, wimgapi.CompressLZX)
+}
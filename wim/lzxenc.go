@@ -0,0 +1,733 @@
+package wim
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+const (
+	lzxMaxMatchLen = lzxMinMatchLen + lzxNumPrimaryLens + lzxLenCodeSymbols - 1
+
+	// lzxSplitSize is the spacing of the block boundaries the encoder
+	// considers; neighbouring pieces are merged while that is cheaper.
+	lzxSplitSize = 8192
+	// lzxOptimalWindow is how many positions the optimal parser plans at
+	// once.
+	lzxOptimalWindow = 32768
+	// lzxOptimalAllLens is the match length up to which the optimal parser
+	// tries every shorter length too.
+	lzxOptimalAllLens = 32
+	// lzxUnusedCost is the cost in bits the optimal parser assumes for a
+	// symbol that has no code yet.
+	lzxUnusedCost = 15
+)
+
+// lzxPreExtraBits is the number of extra bits after each pretree symbol.
+var lzxPreExtraBits = [lzxPreCodeSymbols]uint8{17: 4, 18: 5, 19: 1}
+
+// lzxBitWriter writes 16-bit little-endian words, MSB first.
+type lzxBitWriter struct {
+	out   []byte
+	bits  uint32
+	count uint
+}
+
+func (w *lzxBitWriter) put(v uint32, n uint) {
+	for n > 0 {
+		take := min(n, 16-w.count)
+		n -= take
+		w.bits = w.bits<<take | (v>>n)&(1<<take-1)
+		w.count += take
+		if w.count == 16 {
+			w.out = binary.LittleEndian.AppendUint16(w.out, uint16(w.bits))
+			w.bits, w.count = 0, 0
+		}
+	}
+}
+
+func (w *lzxBitWriter) flush() []byte {
+	if w.count > 0 {
+		w.put(0, 16-w.count)
+	}
+	return w.out
+}
+
+// lzxRecent is the queue of recent match offsets that LZX offset slots 0-2
+// refer to.
+type lzxRecent [lzxNumRecentOffsets]uint32
+
+var lzxInitialRecent = lzxRecent{1, 1, 1}
+
+// use updates the queue for a match at offset the way the decoder does and
+// returns the slot (0-2) that repeats it, or -1 for a new offset.
+func (q *lzxRecent) use(offset uint32) int {
+	for i, r := range q {
+		if r == offset {
+			q[0], q[i] = q[i], q[0]
+			return i
+		}
+	}
+	q[2], q[1], q[0] = q[1], q[0], offset
+	return -1
+}
+
+// lzxOffsetSlot returns the offset slot for a formatted offset (the match
+// offset plus lzxOffsetAdjustment).
+func lzxOffsetSlot(formatted uint32) int {
+	if formatted < 4 {
+		return int(formatted)
+	}
+	if formatted < 1<<18 {
+		n := bits.Len32(formatted) - 1
+		return 2*n + int(formatted>>(n-1)&1)
+	}
+	return 36 + int((formatted-1<<18)>>17)
+}
+
+// lzxItem is a literal when length is 0 and a match otherwise. offset is
+// the match distance; lzxSymbolize decides how it is coded.
+type lzxItem struct {
+	lit    byte
+	length uint16
+	offset uint32
+	main   uint16 // main tree symbol
+	extra  uint32 // formatted offset minus the slot base
+}
+
+func (it *lzxItem) span() int {
+	if it.length == 0 {
+		return 1
+	}
+	return int(it.length)
+}
+
+// lenSym returns the length tree symbol of a match, or -1 if it has none.
+func (it *lzxItem) lenSym() int {
+	return int(it.length) - lzxMinMatchLen - lzxNumPrimaryLens
+}
+
+// lzxSymbolize sets the symbols of items, starting from the recent offset
+// queue recent, and returns the queue after them.
+func lzxSymbolize(items []lzxItem, recent lzxRecent) lzxRecent {
+	for i := range items {
+		it := &items[i]
+		if it.length == 0 {
+			it.main = uint16(it.lit)
+			continue
+		}
+		slot := recent.use(it.offset)
+		it.extra = 0
+		if slot < 0 {
+			formatted := it.offset + lzxOffsetAdjustment
+			slot = lzxOffsetSlot(formatted)
+			it.extra = formatted - lzxOffsetSlotBase[slot]
+		}
+		it.main = uint16(lzxNumChars + slot*8 + min(int(it.length)-lzxMinMatchLen, lzxNumPrimaryLens))
+	}
+	return recent
+}
+
+func lzxMatchLen(buf []byte, pos, offset, maxLen int) int {
+	if offset > pos {
+		return 0
+	}
+	maxLen = min(maxLen, len(buf)-pos)
+	n := 0
+	for n < maxLen && buf[pos+n] == buf[pos+n-offset] {
+		n++
+	}
+	return n
+}
+
+// lzxBlockStats counts the symbols of a run of items, to build a block's
+// codes or estimate its size.
+type lzxBlockStats struct {
+	size      int
+	main      []uint32
+	length    [lzxLenCodeSymbols]uint32
+	aligned   [lzxAlignedSymbols]uint32
+	extraBits int // offset bits written as is in a verbatim block
+	alignable int // offsets whose low bits an aligned block codes instead
+}
+
+func (s *lzxBlockStats) reset() {
+	s.size, s.extraBits, s.alignable = 0, 0, 0
+	clear(s.main)
+	clear(s.length[:])
+	clear(s.aligned[:])
+}
+
+func (s *lzxBlockStats) add(items []lzxItem) {
+	for i := range items {
+		it := &items[i]
+		s.size += it.span()
+		s.main[it.main]++
+		if it.length == 0 {
+			continue
+		}
+		if l := it.lenSym(); l >= 0 {
+			s.length[l]++
+		}
+		slot := (int(it.main) - lzxNumChars) >> 3
+		extra := int(lzxExtraOffsetBits[slot])
+		if slot >= lzxNumRecentOffsets {
+			s.extraBits += extra
+			if extra >= lzxAlignedOffsetBits {
+				s.alignable++
+				s.aligned[it.extra&7]++
+			}
+		}
+	}
+}
+
+func (s *lzxBlockStats) merge(a, b *lzxBlockStats) {
+	s.size = a.size + b.size
+	s.extraBits = a.extraBits + b.extraBits
+	s.alignable = a.alignable + b.alignable
+	for i := range s.main {
+		s.main[i] = a.main[i] + b.main[i]
+	}
+	for i := range s.length {
+		s.length[i] = a.length[i] + b.length[i]
+	}
+	for i := range s.aligned {
+		s.aligned[i] = a.aligned[i] + b.aligned[i]
+	}
+}
+
+// lzxStep records how the optimal parser's best path reaches a position:
+// a literal when length is 0.
+type lzxStep struct {
+	length uint16
+	offset uint32
+}
+
+// lzxCompressor encodes chunks as WIM LZX: E8-translated, parsed lazily or
+// by cost, and split into verbatim or aligned-offset blocks.
+type lzxCompressor struct {
+	order       uint
+	numMainSyms int
+	params      levelParams
+	mf          *matchFinder
+	buf         []byte
+	items       []lzxItem
+
+	// Optimal parsing state for one window.
+	matches  []lzMatch
+	matchIdx []int32
+	cost     []uint32
+	steps    []lzxStep
+	states   []lzxRecent
+	mainCost []uint32
+	lenCost  [lzxLenCodeSymbols]uint32
+
+	// Block coding state.
+	stats       [3]lzxBlockStats
+	blocks      []int // item index where each block ends
+	mainLens    []uint8
+	prevMain    []uint8
+	zeroMain    []uint8
+	mainCodes   []uint32
+	lenLens     [lzxLenCodeSymbols]uint8
+	prevLen     [lzxLenCodeSymbols]uint8
+	lenCodes    [lzxLenCodeSymbols]uint32
+	alignedLens [lzxAlignedSymbols]uint8
+	alignedCode [lzxAlignedSymbols]uint32
+	precode     []uint32
+	bw          lzxBitWriter
+}
+
+func newLZXCompressor(chunkSize, level int) chunkCompressor {
+	return makeLZXCompressor(chunkSize, level).compress
+}
+
+func makeLZXCompressor(chunkSize, level int) *lzxCompressor {
+	numSlots := lzxNumOffsetSlots(chunkSize)
+	c := &lzxCompressor{
+		order:       lzxWindowOrder(chunkSize),
+		numMainSyms: lzxNumChars + numSlots*8,
+		params:      compressionLevels[level],
+	}
+	maxOffset := int(lzxOffsetSlotBase[numSlots]) - 3
+	c.mf = newMatchFinder(chunkSize, c.params.maxChain, min(c.params.niceLen, lzxMaxMatchLen), maxOffset)
+	for i := range c.stats {
+		c.stats[i].main = make([]uint32, c.numMainSyms)
+	}
+	c.mainLens = make([]uint8, c.numMainSyms)
+	c.prevMain = make([]uint8, c.numMainSyms)
+	c.zeroMain = make([]uint8, c.numMainSyms)
+	c.mainCodes = make([]uint32, c.numMainSyms)
+	c.mainCost = make([]uint32, c.numMainSyms)
+	return c
+}
+
+func (c *lzxCompressor) compress(dst, src []byte) int {
+	c.buf = append(c.buf[:0], src...)
+	lzxDoE8(c.buf)
+	c.mf.reset()
+	c.items = c.items[:0]
+	if c.params.optimalPasses > 0 {
+		c.parseOptimal()
+	} else {
+		c.parseLazy()
+	}
+	lzxSymbolize(c.items, lzxInitialRecent)
+	c.splitBlocks()
+	out := c.writeBlocks(len(dst))
+	if out == nil || len(out) > len(dst) {
+		return 0
+	}
+	return copy(dst, out)
+}
+
+// parseLazy parses greedily or, when lazy, defers a match by one byte if
+// the next position has a longer one.
+func (c *lzxCompressor) parseLazy() {
+	buf := c.buf
+	recent := lzxInitialRecent
+	pos := 0
+	cur := c.lazyMatch(pos, &recent)
+	for pos < len(buf) {
+		if cur.length == 0 {
+			c.items = append(c.items, lzxItem{lit: buf[pos]})
+			pos++
+			if pos < len(buf) {
+				cur = c.lazyMatch(pos, &recent)
+			}
+			continue
+		}
+		next := pos + 1 // first position not yet inserted
+		if c.params.lazy && int(cur.length) < c.mf.niceLen && pos+1 < len(buf) {
+			if m := c.lazyMatch(pos+1, &recent); m.length > cur.length {
+				c.items = append(c.items, lzxItem{lit: buf[pos]})
+				pos++
+				cur = m
+				continue
+			}
+			next++
+		}
+		c.items = append(c.items, lzxItem{length: uint16(cur.length), offset: uint32(cur.offset)})
+		recent.use(uint32(cur.offset))
+		for ; next < pos+int(cur.length); next++ {
+			c.mf.insert(buf, next)
+		}
+		pos += int(cur.length)
+		if pos < len(buf) {
+			cur = c.lazyMatch(pos, &recent)
+		}
+	}
+}
+
+// lazyMatch inserts pos and returns the match to take there: the longest
+// one, unless repeating a recent offset, which needs no offset bits,
+// covers nearly as much.
+func (c *lzxCompressor) lazyMatch(pos int, recent *lzxRecent) lzMatch {
+	length, offset := c.mf.find(c.buf, pos, lzxMaxMatchLen)
+	best := lzMatch{length: int32(length), offset: int32(offset)}
+	var rep lzMatch
+	for _, r := range recent {
+		if n := lzxMatchLen(c.buf, pos, int(r), lzxMaxMatchLen); n > int(rep.length) {
+			rep = lzMatch{length: int32(n), offset: int32(r)}
+		}
+	}
+	if rep.length >= lzxMinMatchLen && rep.length+1 >= best.length {
+		return rep
+	}
+	return best
+}
+
+// parseOptimal plans each window as the cheapest path through literals,
+// repeat matches and new matches under a cost model taken from the
+// previous pass, or from the previous window on the first pass.
+func (c *lzxCompressor) parseOptimal() {
+	for i := range c.mainCost {
+		c.mainCost[i] = 9
+	}
+	for i := range c.lenCost {
+		c.lenCost[i] = 8
+	}
+	recent := lzxInitialRecent
+	for start := 0; start < len(c.buf); start += lzxOptimalWindow {
+		end := min(start+lzxOptimalWindow, len(c.buf))
+		c.collectMatches(start, end)
+		first := len(c.items)
+		for pass := 0; pass < c.params.optimalPasses; pass++ {
+			if pass > 0 {
+				lzxSymbolize(c.items[first:], recent)
+				c.updateCosts(c.items[first:])
+				c.items = c.items[:first]
+			}
+			c.optimalPath(start, end, recent)
+		}
+		next := lzxSymbolize(c.items[first:], recent)
+		c.updateCosts(c.items[first:])
+		recent = next
+	}
+}
+
+// collectMatches finds the match candidates of every position in
+// [start, end). Past a match of nice length, positions are only inserted.
+func (c *lzxCompressor) collectMatches(start, end int) {
+	n := end - start
+	c.matches = c.matches[:0]
+	c.matchIdx = slices.Grow(c.matchIdx[:0], n+1)[:n+1]
+	skip := start
+	for pos := start; pos < end; pos++ {
+		c.matchIdx[pos-start] = int32(len(c.matches))
+		if pos < skip {
+			c.mf.insert(c.buf, pos)
+			continue
+		}
+		c.matches = c.mf.matches(c.buf, pos, min(lzxMaxMatchLen, end-pos), c.matches)
+		if k := len(c.matches); k > int(c.matchIdx[pos-start]) && int(c.matches[k-1].length) >= c.mf.niceLen {
+			skip = pos + int(c.matches[k-1].length)
+		}
+	}
+	c.matchIdx[n] = int32(len(c.matches))
+}
+
+// matchCost is the cost in bits of a match symbol and its length symbol.
+func (c *lzxCompressor) matchCost(slot, length int) uint32 {
+	l := length - lzxMinMatchLen
+	cost := c.mainCost[lzxNumChars+slot*8+min(l, lzxNumPrimaryLens)]
+	if l >= lzxNumPrimaryLens {
+		cost += c.lenCost[l-lzxNumPrimaryLens]
+	}
+	return cost
+}
+
+func (c *lzxCompressor) optimalPath(start, end int, recent lzxRecent) {
+	n := end - start
+	c.cost = slices.Grow(c.cost[:0], n+1)[:n+1]
+	c.steps = slices.Grow(c.steps[:0], n+1)[:n+1]
+	c.states = slices.Grow(c.states[:0], n+1)[:n+1]
+	cost, steps, states := c.cost, c.steps, c.states
+	for i := range cost {
+		cost[i] = math.MaxUint32
+	}
+	cost[0] = 0
+	states[0] = recent
+
+	relax := func(i, length int, offset uint32, cc uint32) {
+		if cc < cost[i+length] {
+			cost[i+length] = cc
+			steps[i+length] = lzxStep{length: uint16(length), offset: offset}
+			s := states[i]
+			s.use(offset)
+			states[i+length] = s
+		}
+	}
+	skip := 0
+	for i := 0; i < n; i++ {
+		pos := start + i
+		base := cost[i]
+		if cc := base + c.mainCost[c.buf[pos]]; cc < cost[i+1] {
+			cost[i+1] = cc
+			steps[i+1] = lzxStep{}
+			states[i+1] = states[i]
+		}
+		if i < skip {
+			continue
+		}
+		maxLen := min(lzxMaxMatchLen, n-i)
+		longest := 0
+		for k, r := range states[i] {
+			l := lzxMatchLen(c.buf, pos, int(r), maxLen)
+			for length := lzxMinMatchLen; length <= l; length = lzxNextLen(length, l) {
+				relax(i, length, r, base+c.matchCost(k, length))
+			}
+			longest = max(longest, l)
+		}
+		prev := lzxMinMatchLen
+		for _, m := range c.matches[c.matchIdx[i]:c.matchIdx[i+1]] {
+			formatted := uint32(m.offset) + lzxOffsetAdjustment
+			slot := lzxOffsetSlot(formatted)
+			extra := uint32(lzxExtraOffsetBits[slot])
+			for length := prev + 1; length <= int(m.length); length = lzxNextLen(length, int(m.length)) {
+				relax(i, length, uint32(m.offset), base+c.matchCost(slot, length)+extra)
+			}
+			prev = int(m.length)
+		}
+		longest = max(longest, prev)
+		if longest >= c.mf.niceLen {
+			skip = i + longest
+		}
+	}
+
+	first := len(c.items)
+	for i := n; i > 0; {
+		st := steps[i]
+		if st.length == 0 {
+			c.items = append(c.items, lzxItem{lit: c.buf[start+i-1]})
+			i--
+			continue
+		}
+		c.items = append(c.items, lzxItem{length: st.length, offset: st.offset})
+		i -= int(st.length)
+	}
+	slices.Reverse(c.items[first:])
+}
+
+// lzxNextLen returns the match length after length that the optimal parser
+// tries for a match of up to max bytes. Past lzxOptimalAllLens only the
+// full length is worth planning around.
+func lzxNextLen(length, max int) int {
+	if length >= lzxOptimalAllLens && length < max {
+		return max
+	}
+	return length + 1
+}
+
+// updateCosts sets the cost model to the code lengths symbolized items
+// would get.
+func (c *lzxCompressor) updateCosts(items []lzxItem) {
+	s := &c.stats[0]
+	s.reset()
+	s.add(items)
+	huffmanLengths(s.main, c.mainLens, lzxMaxCodeLen)
+	huffmanLengths(s.length[:], c.lenLens[:], lzxMaxCodeLen)
+	for i, l := range c.mainLens {
+		c.mainCost[i] = lzxBitCost(s.main[i], l)
+	}
+	for i, l := range c.lenLens {
+		c.lenCost[i] = lzxBitCost(s.length[i], l)
+	}
+}
+
+func lzxBitCost(freq uint32, l uint8) uint32 {
+	if freq == 0 {
+		return lzxUnusedCost
+	}
+	return uint32(l)
+}
+
+// splitBlocks cuts the items into pieces of about lzxSplitSize bytes and
+// merges neighbours while one block costs less than two.
+func (c *lzxCompressor) splitBlocks() {
+	c.blocks = c.blocks[:0]
+	cur, piece, merged := &c.stats[0], &c.stats[1], &c.stats[2]
+	cur.reset()
+	curCost := 0
+	for start := 0; start < len(c.items); {
+		end, size := start, 0
+		for end < len(c.items) && size < lzxSplitSize {
+			size += c.items[end].span()
+			end++
+		}
+		piece.reset()
+		piece.add(c.items[start:end])
+		pieceCost := c.blockCost(piece)
+		if start == 0 {
+			cur, piece = piece, cur
+			curCost = pieceCost
+		} else {
+			merged.merge(cur, piece)
+			if mergedCost := c.blockCost(merged); mergedCost <= curCost+pieceCost {
+				cur, merged = merged, cur
+				curCost = mergedCost
+			} else {
+				c.blocks = append(c.blocks, start)
+				cur, piece = piece, cur
+				curCost = pieceCost
+			}
+		}
+		start = end
+	}
+	c.blocks = append(c.blocks, len(c.items))
+}
+
+// blockCost estimates the size in bits of a block with the counts in s.
+func (c *lzxCompressor) blockCost(s *lzxBlockStats) int {
+	huffmanLengths(s.main, c.mainLens, lzxMaxCodeLen)
+	huffmanLengths(s.length[:], c.lenLens[:], lzxMaxCodeLen)
+	cost := 3 + 1 + 24 + s.extraBits
+	cost += c.precodeCost(c.mainLens, c.zeroMain) + c.precodeCost(c.lenLens[:], c.zeroMain[:lzxLenCodeSymbols])
+	for i, f := range s.main {
+		cost += int(f) * int(c.mainLens[i])
+	}
+	for i, f := range s.length {
+		cost += int(f) * int(c.lenLens[i])
+	}
+	return cost + min(0, c.alignedSaving(s))
+}
+
+// alignedSaving returns how many bits an aligned block would add compared
+// with a verbatim one, which is negative when it is smaller. It leaves the
+// aligned code in c.alignedLens.
+func (c *lzxCompressor) alignedSaving(s *lzxBlockStats) int {
+	if s.alignable == 0 {
+		return 0
+	}
+	huffmanLengths(s.aligned[:], c.alignedLens[:], lzxMaxAlignedLen)
+	saving := lzxAlignedSymbols*3 - s.alignable*lzxAlignedOffsetBits
+	for i, f := range s.aligned {
+		saving += int(f) * int(c.alignedLens[i])
+	}
+	return saving
+}
+
+// lzxPrecode codes lens as the pretree symbols readLens expects, as deltas
+// from prev. Each item is symbol | extra bits<<5 | following symbol<<10.
+func lzxPrecode(dst []uint32, lens, prev []uint8) []uint32 {
+	delta := func(i int) uint32 {
+		return uint32((int(prev[i]) - int(lens[i]) + 17) % 17)
+	}
+	for i := 0; i < len(lens); {
+		run := 1
+		for i+run < len(lens) && lens[i+run] == lens[i] {
+			run++
+		}
+		switch {
+		case lens[i] == 0 && run >= 4:
+			for run >= 20 {
+				r := min(run, 51)
+				dst = append(dst, 18|uint32(r-20)<<5)
+				i += r
+				run -= r
+			}
+			if run >= 4 {
+				dst = append(dst, 17|uint32(run-4)<<5)
+				i += run
+				run = 0
+			}
+		case run >= 4:
+			for ; run >= 4; run -= min(run, 5) {
+				r := min(run, 5)
+				dst = append(dst, 19|uint32(r-4)<<5|delta(i)<<10)
+				i += r
+			}
+		}
+		for ; run > 0; run-- {
+			dst = append(dst, delta(i))
+			i++
+		}
+	}
+	return dst
+}
+
+// lzxPrecodeLens builds the pretree for precode items.
+func lzxPrecodeLens(items []uint32, lens []uint8) {
+	var freqs [lzxPreCodeSymbols]uint32
+	for _, it := range items {
+		freqs[it&31]++
+		if it&31 == 19 {
+			freqs[it>>10]++
+		}
+	}
+	huffmanLengths(freqs[:], lens, lzxMaxPreCodeLen)
+}
+
+func (c *lzxCompressor) precodeCost(lens, prev []uint8) int {
+	c.precode = lzxPrecode(c.precode[:0], lens, prev)
+	var preLens [lzxPreCodeSymbols]uint8
+	lzxPrecodeLens(c.precode, preLens[:])
+	cost := lzxPreCodeSymbols * 4
+	for _, it := range c.precode {
+		sym := it & 31
+		cost += int(preLens[sym]) + int(lzxPreExtraBits[sym])
+		if sym == 19 {
+			cost += int(preLens[it>>10])
+		}
+	}
+	return cost
+}
+
+func (c *lzxCompressor) writeLens(lens, prev []uint8) {
+	c.precode = lzxPrecode(c.precode[:0], lens, prev)
+	var preLens [lzxPreCodeSymbols]uint8
+	var preCodes [lzxPreCodeSymbols]uint32
+	lzxPrecodeLens(c.precode, preLens[:])
+	huffmanCodes(preLens[:], preCodes[:])
+	for _, l := range preLens {
+		c.bw.put(uint32(l), 4)
+	}
+	for _, it := range c.precode {
+		sym := it & 31
+		c.bw.put(preCodes[sym], uint(preLens[sym]))
+		c.bw.put(it>>5&31, uint(lzxPreExtraBits[sym]))
+		if sym == 19 {
+			c.bw.put(preCodes[it>>10], uint(preLens[it>>10]))
+		}
+	}
+}
+
+// writeBlocks codes the items block by block. It gives up and returns nil
+// once the output grows past limit bytes.
+func (c *lzxCompressor) writeBlocks(limit int) []byte {
+	c.bw.out, c.bw.bits, c.bw.count = c.bw.out[:0], 0, 0
+	clear(c.prevMain)
+	clear(c.prevLen[:])
+	start := 0
+	for _, end := range c.blocks {
+		s := &c.stats[0]
+		s.reset()
+		s.add(c.items[start:end])
+		c.writeBlock(c.items[start:end], s)
+		if len(c.bw.out) > limit {
+			return nil
+		}
+		start = end
+	}
+	return c.bw.flush()
+}
+
+func (c *lzxCompressor) writeBlock(items []lzxItem, s *lzxBlockStats) {
+	aligned := c.alignedSaving(s) < 0
+	huffmanLengths(s.main, c.mainLens, lzxMaxCodeLen)
+	huffmanCodes(c.mainLens, c.mainCodes)
+	huffmanLengths(s.length[:], c.lenLens[:], lzxMaxCodeLen)
+	huffmanCodes(c.lenLens[:], c.lenCodes[:])
+
+	bw := &c.bw
+	if aligned {
+		bw.put(lzxBlockAligned, 3)
+	} else {
+		bw.put(lzxBlockVerbatim, 3)
+	}
+	switch {
+	case s.size == lzxDefaultBlockSize:
+		bw.put(1, 1)
+	case c.order >= 16:
+		bw.put(0, 1)
+		bw.put(uint32(s.size), 24)
+	default:
+		bw.put(0, 1)
+		bw.put(uint32(s.size), 16)
+	}
+	if aligned {
+		huffmanCodes(c.alignedLens[:], c.alignedCode[:])
+		for _, l := range c.alignedLens {
+			bw.put(uint32(l), 3)
+		}
+	}
+	c.writeLens(c.mainLens[:lzxNumChars], c.prevMain[:lzxNumChars])
+	c.writeLens(c.mainLens[lzxNumChars:], c.prevMain[lzxNumChars:])
+	c.writeLens(c.lenLens[:], c.prevLen[:])
+	copy(c.prevMain, c.mainLens)
+	c.prevLen = c.lenLens
+
+	for i := range items {
+		it := &items[i]
+		bw.put(c.mainCodes[it.main], uint(c.mainLens[it.main]))
+		if it.length == 0 {
+			continue
+		}
+		if l := it.lenSym(); l >= 0 {
+			bw.put(c.lenCodes[l], uint(c.lenLens[l]))
+		}
+		slot := (int(it.main) - lzxNumChars) >> 3
+		if slot < lzxNumRecentOffsets {
+			continue
+		}
+		extra := uint(lzxExtraOffsetBits[slot])
+		if aligned && extra >= lzxAlignedOffsetBits {
+			bw.put(it.extra>>lzxAlignedOffsetBits, extra-lzxAlignedOffsetBits)
+			a := it.extra & 7
+			bw.put(c.alignedCode[a], uint(c.alignedLens[a]))
+		} else {
+			bw.put(it.extra, extra)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package wim
+
+const (
+	matchHashBits = 16
+	matchMinLen   = 3
+)
+
+// matchFinder finds earlier occurrences of the bytes at a position through
+// hash chains over 3-byte prefixes, for the LZ77 stage of the encoders.
+// The chains cover a power-of-two window, which may be smaller than the
+// buffer.
+type matchFinder struct {
+	head      []int32 // hash → latest position + 1, 0 = none
+	prev      []int32 // position mod window → earlier position + 1 with the same hash
+	mask      int
+	maxChain  int // candidates examined per search
+	niceLen   int // a match this long ends the search
+	maxOffset int
+	found     []lzMatch
+}
+
+func newMatchFinder(windowSize, maxChain, niceLen, maxOffset int) *matchFinder {
+	return &matchFinder{
+		head:      make([]int32, 1<<matchHashBits),
+		prev:      make([]int32, windowSize),
+		mask:      windowSize - 1,
+		maxChain:  maxChain,
+		niceLen:   niceLen,
+		maxOffset: min(maxOffset, windowSize-1),
+	}
+}
+
+// reset forgets every inserted position before a new buffer.
+func (m *matchFinder) reset() {
+	clear(m.head)
+}
+
+func matchHash(b []byte) uint32 {
+	v := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	return (v * 2654435761) >> (32 - matchHashBits)
+}
+
+// insert records position pos of buf.
+func (m *matchFinder) insert(buf []byte, pos int) {
+	if pos+matchMinLen > len(buf) {
+		return
+	}
+	h := matchHash(buf[pos:])
+	m.prev[pos&m.mask] = m.head[h]
+	m.head[h] = int32(pos + 1)
+}
+
+// lzMatch is a match candidate: length bytes at offset bytes back.
+type lzMatch struct {
+	length int32
+	offset int32
+}
+
+// find inserts pos and returns the longest match for it that is at most
+// maxLen bytes long, preferring the nearest among equal lengths. length
+// is 0 when there is no match of at least matchMinLen bytes.
+func (m *matchFinder) find(buf []byte, pos, maxLen int) (length, offset int) {
+	m.found = m.matches(buf, pos, maxLen, m.found[:0])
+	if len(m.found) == 0 {
+		return 0, 0
+	}
+	best := m.found[len(m.found)-1]
+	return int(best.length), int(best.offset)
+}
+
+// matches inserts pos and appends to dst every match that is longer than
+// the ones before it, nearest first, up to the longest of at most maxLen
+// bytes.
+func (m *matchFinder) matches(buf []byte, pos, maxLen int, dst []lzMatch) []lzMatch {
+	maxLen = min(maxLen, len(buf)-pos)
+	if maxLen < matchMinLen {
+		m.insert(buf, pos)
+		return dst
+	}
+	h := matchHash(buf[pos:])
+	cand := int(m.head[h]) - 1
+	m.prev[pos&m.mask] = m.head[h]
+	m.head[h] = int32(pos + 1)
+
+	cur := buf[pos : pos+maxLen]
+	best := matchMinLen - 1
+	for chain := m.maxChain; cand >= 0 && chain > 0; chain-- {
+		if pos-cand > m.maxOffset {
+			break
+		}
+		c := buf[cand:]
+		if c[best] == cur[best] && c[0] == cur[0] {
+			n := 1
+			for n < maxLen && c[n] == cur[n] {
+				n++
+			}
+			if n > best {
+				best = n
+				dst = append(dst, lzMatch{length: int32(n), offset: int32(pos - cand)})
+				if n >= m.niceLen || n == maxLen {
+					break
+				}
+			}
+		}
+		cand = int(m.prev[cand&m.mask]) - 1
+	}
+	return dst
+}
@@ -0,0 +1,154 @@
+package wim
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// encodeMetadata serializes a dentry tree and its security descriptors into
+// a metadata resource, the inverse of File.Image.
+func encodeMetadata(root *Dentry, security [][]byte) []byte {
+	total := 8 + 8*len(security)
+	for _, sd := range security {
+		total += len(sd)
+	}
+	total = int(align8(uint64(total)))
+	buf := make([]byte, 0, total+dentryDiskSize*8)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(total))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(security)))
+	for _, sd := range security {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(len(sd)))
+	}
+	for _, sd := range security {
+		buf = append(buf, sd...)
+	}
+	buf = append(buf, make([]byte, total-len(buf))...)
+
+	// The root comes first, then each directory's children as one run
+	// ended by a zero length, breadth first.
+	type pending struct {
+		d   *Dentry
+		off int
+	}
+	queue := []pending{{root, len(buf)}}
+	buf = appendDentry(buf, root)
+	buf = binary.LittleEndian.AppendUint64(buf, 0)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if !p.d.IsDir() || p.d.Attributes&AttrReparsePoint != 0 {
+			continue
+		}
+		binary.LittleEndian.PutUint64(buf[p.off+16:], uint64(len(buf)))
+		for _, c := range sortedChildren(p.d) {
+			queue = append(queue, pending{c, len(buf)})
+			buf = appendDentry(buf, c)
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, 0)
+	}
+	return buf
+}
+
+// sortedChildren orders children case-insensitively, the order WIMGAPI
+// writes them in.
+func sortedChildren(d *Dentry) []*Dentry {
+	children := slices.Clone(d.Children)
+	slices.SortStableFunc(children, func(a, b *Dentry) int {
+		if c := strings.Compare(strings.ToUpper(a.Name), strings.ToUpper(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return children
+}
+
+func appendUTF16(buf []byte, s string) []byte {
+	for _, u := range utf16.Encode([]rune(s)) {
+		buf = binary.LittleEndian.AppendUint16(buf, u)
+	}
+	return buf
+}
+
+func utf16Len(s string) int {
+	return 2 * len(utf16.Encode([]rune(s)))
+}
+
+func appendDentry(buf []byte, d *Dentry) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, dentryDiskSize)...)
+	b := buf[start:]
+	binary.LittleEndian.PutUint32(b[8:], d.Attributes)
+	binary.LittleEndian.PutUint32(b[12:], uint32(d.SecurityID))
+	binary.LittleEndian.PutUint64(b[40:], wimgapi.TimeToFileTime(d.CreationTime))
+	binary.LittleEndian.PutUint64(b[48:], wimgapi.TimeToFileTime(d.LastAccessTime))
+	binary.LittleEndian.PutUint64(b[56:], wimgapi.TimeToFileTime(d.LastWriteTime))
+
+	// Like WIMGAPI, move the unnamed data to an extra stream entry when
+	// the default hash holds reparse data or named streams follow.
+	isReparse := d.Attributes&AttrReparsePoint != 0
+	unnamedExtra := isReparse || len(d.Streams) > 0
+	switch {
+	case isReparse:
+		copy(b[64:84], d.reparse.Hash[:])
+		binary.LittleEndian.PutUint32(b[88:], d.ReparseTag)
+		if d.ReparseNotFixed {
+			binary.LittleEndian.PutUint16(b[94:], reparseFlagNotFixed)
+		}
+	case !unnamedExtra:
+		copy(b[64:84], d.data.Hash[:])
+	}
+	if !isReparse {
+		binary.LittleEndian.PutUint64(b[88:], d.HardLinkGroup)
+	}
+	numStreams := len(d.Streams)
+	if unnamedExtra {
+		numStreams++
+	}
+	binary.LittleEndian.PutUint16(b[96:], uint16(numStreams))
+	binary.LittleEndian.PutUint16(b[98:], uint16(utf16Len(d.ShortName)))
+	binary.LittleEndian.PutUint16(b[100:], uint16(utf16Len(d.Name)))
+
+	if d.Name != "" {
+		buf = appendUTF16(buf, d.Name)
+		buf = append(buf, 0, 0)
+	}
+	if d.ShortName != "" {
+		buf = appendUTF16(buf, d.ShortName)
+		buf = append(buf, 0, 0)
+	}
+	buf = padTo8(buf, start)
+	binary.LittleEndian.PutUint64(buf[start:], uint64(len(buf)-start))
+
+	if unnamedExtra {
+		buf = appendStreamEntry(buf, Stream{Hash: d.data.Hash})
+	}
+	for _, s := range d.Streams {
+		buf = appendStreamEntry(buf, s)
+	}
+	return buf
+}
+
+func appendStreamEntry(buf []byte, s Stream) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, streamEntryDiskSize)...)
+	copy(buf[start+16:start+36], s.Hash[:])
+	binary.LittleEndian.PutUint16(buf[start+36:], uint16(utf16Len(s.Name)))
+	if s.Name != "" {
+		buf = appendUTF16(buf, s.Name)
+		buf = append(buf, 0, 0)
+	}
+	buf = padTo8(buf, start)
+	binary.LittleEndian.PutUint64(buf[start:], uint64(len(buf)-start))
+	return buf
+}
+
+func padTo8(buf []byte, start int) []byte {
+	for (len(buf)-start)%8 != 0 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
@@ -0,0 +1,149 @@
+package wim
+
+import (
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// chunkPipeline compresses chunks on a bounded pool of workers while the
+// caller reads the next ones, and hands them back in the order they were
+// submitted. At most limit chunk buffers exist at once, counting the one
+// being filled.
+type chunkPipeline struct {
+	newCompressor func() chunkCompressor
+	idle          chan *chunkWorker
+	workers       []*chunkWorker
+	limit         int
+	jobs          int // buffers allocated so far
+	free          []*chunkJob
+	queue         []*chunkJob // in flight, oldest first
+}
+
+// chunkJob is one chunk on its way through a chunkPipeline.
+type chunkJob struct {
+	in, out []byte
+	data    []byte // what to store: in or the compressed part of out
+	done    chan struct{}
+}
+
+// chunkWorker owns a compressor and counts what it has done. The counters
+// are read while it works.
+type chunkWorker struct {
+	compress chunkCompressor
+	chunks   atomic.Uint64
+	bytes    atomic.Uint64
+	busy     atomic.Int64 // nanoseconds
+}
+
+func newChunkPipeline(newCompressor func() chunkCompressor, workers, limit int) *chunkPipeline {
+	workers = max(1, min(workers, limit))
+	p := &chunkPipeline{
+		newCompressor: newCompressor,
+		idle:          make(chan *chunkWorker, workers),
+		workers:       make([]*chunkWorker, workers),
+		limit:         max(1, limit),
+	}
+	for i := range p.workers {
+		p.workers[i] = new(chunkWorker)
+		p.idle <- p.workers[i]
+	}
+	return p
+}
+
+// get returns an empty buffer to fill with up to n bytes. When all
+// buffers are in use it first waits for the oldest chunk and passes it to
+// emit; an error from emit is returned along with the buffer.
+func (p *chunkPipeline) get(n int, emit func(*chunkJob) error) (*chunkJob, error) {
+	var job *chunkJob
+	var err error
+	switch {
+	case len(p.free) > 0:
+		job = p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+	case p.jobs < p.limit:
+		job = &chunkJob{done: make(chan struct{}, 1)}
+		p.jobs++
+	default:
+		job = p.queue[0]
+		p.queue = p.queue[1:]
+		<-job.done
+		err = emit(job)
+	}
+	// Buffers grow to the chunks they get, which matters for the large
+	// chunks of solid resources.
+	job.in = slices.Grow(job.in[:0], n)[:0]
+	return job, err
+}
+
+// submit starts compressing the chunk in job.in on the next idle worker.
+func (p *chunkPipeline) submit(job *chunkJob) {
+	if cap(job.out) < len(job.in) {
+		job.out = make([]byte, len(job.in))
+	}
+	p.queue = append(p.queue, job)
+	go func() {
+		w := <-p.idle
+		if w.compress == nil {
+			w.compress = p.newCompressor()
+		}
+		start := time.Now()
+		job.data = job.in
+		if n := w.compress(job.out[:len(job.in)-1], job.in); n > 0 {
+			job.data = job.out[:n]
+		}
+		w.busy.Add(int64(time.Since(start)))
+		w.chunks.Add(1)
+		w.bytes.Add(uint64(len(job.in)))
+		p.idle <- w
+		job.done <- struct{}{}
+	}()
+}
+
+// drain waits for every chunk in flight and passes each to emit in order.
+// After emit fails, or when it is nil, the rest are only waited for.
+func (p *chunkPipeline) drain(emit func(*chunkJob) error) error {
+	var err error
+	for _, job := range p.queue {
+		<-job.done
+		if err == nil && emit != nil {
+			err = emit(job)
+		}
+		p.free = append(p.free, job)
+	}
+	p.queue = p.queue[:0]
+	return err
+}
+
+// put returns a buffer that was not submitted.
+func (p *chunkPipeline) put(job *chunkJob) {
+	p.free = append(p.free, job)
+}
+
+// WorkerProgress is what one compression worker has done.
+type WorkerProgress struct {
+	Chunks uint64
+	Bytes  uint64        // uncompressed bytes compressed
+	Busy   time.Duration // time spent compressing
+}
+
+// BytesPerSec is the worker's throughput while busy.
+func (p WorkerProgress) BytesPerSec() float64 {
+	if p.Busy <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Busy.Seconds()
+}
+
+// addWorkers adds the counters of p's workers to ws, by worker number.
+func (p *chunkPipeline) addWorkers(ws []WorkerProgress) []WorkerProgress {
+	for i, w := range p.workers {
+		if i == len(ws) {
+			ws = append(ws, WorkerProgress{})
+		}
+		ws[i].Chunks += w.chunks.Load()
+		ws[i].Bytes += w.bytes.Load()
+		ws[i].Busy += time.Duration(w.busy.Load())
+	}
+	return ws
+}
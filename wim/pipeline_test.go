@@ -0,0 +1,219 @@
+package wim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestChunkPipelineOrder(t *testing.T) {
+	var running, peak atomic.Int32
+	// Each chunk is "compressed" to its first byte, slower for lower
+	// values, so later chunks finish first.
+	slow := func() chunkCompressor {
+		return func(dst, src []byte) int {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(8-src[0]%8) * time.Millisecond)
+			dst[0] = src[0]
+			return 1
+		}
+	}
+	for _, c := range []struct{ workers, limit int }{{1, 1}, {4, 8}, {4, 2}} {
+		running.Store(0)
+		peak.Store(0)
+		p := newChunkPipeline(slow, c.workers, c.limit)
+		var got []byte
+		emit := func(job *chunkJob) error {
+			got = append(got, job.data...)
+			return nil
+		}
+		for i := range 40 {
+			job, err := p.get(2, emit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			job.in = append(job.in, byte(i), 0)
+			p.submit(job)
+		}
+		if err := p.drain(emit); err != nil {
+			t.Fatal(err)
+		}
+		for i, b := range got {
+			if int(b) != i {
+				t.Fatalf("%+v: chunk %d came back as %d", c, i, b)
+			}
+		}
+		if len(got) != 40 {
+			t.Fatalf("%+v: %d chunks", c, len(got))
+		}
+		if max := int32(min(c.workers, c.limit)); peak.Load() > max {
+			t.Errorf("%+v: %d chunks compressed at once", c, peak.Load())
+		}
+		if p.jobs > c.limit {
+			t.Errorf("%+v: %d buffers", c, p.jobs)
+		}
+		var chunks uint64
+		for _, w := range p.addWorkers(nil) {
+			chunks += w.Chunks
+		}
+		if chunks != 40 {
+			t.Errorf("%+v: workers counted %d chunks", c, chunks)
+		}
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	data := bytes.Repeat([]byte("progress "), 20000)
+	fsys := fstest.MapFS{"a.txt": {Data: data}}
+	var last WriterProgress
+	calls := 0
+	opts := WriterOptions{
+		Compression: wimgapi.CompressXPRESS,
+		Concurrency: 3,
+		MemoryLimit: 4 * defaultChunk, // two chunks in flight
+		Progress: func(p WriterProgress) error {
+			calls++
+			last = p
+			return nil
+		},
+	}
+	f := captureTestWIMWith(t, opts, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		if n := w.chunks.limit; n != 2 {
+			t.Errorf("%d chunks in flight", n)
+		}
+	})
+	if calls == 0 {
+		t.Fatal("Progress not called")
+	}
+	// The root and a.txt.
+	if last.Bytes <= uint64(len(data)) || last.Files != 2 || len(last.Workers) != 2 {
+		t.Fatalf("progress = %+v", last)
+	}
+	var chunks, bytes uint64
+	for _, w := range last.Workers {
+		chunks += w.Chunks
+		bytes += w.Bytes
+	}
+	if want := uint64(len(data)+defaultChunk-1) / defaultChunk; chunks < want || bytes < uint64(len(data)) {
+		t.Errorf("workers compressed %d chunks, %d bytes", chunks, bytes)
+	}
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t)(img.Open("a.txt")); got != string(data) {
+		t.Fatal("a.txt differs")
+	}
+}
+
+func TestWriterProgressStops(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := range 8 {
+		fsys[fmt.Sprintf("f%d.txt", i)] = &fstest.MapFile{Data: bytes.Repeat([]byte{byte(i)}, 3*defaultChunk)}
+	}
+	errStop := errors.New("stop")
+	calls := 0
+	w, err := Create(filepath.Join(t.TempDir(), "test.wim"), WriterOptions{
+		Compression: wimgapi.CompressXPRESS,
+		Progress: func(p WriterProgress) error {
+			calls++
+			return errStop
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); !errors.Is(err, errStop) {
+		t.Fatalf("AddImage = %v, want %v", err, errStop)
+	}
+	if calls != 1 {
+		t.Errorf("Progress called %d times before AddImage stopped", calls)
+	}
+	if err := w.Close(); !errors.Is(err, errStop) {
+		t.Fatalf("Close = %v, want %v", err, errStop)
+	}
+}
+
+// BenchmarkWriter captures a tree of compressible files at several
+// concurrencies; compare the MB/s to see the pipeline's speedup.
+func BenchmarkWriter(b *testing.B) {
+	inputs := compressTestInputs()
+	fsys := fstest.MapFS{}
+	for i := range 16 {
+		var data []byte
+		for len(data) < 1<<20 {
+			data = append(data, inputs["text"]...)
+			data = append(data, lzxCallInput(1<<14)...)
+			data = append(data, inputs["mixed"]...)
+		}
+		data[0] = byte(i) // no two files are the same
+		fsys[fmt.Sprintf("f%d.bin", i)] = &fstest.MapFile{Data: data}
+	}
+	var total int64
+	for _, f := range fsys {
+		total += int64(len(f.Data))
+	}
+	for _, c := range []wimgapi.Compression{wimgapi.CompressXPRESS, wimgapi.CompressLZX} {
+		for _, n := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%s/concurrency%d", c, n), func(b *testing.B) {
+				b.SetBytes(total)
+				for b.Loop() {
+					w, err := NewWriter(&memWriteSeeker{}, WriterOptions{Compression: c, Concurrency: n})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+						b.Fatal(err)
+					}
+					if err := w.Close(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// memWriteSeeker is an in-memory output for benchmarks.
+type memWriteSeeker struct {
+	buf []byte
+	off int
+}
+
+func (m *memWriteSeeker) Write(p []byte) (int, error) {
+	if end := m.off + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	copy(m.buf[m.off:], p)
+	m.off += len(p)
+	return len(p), nil
+}
+
+func (m *memWriteSeeker) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		off += int64(m.off)
+	case io.SeekEnd:
+		off += int64(len(m.buf))
+	}
+	m.off = int(off)
+	return off, nil
+}
@@ -0,0 +1,211 @@
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// Reparse tags.
+const (
+	ReparseTagMountPoint = 0xA0000003
+	ReparseTagSymlink    = 0xA000000C
+	ReparseTagDedup      = 0x80000013
+	ReparseTagWOF        = 0x80000017
+)
+
+// WOF providers and the compression formats of the file provider, which
+// Windows uses for CompactOS.
+const (
+	WOFProviderWIM  = 1
+	WOFProviderFile = 2
+
+	WOFXpress4K  = 0
+	WOFLZX       = 1
+	WOFXpress8K  = 2
+	WOFXpress16K = 3
+)
+
+const (
+	symlinkFlagRelative     = 1
+	reparseTagNameSurrogate = 0x20000000 // the tag names another file, as links do
+	reparseFlagNotFixed     = 0x0001     // dentry flag: an absolute link RP fix left alone
+)
+
+// ReparsePoint is decoded reparse data. Links fill in the names, WOF
+// reparse points WOF, and every tag, including ones this package does not
+// know, keeps its raw data. Dedup reparse data is undocumented and only
+// kept raw; the file data WIMGAPI captures with it is the file's content.
+type ReparsePoint struct {
+	Tag            uint32
+	SubstituteName string
+	PrintName      string
+	Relative       bool
+	WOF            *WOFInfo
+	Data           []byte // without the 8-byte reparse header
+}
+
+// WOFInfo describes a file that Windows Overlay Filter serves compressed
+// (file provider) or from a WIM (WIM provider). The image holds the file's
+// uncompressed data either way.
+type WOFInfo struct {
+	Provider  uint32
+	Algorithm uint32 // file provider: WOFXpress4K and so on
+	// DataSourceID and Hash locate the data in the backing WIM of the WIM
+	// provider.
+	DataSourceID uint64
+	Hash         Hash
+}
+
+// IsLink reports whether rp is a symbolic link or junction.
+func (rp *ReparsePoint) IsLink() bool {
+	return rp.Tag == ReparseTagSymlink || rp.Tag == ReparseTagMountPoint
+}
+
+// Target returns the link target with forward slashes: the print name when
+// present, otherwise the substitute name without its \??\ prefix.
+func (rp *ReparsePoint) Target() string {
+	t := rp.PrintName
+	if t == "" {
+		t = strings.TrimPrefix(rp.SubstituteName, `\??\`)
+	}
+	return strings.ReplaceAll(t, `\`, "/")
+}
+
+// absTarget returns the target of an absolute link in slash form, such as
+// C:/Users, or /usr/lib for a link relative to the root of its drive,
+// which is how POSIX absolute symlinks are captured. ok is false for
+// relative links and other reparse points.
+func (rp *ReparsePoint) absTarget() (target string, ok bool) {
+	if !rp.IsLink() {
+		return "", false
+	}
+	name := rp.SubstituteName
+	if name == "" {
+		name = rp.PrintName
+	}
+	switch {
+	case strings.HasPrefix(name, `\??\`):
+		name = strings.TrimPrefix(name, `\??\`)
+	case rp.Relative && !strings.HasPrefix(name, `\`):
+		return "", false
+	}
+	return strings.TrimSuffix(strings.ReplaceAll(name, `\`, "/"), "/"), true
+}
+
+var errBadReparseData = errors.New("wim: malformed reparse data")
+
+// parseReparsePoint decodes reparse data as WIM stores it: the reparse
+// buffer without its 8-byte tag and length header.
+func parseReparsePoint(tag uint32, data []byte) (*ReparsePoint, error) {
+	rp := &ReparsePoint{Tag: tag, Data: data}
+	switch tag {
+	case ReparseTagSymlink, ReparseTagMountPoint:
+		return rp, rp.parseLink()
+	case ReparseTagWOF:
+		return rp, rp.parseWOF()
+	}
+	return rp, nil
+}
+
+func (rp *ReparsePoint) parseLink() error {
+	data := rp.Data
+	hdr := 8
+	if rp.Tag == ReparseTagSymlink {
+		hdr = 12
+	}
+	if len(data) < hdr {
+		return errBadReparseData
+	}
+	if rp.Tag == ReparseTagSymlink {
+		rp.Relative = binary.LittleEndian.Uint32(data[8:12])&symlinkFlagRelative != 0
+	}
+	name := func(off, n uint16) (string, error) {
+		start := hdr + int(off)
+		if n%2 != 0 || start+int(n) > len(data) {
+			return "", errBadReparseData
+		}
+		return wimgapi.DecodeUTF16Bytes(data[start : start+int(n)]), nil
+	}
+	var err error
+	if rp.SubstituteName, err = name(binary.LittleEndian.Uint16(data[0:2]), binary.LittleEndian.Uint16(data[2:4])); err != nil {
+		return err
+	}
+	rp.PrintName, err = name(binary.LittleEndian.Uint16(data[4:6]), binary.LittleEndian.Uint16(data[6:8]))
+	return err
+}
+
+// parseWOF decodes a WOF_EXTERNAL_INFO header and the provider's
+// structure after it.
+func (rp *ReparsePoint) parseWOF() error {
+	data := rp.Data
+	if len(data) < 8 {
+		return errBadReparseData
+	}
+	w := &WOFInfo{Provider: binary.LittleEndian.Uint32(data[4:8])}
+	data = data[8:]
+	switch w.Provider {
+	case WOFProviderFile:
+		// Version, algorithm.
+		if len(data) < 8 {
+			return errBadReparseData
+		}
+		w.Algorithm = binary.LittleEndian.Uint32(data[4:8])
+	case WOFProviderWIM:
+		// Version, flags, data source, hash.
+		if len(data) < 36 {
+			return errBadReparseData
+		}
+		w.DataSourceID = binary.LittleEndian.Uint64(data[8:16])
+		copy(w.Hash[:], data[16:36])
+	}
+	rp.WOF = w
+	return nil
+}
+
+// ReparseData returns d's raw reparse data, without the 8-byte header.
+func (img *Image) ReparseData(d *Dentry) ([]byte, error) {
+	if d.Attributes&AttrReparsePoint == 0 {
+		return nil, fmt.Errorf("wim: %s is not a reparse point", d.Path())
+	}
+	r, err := img.f.openBlob(d.reparse.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// ReparsePoint decodes d's reparse data.
+func (img *Image) ReparsePoint(d *Dentry) (*ReparsePoint, error) {
+	data, err := img.ReparseData(d)
+	if err != nil {
+		return nil, err
+	}
+	return parseReparsePoint(d.ReparseTag, data)
+}
+
+// symlinkReparseData builds WIM-form reparse data for a symbolic link to
+// target. Slashes become backslashes; targets starting with a drive letter
+// are absolute, anything else is stored as relative.
+func symlinkReparseData(target string) []byte {
+	printName := strings.ReplaceAll(target, "/", `\`)
+	sub := printName
+	var flags uint32 = symlinkFlagRelative
+	if len(printName) >= 2 && printName[1] == ':' {
+		sub = `\??\` + printName
+		flags = 0
+	}
+	subLen, printLen := utf16Len(sub), utf16Len(printName)
+	data := make([]byte, 12, 12+subLen+printLen)
+	binary.LittleEndian.PutUint16(data[0:], 0)
+	binary.LittleEndian.PutUint16(data[2:], uint16(subLen))
+	binary.LittleEndian.PutUint16(data[4:], uint16(subLen))
+	binary.LittleEndian.PutUint16(data[6:], uint16(printLen))
+	binary.LittleEndian.PutUint32(data[8:], flags)
+	data = appendUTF16(data, sub)
+	return appendUTF16(data, printName)
+}
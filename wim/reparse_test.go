@@ -0,0 +1,268 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestParseReparsePoint(t *testing.T) {
+	wofFile := binary.LittleEndian.AppendUint32(nil, 1)
+	wofFile = binary.LittleEndian.AppendUint32(wofFile, WOFProviderFile)
+	wofFile = binary.LittleEndian.AppendUint32(wofFile, 1)
+	wofFile = binary.LittleEndian.AppendUint32(wofFile, WOFXpress16K)
+
+	wofWIM := binary.LittleEndian.AppendUint32(nil, 1)
+	wofWIM = binary.LittleEndian.AppendUint32(wofWIM, WOFProviderWIM)
+	wofWIM = binary.LittleEndian.AppendUint32(wofWIM, 1)
+	wofWIM = binary.LittleEndian.AppendUint32(wofWIM, 0)
+	wofWIM = binary.LittleEndian.AppendUint64(wofWIM, 3)
+	hash := Hash{0xde, 0xad, 0xbe, 0xef}
+	wofWIM = append(wofWIM, hash[:]...)
+
+	for _, tt := range []struct {
+		name     string
+		tag      uint32
+		data     []byte
+		target   string
+		abs      string
+		relative bool
+		wof      *WOFInfo
+	}{
+		{name: "absolute symlink", tag: ReparseTagSymlink, data: symlinkReparseData(`C:\Windows`), target: "C:/Windows", abs: "C:/Windows"},
+		{name: "relative symlink", tag: ReparseTagSymlink, data: symlinkReparseData("../lib"), target: "../lib", relative: true},
+		{name: "drive-relative symlink", tag: ReparseTagSymlink, data: symlinkReparseData("/usr/lib"), target: "/usr/lib", abs: "/usr/lib", relative: true},
+		{name: "junction", tag: ReparseTagMountPoint, data: mountPointReparseData(`D:\Data\`), target: "D:/Data/", abs: "D:/Data"},
+		{name: "WOF file", tag: ReparseTagWOF, data: wofFile, wof: &WOFInfo{Provider: WOFProviderFile, Algorithm: WOFXpress16K}},
+		{name: "WOF WIM", tag: ReparseTagWOF, data: wofWIM, wof: &WOFInfo{Provider: WOFProviderWIM, DataSourceID: 3, Hash: hash}},
+		{name: "dedup", tag: ReparseTagDedup, data: []byte{1, 2, 3}},
+		{name: "unknown", tag: 0x8000001B, data: []byte{4, 5}},
+	} {
+		rp, err := parseReparsePoint(tt.tag, tt.data)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(rp.Data, tt.data) || rp.Tag != tt.tag {
+			t.Errorf("%s: tag %#x, data %x", tt.name, rp.Tag, rp.Data)
+		}
+		if rp.IsLink() != (tt.target != "") || rp.Relative != tt.relative {
+			t.Errorf("%s: IsLink %v, Relative %v", tt.name, rp.IsLink(), rp.Relative)
+		}
+		if tt.target != "" && rp.Target() != tt.target {
+			t.Errorf("%s: Target = %q, want %q", tt.name, rp.Target(), tt.target)
+		}
+		if abs, ok := rp.absTarget(); abs != tt.abs || ok != (tt.abs != "") {
+			t.Errorf("%s: absTarget = %q, %v", tt.name, abs, ok)
+		}
+		switch {
+		case tt.wof == nil && rp.WOF != nil:
+			t.Errorf("%s: WOF = %+v", tt.name, rp.WOF)
+		case tt.wof != nil && (rp.WOF == nil || *rp.WOF != *tt.wof):
+			t.Errorf("%s: WOF = %+v, want %+v", tt.name, rp.WOF, tt.wof)
+		}
+	}
+	if _, err := parseReparsePoint(ReparseTagWOF, wofWIM[:20]); err == nil {
+		t.Error("short WOF data parsed")
+	}
+}
+
+func TestRelativePath(t *testing.T) {
+	for _, tt := range []struct{ from, to, want string }{
+		{".", "Windows", "Windows"},
+		{"a/b", "a/c/d", "../c/d"},
+		{"a", "", ".."},
+		{"", "", "."},
+		{"a/b", "a/b", "."},
+	} {
+		if got := relativePath(tt.from, tt.to); got != tt.want {
+			t.Errorf("relativePath(%q, %q) = %q, want %q", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFixedTarget(t *testing.T) {
+	for _, tt := range []struct{ target, rest, want string }{
+		{"D:/capture/Users", "/Users", `D:\Users`},
+		{"e:/capture", "", `E:\`},
+		{"/srv/root/usr/lib", "/usr/lib", `C:\usr\lib`},
+	} {
+		if got := fixedTarget(tt.target, tt.rest); got != tt.want {
+			t.Errorf("fixedTarget(%q, %q) = %q, want %q", tt.target, tt.rest, got, tt.want)
+		}
+	}
+}
+
+func TestRPFix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"sub/inside":  filepath.Join(dir, "sub", "file"),
+		"root":        dir,
+		"outside":     "/etc/hostname",
+		"relative":    "sub/file",
+		"sub/sibling": dir + "-other/file", // shares a prefix, but outside
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewDirSource(dir), ImageOptions{Name: "plain"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddImage(NewDirSource(dir), ImageOptions{Name: "fixed", RPFix: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if f.hdr.Flags&FlagRPFix == 0 {
+		t.Fatal("FlagRPFix not set")
+	}
+	img, err := f.Image(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path, stored string
+		notFixed     bool
+		applied      string
+	}{
+		{"sub/inside", `C:\sub\file`, false, "file"},
+		{"root", `C:\`, false, "."},
+		{"outside", `\etc\hostname`, true, "/etc/hostname"},
+		{"relative", `sub\file`, false, "sub/file"},
+		{"sub/sibling", `\` + filepath.Base(dir) + `-other\file`, true, ""},
+	} {
+		d, err := img.Lookup(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rp, err := img.ReparsePoint(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.path == "sub/sibling" {
+			// Stored as captured: the absolute path of the temporary directory.
+			if !d.ReparseNotFixed {
+				t.Errorf("%s: fixed to %q", tt.path, rp.PrintName)
+			}
+			continue
+		}
+		if rp.PrintName != tt.stored || d.ReparseNotFixed != tt.notFixed {
+			t.Errorf("%s: stored %q, not fixed %v", tt.path, rp.PrintName, d.ReparseNotFixed)
+		}
+	}
+
+	// The unfixed image marks absolute links, so the file's flag does
+	// not apply to them.
+	plain, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := plain.Lookup("sub/inside"); !d.ReparseNotFixed {
+		t.Error("link in an image captured without RP fix is not marked")
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	if _, err := img.Apply(out, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"sub/inside": "file",
+		"root":       ".",
+		"outside":    "/etc/hostname",
+		"relative":   "sub/file",
+	} {
+		got, err := os.Readlink(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil || got != want {
+			t.Errorf("applied %s -> %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "sub", "inside")); err != nil || string(data) != "data" {
+		t.Errorf("reading through the applied link: %q, %v", data, err)
+	}
+
+	var buf bytes.Buffer
+	if err := img.WriteTar(&buf, TarOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal("no tar entry for sub/inside")
+		}
+		if hdr.Name == "sub/inside" {
+			if hdr.Linkname != "file" {
+				t.Errorf("tar link target %q", hdr.Linkname)
+			}
+			break
+		}
+	}
+}
+
+func TestRPFixTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "usr/lib/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "lib", Typeflag: tar.TypeSymlink, Linkname: "/usr/lib", Mode: 0o777},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(&buf, ImageOptions{RPFix: true}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := img.Lookup("lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := img.ReparsePoint(d)
+	if err != nil || rp.Target() != "C:/usr/lib" || d.ReparseNotFixed {
+		t.Fatalf("link = %+v, not fixed %v, %v", rp, d.ReparseNotFixed, err)
+	}
+	if got := img.posixLinkTarget("lib", d, rp); got != "usr/lib" {
+		t.Errorf("POSIX target %q", got)
+	}
+
+	// Exporting the image keeps the link fixed.
+	g := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewImageSource(img), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if g.hdr.Flags&FlagRPFix == 0 {
+		t.Fatal("export dropped FlagRPFix")
+	}
+	exported, err := g.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := exported.Lookup("lib"); d.ReparseNotFixed {
+		t.Error("exported link marked not fixed")
+	}
+}
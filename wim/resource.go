@@ -0,0 +1,345 @@
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+var ErrSolidResource = errors.New("wim: metadata in solid resources is not supported")
+
+const (
+	solidHeaderSize = 16
+	// solidResourceMagic is the uncompressed size recorded in the blob
+	// table entry of a solid resource itself, as opposed to its blobs.
+	solidResourceMagic = 1 << 32
+)
+
+// solidResource is a resource that holds many blobs compressed as one
+// stream. It starts with its own uncompressed size, chunk size and
+// format, followed by the compressed size of every chunk.
+type solidResource struct {
+	res         resourceHeader
+	size        uint64
+	chunkSize   uint32
+	compression wimgapi.Compression
+}
+
+func (f *File) readSolidHeader(res resourceHeader) (*solidResource, error) {
+	var b [solidHeaderSize]byte
+	if res.Size < solidHeaderSize {
+		return nil, fmt.Errorf("wim: solid resource at offset %d is too small", res.Offset)
+	}
+	if _, err := f.r.ReadAt(b[:], int64(res.Offset)); err != nil {
+		return nil, fmt.Errorf("wim: read solid resource header: %w", err)
+	}
+	s := &solidResource{
+		res:         res,
+		size:        binary.LittleEndian.Uint64(b[0:8]),
+		chunkSize:   binary.LittleEndian.Uint32(b[8:12]),
+		compression: wimgapi.Compression(binary.LittleEndian.Uint32(b[12:16])),
+	}
+	if s.compression == wimgapi.CompressNone || s.compression.ValidateChunkSize(s.chunkSize) != nil {
+		return nil, fmt.Errorf("wim: solid resource at offset %d: unsupported %s chunks of %d bytes", res.Offset, s.compression, s.chunkSize)
+	}
+	return s, nil
+}
+
+// openResource returns a reader for the uncompressed contents of res.
+func (f *File) openResource(res resourceHeader) (*StreamReader, error) {
+	if res.Flags&resFlagSolid != 0 {
+		return nil, ErrSolidResource
+	}
+	if !res.compressed() {
+		if res.Size != res.UncompressedSize {
+			return nil, fmt.Errorf("wim: uncompressed resource size mismatch at offset %d", res.Offset)
+		}
+		return &StreamReader{ra: io.NewSectionReader(f.r, int64(res.Offset), int64(res.Size)), size: int64(res.Size)}, nil
+	}
+	r, err := newChunkReader(f, res)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamReader{ra: r, seq: r, size: int64(r.size)}, nil
+}
+
+// openSolid returns a reader for size bytes at offset off of the
+// uncompressed contents of s.
+func (f *File) openSolid(s *solidResource, off, size uint64) (*StreamReader, error) {
+	r, err := newSolidChunkReader(f, s)
+	if err != nil {
+		return nil, err
+	}
+	if off+size > s.size || off+size < off {
+		return nil, fmt.Errorf("wim: blob exceeds solid resource at offset %d", s.res.Offset)
+	}
+	r.seek(off)
+	if size > 0 {
+		r.last = int((off + size - 1) / r.chunkSize)
+	}
+	return &StreamReader{ra: r, seq: r, base: int64(off), size: int64(size)}, nil
+}
+
+// readResource reads the whole uncompressed contents of res.
+func (f *File) readResource(res resourceHeader) ([]byte, error) {
+	r, err := f.openResource(res)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, res.UncompressedSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("wim: read resource at offset %d: %w", res.Offset, err)
+	}
+	return buf, nil
+}
+
+// decompressChunk decodes one chunk compressed with format c in chunks of
+// chunkSize bytes. dst has the chunk's uncompressed size.
+func decompressChunk(c wimgapi.Compression, chunkSize uint32, dst, src []byte) error {
+	switch c {
+	case wimgapi.CompressXPRESS:
+		return xpressDecompress(dst, src)
+	case wimgapi.CompressLZX:
+		return lzxDecompress(dst, src, int(chunkSize))
+	case wimgapi.CompressLZMS:
+		return lzmsDecompress(dst, src)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, c)
+	}
+}
+
+// chunkReader decompresses a chunked resource sequentially, through the
+// File's cache, with the next chunks up to last decompressed ahead.
+type chunkReader struct {
+	f           *File
+	res         resourceHeader
+	size        uint64 // uncompressed
+	chunkSize   uint64
+	compression wimgapi.Compression
+	solid       *solidResource // nil for a plain resource
+	numChunks   int
+	dataStart   uint64
+	offsets     []uint64 // compressed start of each chunk plus the end
+	next        int
+	last        int    // last chunk the reader needs
+	ahead       int    // chunks before this one have been prefetched
+	skip        uint64 // bytes to drop from the next chunk loaded
+	in          []byte // stored chunks
+	avail       []byte
+}
+
+// newChunkReader reads the chunk table of a plain compressed resource,
+// which holds the start of every chunk but the first, in 8-byte entries
+// past 4 GiB.
+func newChunkReader(f *File, res resourceHeader) (*chunkReader, error) {
+	r := &chunkReader{
+		f:           f,
+		res:         res,
+		size:        res.UncompressedSize,
+		chunkSize:   uint64(f.hdr.ChunkSize),
+		compression: f.hdr.compression(),
+	}
+	r.numChunks = int((r.size + r.chunkSize - 1) / r.chunkSize)
+	entrySize := uint64(4)
+	if r.size > 0xFFFFFFFF {
+		entrySize = 8
+	}
+	tableSize := uint64(max(r.numChunks-1, 0)) * entrySize
+	if tableSize > res.Size {
+		return nil, fmt.Errorf("wim: chunk table exceeds resource at offset %d", res.Offset)
+	}
+
+	table := make([]byte, tableSize)
+	if _, err := f.r.ReadAt(table, int64(res.Offset)); err != nil {
+		return nil, fmt.Errorf("wim: read chunk table: %w", err)
+	}
+	r.offsets = make([]uint64, r.numChunks+1)
+	for i := 1; i < r.numChunks; i++ {
+		if entrySize == 8 {
+			r.offsets[i] = binary.LittleEndian.Uint64(table[(i-1)*8:])
+		} else {
+			r.offsets[i] = uint64(binary.LittleEndian.Uint32(table[(i-1)*4:]))
+		}
+	}
+	r.offsets[r.numChunks] = res.Size - tableSize
+	r.dataStart = res.Offset + tableSize
+	r.last = r.numChunks - 1
+	return r, r.checkOffsets()
+}
+
+// newSolidChunkReader reads the chunk table of a solid resource, which
+// follows its header and holds the compressed size of every chunk.
+func newSolidChunkReader(f *File, s *solidResource) (*chunkReader, error) {
+	r := &chunkReader{
+		f:           f,
+		res:         s.res,
+		size:        s.size,
+		chunkSize:   uint64(s.chunkSize),
+		compression: s.compression,
+		solid:       s,
+	}
+	numChunks := (r.size + r.chunkSize - 1) / r.chunkSize
+	if numChunks > (s.res.Size-solidHeaderSize)/4 {
+		return nil, fmt.Errorf("wim: chunk table exceeds resource at offset %d", s.res.Offset)
+	}
+	r.numChunks = int(numChunks)
+	table := make([]byte, 4*numChunks)
+	if _, err := f.r.ReadAt(table, int64(s.res.Offset+solidHeaderSize)); err != nil {
+		return nil, fmt.Errorf("wim: read chunk table: %w", err)
+	}
+	r.offsets = make([]uint64, r.numChunks+1)
+	for i := range r.numChunks {
+		r.offsets[i+1] = r.offsets[i] + uint64(binary.LittleEndian.Uint32(table[4*i:]))
+	}
+	r.dataStart = s.res.Offset + solidHeaderSize + uint64(len(table))
+	r.last = r.numChunks - 1
+	if r.offsets[r.numChunks] > s.res.Size-solidHeaderSize-uint64(len(table)) {
+		return nil, fmt.Errorf("wim: corrupt chunk table at offset %d", s.res.Offset)
+	}
+	return r, nil
+}
+
+func (r *chunkReader) checkOffsets() error {
+	for i := 1; i <= r.numChunks; i++ {
+		if r.offsets[i] < r.offsets[i-1] {
+			return fmt.Errorf("wim: corrupt chunk table at offset %d", r.res.Offset)
+		}
+	}
+	return nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.avail) == 0 {
+		if r.next >= r.numChunks {
+			return 0, io.EOF
+		}
+		if err := r.loadChunk(r.next); err != nil {
+			return 0, err
+		}
+		r.next++
+		skip := min(r.skip, uint64(len(r.avail)))
+		r.avail = r.avail[skip:]
+		r.skip -= skip
+	}
+	n := copy(p, r.avail)
+	r.avail = r.avail[n:]
+	return n, nil
+}
+
+// seek moves the sequential reader to uncompressed offset off.
+func (r *chunkReader) seek(off uint64) {
+	r.next = int(off / r.chunkSize)
+	r.skip = off % r.chunkSize
+	r.avail = nil
+	r.ahead = r.next
+}
+
+// ReadAt reads the uncompressed bytes at off, decompressing only the
+// chunks p covers, through the cache. It leaves the sequential reader
+// alone and is safe for concurrent use.
+func (r *chunkReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errOffset
+	}
+	n := 0
+	for n < len(p) && uint64(off) < r.size {
+		i := int(uint64(off) / r.chunkSize)
+		within := uint64(off) % r.chunkSize
+		csize := r.offsets[i+1] - r.offsets[i]
+		usize := r.chunkUncompressedSize(i)
+		if csize > usize {
+			return n, fmt.Errorf("wim: chunk %d of resource at offset %d: %w", i, r.res.Offset, errCorruptChunk)
+		}
+		m := int(min(uint64(len(p)-n), usize-within))
+		if csize == usize {
+			if _, err := r.f.r.ReadAt(p[n:n+m], int64(r.dataStart+r.offsets[i]+within)); err != nil {
+				return n, fmt.Errorf("wim: read chunk %d: %w", i, err)
+			}
+		} else {
+			data, err := r.f.cache.get(r.key(i), int64(usize), r.decompressor(i))
+			if err != nil {
+				return n, err
+			}
+			copy(p[n:n+m], data[within:])
+		}
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *chunkReader) chunkUncompressedSize(i int) uint64 {
+	if i == r.numChunks-1 {
+		return r.size - uint64(i)*r.chunkSize
+	}
+	return r.chunkSize
+}
+
+func (r *chunkReader) loadChunk(i int) error {
+	csize := r.offsets[i+1] - r.offsets[i]
+	usize := r.chunkUncompressedSize(i)
+	if csize > usize {
+		return fmt.Errorf("wim: chunk %d of resource at offset %d: %w", i, r.res.Offset, errCorruptChunk)
+	}
+	if csize == usize {
+		if uint64(cap(r.in)) < csize {
+			r.in = make([]byte, csize)
+		}
+		in := r.in[:csize]
+		if _, err := r.f.r.ReadAt(in, int64(r.dataStart+r.offsets[i])); err != nil {
+			return fmt.Errorf("wim: read chunk %d: %w", i, err)
+		}
+		r.avail = in
+		r.readAhead(i)
+		return nil
+	}
+
+	out, err := r.f.cache.get(r.key(i), int64(usize), r.decompressor(i))
+	if err != nil {
+		return err
+	}
+	r.avail = out
+	r.readAhead(i)
+	return nil
+}
+
+func (r *chunkReader) key(i int) chunkKey {
+	return chunkKey{f: r.f, res: r.res.Offset, chunk: i}
+}
+
+// decompressor returns a function that reads and decompresses chunk i
+// into a new buffer, for any goroutine to call.
+func (r *chunkReader) decompressor(i int) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		csize := r.offsets[i+1] - r.offsets[i]
+		in := make([]byte, csize)
+		if _, err := r.f.r.ReadAt(in, int64(r.dataStart+r.offsets[i])); err != nil {
+			return nil, fmt.Errorf("wim: read chunk %d: %w", i, err)
+		}
+		out := make([]byte, r.chunkUncompressedSize(i))
+		if err := decompressChunk(r.compression, uint32(r.chunkSize), out, in); err != nil {
+			return nil, fmt.Errorf("wim: chunk %d of resource at offset %d: %w", i, r.res.Offset, err)
+		}
+		return out, nil
+	}
+}
+
+// readAhead starts decompressing the compressed chunks after i that the
+// reader will need, as many as the File allows and half its cache holds.
+func (r *chunkReader) readAhead(i int) {
+	n := min(int64(r.f.readAhead), r.f.cache.max/2/int64(r.chunkSize))
+	end := min(i+int(n), r.last)
+	for j := max(i+1, r.ahead); j <= end; j++ {
+		usize := r.chunkUncompressedSize(j)
+		if r.offsets[j+1]-r.offsets[j] < usize {
+			r.f.cache.prefetch(r.key(j), int64(usize), r.decompressor(j))
+		}
+	}
+	r.ahead = max(r.ahead, end+1)
+}
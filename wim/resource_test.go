@@ -0,0 +1,34 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func TestChunkedResource(t *testing.T) {
+	// One XPRESS chunk of 32768 'a's followed by a short raw chunk.
+	first := xpressFixedEncode([]xpressOp{{lit: 'a'}, {offset: 1, length: defaultChunk - 1}})
+	last := []byte("raw tail")
+	want := append(bytes.Repeat([]byte{'a'}, defaultChunk), last...)
+
+	var data []byte
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(first)))
+	data = append(data, first...)
+	data = append(data, last...)
+
+	f := &File{r: bytes.NewReader(data), hdr: header{Flags: FlagCompression | FlagCompressXPRESS, ChunkSize: defaultChunk}, cache: NewChunkCache(DefaultCacheSize)}
+	res := resourceHeader{Size: uint64(len(data)), Flags: resFlagCompressed, UncompressedSize: uint64(len(want))}
+	r, err := f.openResource(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,158 @@
+package wim
+
+import (
+	"encoding/binary"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// RP fix, as WIMGAPI does it unless told WIM_FLAG_NO_RP_FIX: an absolute
+// symbolic link or junction that points into the captured tree is stored
+// with the capture root cut from its target, behind its drive letter, so
+// D:\capture\Users captured from D:\capture becomes D:\Users; targets
+// without a drive, captured on POSIX systems, get C:. Applying the
+// image puts the target directory back in front. Absolute links that point
+// elsewhere are stored as they are and marked as not fixed. A WIM that
+// holds fixed links has FlagRPFix set.
+
+// linkRoot returns the absolute path, in slash form, that links captured
+// from src are fixed against, and whether names compare case-insensitively.
+// A tar file is its own root, so its absolute symlinks are all inside it.
+func linkRoot(src CaptureSource) (root string, fold, ok bool) {
+	switch s := src.(type) {
+	case *dirSource:
+		abs, err := filepath.Abs(s.dir)
+		if err != nil {
+			return "", false, false
+		}
+		return filepath.ToSlash(abs), runtime.GOOS == "windows", true
+	case *tarSource:
+		return "/", false, true
+	}
+	return "", false, false
+}
+
+// fixLink returns the reparse data to store for e, and whether it is an
+// absolute link left unfixed. Links copied from another image keep their
+// data and their state.
+func (b *imageBuilder) fixLink(src CaptureSource, e *SourceEntry) ([]byte, bool) {
+	rp, err := parseReparsePoint(e.ReparseTag, e.ReparseData)
+	if err != nil {
+		return e.ReparseData, false
+	}
+	target, abs := rp.absTarget()
+	if !abs {
+		return e.ReparseData, false
+	}
+	if is, ok := src.(*imageSource); ok {
+		d, _ := e.Sys.(*Dentry)
+		fixed := is.img.f.hdr.Flags&FlagRPFix != 0 && d != nil && !d.ReparseNotFixed
+		return e.ReparseData, !fixed
+	}
+	if !b.rpfix {
+		return e.ReparseData, true
+	}
+	root, fold, ok := linkRoot(src)
+	if !ok {
+		return e.ReparseData, true
+	}
+	rest, ok := cutRoot(target, root, fold)
+	if !ok {
+		return e.ReparseData, true
+	}
+	fixed := fixedTarget(target, rest)
+	if e.ReparseTag == ReparseTagMountPoint {
+		return mountPointReparseData(fixed), false
+	}
+	return symlinkReparseData(fixed), false
+}
+
+// fixedTarget returns the stored target for the absolute link target with
+// the capture root cut off, leaving rest: rest behind the drive of target.
+func fixedTarget(target, rest string) string {
+	drive := "C:"
+	if len(target) >= 2 && target[1] == ':' {
+		drive = strings.ToUpper(target[:2])
+	}
+	return drive + `\` + strings.ReplaceAll(strings.TrimPrefix(rest, "/"), "/", `\`)
+}
+
+// cutRoot returns what follows root in target, starting with a slash, or
+// "" for root itself.
+func cutRoot(target, root string, fold bool) (rest string, ok bool) {
+	root = strings.TrimSuffix(root, "/")
+	if len(target) < len(root) {
+		return "", false
+	}
+	head, rest := target[:len(root)], target[len(root):]
+	if head != root && !(fold && strings.EqualFold(head, root)) {
+		return "", false
+	}
+	if rest != "" && rest[0] != '/' {
+		return "", false
+	}
+	return rest, true
+}
+
+// mountPointReparseData builds WIM-form reparse data for a junction to the
+// absolute path target.
+func mountPointReparseData(target string) []byte {
+	sub := `\??\` + target
+	subLen, printLen := utf16Len(sub), utf16Len(target)
+	data := make([]byte, 8, 8+subLen+printLen+4)
+	binary.LittleEndian.PutUint16(data[0:], 0)
+	binary.LittleEndian.PutUint16(data[2:], uint16(subLen))
+	binary.LittleEndian.PutUint16(data[4:], uint16(subLen+2))
+	binary.LittleEndian.PutUint16(data[6:], uint16(printLen))
+	data = append(appendUTF16(data, sub), 0, 0)
+	return append(appendUTF16(data, target), 0, 0)
+}
+
+// isFixed reports whether the link rp of d was fixed on capture.
+func (img *Image) isFixed(d *Dentry, rp *ReparsePoint) bool {
+	_, abs := rp.absTarget()
+	return abs && img.f.hdr.Flags&FlagRPFix != 0 && !d.ReparseNotFixed
+}
+
+// posixLinkTarget returns the target for the link rp at p, a path from
+// the image root, on a POSIX file system. A fixed link points relatively
+// to the entry it named, so the tree can be moved; other targets keep
+// their Windows form with forward slashes, such as C:/Windows.
+func (img *Image) posixLinkTarget(p string, d *Dentry, rp *ReparsePoint) string {
+	if !img.isFixed(d, rp) {
+		return rp.Target()
+	}
+	target, _ := rp.absTarget()
+	_, rest, ok := strings.Cut(target, ":")
+	if !ok {
+		return rp.Target()
+	}
+	return relativePath(path.Dir(p), strings.Trim(rest, "/"))
+}
+
+// relativePath returns the path from directory from to to, both
+// slash-separated from the same root, with "." for the root itself.
+func relativePath(from, to string) string {
+	split := func(p string) []string {
+		if p == "" || p == "." {
+			return nil
+		}
+		return strings.Split(p, "/")
+	}
+	f, t := split(from), split(to)
+	i := 0
+	for i < len(f) && i < len(t) && f[i] == t[i] {
+		i++
+	}
+	parts := make([]string, 0, len(f)-i+len(t)-i)
+	for range f[i:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, t[i:]...)
+	if len(parts) == 0 {
+		return "."
+	}
+	return strings.Join(parts, "/")
+}
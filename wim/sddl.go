@@ -0,0 +1,393 @@
+package wim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sddlACETypes = map[byte]string{
+	ACEAccessAllowed:               "A",
+	ACEAccessDenied:                "D",
+	ACESystemAudit:                 "AU",
+	ACESystemAlarm:                 "AL",
+	ACEAccessAllowedObject:         "OA",
+	ACEAccessDeniedObject:          "OD",
+	ACESystemAuditObject:           "OU",
+	ACESystemAlarmObject:           "OL",
+	ACEAccessAllowedCallback:       "XA",
+	ACEAccessDeniedCallback:        "XD",
+	ACEAccessAllowedCallbackObject: "ZA",
+	ACESystemAuditCallback:         "XU",
+	ACESystemMandatoryLabel:        "ML",
+	ACESystemResourceAttribute:     "RA",
+	ACESystemScopedPolicyID:        "SP",
+}
+
+type sddlFlag struct {
+	code string
+	bit  uint32
+}
+
+var sddlACEFlags = []sddlFlag{
+	{"OI", ACEObjectInherit},
+	{"CI", ACEContainerInherit},
+	{"NP", ACENoPropagate},
+	{"IO", ACEInheritOnly},
+	{"ID", ACEInherited},
+	{"SA", ACESuccessfulAccess},
+	{"FA", ACEFailedAccess},
+}
+
+// sddlRightSets are access masks SDDL names as a whole; they are used
+// only for an exact match.
+var sddlRightSets = []sddlFlag{
+	{"FA", 0x1F01FF},
+	{"FR", 0x120089},
+	{"FW", 0x120116},
+	{"FX", 0x1200A0},
+	{"KA", 0xF003F},
+	{"KR", 0x20019},
+	{"KW", 0x20006},
+	{"KX", 0x20019},
+}
+
+// sddlRights are the single rights masks are otherwise spelled with, in
+// the order Windows writes them.
+var sddlRights = []sddlFlag{
+	{"GA", 0x10000000},
+	{"GR", 0x80000000},
+	{"GW", 0x40000000},
+	{"GX", 0x20000000},
+	{"CC", 0x1},
+	{"DC", 0x2},
+	{"LC", 0x4},
+	{"SW", 0x8},
+	{"RP", 0x10},
+	{"WP", 0x20},
+	{"DT", 0x40},
+	{"LO", 0x80},
+	{"CR", 0x100},
+	{"SD", 0x10000},
+	{"RC", 0x20000},
+	{"WD", 0x40000},
+	{"WO", 0x80000},
+}
+
+// sddlLabelRights are the rights of mandatory label ACEs.
+var sddlLabelRights = []sddlFlag{
+	{"NW", 0x1},
+	{"NR", 0x2},
+	{"NX", 0x4},
+}
+
+// SDDL renders sd in the Security Descriptor Definition Language the way
+// ConvertSecurityDescriptorToStringSecurityDescriptor does, such as
+// O:BAG:SYD:PAI(A;OICI;FA;;;SY). Domain-relative SIDs are written in full.
+// The conditions of callback ACEs and the values of resource attribute
+// ACEs are left out; ACE.ApplicationData keeps them.
+func (sd *SecurityDescriptor) SDDL() string {
+	var b strings.Builder
+	if sd.Owner != nil {
+		b.WriteString("O:" + sddlSID(sd.Owner))
+	}
+	if sd.Group != nil {
+		b.WriteString("G:" + sddlSID(sd.Group))
+	}
+	if sd.Control&SEDACLPresent != 0 {
+		b.WriteString("D:")
+		writeSDDLACL(&b, sd.DACL, sd.Control, SEDACLProtected, SEDACLAutoInheritReq, SEDACLAutoInherited)
+	}
+	if sd.Control&SESACLPresent != 0 {
+		b.WriteString("S:")
+		writeSDDLACL(&b, sd.SACL, sd.Control, SESACLProtected, SESACLAutoInheritReq, SESACLAutoInherited)
+	}
+	return b.String()
+}
+
+func sddlSID(s *SID) string {
+	str := s.String()
+	if alias, ok := sidAbbrevs[str]; ok {
+		return alias
+	}
+	return str
+}
+
+func writeSDDLACL(b *strings.Builder, acl *ACL, control, protected, req, inherited uint16) {
+	if control&protected != 0 {
+		b.WriteString("P")
+	}
+	if control&req != 0 {
+		b.WriteString("AR")
+	}
+	if control&inherited != 0 {
+		b.WriteString("AI")
+	}
+	if acl == nil {
+		b.WriteString("NO_ACCESS_CONTROL")
+		return
+	}
+	for i := range acl.ACEs {
+		writeSDDLACE(b, &acl.ACEs[i])
+	}
+}
+
+func writeSDDLACE(b *strings.Builder, ace *ACE) {
+	b.WriteByte('(')
+	if t, ok := sddlACETypes[ace.Type]; ok {
+		b.WriteString(t)
+	} else {
+		fmt.Fprintf(b, "0x%02x", ace.Type)
+	}
+	b.WriteByte(';')
+	b.WriteString(sddlBits(uint32(ace.Flags), sddlACEFlags))
+	b.WriteByte(';')
+	b.WriteString(sddlMask(ace))
+	b.WriteByte(';')
+	if ace.ObjectType != nil {
+		b.WriteString(sddlGUID(*ace.ObjectType))
+	}
+	b.WriteByte(';')
+	if ace.InheritedObjectType != nil {
+		b.WriteString(sddlGUID(*ace.InheritedObjectType))
+	}
+	b.WriteByte(';')
+	b.WriteString(sddlSID(ace.SID))
+	b.WriteByte(')')
+}
+
+// sddlBits spells v with codes, with any bits left over in hex.
+func sddlBits(v uint32, codes []sddlFlag) string {
+	var b strings.Builder
+	for _, c := range codes {
+		if v&c.bit != 0 {
+			b.WriteString(c.code)
+			v &^= c.bit
+		}
+	}
+	if v != 0 {
+		fmt.Fprintf(&b, "0x%x", v)
+	}
+	return b.String()
+}
+
+func sddlMask(ace *ACE) string {
+	if ace.Mask == 0 {
+		return ""
+	}
+	codes := sddlRights
+	if ace.Type == ACESystemMandatoryLabel {
+		codes = sddlLabelRights
+	} else {
+		for _, set := range sddlRightSets {
+			if ace.Mask == set.bit {
+				return set.code
+			}
+		}
+	}
+	var known uint32
+	for _, c := range codes {
+		known |= c.bit
+	}
+	if ace.Mask&^known != 0 {
+		return fmt.Sprintf("0x%x", ace.Mask)
+	}
+	return sddlBits(ace.Mask, codes)
+}
+
+func sddlGUID(g GUID) string {
+	return strings.ToLower(strings.Trim(g.String(), "{}"))
+}
+
+// ParseSDDL parses an SDDL string into a security descriptor, which
+// SecurityDescriptor.Bytes encodes for SourceEntry.Security. It accepts
+// what SDDL renders, and rejects conditional expressions and resource
+// attributes.
+func ParseSDDL(s string) (*SecurityDescriptor, error) {
+	sd := &SecurityDescriptor{Revision: 1, Control: SESelfRelative}
+	s = strings.TrimSpace(s)
+	for s != "" {
+		if len(s) < 2 || s[1] != ':' {
+			return nil, fmt.Errorf("wim: invalid SDDL at %q", s)
+		}
+		kind := s[0]
+		value, rest := cutSDDLComponent(s[2:])
+		s = rest
+		var err error
+		switch kind {
+		case 'O', 'o':
+			sd.Owner, err = ParseSID(value)
+		case 'G', 'g':
+			sd.Group, err = ParseSID(value)
+		case 'D', 'd':
+			sd.Control |= SEDACLPresent
+			sd.DACL, err = parseSDDLACL(value, &sd.Control, SEDACLProtected, SEDACLAutoInheritReq, SEDACLAutoInherited)
+		case 'S', 's':
+			sd.Control |= SESACLPresent
+			sd.SACL, err = parseSDDLACL(value, &sd.Control, SESACLProtected, SESACLAutoInheritReq, SESACLAutoInherited)
+		default:
+			err = fmt.Errorf("wim: invalid SDDL component %c:", kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sd, nil
+}
+
+// cutSDDLComponent splits s at the next O:, G:, D: or S: outside an ACE.
+func cutSDDLComponent(s string) (value, rest string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && i+1 < len(s) && s[i+1] == ':' && strings.IndexByte("OGDSogds", c) >= 0:
+			return strings.TrimSpace(s[:i]), s[i:]
+		}
+	}
+	return strings.TrimSpace(s), ""
+}
+
+func parseSDDLACL(s string, control *uint16, protected, req, inherited uint16) (*ACL, error) {
+	flags, aces, _ := strings.Cut(s, "(")
+	if aces != "" {
+		aces = "(" + aces
+	}
+	for flags != "" {
+		switch {
+		case strings.HasPrefix(flags, "P"):
+			*control |= protected
+			flags = flags[1:]
+		case strings.HasPrefix(flags, "AR"):
+			*control |= req
+			flags = flags[2:]
+		case strings.HasPrefix(flags, "AI"):
+			*control |= inherited
+			flags = flags[2:]
+		case flags == "NO_ACCESS_CONTROL" && aces == "":
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("wim: invalid SDDL ACL flags %q", flags)
+		}
+	}
+	acl := &ACL{Revision: aclRevision}
+	for aces != "" {
+		end := strings.IndexByte(aces, ')')
+		if aces[0] != '(' || end < 0 {
+			return nil, fmt.Errorf("wim: invalid SDDL ACE list %q", aces)
+		}
+		ace, err := parseSDDLACE(aces[1:end])
+		if err != nil {
+			return nil, err
+		}
+		if isObjectACE(ace.Type) {
+			acl.Revision = aclRevisionDS
+		}
+		acl.ACEs = append(acl.ACEs, ace)
+		aces = strings.TrimSpace(aces[end+1:])
+	}
+	return acl, nil
+}
+
+func parseSDDLACE(s string) (ACE, error) {
+	var ace ACE
+	fields := strings.Split(s, ";")
+	if len(fields) != 6 {
+		return ace, fmt.Errorf("wim: unsupported SDDL ACE %q", s)
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	typ, ok := -1, false
+	for t, code := range sddlACETypes {
+		if strings.EqualFold(code, fields[0]) {
+			typ, ok = int(t), true
+		}
+	}
+	if !ok {
+		return ace, fmt.Errorf("wim: unknown SDDL ACE type %q", fields[0])
+	}
+	ace.Type = byte(typ)
+	if ace.Type == ACEAccessAllowedCallback || ace.Type == ACEAccessDeniedCallback ||
+		ace.Type == ACEAccessAllowedCallbackObject || ace.Type == ACESystemAuditCallback ||
+		ace.Type == ACESystemResourceAttribute {
+		return ace, fmt.Errorf("wim: unsupported SDDL ACE %q", s)
+	}
+	flags, err := parseSDDLBits(fields[1], sddlACEFlags)
+	if err != nil || flags > 0xFF {
+		return ace, fmt.Errorf("wim: invalid SDDL ACE flags %q", fields[1])
+	}
+	ace.Flags = byte(flags)
+	if ace.Mask, err = parseSDDLMask(fields[2], ace.Type); err != nil {
+		return ace, err
+	}
+	for _, g := range []struct {
+		s   string
+		dst **GUID
+	}{{fields[3], &ace.ObjectType}, {fields[4], &ace.InheritedObjectType}} {
+		if g.s == "" {
+			continue
+		}
+		if !isObjectACE(ace.Type) {
+			return ace, fmt.Errorf("wim: object type in SDDL ACE %q", s)
+		}
+		guid, err := parseGUID(g.s)
+		if err != nil {
+			return ace, err
+		}
+		*g.dst = &guid
+	}
+	if ace.SID, err = ParseSID(fields[5]); err != nil {
+		return ace, err
+	}
+	return ace, nil
+}
+
+// parseSDDLBits reads codes, or a number in the syntax of strconv with
+// base 0, or codes followed by the hex SDDL writes for leftover bits.
+func parseSDDLBits(s string, codes []sddlFlag) (uint32, error) {
+	var v uint32
+	for s != "" {
+		if s[0] >= '0' && s[0] <= '9' {
+			n, err := strconv.ParseUint(s, 0, 32)
+			if err != nil {
+				return 0, err
+			}
+			return v | uint32(n), nil
+		}
+		found := false
+		for _, c := range codes {
+			if len(s) >= 2 && strings.EqualFold(s[:2], c.code) {
+				v |= c.bit
+				s = s[2:]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("wim: unknown SDDL code %q", s)
+		}
+	}
+	return v, nil
+}
+
+func parseSDDLMask(s string, typ byte) (uint32, error) {
+	if typ == ACESystemMandatoryLabel {
+		if v, err := parseSDDLBits(s, sddlLabelRights); err == nil {
+			return v, nil
+		}
+	}
+	for _, set := range sddlRightSets {
+		if strings.EqualFold(s, set.code) {
+			return set.bit, nil
+		}
+	}
+	v, err := parseSDDLBits(s, append(sddlRightSets[:len(sddlRightSets):len(sddlRightSets)], sddlRights...))
+	if err != nil {
+		return 0, fmt.Errorf("wim: invalid SDDL rights %q", s)
+	}
+	return v, nil
+}
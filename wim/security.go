@@ -0,0 +1,466 @@
+package wim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Security descriptor control flags.
+const (
+	SEOwnerDefaulted     = 0x0001
+	SEGroupDefaulted     = 0x0002
+	SEDACLPresent        = 0x0004
+	SEDACLDefaulted      = 0x0008
+	SESACLPresent        = 0x0010
+	SESACLDefaulted      = 0x0020
+	SEDACLAutoInheritReq = 0x0100
+	SESACLAutoInheritReq = 0x0200
+	SEDACLAutoInherited  = 0x0400
+	SESACLAutoInherited  = 0x0800
+	SEDACLProtected      = 0x1000
+	SESACLProtected      = 0x2000
+	SERMControlValid     = 0x4000
+	SESelfRelative       = 0x8000
+)
+
+const (
+	securityHeaderSize      = 20
+	aceHeaderSize           = 4
+	aceObjectTypePresent    = 1
+	aceInheritedTypePresent = 2
+	aclRevision             = 2
+	aclRevisionDS           = 4 // needed once an ACL holds object ACEs
+)
+
+// ACE types.
+const (
+	ACEAccessAllowed               = 0x00
+	ACEAccessDenied                = 0x01
+	ACESystemAudit                 = 0x02
+	ACESystemAlarm                 = 0x03
+	ACEAccessAllowedObject         = 0x05
+	ACEAccessDeniedObject          = 0x06
+	ACESystemAuditObject           = 0x07
+	ACESystemAlarmObject           = 0x08
+	ACEAccessAllowedCallback       = 0x09
+	ACEAccessDeniedCallback        = 0x0A
+	ACEAccessAllowedCallbackObject = 0x0B
+	ACEAccessDeniedCallbackObject  = 0x0C
+	ACESystemAuditCallback         = 0x0D
+	ACESystemAuditCallbackObject   = 0x0F
+	ACESystemMandatoryLabel        = 0x11
+	ACESystemResourceAttribute     = 0x12
+	ACESystemScopedPolicyID        = 0x13
+)
+
+// ACE flags.
+const (
+	ACEObjectInherit    = 0x01
+	ACEContainerInherit = 0x02
+	ACENoPropagate      = 0x04
+	ACEInheritOnly      = 0x08
+	ACEInherited        = 0x10
+	ACESuccessfulAccess = 0x40
+	ACEFailedAccess     = 0x80
+)
+
+var errBadSecurity = errors.New("wim: malformed security descriptor")
+
+// SID is a Windows security identifier.
+type SID struct {
+	Revision       byte
+	Authority      uint64 // 48 bits
+	SubAuthorities []uint32
+}
+
+// String formats s as S-1-5-32-544.
+func (s *SID) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "S-%d-", s.Revision)
+	if s.Authority >= 1<<32 {
+		fmt.Fprintf(&b, "0x%012X", s.Authority)
+	} else {
+		b.WriteString(strconv.FormatUint(s.Authority, 10))
+	}
+	for _, sub := range s.SubAuthorities {
+		b.WriteByte('-')
+		b.WriteString(strconv.FormatUint(uint64(sub), 10))
+	}
+	return b.String()
+}
+
+func (s *SID) size() int {
+	return 8 + 4*len(s.SubAuthorities)
+}
+
+func (s *SID) append(b []byte) []byte {
+	b = append(b, s.Revision, byte(len(s.SubAuthorities)))
+	var auth [8]byte
+	binary.BigEndian.PutUint64(auth[:], s.Authority)
+	b = append(b, auth[2:]...)
+	for _, sub := range s.SubAuthorities {
+		b = binary.LittleEndian.AppendUint32(b, sub)
+	}
+	return b
+}
+
+func parseSIDBytes(b []byte) (*SID, int, error) {
+	if len(b) < 8 {
+		return nil, 0, errBadSecurity
+	}
+	n := int(b[1])
+	size := 8 + 4*n
+	if len(b) < size {
+		return nil, 0, errBadSecurity
+	}
+	var auth [8]byte
+	copy(auth[2:], b[2:8])
+	s := &SID{Revision: b[0], Authority: binary.BigEndian.Uint64(auth[:]), SubAuthorities: make([]uint32, n)}
+	for i := range n {
+		s.SubAuthorities[i] = binary.LittleEndian.Uint32(b[8+4*i:])
+	}
+	return s, size, nil
+}
+
+// ParseSID parses a SID string like S-1-5-18 or an SDDL alias like SY.
+func ParseSID(str string) (*SID, error) {
+	if s, ok := sidAliases[strings.ToUpper(str)]; ok {
+		return ParseSID(s)
+	}
+	parts := strings.Split(str, "-")
+	if len(parts) < 3 || len(parts) > 3+15 || !strings.EqualFold(parts[0], "S") {
+		return nil, fmt.Errorf("wim: invalid SID %q", str)
+	}
+	rev, err := strconv.ParseUint(parts[1], 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("wim: invalid SID %q", str)
+	}
+	auth, err := strconv.ParseUint(parts[2], 0, 48)
+	if err != nil {
+		return nil, fmt.Errorf("wim: invalid SID %q", str)
+	}
+	s := &SID{Revision: byte(rev), Authority: auth}
+	for _, p := range parts[3:] {
+		sub, err := strconv.ParseUint(p, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("wim: invalid SID %q", str)
+		}
+		s.SubAuthorities = append(s.SubAuthorities, uint32(sub))
+	}
+	return s, nil
+}
+
+// sidAliases are the SDDL abbreviations of well-known SIDs that do not
+// depend on the domain.
+var sidAliases = map[string]string{
+	"AC": "S-1-15-2-1",
+	"AN": "S-1-5-7",
+	"AO": "S-1-5-32-548",
+	"AU": "S-1-5-11",
+	"BA": "S-1-5-32-544",
+	"BG": "S-1-5-32-546",
+	"BO": "S-1-5-32-551",
+	"BU": "S-1-5-32-545",
+	"CG": "S-1-3-1",
+	"CO": "S-1-3-0",
+	"CY": "S-1-5-32-569",
+	"ED": "S-1-5-9",
+	"ER": "S-1-5-32-573",
+	"HI": "S-1-16-12288",
+	"IS": "S-1-5-32-568",
+	"IU": "S-1-5-4",
+	"LS": "S-1-5-19",
+	"LU": "S-1-5-32-559",
+	"LW": "S-1-16-4096",
+	"ME": "S-1-16-8192",
+	"MU": "S-1-5-32-558",
+	"NO": "S-1-5-32-556",
+	"NS": "S-1-5-20",
+	"NU": "S-1-5-2",
+	"OW": "S-1-3-4",
+	"PO": "S-1-5-32-550",
+	"PS": "S-1-5-10",
+	"PU": "S-1-5-32-547",
+	"RC": "S-1-5-12",
+	"RD": "S-1-5-32-555",
+	"RE": "S-1-5-32-552",
+	"RU": "S-1-5-32-554",
+	"SI": "S-1-16-16384",
+	"SO": "S-1-5-32-549",
+	"SU": "S-1-5-6",
+	"SY": "S-1-5-18",
+	"WD": "S-1-1-0",
+	"WR": "S-1-5-33",
+}
+
+var sidAbbrevs = func() map[string]string {
+	m := make(map[string]string, len(sidAliases))
+	for k, v := range sidAliases {
+		m[v] = k
+	}
+	return m
+}()
+
+// ACE is one access control entry. ObjectType and InheritedObjectType
+// are set only for object ACEs that carry them. ApplicationData holds
+// whatever follows the SID, such as the condition of a callback ACE.
+type ACE struct {
+	Type                byte
+	Flags               byte
+	Mask                uint32
+	ObjectType          *GUID
+	InheritedObjectType *GUID
+	SID                 *SID
+	ApplicationData     []byte
+}
+
+// parseGUID parses a GUID as SDDL writes it, without braces.
+func parseGUID(s string) (GUID, error) {
+	var g GUID
+	parts := strings.Split(s, "-")
+	if len(s) != 36 || len(parts) != 5 {
+		return g, fmt.Errorf("wim: invalid GUID %q", s)
+	}
+	d1, err1 := strconv.ParseUint(parts[0], 16, 32)
+	d2, err2 := strconv.ParseUint(parts[1], 16, 16)
+	d3, err3 := strconv.ParseUint(parts[2], 16, 16)
+	d4, err4 := strconv.ParseUint(parts[3]+parts[4], 16, 64)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return g, fmt.Errorf("wim: invalid GUID %q", s)
+	}
+	binary.LittleEndian.PutUint32(g[0:], uint32(d1))
+	binary.LittleEndian.PutUint16(g[4:], uint16(d2))
+	binary.LittleEndian.PutUint16(g[6:], uint16(d3))
+	binary.BigEndian.PutUint64(g[8:], d4)
+	return g, nil
+}
+
+func isObjectACE(t byte) bool {
+	switch t {
+	case ACEAccessAllowedObject, ACEAccessDeniedObject, ACESystemAuditObject, ACESystemAlarmObject,
+		ACEAccessAllowedCallbackObject, ACEAccessDeniedCallbackObject, ACESystemAuditCallbackObject:
+		return true
+	}
+	return false
+}
+
+// ACL is an access control list.
+type ACL struct {
+	Revision byte
+	ACEs     []ACE
+}
+
+func parseACL(b []byte) (*ACL, error) {
+	if len(b) < 8 {
+		return nil, errBadSecurity
+	}
+	size := int(binary.LittleEndian.Uint16(b[2:4]))
+	count := int(binary.LittleEndian.Uint16(b[4:6]))
+	if size < 8 || size > len(b) {
+		return nil, errBadSecurity
+	}
+	acl := &ACL{Revision: b[0], ACEs: make([]ACE, 0, count)}
+	off := 8
+	for range count {
+		if off+aceHeaderSize > size {
+			return nil, errBadSecurity
+		}
+		aceSize := int(binary.LittleEndian.Uint16(b[off+2:]))
+		if aceSize < aceHeaderSize+4 || off+aceSize > size {
+			return nil, errBadSecurity
+		}
+		ace, err := parseACE(b[off : off+aceSize])
+		if err != nil {
+			return nil, err
+		}
+		acl.ACEs = append(acl.ACEs, ace)
+		off += aceSize
+	}
+	return acl, nil
+}
+
+func parseACE(b []byte) (ACE, error) {
+	ace := ACE{Type: b[0], Flags: b[1], Mask: binary.LittleEndian.Uint32(b[4:8])}
+	rest := b[8:]
+	if isObjectACE(ace.Type) {
+		if len(rest) < 4 {
+			return ace, errBadSecurity
+		}
+		flags := binary.LittleEndian.Uint32(rest)
+		rest = rest[4:]
+		for _, f := range []struct {
+			bit uint32
+			dst **GUID
+		}{{aceObjectTypePresent, &ace.ObjectType}, {aceInheritedTypePresent, &ace.InheritedObjectType}} {
+			if flags&f.bit == 0 {
+				continue
+			}
+			if len(rest) < 16 {
+				return ace, errBadSecurity
+			}
+			g := GUID(rest[:16])
+			*f.dst = &g
+			rest = rest[16:]
+		}
+	}
+	sid, n, err := parseSIDBytes(rest)
+	if err != nil {
+		return ace, err
+	}
+	ace.SID = sid
+	if len(rest) > n {
+		ace.ApplicationData = rest[n:]
+	}
+	return ace, nil
+}
+
+func (ace *ACE) append(b []byte) []byte {
+	start := len(b)
+	b = append(b, ace.Type, ace.Flags, 0, 0)
+	b = binary.LittleEndian.AppendUint32(b, ace.Mask)
+	if isObjectACE(ace.Type) {
+		var flags uint32
+		if ace.ObjectType != nil {
+			flags |= aceObjectTypePresent
+		}
+		if ace.InheritedObjectType != nil {
+			flags |= aceInheritedTypePresent
+		}
+		b = binary.LittleEndian.AppendUint32(b, flags)
+		if ace.ObjectType != nil {
+			b = append(b, ace.ObjectType[:]...)
+		}
+		if ace.InheritedObjectType != nil {
+			b = append(b, ace.InheritedObjectType[:]...)
+		}
+	}
+	b = ace.SID.append(b)
+	b = append(b, ace.ApplicationData...)
+	for (len(b)-start)%4 != 0 {
+		b = append(b, 0)
+	}
+	binary.LittleEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+func (acl *ACL) append(b []byte) []byte {
+	start := len(b)
+	b = append(b, acl.Revision, 0, 0, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(acl.ACEs)))
+	b = append(b, 0, 0)
+	for i := range acl.ACEs {
+		b = acl.ACEs[i].append(b)
+	}
+	binary.LittleEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+	return b
+}
+
+// SecurityDescriptor is a decoded self-relative security descriptor. A
+// DACL or SACL that is present but nil is a NULL ACL, which for the DACL
+// grants everyone full access.
+type SecurityDescriptor struct {
+	Revision byte
+	Control  uint16
+	Owner    *SID
+	Group    *SID
+	DACL     *ACL
+	SACL     *ACL
+}
+
+// ParseSecurityDescriptor decodes a self-relative security descriptor, as
+// WIM images store them.
+func ParseSecurityDescriptor(b []byte) (*SecurityDescriptor, error) {
+	if len(b) < securityHeaderSize {
+		return nil, errBadSecurity
+	}
+	sd := &SecurityDescriptor{Revision: b[0], Control: binary.LittleEndian.Uint16(b[2:4])}
+	if sd.Control&SESelfRelative == 0 {
+		return nil, fmt.Errorf("%w: not self-relative", errBadSecurity)
+	}
+	at := func(field int) ([]byte, bool, error) {
+		off := int(binary.LittleEndian.Uint32(b[field:]))
+		if off == 0 {
+			return nil, false, nil
+		}
+		if off < securityHeaderSize || off >= len(b) {
+			return nil, false, errBadSecurity
+		}
+		return b[off:], true, nil
+	}
+	var err error
+	for _, s := range []struct {
+		field int
+		dst   **SID
+	}{{4, &sd.Owner}, {8, &sd.Group}} {
+		p, ok, e := at(s.field)
+		if e != nil {
+			return nil, e
+		}
+		if ok {
+			if *s.dst, _, err = parseSIDBytes(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, a := range []struct {
+		field   int
+		present uint16
+		dst     **ACL
+	}{{12, SESACLPresent, &sd.SACL}, {16, SEDACLPresent, &sd.DACL}} {
+		p, ok, e := at(a.field)
+		if e != nil {
+			return nil, e
+		}
+		if ok && sd.Control&a.present != 0 {
+			if *a.dst, err = parseACL(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return sd, nil
+}
+
+// Bytes encodes sd in self-relative form, with the SACL, DACL, owner and
+// group after the header in that order, as Windows lays them out.
+func (sd *SecurityDescriptor) Bytes() []byte {
+	b := make([]byte, securityHeaderSize)
+	b[0] = sd.Revision
+	if b[0] == 0 {
+		b[0] = 1
+	}
+	control := sd.Control | SESelfRelative
+	binary.LittleEndian.PutUint16(b[2:], control)
+	put := func(field int) {
+		binary.LittleEndian.PutUint32(b[field:], uint32(len(b)))
+	}
+	if sd.SACL != nil && control&SESACLPresent != 0 {
+		put(12)
+		b = sd.SACL.append(b)
+	}
+	if sd.DACL != nil && control&SEDACLPresent != 0 {
+		put(16)
+		b = sd.DACL.append(b)
+	}
+	if sd.Owner != nil {
+		put(4)
+		b = sd.Owner.append(b)
+	}
+	if sd.Group != nil {
+		put(8)
+		b = sd.Group.append(b)
+	}
+	return b
+}
+
+// Security decodes the security descriptor of d; it returns nil when d
+// has none.
+func (img *Image) Security(d *Dentry) (*SecurityDescriptor, error) {
+	b := img.SecurityDescriptor(d.SecurityID)
+	if b == nil {
+		return nil, nil
+	}
+	return ParseSecurityDescriptor(b)
+}
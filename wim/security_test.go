@@ -0,0 +1,159 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+const trustedInstaller = "S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464"
+
+// Self-relative descriptors laid out as Windows writes them: header, SACL,
+// DACL, owner, group.
+var knownDescriptors = []struct {
+	hex, sddl string
+}{
+	{
+		"0100049474000000840000000000000014000000020060000400000000031400ff011f0001010000000000051200000000031800ff011f0001020000000000052000000020020000000b14000000001001010000000000030000000000001800a90012000102000000000005200000002102000001020000000000052000000020020000010100000000000512000000",
+		"O:BAG:SYD:PAI(A;OICI;FA;;;SY)(A;OICI;FA;;;BA)(A;OICIIO;GA;;;CO)(A;;0x1200a9;;;BU)",
+	},
+	{
+		"0100149c9c000000bc000000140000003000000002001c0001000000110314000100000001010000000000100030000004006c000300000000002800ff011f00010600000000000550000000b589fb381984c2cb5c6c236d5700776ec002648700101400a9001200010100000000000512000000050028000001000001000000531a72ab2f1ed011981900aa0040529b010100000000000100000000010600000000000550000000b589fb381984c2cb5c6c236d5700776ec0026487010600000000000550000000b589fb381984c2cb5c6c236d5700776ec0026487",
+		"O:" + trustedInstaller + "G:" + trustedInstaller + "D:PAI(A;;FA;;;" + trustedInstaller + ")(A;ID;0x1200a9;;;SY)" +
+			"(OA;;CR;ab721a53-1e2f-11d0-9819-00aa0040529b;;WD)S:AI(ML;OICI;NW;;;HI)",
+	},
+	{
+		// A NULL DACL: present, with no ACL.
+		"0100048000000000000000000000000000000000",
+		"D:NO_ACCESS_CONTROL",
+	},
+}
+
+func TestSecurityDescriptorKnown(t *testing.T) {
+	for _, k := range knownDescriptors {
+		raw, err := hex.DecodeString(k.hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd, err := ParseSecurityDescriptor(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", k.sddl, err)
+		}
+		if got := sd.SDDL(); got != k.sddl {
+			t.Errorf("SDDL = %s\nwant   %s", got, k.sddl)
+		}
+		if got := sd.Bytes(); !bytes.Equal(got, raw) {
+			t.Errorf("%s: re-encoded as %x", k.sddl, got)
+		}
+		parsed, err := ParseSDDL(k.sddl)
+		if err != nil {
+			t.Fatalf("ParseSDDL(%s): %v", k.sddl, err)
+		}
+		if got := parsed.Bytes(); !bytes.Equal(got, raw) {
+			t.Errorf("ParseSDDL(%s) encoded as %x", k.sddl, got)
+		}
+	}
+}
+
+func TestSecurityDescriptorDetails(t *testing.T) {
+	raw, _ := hex.DecodeString(knownDescriptors[1].hex)
+	sd, err := ParseSecurityDescriptor(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd.Owner.String() != trustedInstaller || sd.SACL == nil || len(sd.DACL.ACEs) != 3 {
+		t.Fatalf("descriptor = %+v", sd)
+	}
+	oa := sd.DACL.ACEs[2]
+	if oa.Type != ACEAccessAllowedObject || oa.Mask != 0x100 || oa.ObjectType == nil || oa.InheritedObjectType != nil {
+		t.Fatalf("object ACE = %+v", oa)
+	}
+	if sd.DACL.ACEs[1].Flags != ACEInherited {
+		t.Fatalf("flags = %#x", sd.DACL.ACEs[1].Flags)
+	}
+
+	for _, bad := range [][]byte{
+		raw[:10],
+		raw[:40],                               // DACL cut short
+		append([]byte{1, 0, 4, 0}, raw[4:]...), // not self-relative
+	} {
+		if _, err := ParseSecurityDescriptor(bad); err == nil {
+			t.Errorf("parsed malformed descriptor %x", bad)
+		}
+	}
+}
+
+func TestParseSDDL(t *testing.T) {
+	for in, want := range map[string]string{
+		"O:SYG:SYD:(A;;GRGX;;;BU)(D;CI;0x1f01ff;;;S-1-5-21-1-2-3-500)": "O:SYG:SYD:(A;;GRGX;;;BU)(D;CI;FA;;;S-1-5-21-1-2-3-500)",
+		"O:S-1-5-18 D:AI(A;OICIID;FR;;;WD) S:(AU;SAFA;FA;;;WD)":        "O:SYD:AI(A;OICIID;FR;;;WD)S:(AU;SAFA;FA;;;WD)",
+		"D:P":                      "D:P",
+		"D:(A;;CCDCRP;;;AU)":       "D:(A;;CCDCRP;;;AU)",
+		"D:(A;;268435456;;;S-1-0)": "D:(A;;GA;;;S-1-0)",
+	} {
+		sd, err := ParseSDDL(in)
+		if err != nil {
+			t.Fatalf("ParseSDDL(%s): %v", in, err)
+		}
+		back, err := ParseSecurityDescriptor(sd.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := back.SDDL(); got != want {
+			t.Errorf("ParseSDDL(%s) = %s want %s", in, got, want)
+		}
+	}
+	for _, bad := range []string{
+		"X:SY",
+		"O:nobody",
+		"D:(A;;FA;;SY)",
+		"D:(Q;;FA;;;SY)",
+		"D:(A;XX;FA;;;SY)",
+		"D:(A;;FA;not-a-guid;;SY)",
+		"D:(XA;;FX;;;WD;(Member_of {SID(BA)}))",
+		"D:(A;;FA;;;SY",
+	} {
+		if _, err := ParseSDDL(bad); err == nil {
+			t.Errorf("ParseSDDL(%s) succeeded", bad)
+		}
+	}
+}
+
+func TestTarSourceSDDL(t *testing.T) {
+	const sddl = "O:BAG:SYD:PAI(A;;FA;;;SY)"
+	raw := writeTestTar(t, []*tar.Header{
+		{Name: "locked.txt", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: map[string]string{PAXSecuritySDDL: sddl}},
+	}, map[string]string{"locked.txt": "x"})
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := img.Lookup("locked.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd, err := img.Security(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd == nil || sd.SDDL() != sddl {
+		t.Fatalf("security = %v", sd)
+	}
+
+	raw = writeTestTar(t, []*tar.Header{
+		{Name: "bad.txt", Typeflag: tar.TypeReg, Mode: 0o644, PAXRecords: map[string]string{PAXSecuritySDDL: "D:(bogus)"}},
+	}, map[string]string{"bad.txt": "x"})
+	captureTestWIM(t, func(w *Writer) {
+		err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{})
+		if err == nil || !strings.Contains(err.Error(), "bad.txt") {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
@@ -0,0 +1,291 @@
+package wim
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+const (
+	// defaultSolidChunk is the LZMS solid chunk size DISM uses for ESD
+	// files.
+	defaultSolidChunk = 64 << 20
+	// solidTableChunks is how many chunk table entries a solid resource
+	// reserves unless its first blob needs more. A resource that fills up
+	// is closed and the next one started.
+	solidTableChunks = 1024
+)
+
+// solidWriter packs the blobs of a Writer into solid resources. A
+// resource is written as its blobs arrive; the chunk table in front of the
+// chunks is filled in when it is closed.
+type solidWriter struct {
+	compression wimgapi.Compression
+	chunkSize   uint64
+	chunks      *chunkPipeline
+
+	// The resource being written, if cur is not nil.
+	cur       *solidResource
+	curBlobs  []*blobEntry
+	capacity  uint64 // uncompressed bytes the reserved table allows
+	dataStart int64
+	dataEnd   int64
+	job       *chunkJob // the chunk being filled, or nil
+	flushed   uint64    // bytes handed to the pipeline
+	sizes     []uint32
+
+	done  []*solidResource
+	blobs map[*solidResource][]*blobEntry
+}
+
+func newSolidWriter(p *chunkPipeline, c wimgapi.Compression, chunkSize uint32) *solidWriter {
+	return &solidWriter{
+		compression: c,
+		chunkSize:   uint64(chunkSize),
+		chunks:      p,
+		blobs:       make(map[*solidResource][]*blobEntry),
+	}
+}
+
+// addSolidBlob stores a stream in the current solid resource unless an
+// identical blob is already in the WIM. Seekable streams are hashed before
+// they are stored. Other streams are hashed as they are stored; when one
+// turns out to be a duplicate, it is dropped again if it is still in the
+// chunk being filled and otherwise left unreferenced.
+func (w *Writer) addSolidBlob(r io.Reader) (Stream, error) {
+	size, ok := readerSize(r)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return Stream{}, err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	if size == 0 {
+		return Stream{}, nil
+	}
+	var known *Hash
+	if sk, ok := r.(io.Seeker); ok {
+		start, err := sk.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return Stream{}, err
+		}
+		h := sha1.New()
+		if n, err := io.Copy(h, r); err != nil {
+			return Stream{}, err
+		} else if n != size {
+			return Stream{}, errSizeChanged
+		}
+		var sum Hash
+		h.Sum(sum[:0])
+		if s, ok := w.reuseBlob(sum); ok {
+			return s, nil
+		}
+		if _, err := sk.Seek(start, io.SeekStart); err != nil {
+			return Stream{}, err
+		}
+		known = &sum
+	}
+
+	s := w.solid
+	if s.cur != nil && s.size()+uint64(size) > s.capacity {
+		if err := w.endSolid(); err != nil {
+			return Stream{}, err
+		}
+	}
+	if s.cur == nil {
+		w.beginSolid(uint64(size))
+	}
+	start := s.size()
+	sum, err := w.appendSolid(r, uint64(size))
+	if err != nil {
+		return Stream{}, err
+	}
+	if known != nil && *known != sum {
+		return Stream{}, errSizeChanged
+	}
+	if st, ok := w.reuseBlob(sum); ok {
+		if start >= s.flushed {
+			s.truncate(start)
+		}
+		return st, nil
+	}
+	e := &blobEntry{
+		res:        resourceHeader{Size: uint64(size), Flags: resFlagSolid, Offset: start, UncompressedSize: uint64(size)},
+		partNumber: 1,
+		refCount:   1,
+		hash:       sum,
+		solid:      s.cur,
+	}
+	w.blobs[sum] = e
+	s.curBlobs = append(s.curBlobs, e)
+	return Stream{Hash: sum, Size: uint64(size)}, nil
+}
+
+// reuseBlob adds a reference to the blob with hash sum if the WIM already
+// holds it.
+func (w *Writer) reuseBlob(sum Hash) (Stream, bool) {
+	e, ok := w.blobs[sum]
+	if !ok {
+		return Stream{}, false
+	}
+	e.refCount++
+	return Stream{Hash: sum, Size: e.res.UncompressedSize}, true
+}
+
+// size returns the uncompressed size of the current resource so far.
+func (s *solidWriter) size() uint64 {
+	return s.cur.size
+}
+
+// beginSolid starts a resource at the end of the output, reserving a
+// chunk table large enough for a first blob of size bytes.
+func (w *Writer) beginSolid(size uint64) {
+	s := w.solid
+	chunks := max(solidTableChunks, (size+s.chunkSize-1)/s.chunkSize)
+	s.cur = &solidResource{chunkSize: uint32(s.chunkSize), compression: s.compression}
+	s.curBlobs = nil
+	s.capacity = chunks * s.chunkSize
+	s.dataStart = w.pos + solidHeaderSize + int64(4*chunks)
+	s.dataEnd = s.dataStart
+	s.job = nil
+	s.flushed = 0
+	s.sizes = s.sizes[:0]
+}
+
+// appendSolid copies size bytes from r into the current resource,
+// submitting each chunk as it fills, and returns their hash.
+func (w *Writer) appendSolid(r io.Reader, size uint64) (Hash, error) {
+	s := w.solid
+	h := sha1.New()
+	for size > 0 {
+		if s.job == nil {
+			job, err := s.chunks.get(int(s.chunkSize), w.emitSolid)
+			if err != nil {
+				s.chunks.put(job)
+				return Hash{}, err
+			}
+			s.job = job
+		}
+		job := s.job
+		off := uint64(len(job.in))
+		n := min(s.chunkSize-off, size)
+		job.in = job.in[:off+n]
+		if _, err := io.ReadFull(r, job.in[off:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errSizeChanged
+			}
+			return Hash{}, err
+		}
+		h.Write(job.in[off:])
+		s.cur.size += n
+		size -= n
+		if uint64(len(job.in)) == s.chunkSize {
+			s.submit()
+		}
+	}
+	var extra [1]byte
+	if n, _ := io.ReadFull(r, extra[:]); n > 0 {
+		return Hash{}, errSizeChanged
+	}
+	var sum Hash
+	h.Sum(sum[:0])
+	return sum, nil
+}
+
+// submit hands the chunk being filled to the pipeline.
+func (s *solidWriter) submit() {
+	s.flushed += uint64(len(s.job.in))
+	s.chunks.submit(s.job)
+	s.job = nil
+}
+
+// truncate drops the uncompressed bytes of the current resource from
+// size on, which must not have been submitted yet.
+func (s *solidWriter) truncate(size uint64) {
+	s.cur.size = size
+	if s.job != nil {
+		s.job.in = s.job.in[:size-s.flushed]
+	}
+}
+
+// emitSolid writes a compressed chunk of the current resource.
+func (w *Writer) emitSolid(job *chunkJob) error {
+	s := w.solid
+	if err := w.writeAt(s.dataEnd, job.data); err != nil {
+		return err
+	}
+	s.dataEnd += int64(len(job.data))
+	s.sizes = append(s.sizes, uint32(len(job.data)))
+	return w.stored(len(job.in))
+}
+
+// endSolid writes the rest of the current resource and its header and
+// chunk table. The table goes right before the chunks, so when fewer
+// chunks were written than reserved, the resource starts after a gap.
+func (w *Writer) endSolid() error {
+	s := w.solid
+	if s == nil || s.cur == nil {
+		return nil
+	}
+	res := s.cur
+	s.cur = nil
+	if s.job != nil && len(s.job.in) > 0 {
+		s.submit()
+	} else if s.job != nil {
+		s.chunks.put(s.job)
+		s.job = nil
+	}
+	if err := s.chunks.drain(w.emitSolid); err != nil {
+		return err
+	}
+	if res.size == 0 {
+		return nil
+	}
+	buf := make([]byte, solidHeaderSize+4*len(s.sizes))
+	binary.LittleEndian.PutUint64(buf[0:8], res.size)
+	binary.LittleEndian.PutUint32(buf[8:12], res.chunkSize)
+	binary.LittleEndian.PutUint32(buf[12:16], uint32(res.compression))
+	for i, n := range s.sizes {
+		binary.LittleEndian.PutUint32(buf[solidHeaderSize+4*i:], n)
+	}
+	start := s.dataStart - int64(len(buf))
+	if err := w.writeAt(start, buf); err != nil {
+		return err
+	}
+	res.res = resourceHeader{
+		Size:             uint64(s.dataEnd - start),
+		Flags:            resFlagSolid,
+		Offset:           uint64(start),
+		UncompressedSize: solidResourceMagic,
+	}
+	s.done = append(s.done, res)
+	s.blobs[res] = s.curBlobs
+	s.curBlobs = nil
+	w.pos = s.dataEnd
+	return nil
+}
+
+// appendSolidEntries adds the blob table entries of the solid resources
+// to table as one run: each resource followed by its blobs, whose offsets
+// count from the start of the first resource's data.
+func (s *solidWriter) appendSolidEntries(table []byte) []byte {
+	var base uint64
+	var b [blobEntrySize]byte
+	for _, res := range s.done {
+		e := blobEntry{res: res.res, partNumber: 1, refCount: 1}
+		e.put(b[:])
+		table = append(table, b[:]...)
+		for _, blob := range s.blobs[res] {
+			e := *blob
+			e.res.Offset += base
+			e.put(b[:])
+			table = append(table, b[:]...)
+		}
+		base += res.size
+	}
+	return table
+}
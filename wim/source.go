@@ -0,0 +1,344 @@
+package wim
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// SourceEntry describes one file or directory offered by a CaptureSource.
+type SourceEntry struct {
+	Path           string // slash-separated, relative to the source root; "" is the root
+	Attributes     uint32
+	CreationTime   time.Time
+	LastAccessTime time.Time
+	LastWriteTime  time.Time
+	Security       []byte // self-relative security descriptor; nil for none
+	// LinkTo makes the entry a hard link to the earlier entry at that path.
+	// The other fields matter only if that entry was excluded: the link is
+	// then captured in full in its place, or skipped when Attributes is
+	// zero because the source cannot open its data, as in a tar stream.
+	LinkTo string
+	// A nonzero ReparseTag makes the entry a reparse point with
+	// ReparseData, which excludes the 8-byte reparse header.
+	ReparseTag  uint32
+	ReparseData []byte
+	Streams     []string // names of alternate data streams
+	// Sys holds source-specific data, such as the *Dentry of an image
+	// source.
+	Sys any
+}
+
+// CaptureSource is a tree the portable writer can capture as an image.
+type CaptureSource interface {
+	// Walk calls fn for every entry, parents before children. If fn
+	// returns fs.SkipDir for a directory, its contents are skipped; any
+	// other error from fn stops the walk and is returned.
+	Walk(fn func(e *SourceEntry) error) error
+	// Open returns a data stream of e; "" is the unnamed stream. It is
+	// called only while fn runs for e, which stream sources depend on.
+	Open(e *SourceEntry, stream string) (io.ReadCloser, error)
+}
+
+// AddImage captures src as a new image. With opts.Config, excluded
+// entries are skipped; the first hard link to a file whose first path is
+// excluded is captured in full, and the others are linked to it.
+func (w *Writer) AddImage(src CaptureSource, opts ImageOptions) error {
+	if w.closed {
+		return errWriterClosed
+	}
+	b := newImageBuilder(w)
+	b.config = opts.Config
+	b.rpfix = opts.RPFix
+	if is, ok := src.(*imageSource); opts.RPFix || ok && is.img.f.hdr.Flags&FlagRPFix != 0 {
+		w.hdr.Flags |= FlagRPFix
+	}
+	// firsts maps the excluded first path of a hard link group to the
+	// link captured in its place.
+	firsts := make(map[string]string)
+	err := src.Walk(func(e *SourceEntry) error {
+		if cfg := opts.Config; cfg != nil && e.Path != "" {
+			if cfg.Excluded(e.Path) {
+				if e.LinkTo == "" && e.Attributes&AttrDirectory != 0 {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if e.LinkTo != "" && cfg.Excluded(e.LinkTo) {
+				first, ok := firsts[e.LinkTo]
+				if !ok {
+					if e.Attributes == 0 {
+						return nil
+					}
+					firsts[e.LinkTo] = e.Path
+				}
+				relinked := *e
+				relinked.LinkTo = first
+				e = &relinked
+			}
+		}
+		if err := b.addEntry(src, e); err != nil {
+			return fmt.Errorf("wim: capture %s: %w", e.Path, err)
+		}
+		return w.captured()
+	})
+	// The metadata resource goes after this image's solid resource.
+	if serr := w.endSolid(); err == nil {
+		err = serr
+	}
+	if err != nil {
+		return err
+	}
+	return w.addMetadata(b, opts)
+}
+
+func (b *imageBuilder) addEntry(src CaptureSource, e *SourceEntry) error {
+	if e.LinkTo != "" {
+		return b.link(e.Path, e.LinkTo)
+	}
+	d := &Dentry{
+		Attributes:     e.Attributes,
+		SecurityID:     b.securityID(e.Security),
+		CreationTime:   e.CreationTime,
+		LastAccessTime: e.LastAccessTime,
+		LastWriteTime:  e.LastWriteTime,
+	}
+	if e.ReparseTag != 0 {
+		d.Attributes |= AttrReparsePoint
+		d.ReparseTag = e.ReparseTag
+		data, notFixed := b.fixLink(src, e)
+		d.ReparseNotFixed = notFixed
+		rp, err := b.w.addBlob(bytes.NewReader(data), true)
+		if err != nil {
+			return err
+		}
+		d.reparse = rp
+	}
+	if !d.IsDir() {
+		s, err := b.addSourceStream(src, e, "")
+		if err != nil {
+			return err
+		}
+		d.data = s
+	}
+	for _, name := range e.Streams {
+		s, err := b.addSourceStream(src, e, name)
+		if err != nil {
+			return err
+		}
+		s.Name = name
+		d.Streams = append(d.Streams, s)
+	}
+	return b.add(e.Path, d)
+}
+
+func (b *imageBuilder) addSourceStream(src CaptureSource, e *SourceEntry, name string) (Stream, error) {
+	r, err := src.Open(e, name)
+	if err != nil {
+		return Stream{}, err
+	}
+	defer r.Close()
+	compress := b.config == nil || !b.config.CompressionExcluded(e.Path)
+	return b.w.addBlob(r, compress)
+}
+
+// sizedReader is a stream whose length is known up front, which saves the
+// writer from buffering it before compression.
+type sizedReader struct {
+	io.Reader
+	size int64
+}
+
+func (r sizedReader) Size() int64  { return r.size }
+func (r sizedReader) Close() error { return nil }
+
+// hashedReader is a stream whose hash is known up front, so the writer
+// can skip a blob it already holds without reading it.
+type hashedReader struct {
+	sizedReader
+	hash Hash
+}
+
+func (r hashedReader) Hash() Hash { return r.hash }
+
+// modeAttributes derives Windows attributes from a Unix file mode, for
+// sources that have nothing better.
+func modeAttributes(mode fs.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return AttrDirectory
+	case mode.Perm()&0o222 == 0:
+		return AttrArchive | AttrReadOnly
+	default:
+		return AttrArchive
+	}
+}
+
+type fsSource struct {
+	fsys fs.FS
+}
+
+// NewFSSource captures the tree of fsys. Attributes and times come from
+// the file mode and modification time. Symbolic links are captured when
+// fsys has a ReadLink method and skipped otherwise, like anything else that
+// is neither a regular file nor a directory; links with absolute targets or
+// targets outside fsys are captured as links to files. Files whose
+// FileInfo.Sys is a *Dentry, as in Image.FS, keep their named streams, read
+// as "file:name".
+func NewFSSource(fsys fs.FS) CaptureSource {
+	return &fsSource{fsys: fsys}
+}
+
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+func (s *fsSource) Walk(fn func(e *SourceEntry) error) error {
+	return fs.WalkDir(s.fsys, ".", func(name string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		e := &SourceEntry{
+			Attributes:     modeAttributes(fi.Mode()),
+			CreationTime:   fi.ModTime(),
+			LastAccessTime: fi.ModTime(),
+			LastWriteTime:  fi.ModTime(),
+		}
+		if name != "." {
+			e.Path = name
+		}
+		if d, ok := fi.Sys().(*Dentry); ok {
+			for _, st := range d.Streams {
+				e.Streams = append(e.Streams, st.Name)
+			}
+		}
+		switch {
+		case fi.Mode()&fs.ModeSymlink != 0:
+			rl, ok := s.fsys.(readLinkFS)
+			if !ok {
+				return nil
+			}
+			target, err := rl.ReadLink(name)
+			if err != nil {
+				return err
+			}
+			e.Attributes = AttrArchive
+			if s.linkIsDir(name, target, fi) {
+				e.Attributes = AttrDirectory
+			}
+			e.ReparseTag = ReparseTagSymlink
+			e.ReparseData = symlinkReparseData(target)
+		case !fi.Mode().IsDir() && !fi.Mode().IsRegular():
+			return nil
+		}
+		return skipDirOnly(fn(e), de.IsDir())
+	})
+}
+
+// linkIsDir reports whether the symbolic link name to target is a link to
+// a directory, which Windows records. A *Dentry in fi.Sys says so itself.
+// Otherwise the target is looked up in fsys; absolute targets and relative
+// ones that climb out of fsys cannot be, and are taken as links to files.
+func (s *fsSource) linkIsDir(name, target string, fi fs.FileInfo) bool {
+	if d, ok := fi.Sys().(*Dentry); ok {
+		return d.IsDir()
+	}
+	if strings.HasPrefix(target, "/") || strings.HasPrefix(target, `\`) || len(target) >= 2 && target[1] == ':' {
+		return false
+	}
+	p := path.Join(path.Dir(name), target)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return false
+	}
+	st, err := fs.Stat(s.fsys, p)
+	return err == nil && st.IsDir()
+}
+
+func (s *fsSource) Open(e *SourceEntry, stream string) (io.ReadCloser, error) {
+	if stream != "" {
+		name := e.Path
+		if name == "" {
+			name = "."
+		}
+		return s.fsys.Open(name + ":" + stream)
+	}
+	if e.ReparseTag != 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	return s.fsys.Open(e.Path)
+}
+
+// skipDirOnly keeps fs.SkipDir from reaching fs.WalkDir for an entry it
+// doesn't walk into, where it would skip the rest of the parent instead.
+func skipDirOnly(err error, isDir bool) error {
+	if err == fs.SkipDir && !isDir {
+		return nil
+	}
+	return err
+}
+
+type imageSource struct {
+	img *Image
+}
+
+// NewImageSource captures an existing image, for copying it into another
+// WIM. Every dentry keeps its metadata, streams and hard links; reparse
+// data is copied as is.
+func NewImageSource(img *Image) CaptureSource {
+	return &imageSource{img: img}
+}
+
+func (s *imageSource) Walk(fn func(e *SourceEntry) error) error {
+	links := make(map[uint64]string)
+	return s.img.Walk(func(p string, d *Dentry) error {
+		e := &SourceEntry{
+			Path:           p,
+			Attributes:     d.Attributes,
+			CreationTime:   d.CreationTime,
+			LastAccessTime: d.LastAccessTime,
+			LastWriteTime:  d.LastWriteTime,
+			Security:       s.img.SecurityDescriptor(d.SecurityID),
+			Sys:            d,
+		}
+		if g := d.HardLinkGroup; g != 0 && !d.IsDir() {
+			if first, ok := links[g]; ok {
+				e.LinkTo = first
+			} else {
+				links[g] = p
+			}
+		}
+		if d.Attributes&AttrReparsePoint != 0 {
+			data, err := s.img.ReparseData(d)
+			if err != nil {
+				return err
+			}
+			e.ReparseTag = d.ReparseTag
+			e.ReparseData = data
+		}
+		for _, st := range d.Streams {
+			e.Streams = append(e.Streams, st.Name)
+		}
+		return skipDirOnly(fn(e), d.IsDir())
+	})
+}
+
+func (s *imageSource) Open(e *SourceEntry, stream string) (io.ReadCloser, error) {
+	d, ok := e.Sys.(*Dentry)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an entry of this image", e.Path)
+	}
+	r, err := s.img.OpenStream(d, stream)
+	if err != nil {
+		return nil, err
+	}
+	st, _ := d.Stream(stream)
+	return hashedReader{sizedReader{r, int64(st.Size)}, st.Hash}, nil
+}
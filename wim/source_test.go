@@ -0,0 +1,353 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestFSSource(t *testing.T) {
+	mtime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a.txt":          {Data: []byte("alpha"), Mode: 0o644, ModTime: mtime},
+		"ro.txt":         {Data: []byte("alpha"), Mode: 0o444, ModTime: mtime},
+		"sub/deep/b.bin": {Data: []byte{0, 1, 2}, Mode: 0o644, ModTime: mtime},
+		"empty":          {Mode: 0o644, ModTime: mtime},
+	}
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{Name: "fs"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(f.blobs) != 2 {
+		t.Fatalf("%d blobs stored", len(f.blobs))
+	}
+	info := f.Images()[0]
+	if info.FileCount != 4 || info.DirCount != 2 || info.TotalBytes != 13 {
+		t.Fatalf("info = %+v", info)
+	}
+
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := readAll(t)
+	if got := read(img.Open("sub/deep/b.bin")); got != "\x00\x01\x02" {
+		t.Fatalf("b.bin = %q", got)
+	}
+	a, _ := img.Lookup("a.txt")
+	ro, _ := img.Lookup("ro.txt")
+	if a.Attributes != AttrArchive || ro.Attributes != AttrArchive|AttrReadOnly {
+		t.Fatalf("attributes %s, %s", FormatAttributes(a.Attributes), FormatAttributes(ro.Attributes))
+	}
+	if !a.LastWriteTime.Equal(mtime) || !a.CreationTime.Equal(mtime) {
+		t.Fatalf("times = %v, %v", a.CreationTime, a.LastWriteTime)
+	}
+}
+
+// linkMapFS reads symbolic links from a MapFS, where Data is the target.
+type linkMapFS struct{ fstest.MapFS }
+
+func (fsys linkMapFS) ReadLink(name string) (string, error) {
+	return string(fsys.MapFS[name].Data), nil
+}
+
+func TestFSSourceLinks(t *testing.T) {
+	link := func(target string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(target), Mode: fs.ModeSymlink | 0o777}
+	}
+	fsys := linkMapFS{fstest.MapFS{
+		"d/file":    {Data: []byte("x")},
+		"sub/rel":   link("../d"),
+		"sub/file":  link("../d/file"),
+		"abs":       link("/d"),
+		"drive":     link(`C:\d`),
+		"escape":    link("../d"),
+		"sub/climb": link("../../d"),
+		"dentry":    {Data: []byte("/Windows"), Mode: fs.ModeSymlink, Sys: &Dentry{Attributes: AttrDirectory | AttrReparsePoint}},
+	}}
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, dir := range map[string]bool{
+		"sub/rel": true, "sub/file": false, "abs": false, "drive": false,
+		"escape": false, "sub/climb": false, "dentry": true,
+	} {
+		d, err := img.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.ReparseTag != ReparseTagSymlink || d.IsDir() != dir {
+			t.Errorf("%s: tag %#x, directory %v", name, d.ReparseTag, d.IsDir())
+		}
+	}
+}
+
+func TestImageSource(t *testing.T) {
+	shared := testFile("a.txt", "linked")
+	shared.linkGroup = 7
+	other := testFile("b.txt", "linked")
+	other.linkGroup = 7
+	hosts := testFile("hosts", "127.0.0.1 localhost\n")
+	hosts.streams = map[string][]byte{"Zone.Identifier": []byte("ZoneId=3")}
+	orig := openTestImage(t, testDir("",
+		testDir("dir", shared, hosts, testSymlink("link", "../b.txt", true)),
+		other,
+	))
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewImageSource(orig), ImageOptions{Name: "copy"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := Diff(orig, img, DiffOptions{}); len(res.Changes) != 0 {
+		t.Fatalf("copy changed %d entries: %+v", len(res.Changes), res.Changes[0])
+	}
+	a, _ := img.Lookup("dir/a.txt")
+	b, _ := img.Lookup("b.txt")
+	if a.HardLinkGroup == 0 || a.HardLinkGroup != b.HardLinkGroup {
+		t.Fatalf("hard link groups %d, %d", a.HardLinkGroup, b.HardLinkGroup)
+	}
+	if info := f.Images()[0]; info.HardLinkBytes != 6 {
+		t.Fatalf("hard link bytes = %d", info.HardLinkBytes)
+	}
+	link, _ := img.Lookup("dir/link")
+	if rp, err := img.ReparsePoint(link); err != nil || rp.Target() != "../b.txt" {
+		t.Fatalf("link = %+v, %v", rp, err)
+	}
+}
+
+func TestDirSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub", "deep"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a.txt":          "alpha",
+		"sub/deep/b.txt": "beta",
+		"sub/empty":      "",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlinks := runtime.GOOS != "windows"
+	if symlinks {
+		if err := os.Symlink("deep", filepath.Join(dir, "sub", "link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewDirSource(dir), ImageOptions{Name: "dir"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches, err := VerifyAgainstDir(img, dir, VerifyOptions{Streams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+	if !symlinks {
+		return
+	}
+	link, err := img.Lookup("sub/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp, err := img.ReparsePoint(link)
+	if err != nil || rp.Target() != "deep" || !link.IsDir() {
+		t.Fatalf("link = %+v (%s), %v", rp, FormatAttributes(link.Attributes), err)
+	}
+	if _, err := img.Lookup("sub/link/b.txt"); err == nil {
+		t.Fatal("capture followed the symlink")
+	}
+}
+
+func TestDirSourceHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("shared by three links")
+	if err := os.WriteFile(filepath.Join(dir, "a.dll"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.dll", "sub/c.dll"} {
+		if err := os.Link(filepath.Join(dir, "a.dll"), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Skipf("no hard links here: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "copy.dll"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewDirSource(dir), ImageOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := make(map[string]uint64)
+	for _, name := range []string{"a.dll", "b.dll", "sub/c.dll", "copy.dll"} {
+		d, err := img.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		groups[name] = d.HardLinkGroup
+	}
+	if g := groups["a.dll"]; g == 0 || groups["b.dll"] != g || groups["sub/c.dll"] != g || groups["copy.dll"] != 0 {
+		t.Fatalf("hard link groups %v", groups)
+	}
+	if info := f.Images()[0]; info.HardLinkBytes != 2*uint64(len(data)) || info.TotalBytes != 4*uint64(len(data)) {
+		t.Errorf("hard link bytes %d, total bytes %d", info.HardLinkBytes, info.TotalBytes)
+	}
+	if b := f.blobs[sha1.Sum(data)]; b == nil || b.refCount != 4 {
+		t.Errorf("blob = %+v", b)
+	}
+
+	out := t.TempDir()
+	if _, err := img.Apply(out, ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	stat := func(name string) os.FileInfo {
+		fi, err := os.Stat(filepath.Join(out, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi
+	}
+	a := stat("a.dll")
+	if !os.SameFile(a, stat("b.dll")) || !os.SameFile(a, stat("sub/c.dll")) {
+		t.Error("applied links are separate files")
+	}
+	if os.SameFile(a, stat("copy.dll")) {
+		t.Error("copy.dll applied as a link")
+	}
+}
+
+func TestAddImageConfigHardLinks(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte("linked under an excluded name first")
+	if err := os.WriteFile(filepath.Join(dir, "a.tmp"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"b.dat", "c.dat"} {
+		if err := os.Link(filepath.Join(dir, "a.tmp"), filepath.Join(dir, name)); err != nil {
+			t.Skipf("no hard links here: %v", err)
+		}
+	}
+	cfg, err := wimgapi.ParseCaptureConfig([]byte("[ExclusionList]\n*.tmp\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImage(NewDirSource(dir), ImageOptions{Config: cfg}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := img.Lookup("a.tmp"); err == nil {
+		t.Error("a.tmp captured")
+	}
+	b, err := img.Lookup("b.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := img.Lookup("c.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.HardLinkGroup == 0 || c.HardLinkGroup != b.HardLinkGroup {
+		t.Errorf("hard link groups %d, %d", b.HardLinkGroup, c.HardLinkGroup)
+	}
+	for _, name := range []string{"b.dat", "c.dat"} {
+		if got := readAll(t)(img.Open(name)); got != string(data) {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+}
+
+func TestAddImageConfig(t *testing.T) {
+	cfg, err := wimgapi.ParseCaptureConfig([]byte("[ExclusionList]\n\\pagefile.sys\n\\cache\n*.tmp\n[ExclusionException]\n\\cache\\keep\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"pagefile.sys":    {Data: []byte("x")},
+		"cache/keep":      {Data: []byte("kept")},
+		"cache/drop":      {Data: []byte("dropped")},
+		"dir/a.tmp":       {Data: []byte("tmp")},
+		"dir/b.txt":       {Data: []byte("b")},
+		"tmp.tmp/c.txt":   {Data: []byte("c")},
+		"tmp.tmp/d/e.txt": {Data: []byte("e")},
+	}
+	var entries []*tar.Header
+	data := make(map[string]string)
+	for name, f := range fsys {
+		entries = append(entries, &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644})
+		data[name] = string(f.Data)
+	}
+	entries = append([]*tar.Header{{Name: "tmp.tmp/", Typeflag: tar.TypeDir, Mode: 0o755}}, entries...)
+	entries = append(entries, &tar.Header{Name: "link", Typeflag: tar.TypeLink, Linkname: "cache/drop"})
+	raw := writeTestTar(t, entries, data)
+
+	sources := map[string]CaptureSource{
+		"fs":  NewFSSource(fsys),
+		"tar": NewTarSource(bytes.NewReader(raw)),
+	}
+	for name, src := range sources {
+		f := captureTestWIM(t, func(w *Writer) {
+			if err := w.AddImage(src, ImageOptions{Config: cfg}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		img, err := f.Image(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		img.Walk(func(p string, d *Dentry) error {
+			got = append(got, p)
+			return nil
+		})
+		want := []string{"", "cache", "cache/keep", "dir", "dir/b.txt"}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: captured %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package wim
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	errWhence = errors.New("wim: invalid whence")
+	errOffset = errors.New("wim: negative offset")
+)
+
+// StreamReader reads the contents of a stream. Besides reading in order,
+// with the next chunks decompressed ahead, it can seek and read at any
+// offset, decompressing only the chunks a read covers. ReadAt may be
+// called concurrently with other calls; Read and Seek may not.
+type StreamReader struct {
+	ra   io.ReaderAt  // the resource, uncompressed
+	seq  *chunkReader // sequential reader of a compressed resource
+	base int64        // where the stream starts in ra
+	size int64
+	off  int64 // of Read and Seek
+	at   int64 // where seq is
+}
+
+// Size returns the length of the stream.
+func (s *StreamReader) Size() int64 {
+	return s.size
+}
+
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if s.off >= s.size {
+		return 0, io.EOF
+	}
+	p = p[:min(int64(len(p)), s.size-s.off)]
+	if s.seq == nil {
+		n, err := s.ReadAt(p, s.off)
+		s.off += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	if s.at != s.off {
+		s.seq.seek(uint64(s.base + s.off))
+	}
+	n, err := s.seq.Read(p)
+	s.off += int64(n)
+	s.at = s.off
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (s *StreamReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errOffset
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	short := int64(len(p)) > s.size-off
+	if short {
+		p = p[:s.size-off]
+	}
+	n, err := s.ra.ReadAt(p, s.base+off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	if err == nil && short {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *StreamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.off
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errWhence
+	}
+	if offset < 0 {
+		return 0, errOffset
+	}
+	s.off = offset
+	return offset, nil
+}
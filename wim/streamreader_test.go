@@ -0,0 +1,165 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// hugeResource is a compressed resource of more than 4 GiB, with 8-byte
+// chunk table entries, made of identical XPRESS chunks of 'a's and a short
+// raw tail. Only the table is kept in memory.
+type hugeResource struct {
+	table []byte
+	chunk []byte
+	tail  []byte
+	full  int // number of compressed chunks
+}
+
+func newHugeResource() *hugeResource {
+	r := &hugeResource{
+		chunk: xpressFixedEncode([]xpressOp{{lit: 'a'}, {offset: 1, length: defaultChunk - 1}}),
+		tail:  []byte("the end of a large stream"),
+		full:  1<<32/defaultChunk + 1,
+	}
+	for i := 1; i <= r.full; i++ {
+		r.table = binary.LittleEndian.AppendUint64(r.table, uint64(i*len(r.chunk)))
+	}
+	return r
+}
+
+func (r *hugeResource) size() int64 {
+	return int64(len(r.table) + r.full*len(r.chunk) + len(r.tail))
+}
+
+func (r *hugeResource) uncompressedSize() int64 {
+	return int64(r.full)*defaultChunk + int64(len(r.tail))
+}
+
+func (r *hugeResource) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		var src []byte
+		switch data := pos - int64(len(r.table)); {
+		case pos >= r.size():
+			return n, io.EOF
+		case data < 0:
+			src = r.table[pos:]
+		case data < int64(r.full*len(r.chunk)):
+			src = r.chunk[data%int64(len(r.chunk)):]
+		default:
+			src = r.tail[data-int64(r.full*len(r.chunk)):]
+		}
+		n += copy(p[n:], src)
+	}
+	return n, nil
+}
+
+func TestStreamReaderHugeResource(t *testing.T) {
+	huge := newHugeResource()
+	cache := NewChunkCache(DefaultCacheSize)
+	f := &File{r: huge, hdr: header{Flags: FlagCompression | FlagCompressXPRESS, ChunkSize: defaultChunk}, cache: cache, readAhead: -1}
+	r, err := f.openResource(resourceHeader{Size: uint64(huge.size()), Flags: resFlagCompressed, UncompressedSize: uint64(huge.uncompressedSize())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size() != huge.uncompressedSize() {
+		t.Fatalf("size %d", r.Size())
+	}
+
+	want := append(bytes.Repeat([]byte{'a'}, 10), huge.tail...)
+	got := make([]byte, len(want)+5)
+	n, err := r.ReadAt(got, r.Size()-int64(len(want)))
+	if n != len(want) || err != io.EOF {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+	if !bytes.Equal(got[:n], want) {
+		t.Fatalf("ReadAt read %q", got[:n])
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("%d chunks decompressed, want 1", len(cache.entries))
+	}
+
+	if _, err := r.Seek(-int64(len(huge.tail)), io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, huge.tail) {
+		t.Fatalf("read %q after seeking", rest)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("%d chunks decompressed after seeking, want 1", len(cache.entries))
+	}
+}
+
+func TestStreamReaderSeek(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	data := make([]byte, 5*defaultChunk+123)
+	for i := range data {
+		data[i] = "stream "[rng.Intn(7)]
+	}
+	fsys := fstest.MapFS{"file.bin": {Data: data}}
+	for _, opts := range []WriterOptions{
+		{},
+		{Compression: wimgapi.CompressXPRESS},
+		{Compression: wimgapi.CompressLZMS, Solid: true, SolidChunkSize: 1 << 16},
+	} {
+		f := captureTestWIMWith(t, opts, func(w *Writer) {
+			// A file ahead of it puts the blob at an offset into the
+			// solid resource.
+			if err := w.AddImage(NewFSSource(fstest.MapFS{"first": {Data: []byte("first file")}}), ImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		img, err := f.Image(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := img.Open("file.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, at := range []struct{ off, n int }{
+			{3 * defaultChunk, 10},
+			{defaultChunk - 5, 10},
+			{0, 100},
+			{len(data) - 50, 50},
+			{2*defaultChunk + 17, 2 * defaultChunk},
+		} {
+			got := make([]byte, at.n)
+			if n, err := r.ReadAt(got, int64(at.off)); n != at.n || err != nil {
+				t.Fatalf("%+v: ReadAt(%d, %d) = %d, %v", opts, at.off, at.n, n, err)
+			}
+			if !bytes.Equal(got, data[at.off:at.off+at.n]) {
+				t.Fatalf("%+v: ReadAt(%d, %d) read the wrong bytes", opts, at.off, at.n)
+			}
+			if _, err := r.Seek(int64(at.off), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(r, got); err != nil {
+				t.Fatalf("%+v: read at %d: %v", opts, at.off, err)
+			}
+			if !bytes.Equal(got, data[at.off:at.off+at.n]) {
+				t.Fatalf("%+v: read at %d read the wrong bytes", opts, at.off)
+			}
+		}
+		if n, err := r.ReadAt(make([]byte, 10), int64(len(data))-4); n != 4 || err != io.EOF {
+			t.Errorf("%+v: ReadAt past the end = %d, %v", opts, n, err)
+		}
+		if _, err := r.Seek(-1, io.SeekStart); err == nil {
+			t.Errorf("%+v: seeking before the start succeeded", opts)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package wim
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// PAX records WriteTar uses for Windows metadata tar has no field for. They
+// are extended attribute records named like ntfs-3g's attributes, so
+// `tar --xattrs` onto an ntfs-3g mount restores them, and other tar readers
+// ignore them quietly.
+const (
+	PAXAttributes   = paxXattrPrefix + xattrNTFSAttrib
+	PAXCreationTime = paxXattrPrefix + xattrNTFSCrtime
+	PAXSecurity     = paxXattrPrefix + xattrNTFSACL
+	PAXStreamPrefix = paxXattrPrefix + xattrStreamPrefix
+
+	paxXattrPrefix = "SCHILY.xattr."
+)
+
+// PAXSecuritySDDL is a PAX record NewTarSource reads a security descriptor
+// from in SDDL, for tar files written by hand or by other tools.
+const PAXSecuritySDDL = "WIM.sddl"
+
+// TarOptions selects the optional parts of WriteTar's output.
+type TarOptions struct {
+	Security bool // security descriptors as PAXSecurity records
+	Streams  bool // alternate data streams as PAXStreamPrefix records
+}
+
+// WriteTar writes the image as a PAX tar archive. Hard links after the
+// first become tar hard links, and symbolic links and junctions become
+// symlinks, relative ones where RP fix made them relative to the image
+// root. Other reparse points, such as WOF, are written as files with
+// their data and attributes.
+func (img *Image) WriteTar(w io.Writer, opts TarOptions) error {
+	// List the files first, so the next ones can be decompressed while
+	// one is written.
+	type entry struct {
+		path string
+		d    *Dentry
+	}
+	var entries []entry
+	var blobs []Stream
+	img.Walk(func(path string, d *Dentry) error {
+		if path != "" {
+			entries = append(entries, entry{path, d})
+			blobs = append(blobs, d.data)
+		}
+		return nil
+	})
+	pf := newPrefetcher(img.f, blobs)
+
+	tw := tar.NewWriter(w)
+	links := make(map[uint64]string)
+	for i, e := range entries {
+		path, d := e.path, e.d
+		hdr, err := img.tarHeader(path, d, opts)
+		if err != nil {
+			return fmt.Errorf("wim: %s: %w", path, err)
+		}
+		if d.HardLinkGroup != 0 && hdr.Typeflag == tar.TypeReg {
+			if first, ok := links[d.HardLinkGroup]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = first
+				hdr.Size = 0
+			} else {
+				links[d.HardLinkGroup] = path
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Size == 0 {
+			continue
+		}
+		pf.advance(i)
+		r, err := img.OpenStream(d, "")
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (img *Image) tarHeader(path string, d *Dentry, opts TarOptions) (*tar.Header, error) {
+	hdr := &tar.Header{
+		Name:       path,
+		Typeflag:   tar.TypeReg,
+		Mode:       0o644,
+		Size:       int64(d.Size()),
+		ModTime:    d.LastWriteTime,
+		AccessTime: d.LastAccessTime,
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{
+			PAXAttributes: string(binary.BigEndian.AppendUint32(nil, d.Attributes)),
+		},
+	}
+	if !d.CreationTime.IsZero() {
+		ft := wimgapi.TimeToFileTime(d.CreationTime)
+		hdr.PAXRecords[PAXCreationTime] = string(binary.BigEndian.AppendUint64(nil, ft))
+	}
+
+	switch {
+	case isLinkTag(d):
+		// Other reparse points, such as WOF, keep their data as files.
+		rp, err := img.ReparsePoint(d)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = img.posixLinkTarget(path, d, rp)
+		hdr.Mode = 0o777
+		hdr.Size = 0
+	case d.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0o755
+		hdr.Size = 0
+	}
+	if d.Attributes&AttrReadOnly != 0 {
+		hdr.Mode &^= 0o222
+	}
+
+	if opts.Security {
+		if sd := img.SecurityDescriptor(d.SecurityID); sd != nil {
+			hdr.PAXRecords[PAXSecurity] = string(sd)
+		}
+	}
+	if opts.Streams {
+		for _, s := range d.Streams {
+			r, err := img.OpenStream(d, s.Name)
+			if err != nil {
+				return nil, err
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			hdr.PAXRecords[PAXStreamPrefix+s.Name] = string(data)
+		}
+	}
+	return hdr, nil
+}
@@ -0,0 +1,76 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+func TestWriteTar(t *testing.T) {
+	a := testFile("a.txt", "shared")
+	a.linkGroup = 7
+	b := testFile("b.txt", "shared")
+	b.linkGroup = 7
+	ro := testFile("ro.txt", "read only")
+	ro.attr |= AttrReadOnly | AttrHidden
+	ro.streams = map[string][]byte{"Zone.Identifier": []byte("ZoneId=3")}
+	img := openTestImage(t, testDir("",
+		testDir("dir", a, b, testSymlink("link", `..\ro.txt`, true)),
+		ro,
+	))
+
+	var buf bytes.Buffer
+	if err := img.WriteTar(&buf, TarOptions{Security: true, Streams: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := make(map[string]*tar.Header)
+	var order []string
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			data, _ := io.ReadAll(tr)
+			if int64(len(data)) != hdr.Size {
+				t.Fatalf("%s: read %d bytes, header says %d", hdr.Name, len(data), hdr.Size)
+			}
+		}
+		headers[hdr.Name] = hdr
+		order = append(order, hdr.Name)
+	}
+	if len(order) != 5 || order[0] != "dir/" {
+		t.Fatalf("entries = %q", order)
+	}
+
+	if h := headers["dir/b.txt"]; h.Typeflag != tar.TypeLink || h.Linkname != "dir/a.txt" {
+		t.Fatalf("b.txt = %c -> %q", h.Typeflag, h.Linkname)
+	}
+	if h := headers["dir/link"]; h.Typeflag != tar.TypeSymlink || h.Linkname != "../ro.txt" {
+		t.Fatalf("link = %c -> %q", h.Typeflag, h.Linkname)
+	}
+	h := headers["ro.txt"]
+	if h.Mode != 0o444 || h.PAXRecords[PAXAttributes] != "\x00\x00\x00\x23" {
+		t.Fatalf("ro.txt mode %o attrs %q", h.Mode, h.PAXRecords[PAXAttributes])
+	}
+	if h.PAXRecords[PAXStreamPrefix+"Zone.Identifier"] != "ZoneId=3" {
+		t.Fatalf("stream record = %q", h.PAXRecords[PAXStreamPrefix+"Zone.Identifier"])
+	}
+	if len(h.PAXRecords[PAXSecurity]) != 20 {
+		t.Fatalf("security record = %q", h.PAXRecords[PAXSecurity])
+	}
+	d := img.Root().Child("ro.txt")
+	crtime := binary.BigEndian.Uint64([]byte(h.PAXRecords[PAXCreationTime]))
+	if crtime != wimgapi.TimeToFileTime(d.CreationTime) || !h.ModTime.Equal(d.LastWriteTime) {
+		t.Fatalf("times: creation %d mtime %v", crtime, h.ModTime)
+	}
+}
@@ -0,0 +1,157 @@
+package wim
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// AddImageFromTar captures a tar stream as a new image without extracting
+// it; it is AddImage with NewTarSource.
+func (w *Writer) AddImageFromTar(r io.Reader, opts ImageOptions) error {
+	return w.AddImage(NewTarSource(r), opts)
+}
+
+type tarSource struct {
+	tr      *tar.Reader
+	hdr     *tar.Header
+	skipped []string // directories whose contents are skipped, with a trailing slash
+}
+
+// NewTarSource captures a tar stream, reading it once as the image is
+// built. Windows metadata is taken from the PAX records WriteTar produces:
+// attributes, creation time, security descriptors and alternate data
+// streams. A PAXSecuritySDDL record stands in for a missing PAXSecurity
+// one. Hard links stay hard links and symlinks become symbolic link
+// reparse points. Device nodes and FIFOs have no WIM equivalent and are
+// skipped.
+func NewTarSource(r io.Reader) CaptureSource {
+	return &tarSource{tr: tar.NewReader(r)}
+}
+
+func cleanTarPath(name string) string {
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+func (s *tarSource) Walk(fn func(e *SourceEntry) error) error {
+	for {
+		hdr, err := s.tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("wim: read tar: %w", err)
+		}
+		e, err := tarEntry(hdr)
+		if err != nil {
+			return err
+		}
+		if e == nil || s.isSkipped(e.Path) {
+			continue
+		}
+		s.hdr = hdr
+		err = fn(e)
+		s.hdr = nil
+		if err == fs.SkipDir {
+			if hdr.Typeflag == tar.TypeDir {
+				s.skipped = append(s.skipped, e.Path+"/")
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *tarSource) isSkipped(p string) bool {
+	for _, dir := range s.skipped {
+		if strings.HasPrefix(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// tarEntry describes hdr, or returns nil for entries that are skipped.
+func tarEntry(hdr *tar.Header) (*SourceEntry, error) {
+	e := &SourceEntry{
+		Path:           cleanTarPath(hdr.Name),
+		CreationTime:   hdr.ModTime,
+		LastAccessTime: hdr.AccessTime,
+		LastWriteTime:  hdr.ModTime,
+		Sys:            hdr,
+	}
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		e.LinkTo = cleanTarPath(hdr.Linkname)
+		return e, nil
+	case tar.TypeDir, tar.TypeReg, tar.TypeSymlink:
+	default:
+		return nil, nil
+	}
+	if e.LastAccessTime.IsZero() {
+		e.LastAccessTime = hdr.ModTime
+	}
+	e.Attributes = modeAttributes(hdr.FileInfo().Mode())
+
+	if v, ok := hdr.PAXRecords[PAXAttributes]; ok && len(v) == 4 {
+		e.Attributes = binary.BigEndian.Uint32([]byte(v))
+	}
+	if v, ok := hdr.PAXRecords[PAXCreationTime]; ok && len(v) == 8 {
+		e.CreationTime = wimgapi.FileTimeToTime(binary.BigEndian.Uint64([]byte(v)))
+	}
+	if v, ok := hdr.PAXRecords[PAXSecurity]; ok {
+		e.Security = []byte(v)
+	} else if v, ok := hdr.PAXRecords[PAXSecuritySDDL]; ok {
+		sd, err := ParseSDDL(v)
+		if err != nil {
+			return nil, fmt.Errorf("wim: %s: %w", hdr.Name, err)
+		}
+		e.Security = sd.Bytes()
+	}
+	for k := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(k, PAXStreamPrefix); ok && name != "" {
+			e.Streams = append(e.Streams, name)
+		}
+	}
+	slices.Sort(e.Streams)
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		e.Attributes |= AttrDirectory
+	case tar.TypeSymlink:
+		// A directory attribute from PAX marks a directory symlink.
+		e.ReparseTag = ReparseTagSymlink
+		e.ReparseData = symlinkReparseData(hdr.Linkname)
+	case tar.TypeReg:
+		e.Attributes &^= AttrDirectory | AttrReparsePoint
+	}
+	return e, nil
+}
+
+func (s *tarSource) Open(e *SourceEntry, stream string) (io.ReadCloser, error) {
+	hdr, ok := e.Sys.(*tar.Header)
+	if !ok || hdr != s.hdr {
+		return nil, fmt.Errorf("%s: not the current tar entry", e.Path)
+	}
+	if stream != "" {
+		v, ok := hdr.PAXRecords[PAXStreamPrefix+stream]
+		if !ok {
+			return nil, &fs.PathError{Op: "open", Path: e.Path + ":" + stream, Err: fs.ErrNotExist}
+		}
+		return sizedReader{strings.NewReader(v), int64(len(v))}, nil
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return sizedReader{strings.NewReader(""), 0}, nil
+	}
+	return sizedReader{s.tr, hdr.Size}, nil
+}
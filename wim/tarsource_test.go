@@ -0,0 +1,162 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestTar(t *testing.T, entries []*tar.Header, data map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		hdr.Format = tar.FormatPAX
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(data[hdr.Name]))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Size > 0 {
+			io.WriteString(tw, data[hdr.Name])
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// captureTestWIM writes images made by add to a temporary WIM and opens it.
+func captureTestWIM(t *testing.T, add func(w *Writer)) *File {
+	t.Helper()
+	return captureTestWIMWith(t, WriterOptions{}, add)
+}
+
+func captureTestWIMWith(t *testing.T, opts WriterOptions, add func(w *Writer)) *File {
+	t.Helper()
+	f, err := Open(writeTestWIM(t, opts, add))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// writeTestWIM writes images made by add to a temporary WIM and returns
+// its path.
+func writeTestWIM(t testing.TB, opts WriterOptions, add func(w *Writer)) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.wim")
+	w, err := Create(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(w)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAddImageFromTar(t *testing.T) {
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 123456700, time.UTC)
+	sd := []byte{1, 0, 4, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	entries := []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime},
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: mtime},
+		{Name: "dir/a.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime},
+		{Name: "dir/b.txt", Typeflag: tar.TypeLink, Linkname: "dir/a.txt"},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "../ro.txt", ModTime: mtime},
+		{Name: "ro.txt", Typeflag: tar.TypeReg, Mode: 0o444, ModTime: mtime, PAXRecords: map[string]string{
+			PAXAttributes:                       string(binary.BigEndian.AppendUint32(nil, AttrArchive|AttrReadOnly|AttrHidden)),
+			PAXSecurity:                         string(sd),
+			PAXStreamPrefix + "Zone.Identifier": "ZoneId=3",
+		}},
+		{Name: "dup.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		{Name: "implicit/sub/file", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: mtime},
+	}
+	data := map[string]string{
+		"dir/a.txt":         "shared contents",
+		"ro.txt":            "read only",
+		"dup.txt":           "shared contents",
+		"implicit/sub/file": "deep",
+	}
+	raw := writeTestTar(t, entries, data)
+
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{Name: "From tar", Description: "a & b"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if len(f.blobs) != 5 { // shared, read only, ZoneId, reparse data, deep
+		t.Fatalf("%d blobs stored", len(f.blobs))
+	}
+	info := f.Images()[0]
+	if info.Name != "From tar" || info.Description != "a & b" || info.FileCount != 6 || info.DirCount != 3 {
+		t.Fatalf("info = %+v", info)
+	}
+	if info.HardLinkBytes != 15 || info.TotalBytes != 15*3+9+4 {
+		t.Fatalf("bytes = %d total, %d hard link", info.TotalBytes, info.HardLinkBytes)
+	}
+
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := readAll(t)
+	a, _ := img.Lookup("dir/a.txt")
+	b, _ := img.Lookup("dir/b.txt")
+	if a == nil || b == nil || a.HardLinkGroup == 0 || a.HardLinkGroup != b.HardLinkGroup || b.Hash() != a.Hash() {
+		t.Fatalf("hard link not preserved: %+v %+v", a, b)
+	}
+	if !a.LastWriteTime.Equal(mtime) {
+		t.Fatalf("mtime = %v want %v", a.LastWriteTime, mtime)
+	}
+	ro, _ := img.Lookup("ro.txt")
+	if ro.Attributes != AttrArchive|AttrReadOnly|AttrHidden || len(img.SecurityDescriptor(ro.SecurityID)) != len(sd) {
+		t.Fatalf("ro.txt attrs %s sd %d", FormatAttributes(ro.Attributes), ro.SecurityID)
+	}
+	if got := read(img.OpenStream(ro, "Zone.Identifier")); got != "ZoneId=3" {
+		t.Fatalf("stream = %q", got)
+	}
+	link, _ := img.Lookup("dir/link")
+	rp, err := img.ReparsePoint(link)
+	if err != nil || rp.Target() != "../ro.txt" || !rp.Relative {
+		t.Fatalf("link = %+v, %v", rp, err)
+	}
+	if got := read(img.Open("implicit/sub/file")); got != "deep" {
+		t.Fatalf("implicit file = %q", got)
+	}
+	if _, err := img.Lookup("dev/null"); err == nil {
+		t.Fatal("device node captured")
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	orig := testImage(t)
+	var buf bytes.Buffer
+	if err := orig.WriteTar(&buf, TarOptions{Security: true, Streams: true}); err != nil {
+		t.Fatal(err)
+	}
+	f := captureTestWIM(t, func(w *Writer) {
+		if err := w.AddImageFromTar(&buf, ImageOptions{Name: "copy"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tar has no entry for the root, so only its times may differ.
+	res := Diff(orig, img, DiffOptions{})
+	if len(res.Changes) != 0 {
+		t.Fatalf("round trip changed %d entries: %+v", len(res.Changes), res.Changes[0])
+	}
+}
@@ -0,0 +1,315 @@
+package wim
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// MismatchKind classifies a difference found by VerifyAgainstDir.
+type MismatchKind int
+
+const (
+	MismatchMissing    MismatchKind = iota // in the image, not on disk
+	MismatchExtra                          // on disk, not in the image
+	MismatchType                           // file versus directory
+	MismatchSize                           // unnamed stream size
+	MismatchHash                           // unnamed stream SHA-1
+	MismatchAttributes                     // file attributes
+	MismatchSecurity                       // security descriptor
+	MismatchStream                         // alternate data stream missing or different
+	MismatchUnreadable                     // the disk entry could not be read
+)
+
+func (k MismatchKind) String() string {
+	switch k {
+	case MismatchMissing:
+		return "missing"
+	case MismatchExtra:
+		return "extra"
+	case MismatchType:
+		return "type"
+	case MismatchSize:
+		return "size"
+	case MismatchHash:
+		return "hash"
+	case MismatchAttributes:
+		return "attributes"
+	case MismatchSecurity:
+		return "security"
+	case MismatchStream:
+		return "stream"
+	case MismatchUnreadable:
+		return "unreadable"
+	default:
+		return "unknown"
+	}
+}
+
+// Attribute bits VerifyAgainstDir compares when the platform reports real
+// Windows attributes, and when it can only derive them from the file mode.
+// Archive and the like change too easily to be useful.
+const (
+	nativeAttrMask = AttrReadOnly | AttrHidden | AttrSystem | AttrDirectory | AttrReparsePoint
+	posixAttrMask  = AttrDirectory | AttrReparsePoint
+)
+
+var errStreamsUnsupported = errors.New("alternate data streams are not available on this platform")
+
+// posixAttributes derives what Windows attributes it can from a file mode.
+func posixAttributes(fi fs.FileInfo) uint32 {
+	var attrs uint32
+	if fi.IsDir() {
+		attrs |= AttrDirectory
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		attrs |= AttrReparsePoint
+	}
+	return attrs
+}
+
+// Mismatch is one difference between an image and a directory.
+type Mismatch struct {
+	Path   string // slash-separated, relative to the image root
+	Kind   MismatchKind
+	Detail string
+}
+
+func (m Mismatch) String() string {
+	if m.Detail == "" {
+		return fmt.Sprintf("%s: %s", m.Path, m.Kind)
+	}
+	return fmt.Sprintf("%s: %s: %s", m.Path, m.Kind, m.Detail)
+}
+
+// VerifyOptions selects the optional checks of VerifyAgainstDir. Security
+// descriptors and alternate data streams are read where the platform
+// exposes them: natively on Windows, and through ntfs-3g's extended
+// attributes on Linux. Elsewhere those checks are skipped.
+type VerifyOptions struct {
+	Security    bool
+	Streams     bool
+	IgnoreExtra bool // don't report disk entries missing from the image
+}
+
+// VerifyAgainstDir compares the image with the directory tree at dir
+// without applying it. Every entry's type, size, SHA-1 and attributes are
+// checked; which attribute bits can be compared depends on the platform.
+// Differences come back as mismatches; the error is only for failures that
+// stop the walk, such as dir itself being unreadable.
+func VerifyAgainstDir(img *Image, dir string, opts VerifyOptions) ([]Mismatch, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	v := &verifier{img: img, opts: opts}
+	v.dir(img.Root(), dir, "")
+	return v.out, nil
+}
+
+type verifier struct {
+	img  *Image
+	opts VerifyOptions
+	out  []Mismatch
+}
+
+func (v *verifier) add(path string, kind MismatchKind, format string, args ...any) {
+	v.out = append(v.out, Mismatch{Path: path, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (v *verifier) dir(d *Dentry, diskPath, path string) {
+	v.entry(d, diskPath, path)
+	if d.Attributes&AttrReparsePoint != 0 {
+		return
+	}
+
+	for _, c := range d.Children {
+		p := joinPath(path, c.Name)
+		cp := filepath.Join(diskPath, c.Name)
+		fi, err := os.Lstat(cp)
+		switch {
+		case os.IsNotExist(err):
+			v.add(p, MismatchMissing, "")
+			continue
+		case err != nil:
+			v.add(p, MismatchUnreadable, "%v", err)
+			continue
+		}
+		if c.IsDir() != fi.IsDir() && c.Attributes&AttrReparsePoint == 0 {
+			v.add(p, MismatchType, "image dir=%v, disk dir=%v", c.IsDir(), fi.IsDir())
+			continue
+		}
+		if c.IsDir() && fi.IsDir() {
+			v.dir(c, cp, p)
+		} else {
+			v.entry(c, cp, p)
+		}
+	}
+
+	if v.opts.IgnoreExtra || !d.IsDir() {
+		return
+	}
+	entries, err := os.ReadDir(diskPath)
+	if err != nil {
+		v.add(path, MismatchUnreadable, "%v", err)
+		return
+	}
+	for _, e := range entries {
+		if d.Child(e.Name()) == nil {
+			v.add(joinPath(path, e.Name()), MismatchExtra, "")
+		}
+	}
+}
+
+// entry checks one dentry's own data and metadata, not its children.
+func (v *verifier) entry(d *Dentry, diskPath, path string) {
+	fi, err := os.Lstat(diskPath)
+	if err != nil {
+		v.add(path, MismatchUnreadable, "%v", err)
+		return
+	}
+
+	attrs, mask, err := liveAttributes(diskPath, fi)
+	if mask == posixAttrMask && d.Attributes&AttrReparsePoint != 0 {
+		// A symlink's mode doesn't say whether it points at a directory.
+		mask &^= AttrDirectory
+	}
+	if err != nil {
+		v.add(path, MismatchUnreadable, "attributes: %v", err)
+	} else if want := d.Attributes & mask; attrs&mask != want {
+		v.add(path, MismatchAttributes, "image %s, disk %s", FormatAttributes(want), FormatAttributes(attrs&mask))
+	}
+
+	if !d.IsDir() && d.Attributes&AttrReparsePoint == 0 {
+		switch {
+		case uint64(fi.Size()) != d.Size():
+			v.add(path, MismatchSize, "image %d, disk %d", d.Size(), fi.Size())
+		default:
+			if h, err := hashFile(diskPath); err != nil {
+				v.add(path, MismatchUnreadable, "%v", err)
+			} else if h != d.Hash() {
+				v.add(path, MismatchHash, "image %s, disk %s", d.Hash(), h)
+			}
+		}
+	}
+
+	if v.opts.Security && path != "" {
+		got, err := liveSecurity(diskPath)
+		switch {
+		case err != nil:
+			v.add(path, MismatchUnreadable, "security: %v", err)
+		case got != nil && !securityDescriptorsEqual(v.img.SecurityDescriptor(d.SecurityID), got):
+			v.add(path, MismatchSecurity, "")
+		}
+	}
+
+	if v.opts.Streams {
+		for _, s := range d.Streams {
+			r, err := openLiveStream(diskPath, s.Name)
+			if err == errStreamsUnsupported {
+				break
+			}
+			if err != nil {
+				v.add(path, MismatchStream, "%s: %v", s.Name, err)
+				continue
+			}
+			h, err := hashReader(r)
+			r.Close()
+			if err != nil {
+				v.add(path, MismatchUnreadable, "%s: %v", s.Name, err)
+			} else if h != s.Hash {
+				v.add(path, MismatchStream, "%s: image %s, disk %s", s.Name, s.Hash, h)
+			}
+		}
+	}
+}
+
+func hashFile(path string) (Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hash{}, err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// hashReader returns the SHA-1 of r, or the zero Hash for empty input to
+// match how WIM records empty streams.
+func hashReader(r io.Reader) (Hash, error) {
+	h := sha1.New()
+	n, err := io.Copy(h, r)
+	if err != nil || n == 0 {
+		return Hash{}, err
+	}
+	var out Hash
+	h.Sum(out[:0])
+	return out, nil
+}
+
+// securityDescriptorsEqual compares two self-relative descriptors by
+// owner, group and DACL, and by SACL only when both carry one: reading a
+// SACL from disk needs a privilege the caller may not hold.
+func securityDescriptorsEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	pa, ok1 := splitSecurityDescriptor(a)
+	pb, ok2 := splitSecurityDescriptor(b)
+	if !ok1 || !ok2 {
+		return false
+	}
+	if pa.sacl == "" || pb.sacl == "" {
+		pa.sacl, pb.sacl = "", ""
+	}
+	return pa == pb
+}
+
+type sdParts struct {
+	owner, group, sacl, dacl string
+}
+
+// splitSecurityDescriptor extracts the parts of a self-relative descriptor
+// as strings so they compare with ==.
+func splitSecurityDescriptor(sd []byte) (sdParts, bool) {
+	if len(sd) < 20 || sd[0] != 1 {
+		return sdParts{}, false
+	}
+	var p sdParts
+	sid := func(off uint32) (string, bool) {
+		if off == 0 {
+			return "", true
+		}
+		if int(off)+8 > len(sd) {
+			return "", false
+		}
+		n := int(off) + 8 + 4*int(sd[off+1])
+		if n > len(sd) {
+			return "", false
+		}
+		return string(sd[off:n]), true
+	}
+	acl := func(off uint32) (string, bool) {
+		if off == 0 {
+			return "", true
+		}
+		if int(off)+8 > len(sd) {
+			return "", false
+		}
+		n := int(off) + int(binary.LittleEndian.Uint16(sd[off+2:]))
+		if n > len(sd) {
+			return "", false
+		}
+		return string(sd[off:n]), true
+	}
+	var ok [4]bool
+	p.owner, ok[0] = sid(binary.LittleEndian.Uint32(sd[4:8]))
+	p.group, ok[1] = sid(binary.LittleEndian.Uint32(sd[8:12]))
+	p.sacl, ok[2] = acl(binary.LittleEndian.Uint32(sd[12:16]))
+	p.dacl, ok[3] = acl(binary.LittleEndian.Uint32(sd[16:20]))
+	return p, ok == [4]bool{true, true, true, true}
+}
@@ -0,0 +1,73 @@
+package wim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+func liveAttributes(path string, fi fs.FileInfo) (attrs, mask uint32, err error) {
+	if b, err := getNTFSXattr(path, xattrNTFSAttrib); err == nil && len(b) == 4 {
+		return binary.BigEndian.Uint32(b), nativeAttrMask, nil
+	}
+	return posixAttributes(fi), posixAttrMask, nil
+}
+
+// liveSecurity returns nil without error when the file system has no
+// security descriptors to offer.
+func liveSecurity(path string) ([]byte, error) {
+	b, err := getNTFSXattr(path, xattrNTFSACL)
+	if xattrUnavailable(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func openLiveStream(path, name string) (io.ReadCloser, error) {
+	b, err := lgetxattr(path, xattrStreamPrefix+name)
+	switch {
+	case errors.Is(err, unix.ENODATA):
+		return nil, fs.ErrNotExist
+	case xattrUnavailable(err):
+		return nil, errStreamsUnsupported
+	case err != nil:
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// getNTFSXattr reads the system.* attribute name, or the user.* fallback
+// Image.Apply writes where the file system refuses it.
+func getNTFSXattr(path, name string) ([]byte, error) {
+	b, err := lgetxattr(path, name)
+	if xattrUnavailable(err) {
+		return lgetxattr(path, userXattr(name))
+	}
+	return b, err
+}
+
+func xattrUnavailable(err error) bool {
+	return errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP)
+}
+
+func lgetxattr(path, name string) ([]byte, error) {
+	for {
+		n, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		n, err = unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue // grew between the two calls
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
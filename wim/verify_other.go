@@ -0,0 +1,20 @@
+//go:build !windows && !linux
+
+package wim
+
+import (
+	"io"
+	"io/fs"
+)
+
+func liveAttributes(path string, fi fs.FileInfo) (attrs, mask uint32, err error) {
+	return posixAttributes(fi), posixAttrMask, nil
+}
+
+func liveSecurity(path string) ([]byte, error) {
+	return nil, nil
+}
+
+func openLiveStream(path, name string) (io.ReadCloser, error) {
+	return nil, errStreamsUnsupported
+}
@@ -0,0 +1,100 @@
+package wim
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestVerifyAgainstDir(t *testing.T) {
+	img := openTestImage(t, testDir("",
+		testDir("etc", testFile("hosts", "127.0.0.1 localhost\n"), testFile("motd", "hello")),
+		testFile("empty", ""),
+		testFile("gone", "bye"),
+		testFile("same-size", "aaaa"),
+		testDir("was-dir"),
+	))
+
+	dir := t.TempDir()
+	write := func(name, data string) {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("etc/hosts", "127.0.0.1 localhost\n")
+	write("etc/motd", "hello, world")
+	write("empty", "")
+	write("same-size", "bbbb")
+	write("was-dir", "now a file")
+	write("extra.txt", "x")
+
+	got, err := VerifyAgainstDir(img, dir, VerifyOptions{Security: true, Streams: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, m := range got {
+		kinds = append(kinds, m.Path+" "+m.Kind.String())
+	}
+	want := []string{
+		"etc/motd size",
+		"gone missing",
+		"same-size hash",
+		"was-dir type",
+		"extra.txt extra",
+	}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("mismatches = %q\nwant %q", kinds, want)
+	}
+
+	got, err = VerifyAgainstDir(img, dir, VerifyOptions{IgnoreExtra: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("with IgnoreExtra: %v", got)
+	}
+
+	if _, err := VerifyAgainstDir(img, filepath.Join(dir, "nope"), VerifyOptions{}); err == nil {
+		t.Fatal("missing dir accepted")
+	}
+}
+
+func TestSecurityDescriptorsEqual(t *testing.T) {
+	owner := []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0} // S-1-5-18
+	dacl := []byte{2, 0, 8, 0, 0, 0, 0, 0}               // empty ACL
+	sacl := []byte{2, 0, 8, 0, 0, 0, 0, 0}
+	build := func(parts ...[]byte) []byte {
+		sd := []byte{1, 0, 0x04, 0x80}
+		sd = append(sd, make([]byte, 16)...)
+		for i, p := range parts {
+			if p == nil {
+				continue
+			}
+			off := len(sd)
+			sd = append(sd, p...)
+			sd[4+4*i] = byte(off)
+		}
+		return sd
+	}
+	a := build(owner, owner, nil, dacl)
+	// Same parts in another order, plus a SACL only one side has.
+	b := []byte{1, 0, 0x14, 0x80, 40, 0, 0, 0, 28, 0, 0, 0, 20, 0, 0, 0, 52, 0, 0, 0}
+	b = append(b, sacl...)
+	b = append(b, owner...)
+	b = append(b, owner...)
+	b = append(b, dacl...)
+	if !securityDescriptorsEqual(a, b) {
+		t.Fatal("equivalent descriptors compared unequal")
+	}
+	other := build([]byte{1, 1, 0, 0, 0, 0, 0, 5, 32, 0, 0, 0}, owner, nil, dacl)
+	if securityDescriptorsEqual(a, other) {
+		t.Fatal("different owners compared equal")
+	}
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package wim
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func liveAttributes(path string, fi fs.FileInfo) (attrs, mask uint32, err error) {
+	if d, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		return d.FileAttributes, nativeAttrMask, nil
+	}
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	attrs, err = windows.GetFileAttributes(p)
+	return attrs, nativeAttrMask, err
+}
+
+// liveSecurity reads the owner, group and DACL of path, as a self-relative
+// descriptor.
+func liveSecurity(path string) ([]byte, error) {
+	info := windows.SECURITY_INFORMATION(windows.OWNER_SECURITY_INFORMATION |
+		windows.GROUP_SECURITY_INFORMATION | windows.DACL_SECURITY_INFORMATION)
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, info)
+	if err != nil {
+		return nil, err
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(sd)), sd.Length()), nil
+}
+
+func openLiveStream(path, name string) (io.ReadCloser, error) {
+	return os.Open(path + ":" + name)
+}
@@ -0,0 +1,556 @@
+package wim
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+var (
+	errWriterClosed = errors.New("wim: writer is closed")
+	errSizeChanged  = errors.New("wim: stream changed size while it was read")
+)
+
+// Writer creates a WIM file in pure Go. Blobs are written as images are
+// added and stored once however often they are referenced; Close writes the
+// blob table, XML data and header.
+type Writer struct {
+	w        io.WriteSeeker
+	closer   io.Closer
+	pos      int64 // end of the data written so far
+	end      int64 // furthest byte ever written, for truncation
+	hdr      header
+	blobs    map[Hash]*blobEntry
+	order    []*blobEntry
+	metadata []*blobEntry
+	images   []wimgapi.ImageInfo
+	closed   bool
+
+	// chunks is nil for an uncompressed WIM.
+	chunks *chunkPipeline
+	solid  *solidWriter // nil unless WriterOptions.Solid
+
+	onProgress   func(WriterProgress) error
+	started      time.Time
+	lastProgress time.Time
+	bytes        uint64
+	files        uint64
+}
+
+// WriterOptions selects how a new WIM stores its resources.
+type WriterOptions struct {
+	Compression wimgapi.Compression
+	// ChunkSize is the unit of compression; zero picks the format's
+	// default. Non-default sizes, such as 4 KiB XPRESS for WIMBoot, are
+	// readable by wimlib and recent WIMGAPI versions.
+	ChunkSize uint32
+	// Level trades speed for size, from 1 (fastest) to 9 (smallest);
+	// zero picks 6.
+	Level int
+	// Concurrency is how many chunks are compressed at once; zero uses
+	// GOMAXPROCS.
+	Concurrency int
+	// MemoryLimit caps the chunk buffers, which take twice the chunk size
+	// for each chunk in flight, lowering the concurrency if need be; zero
+	// allows two chunks per worker. Compressors need memory of their own.
+	MemoryLimit int64
+	// Progress, if set, is called about every ProgressInterval while
+	// entries are captured and resources written, and once more by Close,
+	// on the goroutine that is adding the image. An error from it stops
+	// AddImage or AddImageFromTar, which return it; Close returns it after
+	// finishing the file.
+	Progress func(WriterProgress) error
+	// Solid packs the file data of each image into solid resources, as
+	// ESD files do: the blobs are compressed as one stream, which suits
+	// many small files far better. Metadata stays in ordinary resources,
+	// and the compression exclusion list of ImageOptions.Config does not
+	// apply. Solid WIMs need a compressed format and are readable by
+	// Windows 8 and later.
+	Solid bool
+	// SolidChunkSize is the chunk size of solid resources; zero picks
+	// 64 MiB for LZMS and ChunkSize otherwise.
+	SolidChunkSize uint32
+}
+
+// ImageOptions describes an image being added.
+type ImageOptions struct {
+	Name        string
+	Description string
+	Flags       string // edition flags such as "Professional"
+	// Config leaves out the entries its exclusion lists match and stores
+	// the data of those on its compression exclusion list uncompressed.
+	Config *wimgapi.CaptureConfig
+	// RPFix stores absolute symbolic links and junctions that point into
+	// the captured tree relative to its root, and sets FlagRPFix.
+	RPFix bool
+}
+
+// Create creates or truncates the WIM file at path.
+func Create(path string, opts WriterOptions) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, opts)
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// NewWriter starts a WIM at the current position of ws, which must be the
+// beginning of the output.
+func NewWriter(ws io.WriteSeeker, opts WriterOptions) (*Writer, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = opts.Compression.DefaultChunkSize()
+	}
+	if err := opts.Compression.ValidateChunkSize(chunkSize); err != nil {
+		return nil, err
+	}
+	level := opts.Level
+	if level == 0 {
+		level = defaultLevel
+	}
+	if level < minLevel || level > maxLevel {
+		return nil, fmt.Errorf("wim: compression level %d is not between %d and %d", opts.Level, minLevel, maxLevel)
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	if opts.Solid && opts.Compression == wimgapi.CompressNone {
+		return nil, errors.New("wim: solid resources must be compressed")
+	}
+	solidChunkSize := opts.SolidChunkSize
+	if solidChunkSize == 0 {
+		solidChunkSize = chunkSize
+		if opts.Compression == wimgapi.CompressLZMS {
+			solidChunkSize = defaultSolidChunk
+		}
+	}
+	if opts.Solid {
+		if err := opts.Compression.ValidateChunkSize(solidChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	w := &Writer{
+		w:          ws,
+		blobs:      make(map[Hash]*blobEntry),
+		onProgress: opts.Progress,
+		started:    time.Now(),
+		hdr: header{
+			Version:    wimVersion,
+			Flags:      compressionFlags(opts.Compression),
+			ChunkSize:  chunkSize,
+			PartNumber: 1,
+			TotalParts: 1,
+		},
+	}
+	if opts.Compression != wimgapi.CompressNone {
+		newCompressor, ok := compressors[opts.Compression]
+		if !ok {
+			return nil, fmt.Errorf("%w: writing %s is not supported", ErrUnsupportedFormat, opts.Compression)
+		}
+		w.chunks = newChunkPipeline(func() chunkCompressor {
+			return newCompressor(int(chunkSize), level)
+		}, concurrency, inFlight(opts, concurrency, chunkSize))
+		if opts.Solid {
+			w.solid = newSolidWriter(newChunkPipeline(func() chunkCompressor {
+				return newCompressor(int(solidChunkSize), level)
+			}, concurrency, inFlight(opts, concurrency, solidChunkSize)), opts.Compression, solidChunkSize)
+			w.hdr.Version = solidVersion
+		}
+	}
+	if _, err := rand.Read(w.hdr.GUID[:]); err != nil {
+		return nil, err
+	}
+	// Reserve the header; Close fills it in.
+	if err := w.writeAt(0, make([]byte, headerSize)); err != nil {
+		return nil, err
+	}
+	w.pos = headerSize
+	return w, nil
+}
+
+// inFlight returns how many chunks of chunkSize may be in flight at once.
+func inFlight(opts WriterOptions, concurrency int, chunkSize uint32) int {
+	if opts.MemoryLimit > 0 {
+		return int(min(opts.MemoryLimit/(2*int64(chunkSize)), int64(2*concurrency)))
+	}
+	return 2 * concurrency
+}
+
+func (w *Writer) writeAt(off int64, p []byte) error {
+	if _, err := w.w.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(p); err != nil {
+		return err
+	}
+	w.end = max(w.end, off+int64(len(p)))
+	return nil
+}
+
+// writeResource stores the contents of r at the end of the output and
+// returns its resource header and SHA-1. In a compressed WIM the resource
+// is compressed unless compress is false.
+func (w *Writer) writeResource(r io.Reader, flags uint8, compress bool) (resourceHeader, Hash, error) {
+	if !compress || w.chunks == nil {
+		return w.writeStored(r, flags)
+	}
+	size, ok := readerSize(r)
+	if !ok {
+		// The chunk table comes first, so the size must be known.
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return resourceHeader{}, Hash{}, err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+	if size == 0 {
+		return w.writeStored(r, flags)
+	}
+	return w.writeChunked(r, uint64(size), flags)
+}
+
+// readerSize returns the number of bytes left in r when it can tell
+// without reading.
+func readerSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case interface{ Size() int64 }:
+		return r.Size(), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			return 0, false
+		}
+		return fi.Size(), true
+	}
+	return 0, false
+}
+
+func (w *Writer) writeStored(r io.Reader, flags uint8) (resourceHeader, Hash, error) {
+	if _, err := w.w.Seek(w.pos, io.SeekStart); err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+	h := sha1.New()
+	n, err := io.Copy(io.MultiWriter(w.w, h), r)
+	if err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+	w.end = max(w.end, w.pos+n)
+	if err := w.stored(int(n)); err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+	var sum Hash
+	h.Sum(sum[:0])
+	res := resourceHeader{Size: uint64(n), Flags: flags, Offset: uint64(w.pos), UncompressedSize: uint64(n)}
+	return res, sum, nil
+}
+
+// writeChunked compresses size bytes from r chunk by chunk on the
+// writer's pipeline, reading and hashing the next chunks while earlier ones
+// are compressed. The chunk table, with 8-byte entries past 4 GiB, precedes
+// the chunks.
+func (w *Writer) writeChunked(r io.Reader, size uint64, flags uint8) (resourceHeader, Hash, error) {
+	chunkSize := uint64(w.hdr.ChunkSize)
+	numChunks := (size + chunkSize - 1) / chunkSize
+	entrySize := uint64(4)
+	if size > 0xFFFFFFFF {
+		entrySize = 8
+	}
+	table := make([]byte, (numChunks-1)*entrySize)
+	dataStart := w.pos + int64(len(table))
+	if _, err := w.w.Seek(dataStart, io.SeekStart); err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+
+	var i, off uint64
+	emit := func(job *chunkJob) error {
+		if i > 0 {
+			if entrySize == 8 {
+				binary.LittleEndian.PutUint64(table[(i-1)*8:], off)
+			} else {
+				binary.LittleEndian.PutUint32(table[(i-1)*4:], uint32(off))
+			}
+		}
+		if _, err := w.w.Write(job.data); err != nil {
+			return err
+		}
+		i++
+		off += uint64(len(job.data))
+		return w.stored(len(job.in))
+	}
+	h := sha1.New()
+	p := w.chunks
+	for start := uint64(0); start < size; start += chunkSize {
+		n := min(chunkSize, size-start)
+		job, err := p.get(int(n), emit)
+		if err == nil {
+			job.in = job.in[:n]
+			if _, err = io.ReadFull(r, job.in); err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = errSizeChanged
+			}
+		}
+		if err != nil {
+			p.put(job)
+			p.drain(nil)
+			return resourceHeader{}, Hash{}, err
+		}
+		h.Write(job.in)
+		p.submit(job)
+	}
+	if err := p.drain(emit); err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+	var extra [1]byte
+	if n, _ := io.ReadFull(r, extra[:]); n > 0 {
+		return resourceHeader{}, Hash{}, errSizeChanged
+	}
+	w.end = max(w.end, dataStart+int64(off))
+	if err := w.writeAt(w.pos, table); err != nil {
+		return resourceHeader{}, Hash{}, err
+	}
+
+	var sum Hash
+	h.Sum(sum[:0])
+	res := resourceHeader{
+		Size:             uint64(len(table)) + off,
+		Flags:            flags | resFlagCompressed,
+		Offset:           uint64(w.pos),
+		UncompressedSize: size,
+	}
+	return res, sum, nil
+}
+
+// addBlob stores a stream's contents unless an identical blob is already
+// in the WIM. Empty streams are not stored and have the zero hash.
+func (w *Writer) addBlob(r io.Reader, compress bool) (Stream, error) {
+	if h, ok := r.(interface{ Hash() Hash }); ok {
+		// Copying from another WIM: skip blobs already stored.
+		if s, ok := w.reuseBlob(h.Hash()); ok {
+			return s, nil
+		}
+	}
+	if w.solid != nil {
+		return w.addSolidBlob(r)
+	}
+	res, sum, err := w.writeResource(r, 0, compress)
+	if err != nil {
+		return Stream{}, err
+	}
+	if res.UncompressedSize == 0 {
+		return Stream{}, nil
+	}
+	s := Stream{Hash: sum, Size: res.UncompressedSize}
+	if e, ok := w.blobs[sum]; ok {
+		// Already stored: the next write overwrites this copy.
+		e.refCount++
+		return s, nil
+	}
+	e := &blobEntry{res: res, partNumber: 1, refCount: 1, hash: sum}
+	w.blobs[sum] = e
+	w.order = append(w.order, e)
+	w.pos += int64(res.Size)
+	return s, nil
+}
+
+// addMetadata stores an image's metadata resource and XML entry.
+func (w *Writer) addMetadata(b *imageBuilder, opts ImageOptions) error {
+	md := encodeMetadata(b.root, b.security)
+	res, sum, err := w.writeResource(bytes.NewReader(md), resFlagMetadata, true)
+	if err != nil {
+		return err
+	}
+	w.pos += int64(res.Size)
+	w.metadata = append(w.metadata, &blobEntry{res: res, partNumber: 1, refCount: 1, hash: sum})
+
+	now := time.Now().UTC()
+	info := b.stats()
+	info.Index = len(w.metadata)
+	info.Name = opts.Name
+	info.Description = opts.Description
+	info.Flags = opts.Flags
+	info.CreationTime = now
+	info.ModificationTime = now
+	w.images = append(w.images, info)
+	return nil
+}
+
+// Close writes the blob table, XML data and header. It closes the
+// underlying file only when the Writer was made by Create.
+func (w *Writer) Close() error {
+	if w.closed {
+		return errWriterClosed
+	}
+	w.closed = true
+	err := w.finish()
+	if w.onProgress != nil {
+		if perr := w.onProgress(w.progress()); err == nil {
+			err = perr
+		}
+	}
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (w *Writer) finish() error {
+	if err := w.endSolid(); err != nil {
+		return err
+	}
+	table := make([]byte, 0, (len(w.order)+len(w.metadata))*blobEntrySize)
+	for _, e := range w.order {
+		var b [blobEntrySize]byte
+		e.put(b[:])
+		table = append(table, b[:]...)
+	}
+	if w.solid != nil {
+		table = w.solid.appendSolidEntries(table)
+	}
+	for _, e := range w.metadata {
+		var b [blobEntrySize]byte
+		e.put(b[:])
+		table = append(table, b[:]...)
+	}
+	if err := w.writeAt(w.pos, table); err != nil {
+		return err
+	}
+	w.hdr.LookupTable = resourceHeader{Size: uint64(len(table)), Offset: uint64(w.pos), UncompressedSize: uint64(len(table))}
+	w.pos += int64(len(table))
+
+	xmlData := encodeXMLData(w.images, uint64(w.pos))
+	if err := w.writeAt(w.pos, xmlData); err != nil {
+		return err
+	}
+	w.hdr.XMLData = resourceHeader{Size: uint64(len(xmlData)), Offset: uint64(w.pos), UncompressedSize: uint64(len(xmlData))}
+	w.pos += int64(len(xmlData))
+
+	w.hdr.ImageCount = uint32(len(w.images))
+	buf := make([]byte, headerSize)
+	w.hdr.put(buf)
+	if err := w.writeAt(0, buf); err != nil {
+		return err
+	}
+
+	// A duplicate blob written last may have left bytes past the end.
+	if w.end > w.pos {
+		if t, ok := w.w.(interface{ Truncate(int64) error }); ok {
+			return t.Truncate(w.pos)
+		}
+	}
+	return nil
+}
+
+// ProgressInterval is how often WriterOptions.Progress is called.
+const ProgressInterval = 250 * time.Millisecond
+
+// WriterProgress describes the work a Writer has done so far.
+type WriterProgress struct {
+	Elapsed time.Duration
+	Bytes   uint64 // uncompressed bytes of the resources written
+	Files   uint64 // entries captured into images, directories included
+	// Workers has an entry for each compression worker; it is empty for
+	// an uncompressed WIM.
+	Workers []WorkerProgress
+}
+
+// BytesPerSec is the overall throughput.
+func (p WriterProgress) BytesPerSec() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// stored counts n more bytes written and reports progress when it is due.
+func (w *Writer) stored(n int) error {
+	w.bytes += uint64(n)
+	return w.tick()
+}
+
+// captured counts an entry added to an image and reports progress when it
+// is due.
+func (w *Writer) captured() error {
+	w.files++
+	return w.tick()
+}
+
+func (w *Writer) tick() error {
+	if w.onProgress == nil {
+		return nil
+	}
+	if now := time.Now(); now.Sub(w.lastProgress) >= ProgressInterval {
+		w.lastProgress = now
+		return w.onProgress(w.progress())
+	}
+	return nil
+}
+
+func (w *Writer) progress() WriterProgress {
+	p := WriterProgress{Elapsed: time.Since(w.started), Bytes: w.bytes, Files: w.files}
+	if w.chunks != nil {
+		p.Workers = w.chunks.addWorkers(p.Workers)
+	}
+	if w.solid != nil {
+		p.Workers = w.solid.chunks.addWorkers(p.Workers)
+	}
+	return p
+}
+
+// encodeXMLData renders the WIM's XML data as UTF-16LE with a BOM.
+// totalBytes is the offset of the XML data, which is what WIMGAPI records.
+func encodeXMLData(images []wimgapi.ImageInfo, totalBytes uint64) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<WIM><TOTALBYTES>%d</TOTALBYTES>", totalBytes)
+	for _, img := range images {
+		fmt.Fprintf(&sb, `<IMAGE INDEX="%d">`, img.Index)
+		fmt.Fprintf(&sb, "<DIRCOUNT>%d</DIRCOUNT><FILECOUNT>%d</FILECOUNT>", img.DirCount, img.FileCount)
+		fmt.Fprintf(&sb, "<TOTALBYTES>%d</TOTALBYTES><HARDLINKBYTES>%d</HARDLINKBYTES>", img.TotalBytes, img.HardLinkBytes)
+		writeXMLTime(&sb, "CREATIONTIME", img.CreationTime)
+		writeXMLTime(&sb, "LASTMODIFICATIONTIME", img.ModificationTime)
+		writeXMLText(&sb, "NAME", img.Name)
+		writeXMLText(&sb, "DESCRIPTION", img.Description)
+		writeXMLText(&sb, "FLAGS", img.Flags)
+		sb.WriteString("</IMAGE>")
+	}
+	sb.WriteString("</WIM>")
+
+	out := []byte{0xFF, 0xFE}
+	return appendUTF16(out, sb.String())
+}
+
+func writeXMLTime(sb *strings.Builder, tag string, t time.Time) {
+	ft := wimgapi.TimeToFileTime(t)
+	fmt.Fprintf(sb, "<%s><HIGHPART>0x%08X</HIGHPART><LOWPART>0x%08X</LOWPART></%s>", tag, ft>>32, ft&0xFFFFFFFF, tag)
+}
+
+func writeXMLText(sb *strings.Builder, tag, text string) {
+	if text == "" {
+		return
+	}
+	fmt.Fprintf(sb, "<%s>", tag)
+	xml.EscapeText(sb, []byte(text))
+	fmt.Fprintf(sb, "</%s>", tag)
+}
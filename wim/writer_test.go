@@ -0,0 +1,216 @@
+package wim
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// withoutCompressor unregisters the encoder for c for the rest of the test.
+func withoutCompressor(t *testing.T, c wimgapi.Compression) {
+	old, had := compressors[c]
+	delete(compressors, c)
+	t.Cleanup(func() {
+		if had {
+			compressors[c] = old
+		}
+	})
+}
+
+func TestWriterChunkSize(t *testing.T) {
+	noise := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(noise)
+	runs := bytes.Repeat([]byte("a"), 9000)
+	runs = append(runs, bytes.Repeat([]byte("b"), 3000)...)
+	fsys := fstest.MapFS{
+		"runs.bin":  {Data: runs},
+		"noise.bin": {Data: noise},
+		"mixed.bin": {Data: append(append([]byte{}, noise[:3000]...), runs...)},
+		"tiny.txt":  {Data: []byte("x")},
+		"a.zip":     {Data: runs[:8192]},
+	}
+	cfg := &wimgapi.CaptureConfig{CompressionExclusionList: []string{"*.zip"}}
+	f := captureTestWIMWith(t, WriterOptions{Compression: wimgapi.CompressXPRESS, ChunkSize: 4096}, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{Config: cfg}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	info := f.Info()
+	if info.Compression != wimgapi.CompressXPRESS || info.ChunkSize != 4096 {
+		t.Fatalf("info = %+v", info)
+	}
+	if !f.metadata[0].res.compressed() {
+		t.Fatal("metadata resource stored uncompressed")
+	}
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := readAll(t)
+	for name, file := range fsys {
+		if got := read(img.Open(name)); got != string(file.Data) {
+			t.Errorf("%s: read %d bytes, want %d", name, len(got), len(file.Data))
+		}
+	}
+	blob := func(name string) resourceHeader {
+		d, err := img.Lookup(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.blobs[d.Hash()].res
+	}
+	if res := blob("runs.bin"); !res.compressed() || res.Size >= res.UncompressedSize/4 {
+		t.Errorf("runs.bin stored in %d bytes", res.Size)
+	}
+	if res := blob("noise.bin"); res.Size != res.UncompressedSize+4 { // raw chunks plus a one-entry table
+		t.Errorf("noise.bin stored in %d bytes", res.Size)
+	}
+	if res := blob("a.zip"); res.compressed() {
+		t.Error("a.zip compressed despite the compression exclusion list")
+	}
+}
+
+func TestWriterOptionsValidation(t *testing.T) {
+	dir := t.TempDir()
+	for _, opts := range []WriterOptions{
+		{Compression: wimgapi.CompressLZX, ChunkSize: 4096},
+		{Compression: wimgapi.CompressXPRESS, ChunkSize: 5000},
+		{Compression: wimgapi.CompressXPRESS, ChunkSize: 1 << 17},
+		{Compression: wimgapi.Compression(7)},
+		{Compression: wimgapi.CompressXPRESS, Level: 10},
+		{Solid: true},
+		{Compression: wimgapi.CompressLZX, Solid: true, SolidChunkSize: 1 << 22},
+	} {
+		if _, err := Create(filepath.Join(dir, "bad.wim"), opts); err == nil {
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+
+	withoutCompressor(t, wimgapi.CompressXPRESS)
+	if _, err := Create(filepath.Join(dir, "x.wim"), WriterOptions{Compression: wimgapi.CompressXPRESS}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("no encoder: err = %v", err)
+	}
+}
+
+func TestWriterConcurrency(t *testing.T) {
+	data := make([]byte, 100000)
+	rng := rand.New(rand.NewSource(2))
+	for i := range data {
+		data[i] = "abcd"[rng.Intn(4)]
+	}
+	fsys := fstest.MapFS{"a.bin": {Data: data}, "b.bin": {Data: data[:5000]}}
+	sizes := make(map[Hash]uint64)
+	for _, n := range []int{1, 3, 8} {
+		f := captureTestWIMWith(t, WriterOptions{Compression: wimgapi.CompressXPRESS, ChunkSize: 4096, Concurrency: n}, func(w *Writer) {
+			if err := w.AddImage(NewFSSource(fsys), ImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		})
+		img, err := f.Image(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t)(img.Open("a.bin")); got != string(data) {
+			t.Fatalf("concurrency %d: a.bin differs", n)
+		}
+		for h, b := range f.blobs {
+			if want, ok := sizes[h]; ok && b.res.Size != want {
+				t.Errorf("concurrency %d: blob stored in %d bytes, want %d", n, b.res.Size, want)
+			}
+			sizes[h] = b.res.Size
+		}
+	}
+}
+
+func TestWriterSolid(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	big := make([]byte, 300000) // spans several solid chunks and batches
+	for i := range big {
+		big[i] = "wim "[rng.Intn(4)]
+	}
+	noise := make([]byte, 5000)
+	rng.Read(noise)
+	fsys := fstest.MapFS{
+		"big.bin":     {Data: big},
+		"noise.bin":   {Data: noise},
+		"copy.bin":    {Data: noise},
+		"a/small.txt": {Data: []byte("small")},
+		"a/empty":     {},
+	}
+	// Tar streams cannot be hashed ahead: tardup.txt is dropped again
+	// after it is buffered.
+	raw := writeTestTar(t, []*tar.Header{
+		{Name: "tar.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "tardup.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "small.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{"tar.txt": "from tar", "tardup.txt": "from tar", "small.txt": "small"})
+
+	opts := WriterOptions{Compression: wimgapi.CompressLZMS, Solid: true, SolidChunkSize: 1 << 16, Concurrency: 2}
+	f := captureTestWIMWith(t, opts, func(w *Writer) {
+		if err := w.AddImage(NewFSSource(fsys), ImageOptions{Name: "fs"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.AddImageFromTar(bytes.NewReader(raw), ImageOptions{Name: "tar"}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if f.hdr.Version != solidVersion {
+		t.Errorf("version %#x", f.hdr.Version)
+	}
+	if len(f.blobs) != 4 { // big, noise, small, from tar
+		t.Fatalf("%d blobs stored", len(f.blobs))
+	}
+	for h, b := range f.blobs {
+		if b.solid == nil {
+			t.Errorf("blob %x is not in a solid resource", h)
+		}
+	}
+	if b := f.blobs[sha1.Sum([]byte("from tar"))]; b.refCount != 2 {
+		t.Errorf("tar blob has %d references", b.refCount)
+	}
+
+	read := readAll(t)
+	img, err := f.Image(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, file := range fsys {
+		if got := read(img.Open(name)); got != string(file.Data) {
+			t.Errorf("%s: read %d bytes, want %d", name, len(got), len(file.Data))
+		}
+	}
+	img, err = f.Image(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(img.Open("tardup.txt")); got != "from tar" {
+		t.Errorf("tardup.txt = %q", got)
+	}
+
+	// Exporting into another solid WIM reuses blobs by hash.
+	g := captureTestWIMWith(t, opts, func(w *Writer) {
+		for i := 0; i < 2; i++ {
+			if err := w.AddImage(NewImageSource(img), ImageOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if len(g.blobs) != 2 {
+		t.Fatalf("export: %d blobs stored", len(g.blobs))
+	}
+	img, err = g.Image(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(img.Open("small.txt")); got != "small" {
+		t.Errorf("export: small.txt = %q", got)
+	}
+}
@@ -0,0 +1,31 @@
+package wim
+
+import "strings"
+
+// Extended attributes ntfs-3g uses to expose NTFS metadata on Linux.
+// Alternate data streams appear as user.<name> when mounted with
+// streams_interface=xattr. The tar writer reuses the same names inside
+// SCHILY.xattr PAX records.
+const (
+	xattrNTFSAttrib   = "system.ntfs_attrib_be" // 4-byte big-endian attributes
+	xattrNTFSCrtime   = "system.ntfs_crtime_be" // 8-byte big-endian FILETIME
+	xattrNTFSACL      = "system.ntfs_acl"       // self-relative security descriptor
+	xattrStreamPrefix = "user."
+)
+
+// userXattr returns the user.* name the system.* attribute name falls back
+// to on file systems that reserve the system namespace, as ext4, XFS and
+// btrfs do; user.ntfs_acl for system.ntfs_acl.
+func userXattr(name string) string {
+	return xattrStreamPrefix + strings.TrimPrefix(name, "system.")
+}
+
+// isUserXattrFallback reports whether the user.* attribute attr holds
+// NTFS metadata rather than a stream.
+func isUserXattrFallback(attr string) bool {
+	switch attr {
+	case userXattr(xattrNTFSAttrib), userXattr(xattrNTFSCrtime), userXattr(xattrNTFSACL):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package wim
+
+import "encoding/binary"
+
+const (
+	xpressNumSymbols  = 512
+	xpressMaxCodeLen  = 15
+	xpressTableBytes  = xpressNumSymbols / 2
+	xpressMinMatchLen = 3
+)
+
+// xpressDecompress decodes one XPRESS (LZ77+Huffman, MS-XCA 2.2) chunk into
+// dst, which must have exactly the chunk's uncompressed size.
+func xpressDecompress(dst, src []byte) error {
+	if len(src) < xpressTableBytes+4 {
+		return errCorruptChunk
+	}
+
+	var lens [xpressNumSymbols]uint8
+	for i := 0; i < xpressTableBytes; i++ {
+		lens[2*i] = src[i] & 0x0F
+		lens[2*i+1] = src[i] >> 4
+	}
+	var h huffmanDecoder
+	if err := h.init(lens[:], xpressMaxCodeLen); err != nil {
+		return err
+	}
+
+	pos := xpressTableBytes
+	read16 := func() uint32 {
+		if pos+2 > len(src) {
+			pos += 2
+			return 0
+		}
+		v := uint32(binary.LittleEndian.Uint16(src[pos:]))
+		pos += 2
+		return v
+	}
+
+	next := read16()<<16 | read16()
+	extra := 16
+	consume := func(n int) {
+		next <<= uint(n)
+		extra -= n
+		if extra < 0 {
+			next |= read16() << uint(-extra)
+			extra += 16
+		}
+	}
+
+	out := 0
+	for out < len(dst) {
+		if pos > len(src)+4 {
+			return errCorruptChunk
+		}
+		sym, n, ok := h.decode(next >> (32 - xpressMaxCodeLen))
+		if !ok {
+			return errCorruptChunk
+		}
+		consume(int(n))
+
+		if sym < 256 {
+			dst[out] = byte(sym)
+			out++
+			continue
+		}
+
+		sym -= 256
+		length := int(sym & 0x0F)
+		offsetBits := uint(sym >> 4)
+		if length == 0x0F {
+			if pos >= len(src) {
+				return errCorruptChunk
+			}
+			length = int(src[pos])
+			pos++
+			if length == 0xFF {
+				if pos+2 > len(src) {
+					return errCorruptChunk
+				}
+				length = int(binary.LittleEndian.Uint16(src[pos:]))
+				pos += 2
+				if length < 0x0F {
+					return errCorruptChunk
+				}
+				length -= 0x0F
+			}
+			length += 0x0F
+		}
+		length += xpressMinMatchLen
+
+		offset := 1 << offsetBits
+		if offsetBits > 0 {
+			offset |= int(next >> (32 - offsetBits))
+			consume(int(offsetBits))
+		}
+		if offset > out || length > len(dst)-out {
+			return errCorruptChunk
+		}
+		copyMatch(dst, out, offset, length)
+		out += length
+	}
+	return nil
+}
+
+// copyMatch copies length bytes from offset bytes back, allowing overlap.
+func copyMatch(dst []byte, out, offset, length int) {
+	if offset >= length {
+		copy(dst[out:out+length], dst[out-offset:])
+		return
+	}
+	for i := 0; i < length; i++ {
+		dst[out+i] = dst[out-offset+i]
+	}
+}
@@ -0,0 +1,131 @@
+package wim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ghp3000/go-wimgapi/wimgapi"
+)
+
+// xpressFixedEncode encodes ops with every symbol given a 9-bit code, so
+// code(sym) == sym.
+func xpressFixedEncode(ops []xpressOp) []byte {
+	var w xpressBitWriter
+	w.reset(bytes.Repeat([]byte{0x99}, xpressTableBytes))
+	for _, op := range ops {
+		w.put(uint32(op.symbol()), 9)
+		if op.offset != 0 {
+			w.putMatch(op)
+		}
+	}
+	return w.finish()
+}
+
+func TestXpressDecompress(t *testing.T) {
+	var ops []xpressOp
+	var want []byte
+	for _, c := range []byte("abcdefgh") {
+		ops = append(ops, xpressOp{lit: c})
+		want = append(want, c)
+	}
+	for _, m := range []xpressOp{{offset: 8, length: 8}, {offset: 3, length: 20}, {offset: 1, length: 400}, {offset: 100, length: 5}} {
+		ops = append(ops, m)
+		for i := 0; i < m.length; i++ {
+			want = append(want, want[len(want)-m.offset])
+		}
+	}
+	ops = append(ops, xpressOp{lit: 'z'})
+	want = append(want, 'z')
+
+	src := xpressFixedEncode(ops)
+	got := make([]byte, len(want))
+	if err := xpressDecompress(got, src); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+// TestXpressDecompressKnown decodes chunks written byte by byte from
+// MS-XCA, without the encoder's bit writer. A match's extra length byte
+// comes from the byte stream after the two words loaded up front.
+func TestXpressDecompressKnown(t *testing.T) {
+	chunk := func(lens map[int]byte, stream ...byte) []byte {
+		b := make([]byte, xpressTableBytes)
+		for sym, l := range lens {
+			b[sym/2] |= l << (4 * (sym % 2))
+		}
+		return append(b, stream...)
+	}
+	for _, tt := range []struct {
+		name string
+		src  []byte
+		want string
+	}{
+		{
+			// a=00 b=01 c=10, match 275 (offset bits 1, length 3+3)=11,
+			// offset extra bit 1: 000110111, padded, is word 0x1b80.
+			"short match",
+			chunk(map[int]byte{'a': 2, 'b': 2, 'c': 2, 275: 2}, 0x80, 0x1b, 0, 0),
+			"abcabcabc",
+		},
+		{
+			// a=0, match 271 (offset 1, length 15+3 plus byte 2)=11, b=10:
+			// 01110 is word 0x7000, and the length byte follows the second word.
+			"length byte",
+			chunk(map[int]byte{'a': 1, 'b': 2, 271: 2}, 0x00, 0x70, 0, 0, 2),
+			strings.Repeat("a", 21) + "b",
+		},
+	} {
+		got := make([]byte, len(tt.want))
+		if err := xpressDecompress(got, tt.src); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestXpressDecompressRejectsBadOffset(t *testing.T) {
+	src := xpressFixedEncode([]xpressOp{{lit: 'a'}, {offset: 4, length: 3}})
+	if err := xpressDecompress(make([]byte, 4), src); !errors.Is(err, errCorruptChunk) {
+		t.Fatalf("err = %v want errCorruptChunk", err)
+	}
+	if err := xpressDecompress(make([]byte, 4), src[:10]); !errors.Is(err, errCorruptChunk) {
+		t.Fatalf("short input: err = %v want errCorruptChunk", err)
+	}
+}
+
+func TestXpressCompressLevels(t *testing.T) {
+	src := compressTestInputs()["mixed"]
+	fast := chunkRoundTrip(t, xpressDecompress, newXpressCompressor(1<<16, minLevel), src)
+	best := chunkRoundTrip(t, xpressDecompress, newXpressCompressor(1<<16, maxLevel), src)
+	if best > fast {
+		t.Errorf("level %d gave %d bytes, level %d %d", maxLevel, best, minLevel, fast)
+	}
+}
+
+func FuzzXpressCompress(f *testing.F) {
+	fuzzCompress(f, wimgapi.CompressXPRESS)
+}
+
+func BenchmarkXpressCompress(b *testing.B) {
+	src := compressTestInputs()["text"]
+	for _, level := range []int{minLevel, defaultLevel, maxLevel} {
+		b.Run(fmt.Sprint("level", level), func(b *testing.B) {
+			compress := newXpressCompressor(len(src), level)
+			dst := make([]byte, len(src)-1)
+			b.SetBytes(int64(len(src)))
+			var n int
+			for b.Loop() {
+				n = compress(dst, src)
+			}
+			b.ReportMetric(float64(n)/float64(len(src)), "ratio")
+		})
+	}
+}
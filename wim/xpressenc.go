@@ -0,0 +1,176 @@
+package wim
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	xpressMaxOffset   = 1<<16 - 1
+	xpressMaxMatchLen = 1<<16 - 1 + xpressMinMatchLen
+	xpressEndSymbol   = 256 // a zero-length match, written after the data as WIMGAPI does
+)
+
+// xpressOp is a literal when offset is 0 and a match otherwise.
+type xpressOp struct {
+	lit    byte
+	offset int
+	length int
+}
+
+func (op xpressOp) symbol() int {
+	if op.offset == 0 {
+		return int(op.lit)
+	}
+	return 256 | (bits.Len(uint(op.offset))-1)<<4 | min(op.length-xpressMinMatchLen, 15)
+}
+
+// xpressBitWriter emits the XPRESS bitstream: bits go into 16-bit words
+// reserved two ahead, so that the extra length bytes of a match land in
+// the byte stream where xpressDecompress reads them.
+type xpressBitWriter struct {
+	out       []byte
+	bits      uint32
+	count     uint
+	nextBits  int
+	nextBits2 int
+}
+
+func (w *xpressBitWriter) reset(table []byte) {
+	w.out = append(w.out[:0], table...)
+	w.bits, w.count = 0, 0
+	w.nextBits = len(w.out)
+	w.nextBits2 = w.nextBits + 2
+	w.out = append(w.out, 0, 0, 0, 0)
+}
+
+// put writes the low n bits of v, n <= 16.
+func (w *xpressBitWriter) put(v uint32, n uint) {
+	w.bits = w.bits<<n | v
+	w.count += n
+	if w.count > 16 {
+		w.count -= 16
+		binary.LittleEndian.PutUint16(w.out[w.nextBits:], uint16(w.bits>>w.count))
+		w.nextBits = w.nextBits2
+		w.nextBits2 = len(w.out)
+		w.out = append(w.out, 0, 0)
+	}
+}
+
+func (w *xpressBitWriter) putByte(b byte) {
+	w.out = append(w.out, b)
+}
+
+func (w *xpressBitWriter) finish() []byte {
+	binary.LittleEndian.PutUint16(w.out[w.nextBits:], uint16(w.bits<<(16-w.count)))
+	return w.out
+}
+
+// putMatch writes the length and offset that follow a match symbol.
+func (w *xpressBitWriter) putMatch(op xpressOp) {
+	if l := op.length - xpressMinMatchLen; l >= 15 {
+		if l-15 < 255 {
+			w.putByte(byte(l - 15))
+		} else {
+			w.putByte(255)
+			w.putByte(byte(l))
+			w.putByte(byte(l >> 8))
+		}
+	}
+	offsetBits := uint(bits.Len(uint(op.offset)) - 1)
+	w.put(uint32(op.offset)&(1<<offsetBits-1), offsetBits)
+}
+
+// xpressCompressor encodes chunks as XPRESS Huffman (MS-XCA 2.2). Chunks
+// are at most 64 KiB, so each is a single block with one Huffman table.
+type xpressCompressor struct {
+	mf    *matchFinder
+	lazy  bool
+	ops   []xpressOp
+	freqs [xpressNumSymbols]uint32
+	lens  [xpressNumSymbols]uint8
+	codes [xpressNumSymbols]uint32
+	table [xpressTableBytes]byte
+	bw    xpressBitWriter
+}
+
+func newXpressCompressor(chunkSize, level int) chunkCompressor {
+	p := compressionLevels[level]
+	c := &xpressCompressor{
+		mf:   newMatchFinder(chunkSize, p.maxChain, p.niceLen, xpressMaxOffset),
+		lazy: p.lazy,
+	}
+	return c.compress
+}
+
+func (c *xpressCompressor) compress(dst, src []byte) int {
+	c.parse(src)
+
+	clear(c.freqs[:])
+	for _, op := range c.ops {
+		c.freqs[op.symbol()]++
+	}
+	c.freqs[xpressEndSymbol]++
+	huffmanLengths(c.freqs[:], c.lens[:], xpressMaxCodeLen)
+	huffmanCodes(c.lens[:], c.codes[:])
+	for i := range c.table {
+		c.table[i] = c.lens[2*i] | c.lens[2*i+1]<<4
+	}
+
+	c.bw.reset(c.table[:])
+	for _, op := range c.ops {
+		sym := op.symbol()
+		c.bw.put(c.codes[sym], uint(c.lens[sym]))
+		if op.offset != 0 {
+			c.bw.putMatch(op)
+		}
+		if len(c.bw.out) > len(dst) {
+			return 0
+		}
+	}
+	c.bw.put(c.codes[xpressEndSymbol], uint(c.lens[xpressEndSymbol]))
+	out := c.bw.finish()
+	if len(out) > len(dst) {
+		return 0
+	}
+	return copy(dst, out)
+}
+
+// parse splits src into literals and matches, greedily or, when lazy,
+// deferring a match by one byte if the next position has a longer one.
+func (c *xpressCompressor) parse(src []byte) {
+	mf := c.mf
+	mf.reset()
+	c.ops = c.ops[:0]
+	pos := 0
+	length, offset := mf.find(src, pos, xpressMaxMatchLen)
+	for pos < len(src) {
+		if length == 0 {
+			c.ops = append(c.ops, xpressOp{lit: src[pos]})
+			pos++
+			if pos < len(src) {
+				length, offset = mf.find(src, pos, xpressMaxMatchLen)
+			}
+			continue
+		}
+		next := pos + 1 // first position not yet inserted
+		if c.lazy && length < mf.niceLen && pos+1 < len(src) {
+			l, o := mf.find(src, pos+1, xpressMaxMatchLen)
+			if l > length {
+				c.ops = append(c.ops, xpressOp{lit: src[pos]})
+				pos++
+				length, offset = l, o
+				continue
+			}
+			next++
+		}
+		c.ops = append(c.ops, xpressOp{offset: offset, length: length})
+		for ; next < pos+length; next++ {
+			mf.insert(src, next)
+		}
+		pos += length
+		if pos < len(src) {
+			length, offset = mf.find(src, pos, xpressMaxMatchLen)
+		}
+	}
+}
@@ -9,6 +9,9 @@ import (
 )
 
 func (i *Image) Apply(target string, opts ApplyOptions) error {
+	if err := opts.Flags.Validate(); err != nil {
+		return err
+	}
 	targetPtr, err := windows.UTF16PtrFromString(target)
 	if err != nil {
 		return err
@@ -16,7 +19,7 @@ func (i *Image) Apply(target string, opts ApplyOptions) error {
 
 	var callbackUserData uintptr
 	if opts.Progress != nil {
-		callbackUserData = newCallbackState(opts.Progress)
+		callbackUserData = newCallbackState(opts.Progress, opts.ProgressBuffer, nil)
 		defer deleteCallbackState(callbackUserData)
 
 		registerHandle := i.handle
@@ -6,10 +6,15 @@ import (
 	"sync"
 	"sync/atomic"
 	"syscall"
+	"unsafe"
 )
 
 type callbackState struct {
-	fn ProgressFunc
+	fn    ProgressFunc
+	queue *progressQueue
+	// exclude answers WIM_MSG_PROCESS during capture; it runs on WIMGAPI's
+	// thread because the answer is due before the callback returns.
+	exclude func(path string) bool
 }
 
 var (
@@ -18,14 +23,24 @@ var (
 	callbacks    sync.Map // map[uintptr]*callbackState
 )
 
-func newCallbackState(fn ProgressFunc) uintptr {
+func newCallbackState(fn ProgressFunc, buffer int, exclude func(string) bool) uintptr {
+	state := &callbackState{fn: fn, exclude: exclude}
+	if buffer > 0 {
+		state.queue = newProgressQueue(fn, buffer)
+	}
 	id := callbackSeq.Add(1)
-	callbacks.Store(id, &callbackState{fn: fn})
+	callbacks.Store(id, state)
 	return id
 }
 
 func deleteCallbackState(id uintptr) {
-	callbacks.Delete(id)
+	v, ok := callbacks.LoadAndDelete(id)
+	if !ok {
+		return
+	}
+	if state, ok := v.(*callbackState); ok && state.queue != nil {
+		state.queue.close()
+	}
 }
 
 func wimMessageCallback(messageID, wParam, lParam, userData uintptr) uintptr {
@@ -35,15 +50,31 @@ func wimMessageCallback(messageID, wParam, lParam, userData uintptr) uintptr {
 	}
 
 	state, ok := v.(*callbackState)
-	if !ok || state.fn == nil {
+	if !ok {
 		return WIMCallbackSuccess
 	}
 
-	cancel := state.fn(ProgressEvent{
+	evt := ProgressEvent{
 		MessageID: uint32(messageID),
 		WParam:    wParam,
 		LParam:    lParam,
-	})
+	}
+	if messageHasPath(evt.MessageID) {
+		evt.Path = StringFromUTF16Pointer(wParam)
+	}
+	if evt.MessageID == WIMMessageProcess && state.exclude != nil && lParam != 0 && state.exclude(evt.Path) {
+		// lParam points to a BOOL; FALSE leaves the file out of the image.
+		**(**int32)(unsafe.Pointer(&lParam)) = 0
+	}
+	if state.fn == nil {
+		return WIMCallbackSuccess
+	}
+	var cancel bool
+	if state.queue != nil {
+		cancel = state.queue.push(evt)
+	} else {
+		cancel = state.fn(evt)
+	}
 	if cancel {
 		return WIMCallbackAbortResult
 	}
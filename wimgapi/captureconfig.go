@@ -0,0 +1,231 @@
+package wimgapi
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// CaptureConfig is a capture configuration file in the WimScript.ini format
+// DISM and ImageX read. Each list holds path patterns:
+//
+//   - A pattern without a backslash, such as "*.mp3", matches a file or
+//     directory name at any depth.
+//   - Any other pattern is a path from the capture root; a leading
+//     backslash and a drive letter are optional, so "\Windows\CSC",
+//     "C:\Windows\CSC" and "Windows\CSC" are the same.
+//   - Matching is case-insensitive. '*' matches any run of characters and
+//     '?' exactly one, neither crossing a backslash; "*.*" matches every
+//     name, with or without an extension.
+//   - A pattern matching a directory covers everything beneath it.
+//
+// ExclusionException overrides ExclusionList. Files inside an excluded
+// directory can only be brought back by exceptions that are paths, since
+// the directory itself must be kept for them.
+type CaptureConfig struct {
+	ExclusionList            []string
+	ExclusionException       []string
+	CompressionExclusionList []string
+}
+
+// DefaultCaptureConfig returns the exclusions DISM applies when no
+// configuration file is given. Captures use no configuration unless one
+// is passed, so this preset is opt-in.
+func DefaultCaptureConfig() *CaptureConfig {
+	return &CaptureConfig{
+		ExclusionList: []string{
+			`\$ntfs.log`,
+			`\hiberfil.sys`,
+			`\pagefile.sys`,
+			`\swapfile.sys`,
+			`\System Volume Information`,
+			`\RECYCLER`,
+			`\$Recycle.Bin`,
+			`\Windows\CSC`,
+		},
+		CompressionExclusionList: []string{
+			`*.mp3`,
+			`*.zip`,
+			`*.cab`,
+			`\WINDOWS\inf\*.pnf`,
+		},
+	}
+}
+
+// ReadCaptureConfig reads and parses the configuration file at path.
+func ReadCaptureConfig(path string) (*CaptureConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCaptureConfig(data)
+}
+
+// ParseCaptureConfig parses a configuration file in UTF-8 or, as Windows
+// tools often write it, BOM-prefixed UTF-16LE. Lines starting with ';' are
+// comments, entries may be quoted, and sections other than the three
+// CaptureConfig knows are ignored.
+func ParseCaptureConfig(data []byte) (*CaptureConfig, error) {
+	var text string
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		text = DecodeUTF16Bytes(data)
+	case utf8.Valid(data):
+		text = strings.TrimPrefix(string(data), "\uFEFF")
+	default:
+		return nil, fmt.Errorf("capture config: not UTF-8 or UTF-16LE text")
+	}
+
+	c := &CaptureConfig{}
+	var list *[]string
+	inSection := false
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			name, ok := strings.CutSuffix(line[1:], "]")
+			if !ok {
+				return nil, fmt.Errorf("capture config: line %d: malformed section header %q", n, line)
+			}
+			inSection = true
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "exclusionlist":
+				list = &c.ExclusionList
+			case "exclusionexception":
+				list = &c.ExclusionException
+			case "compressionexclusionlist":
+				list = &c.CompressionExclusionList
+			default:
+				list = nil
+			}
+			continue
+		}
+		if !inSection {
+			return nil, fmt.Errorf("capture config: line %d: entry outside a section", n)
+		}
+		if list != nil {
+			*list = append(*list, strings.Trim(line, `"`))
+		}
+	}
+	return c, sc.Err()
+}
+
+// Excluded reports whether path, relative to the capture root, is left out
+// of the image. Either slash separates components.
+func (c *CaptureConfig) Excluded(path string) bool {
+	parts := splitConfigPath(path)
+	if !matchAnyPattern(c.ExclusionList, parts) || matchAnyPattern(c.ExclusionException, parts) {
+		return false
+	}
+	// Keep a directory that an exception reaches into.
+	for _, pat := range c.ExclusionException {
+		if anchored, pp := parsePattern(pat); anchored && len(pp) > len(parts) && matchComponents(pp[:len(parts)], parts) {
+			return false
+		}
+	}
+	return true
+}
+
+// CompressionExcluded reports whether the data of path, relative to the
+// capture root, is stored uncompressed.
+func (c *CaptureConfig) CompressionExcluded(path string) bool {
+	return matchAnyPattern(c.CompressionExclusionList, splitConfigPath(path))
+}
+
+// RelativeCapturePath returns full relative to the capture root, comparing
+// without regard to case like Windows does. ok is false when full is not
+// inside root.
+func RelativeCapturePath(root, full string) (rel string, ok bool) {
+	root = strings.TrimRight(strings.ReplaceAll(root, "/", `\`), `\`)
+	full = strings.ReplaceAll(full, "/", `\`)
+	if len(full) < len(root) || !strings.EqualFold(full[:len(root)], root) {
+		return "", false
+	}
+	rest := full[len(root):]
+	if rest != "" && rest[0] != '\\' {
+		return "", false
+	}
+	return strings.TrimLeft(rest, `\`), true
+}
+
+func splitConfigPath(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' })
+}
+
+// parsePattern splits a pattern into components. Patterns with a
+// separator are anchored at the capture root.
+func parsePattern(pat string) (anchored bool, parts []string) {
+	pat = strings.ReplaceAll(pat, "/", `\`)
+	if len(pat) >= 2 && pat[1] == ':' {
+		pat = pat[2:]
+	}
+	return strings.Contains(pat, `\`), splitConfigPath(pat)
+}
+
+func matchAnyPattern(patterns []string, parts []string) bool {
+	for _, pat := range patterns {
+		anchored, pp := parsePattern(pat)
+		if len(pp) == 0 {
+			continue
+		}
+		if anchored {
+			if len(pp) <= len(parts) && matchComponents(pp, parts[:len(pp)]) {
+				return true
+			}
+			continue
+		}
+		for _, name := range parts {
+			if matchWildcard(pp[0], name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchComponents(pats, names []string) bool {
+	for i, p := range pats {
+		if !matchWildcard(p, names[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchWildcard matches one path component against a pattern with '*' and
+// '?', ignoring case.
+func matchWildcard(pat, name string) bool {
+	if pat == "*.*" {
+		return true
+	}
+	p := []rune(strings.ToUpper(pat))
+	s := []rune(strings.ToUpper(name))
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == s[si]):
+			pi++
+			si++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
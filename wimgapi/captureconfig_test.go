@@ -0,0 +1,148 @@
+package wimgapi
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+const testCaptureConfig = `; comment
+[ExclusionList]
+\pagefile.sys
+"\System Volume Information"
+*.tmp
+\Users\*\AppData\Local\Temp
+\Windows\CSC
+
+[ExclusionException]
+\Windows\CSC\keep.txt
+
+[PrepopulateList]
+\Windows\System32\*.dll
+
+[CompressionExclusionList]
+*.zip
+C:\Windows\inf\*.pnf
+`
+
+func TestParseCaptureConfig(t *testing.T) {
+	want := &CaptureConfig{
+		ExclusionList:            []string{`\pagefile.sys`, `\System Volume Information`, `*.tmp`, `\Users\*\AppData\Local\Temp`, `\Windows\CSC`},
+		ExclusionException:       []string{`\Windows\CSC\keep.txt`},
+		CompressionExclusionList: []string{`*.zip`, `C:\Windows\inf\*.pnf`},
+	}
+	got, err := ParseCaptureConfig([]byte(testCaptureConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v\nwant %+v", got, want)
+	}
+
+	// Windows tools write the file as UTF-16LE with a BOM.
+	u16 := []byte{0xFF, 0xFE}
+	for _, v := range utf16.Encode([]rune(testCaptureConfig)) {
+		u16 = binary.LittleEndian.AppendUint16(u16, v)
+	}
+	got, err = ParseCaptureConfig(u16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("UTF-16: got %+v", got)
+	}
+
+	for _, bad := range []string{"\\pagefile.sys\n", "[ExclusionList\n"} {
+		if _, err := ParseCaptureConfig([]byte(bad)); err == nil {
+			t.Fatalf("%q parsed", bad)
+		}
+	}
+}
+
+func TestCaptureConfigExcluded(t *testing.T) {
+	cfg, err := ParseCaptureConfig([]byte(testCaptureConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"pagefile.sys", true},
+		{"PAGEFILE.SYS", true},
+		{"data/pagefile.sys", false}, // anchored at the root
+		{"System Volume Information", true},
+		{`System Volume Information\tracking.log`, true},
+		{"a/b/c.tmp", true},
+		{"a/b.tmp/c.txt", true}, // inside an excluded directory
+		{"a/b/c.tmpx", false},
+		{"Users/alice/AppData/Local/Temp/x", true},
+		{"Users/alice/AppData/Local/Tempest", false},
+		{"Windows/CSC", false}, // kept for the exception inside it
+		{"Windows/CSC/other.txt", true},
+		{"Windows/CSC/keep.txt", false},
+		{"Windows/System32/kernel32.dll", false},
+	}
+	for _, tt := range tests {
+		if got := cfg.Excluded(tt.path); got != tt.want {
+			t.Errorf("Excluded(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if !cfg.CompressionExcluded("deep/dir/a.ZIP") || !cfg.CompressionExcluded("Windows/inf/oem1.pnf") || cfg.CompressionExcluded("Windows/inf/oem1.inf") {
+		t.Fatal("compression exclusions")
+	}
+}
+
+func TestDefaultCaptureConfig(t *testing.T) {
+	cfg := DefaultCaptureConfig()
+	for _, p := range []string{"pagefile.sys", "hiberfil.sys", "$Recycle.Bin/S-1-5-18", "System Volume Information"} {
+		if !cfg.Excluded(p) {
+			t.Errorf("%s not excluded", p)
+		}
+	}
+	if cfg.Excluded("Windows/System32") {
+		t.Error("Windows/System32 excluded")
+	}
+}
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pat, name string
+		want      bool
+	}{
+		{"*", "anything", true},
+		{"*.*", "noext", true},
+		{"*.txt", "a.TXT", true},
+		{"*.txt", "a.txt.bak", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*ab*ab", "xabyabab", true},
+		{"*ab*ab", "xabyaba", false},
+	}
+	for _, tt := range tests {
+		if got := matchWildcard(tt.pat, tt.name); got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v", tt.pat, tt.name, got)
+		}
+	}
+}
+
+func TestRelativeCapturePath(t *testing.T) {
+	tests := []struct {
+		root, full, rel string
+		ok              bool
+	}{
+		{`C:\src`, `C:\src\Windows\a.txt`, `Windows\a.txt`, true},
+		{`C:\src\`, `c:\SRC\a`, `a`, true},
+		{`C:\src`, `C:\src`, ``, true},
+		{`C:\src`, `C:\srcx\a`, ``, false},
+		{`C:\src`, `D:\a`, ``, false},
+	}
+	for _, tt := range tests {
+		rel, ok := RelativeCapturePath(tt.root, tt.full)
+		if rel != tt.rel || ok != tt.ok {
+			t.Errorf("RelativeCapturePath(%q, %q) = %q, %v", tt.root, tt.full, rel, ok)
+		}
+	}
+}
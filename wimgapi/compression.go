@@ -0,0 +1,92 @@
+package wimgapi
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// Compression is a WIM compression format, numbered like WIMGAPI's
+// WIM_COMPRESS_* values.
+type Compression uint32
+
+const (
+	CompressNone   Compression = 0
+	CompressXPRESS Compression = 1
+	CompressLZX    Compression = 2
+	CompressLZMS   Compression = 3
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressNone:
+		return "none"
+	case CompressXPRESS:
+		return "XPRESS"
+	case CompressLZX:
+		return "LZX"
+	case CompressLZMS:
+		return "LZMS"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseCompression parses a format name as String writes it, ignoring case.
+func ParseCompression(s string) (Compression, error) {
+	for c := CompressNone; c <= CompressLZMS; c++ {
+		if strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("wimgapi: unknown compression %q", s)
+}
+
+// Validate rejects values that name no format.
+func (c Compression) Validate() error {
+	if c > CompressLZMS {
+		return fmt.Errorf("wimgapi: unknown compression %d", uint32(c))
+	}
+	return nil
+}
+
+// DefaultChunkSize is the chunk size WIM writers use for c when none is
+// chosen: 32 KiB, except 128 KiB for LZMS.
+func (c Compression) DefaultChunkSize() uint32 {
+	if c == CompressLZMS {
+		return 128 << 10
+	}
+	return 32 << 10
+}
+
+// chunkSizeRange is the smallest and largest chunk size each format's
+// window allows, as powers of two.
+func (c Compression) chunkSizeRange() (minShift, maxShift int) {
+	switch c {
+	case CompressXPRESS:
+		return 12, 16 // 4 KiB, used by WIMBoot, to 64 KiB
+	case CompressLZX:
+		return 15, 21 // 32 KiB to 2 MiB
+	case CompressLZMS:
+		return 15, 30 // 32 KiB to 1 GiB, large sizes for solid resources
+	default:
+		return 0, 31
+	}
+}
+
+// ValidateChunkSize checks that size is a power of two in the range c
+// supports. Uncompressed WIMs accept any size, since it is unused.
+func (c Compression) ValidateChunkSize(size uint32) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c == CompressNone {
+		return nil
+	}
+	minShift, maxShift := c.chunkSizeRange()
+	if bits.OnesCount32(size) != 1 || bits.TrailingZeros32(size) < minShift || bits.TrailingZeros32(size) > maxShift {
+		return fmt.Errorf("wimgapi: %s chunk size must be a power of two from %d to %d, not %d",
+			c, uint32(1)<<minShift, uint32(1)<<maxShift, size)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package wimgapi
+
+import "testing"
+
+func TestParseCompression(t *testing.T) {
+	for c := CompressNone; c <= CompressLZMS; c++ {
+		got, err := ParseCompression(c.String())
+		if err != nil || got != c {
+			t.Errorf("ParseCompression(%q) = %v, %v", c.String(), got, err)
+		}
+	}
+	if got, err := ParseCompression("lzx"); err != nil || got != CompressLZX {
+		t.Errorf("lowercase: %v, %v", got, err)
+	}
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Error("zstd parsed")
+	}
+	if Compression(9).String() != "unknown" || Compression(9).Validate() == nil {
+		t.Error("Compression(9) accepted")
+	}
+}
+
+func TestValidateChunkSize(t *testing.T) {
+	tests := []struct {
+		c    Compression
+		size uint32
+		ok   bool
+	}{
+		{CompressNone, 12345, true},
+		{CompressXPRESS, 4096, true},
+		{CompressXPRESS, 1 << 16, true},
+		{CompressXPRESS, 1 << 17, false},
+		{CompressXPRESS, 5000, false},
+		{CompressLZX, 4096, false},
+		{CompressLZX, 1 << 21, true},
+		{CompressLZMS, 1 << 26, true},
+		{CompressLZMS, 0, false},
+		{Compression(9), 1 << 15, false},
+	}
+	for _, tt := range tests {
+		if err := tt.c.ValidateChunkSize(tt.size); (err == nil) != tt.ok {
+			t.Errorf("%s chunk size %d: err = %v", tt.c, tt.size, err)
+		}
+	}
+	for c := CompressNone; c <= CompressLZMS; c++ {
+		if err := c.ValidateChunkSize(c.DefaultChunkSize()); err != nil {
+			t.Errorf("%s default: %v", c, err)
+		}
+	}
+}
@@ -1,5 +1,3 @@
-//go:build windows
-
 package wimgapi
 
 import (
@@ -70,9 +68,10 @@ func (d *ProgressDecoder) Decode(evt ProgressEvent) DecodedProgressEvent {
 			}
 		}
 	case WIMMessageStepIt:
-		d.current++
-	case WIMMessageError, WIMMessageWarning:
-		out.ErrorCode = uint32(evt.WParam)
+		d.current += 1 + uint64(evt.Coalesced)
+	case WIMMessageError, WIMMessageWarning, WIMMessageRetry:
+		// WParam points to the file; LParam is the Win32 error code.
+		out.ErrorCode = uint32(evt.LParam)
 	}
 
 	out.Current = d.current
@@ -137,6 +136,8 @@ func summarize(evt DecodedProgressEvent) string {
 		return fmt.Sprintf("ERROR: code=%d", evt.ErrorCode)
 	case WIMMessageWarning:
 		return fmt.Sprintf("WARNING: code=%d", evt.ErrorCode)
+	case WIMMessageRetry:
+		return fmt.Sprintf("RETRY: code=%d", evt.ErrorCode)
 	case WIMMessageDone:
 		return "DONE"
 	default:
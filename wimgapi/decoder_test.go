@@ -1,5 +1,3 @@
-//go:build windows
-
 package wimgapi
 
 import "testing"
@@ -49,3 +47,17 @@ func TestProgressDecoderObservedSequence(t *testing.T) {
 		t.Fatalf("final state current=%d total=%d want 5/5", ev.Current, ev.Total)
 	}
 }
+
+func TestProgressDecoderErrorCode(t *testing.T) {
+	d := NewProgressDecoder()
+	for _, id := range []uint32{WIMMessageError, WIMMessageWarning, WIMMessageRetry} {
+		if !messageHasPath(id) {
+			t.Errorf("%s: path not copied", messageName(id))
+		}
+		// WParam is the path pointer, never the code.
+		ev := d.Decode(ProgressEvent{MessageID: id, WParam: 0x7ff000, LParam: 32})
+		if ev.ErrorCode != 32 || ev.Summary != messageName(id)+": code=32" {
+			t.Errorf("%s: code=%d summary=%q", messageName(id), ev.ErrorCode, ev.Summary)
+		}
+	}
+}
@@ -3,8 +3,6 @@
 package wimgapi
 
 import (
-	"encoding/binary"
-	"unicode/utf16"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -22,8 +20,11 @@ var (
 	procWIMGetImageInformation       = modWimgapi.NewProc("WIMGetImageInformation")
 	procWIMFreeMemory                = modWimgapi.NewProc("WIMFreeMemory")
 	procWIMApplyImage                = modWimgapi.NewProc("WIMApplyImage")
+	procWIMGetAttributes             = modWimgapi.NewProc("WIMGetAttributes")
 	procWIMRegisterMessageCallback   = modWimgapi.NewProc("WIMRegisterMessageCallback")
 	procWIMUnregisterMessageCallback = modWimgapi.NewProc("WIMUnregisterMessageCallback")
+	procWIMMountImageHandle          = modWimgapi.NewProc("WIMMountImageHandle")
+	procWIMUnmountImageHandle        = modWimgapi.NewProc("WIMUnmountImageHandle")
 )
 
 func ensureLoaded() error {
@@ -51,28 +52,10 @@ func BytesFromPointer(ptr uintptr, size uint32) []byte {
 	return unsafe.Slice((*byte)(unsafe.Pointer(ptr)), size)
 }
 
-// DecodeUTF16Bytes decodes UTF-16LE bytes (optionally BOM-prefixed) into UTF-8 string.
-func DecodeUTF16Bytes(b []byte) string {
-	if len(b) < 2 {
+// StringFromUTF16Pointer copies the NUL-terminated UTF-16 string at ptr.
+func StringFromUTF16Pointer(ptr uintptr) string {
+	if ptr == 0 {
 		return ""
 	}
-
-	start := 0
-	if len(b) >= 2 {
-		bom := binary.LittleEndian.Uint16(b[:2])
-		if bom == 0xFEFF {
-			start = 2
-		}
-	}
-
-	u16 := make([]uint16, 0, (len(b)-start)/2)
-	for i := start; i+1 < len(b); i += 2 {
-		v := binary.LittleEndian.Uint16(b[i : i+2])
-		if v == 0 {
-			break
-		}
-		u16 = append(u16, v)
-	}
-
-	return string(utf16.Decode(u16))
+	return windows.UTF16PtrToString(*(**uint16)(unsafe.Pointer(&ptr)))
 }
@@ -13,6 +13,12 @@ func Open(path string, opts OpenOptions) (*File, error) {
 		return nil, err
 	}
 
+	if err := opts.FlagsAndAttributes.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.CompressionType.Validate(); err != nil {
+		return nil, err
+	}
 	opts = normalizeOpenOptions(opts)
 	pathPtr, err := windows.UTF16PtrFromString(path)
 	if err != nil {
@@ -72,6 +78,33 @@ func (f *File) ImageCount() (int, error) {
 	return int(r1), nil
 }
 
+func (f *File) Attributes() (WIMInfo, error) {
+	var raw wimInfoRaw
+	r1, _, callErr := procWIMGetAttributes.Call(
+		uintptr(f.handle),
+		uintptr(unsafe.Pointer(&raw)),
+		unsafe.Sizeof(raw),
+	)
+	if r1 == 0 {
+		code := codeFromCallErr(callErr)
+		if code == 0 {
+			code = lastErrorCode()
+		}
+		return WIMInfo{}, winError("WIMGetAttributes", code)
+	}
+	return WIMInfo{
+		Path:               windows.UTF16ToString(raw.WimPath[:]),
+		GUID:               raw.Guid,
+		ImageCount:         int(raw.ImageCount),
+		CompressionType:    Compression(raw.CompressionType),
+		PartNumber:         raw.PartNumber,
+		TotalParts:         raw.TotalParts,
+		BootIndex:          int(raw.BootIndex),
+		Attributes:         raw.WimAttributes,
+		FlagsAndAttributes: raw.WimFlagsAndAttr,
+	}, nil
+}
+
 func (f *File) LoadImage(index int) (*Image, error) {
 	if index < 1 {
 		return nil, ErrImageIndexInvalid
@@ -111,14 +144,25 @@ func (f *File) SetTemporaryPath(path string) error {
 }
 
 func (f *File) Capture(path string, opts CaptureOptions) (*Image, error) {
+	if err := opts.Flags.Validate(); err != nil {
+		return nil, err
+	}
 	pathPtr, err := windows.UTF16PtrFromString(path)
 	if err != nil {
 		return nil, err
 	}
 
+	var exclude func(string) bool
+	if cfg := opts.Config; cfg != nil {
+		exclude = func(full string) bool {
+			rel, ok := RelativeCapturePath(path, full)
+			return ok && rel != "" && cfg.Excluded(rel)
+		}
+	}
+
 	var callbackUserData uintptr
-	if opts.Progress != nil {
-		callbackUserData = newCallbackState(opts.Progress)
+	if opts.Progress != nil || exclude != nil {
+		callbackUserData = newCallbackState(opts.Progress, opts.ProgressBuffer, exclude)
 		defer deleteCallbackState(callbackUserData)
 
 		r1, _, callErr := procWIMRegisterMessageCallback.Call(
@@ -0,0 +1,179 @@
+package wimgapi
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// ErrInvalidFlags is returned for flags an operation does not accept or
+// that contradict each other.
+var ErrInvalidFlags = errors.New("wimgapi: invalid flags")
+
+// WIM_FLAG_* values from wimgapi.h. Each operation accepts a subset of
+// them, exposed through its own flag type.
+const (
+	wimFlagVerify        = 0x00000002
+	wimFlagIndex         = 0x00000004
+	wimFlagNoApply       = 0x00000008
+	wimFlagNoDirACL      = 0x00000010
+	wimFlagNoFileACL     = 0x00000020
+	wimFlagShareWrite    = 0x00000040
+	wimFlagFileInfo      = 0x00000080
+	wimFlagNoRPFix       = 0x00000100
+	wimFlagMountReadOnly = 0x00000200
+	wimFlagMountFast     = 0x00000400
+	wimFlagMountLegacy   = 0x00000800
+	wimFlagApplyCIEA     = 0x00001000
+	wimFlagWIMBoot       = 0x00002000
+	wimFlagApplyCompact  = 0x00004000
+	wimFlagSupportEA     = 0x00008000
+)
+
+var wimFlagNames = map[uint32]string{
+	wimFlagVerify:        "verify",
+	wimFlagIndex:         "index",
+	wimFlagNoApply:       "no-apply",
+	wimFlagNoDirACL:      "no-dir-acl",
+	wimFlagNoFileACL:     "no-file-acl",
+	wimFlagShareWrite:    "share-write",
+	wimFlagFileInfo:      "fileinfo",
+	wimFlagNoRPFix:       "no-rp-fix",
+	wimFlagMountReadOnly: "mount-readonly",
+	wimFlagMountFast:     "mount-fast",
+	wimFlagMountLegacy:   "mount-legacy",
+	wimFlagApplyCIEA:     "apply-ci-ea",
+	wimFlagWIMBoot:       "wimboot",
+	wimFlagApplyCompact:  "apply-compact",
+	wimFlagSupportEA:     "support-ea",
+}
+
+// ApplyFlags are the dwApplyFlags of WIMApplyImage.
+type ApplyFlags uint32
+
+const (
+	ApplyVerify    ApplyFlags = wimFlagVerify       // verify files against the WIM as they are written
+	ApplyIndex     ApplyFlags = wimFlagIndex        // apply in blob order rather than directory order
+	ApplyNoApply   ApplyFlags = wimFlagNoApply      // walk the image without writing anything
+	ApplyNoDirACL  ApplyFlags = wimFlagNoDirACL     // leave directory security descriptors unset
+	ApplyNoFileACL ApplyFlags = wimFlagNoFileACL    // leave file security descriptors unset
+	ApplyFileInfo  ApplyFlags = wimFlagFileInfo     // send WIM_MSG_FILEINFO for every file
+	ApplyNoRPFix   ApplyFlags = wimFlagNoRPFix      // apply reparse point targets unchanged
+	ApplyCIEA      ApplyFlags = wimFlagApplyCIEA    // set the code integrity extended attribute on files
+	ApplyWIMBoot   ApplyFlags = wimFlagWIMBoot      // create WIMBoot pointer files instead of copying data
+	ApplyCompact   ApplyFlags = wimFlagApplyCompact // store applied files with Compact OS compression
+	ApplySupportEA ApplyFlags = wimFlagSupportEA    // apply extended attributes
+)
+
+func (f ApplyFlags) String() string {
+	return formatFlags(uint32(f))
+}
+
+// Validate rejects flags WIMApplyImage does not take and combinations
+// that contradict each other.
+func (f ApplyFlags) Validate() error {
+	return validateFlags("apply", uint32(f),
+		uint32(ApplyVerify|ApplyIndex|ApplyNoApply|ApplyNoDirACL|ApplyNoFileACL|ApplyFileInfo|
+			ApplyNoRPFix|ApplyCIEA|ApplyWIMBoot|ApplyCompact|ApplySupportEA),
+		[][2]uint32{
+			{wimFlagWIMBoot, wimFlagApplyCompact},
+			{wimFlagNoApply, wimFlagWIMBoot},
+			{wimFlagNoApply, wimFlagApplyCompact},
+		})
+}
+
+// CaptureFlags are the dwCaptureFlags of WIMCaptureImage.
+type CaptureFlags uint32
+
+const (
+	CaptureVerify    CaptureFlags = wimFlagVerify    // verify each file after storing it
+	CaptureNoDirACL  CaptureFlags = wimFlagNoDirACL  // don't store directory security descriptors
+	CaptureNoFileACL CaptureFlags = wimFlagNoFileACL // don't store file security descriptors
+	CaptureNoRPFix   CaptureFlags = wimFlagNoRPFix   // store reparse point targets unchanged
+	CaptureWIMBoot   CaptureFlags = wimFlagWIMBoot   // capture for WIMBoot
+	CaptureSupportEA CaptureFlags = wimFlagSupportEA // capture extended attributes
+)
+
+func (f CaptureFlags) String() string {
+	return formatFlags(uint32(f))
+}
+
+// Validate rejects flags WIMCaptureImage does not take.
+func (f CaptureFlags) Validate() error {
+	return validateFlags("capture", uint32(f),
+		uint32(CaptureVerify|CaptureNoDirACL|CaptureNoFileACL|CaptureNoRPFix|CaptureWIMBoot|CaptureSupportEA), nil)
+}
+
+// OpenFlags are the dwFlagsAndAttributes of WIMCreateFile.
+type OpenFlags uint32
+
+const (
+	OpenVerify     OpenFlags = wimFlagVerify     // keep and check per-resource hashes
+	OpenShareWrite OpenFlags = wimFlagShareWrite // let other handles write to the WIM
+)
+
+func (f OpenFlags) String() string {
+	return formatFlags(uint32(f))
+}
+
+// Validate rejects flags WIMCreateFile does not take.
+func (f OpenFlags) Validate() error {
+	return validateFlags("open", uint32(f), uint32(OpenVerify|OpenShareWrite), nil)
+}
+
+// MountFlags are the dwMountFlags of WIMMountImageHandle.
+type MountFlags uint32
+
+const (
+	MountVerify   MountFlags = wimFlagVerify        // verify files as they are read from the WIM
+	MountReadOnly MountFlags = wimFlagMountReadOnly // mount without a way to commit changes
+	MountFast     MountFlags = wimFlagMountFast     // mount without preparing the image for servicing
+	MountLegacy   MountFlags = wimFlagMountLegacy   // use the pre-Windows 7 mount driver
+)
+
+func (f MountFlags) String() string {
+	return formatFlags(uint32(f))
+}
+
+// Validate rejects flags WIMMountImageHandle does not take and
+// combinations that contradict each other.
+func (f MountFlags) Validate() error {
+	return validateFlags("mount", uint32(f), uint32(MountVerify|MountReadOnly|MountFast|MountLegacy),
+		[][2]uint32{{wimFlagMountFast, wimFlagMountLegacy}})
+}
+
+// formatFlags names each set bit, joined by '|', with unknown bits in hex.
+// No flags at all is "0".
+func formatFlags(v uint32) string {
+	if v == 0 {
+		return "0"
+	}
+	var names []string
+	var unknown uint32
+	for v != 0 {
+		bit := uint32(1) << bits.TrailingZeros32(v)
+		v &^= bit
+		if name, ok := wimFlagNames[bit]; ok {
+			names = append(names, name)
+		} else {
+			unknown |= bit
+		}
+	}
+	if unknown != 0 {
+		names = append(names, fmt.Sprintf("0x%X", unknown))
+	}
+	return strings.Join(names, "|")
+}
+
+func validateFlags(op string, v, allowed uint32, conflicts [][2]uint32) error {
+	if bad := v &^ allowed; bad != 0 {
+		return fmt.Errorf("%w: %s does not take %s", ErrInvalidFlags, op, formatFlags(bad))
+	}
+	for _, c := range conflicts {
+		if v&c[0] != 0 && v&c[1] != 0 {
+			return fmt.Errorf("%w: %s cannot combine %s with %s", ErrInvalidFlags, op, formatFlags(c[0]), formatFlags(c[1]))
+		}
+	}
+	return nil
+}
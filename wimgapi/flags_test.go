@@ -0,0 +1,59 @@
+package wimgapi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFlagsString(t *testing.T) {
+	tests := []struct {
+		flags interface{ String() string }
+		want  string
+	}{
+		{ApplyFlags(0), "0"},
+		{ApplyVerify | ApplyNoRPFix, "verify|no-rp-fix"},
+		{ApplyWIMBoot | ApplyFileInfo, "fileinfo|wimboot"},
+		{CaptureNoDirACL | CaptureNoFileACL, "no-dir-acl|no-file-acl"},
+		{OpenShareWrite, "share-write"},
+		{MountReadOnly | MountFlags(0x30000), "mount-readonly|0x30000"},
+	}
+	for _, tt := range tests {
+		if got := tt.flags.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestFlagsValidate(t *testing.T) {
+	valid := []interface{ Validate() error }{
+		ApplyFlags(0),
+		ApplyVerify | ApplyIndex | ApplyNoDirACL | ApplyNoFileACL | ApplyNoRPFix,
+		ApplyNoApply | ApplyFileInfo,
+		ApplyWIMBoot | ApplyCIEA,
+		CaptureVerify | CaptureNoRPFix | CaptureWIMBoot,
+		OpenVerify | OpenShareWrite,
+		MountReadOnly | MountVerify,
+	}
+	for _, f := range valid {
+		if err := f.Validate(); err != nil {
+			t.Errorf("%v: %v", f, err)
+		}
+	}
+
+	invalid := []interface{ Validate() error }{
+		ApplyFlags(wimFlagShareWrite),
+		ApplyFlags(0x10000),
+		ApplyWIMBoot | ApplyCompact,
+		ApplyNoApply | ApplyWIMBoot,
+		CaptureFlags(wimFlagIndex),
+		CaptureFlags(wimFlagMountReadOnly),
+		OpenFlags(wimFlagNoApply),
+		MountFast | MountLegacy,
+		MountFlags(wimFlagWIMBoot),
+	}
+	for _, f := range invalid {
+		if err := f.Validate(); !errors.Is(err, ErrInvalidFlags) {
+			t.Errorf("%v: err = %v", f, err)
+		}
+	}
+}
@@ -3,24 +3,11 @@
 package wimgapi
 
 import (
-	"encoding/xml"
 	"strings"
 	"unsafe"
-)
-
-type imageInfoXML struct {
-	Index       int    `xml:"INDEX,attr"`
-	Name        string `xml:"NAME"`
-	Description string `xml:"DESCRIPTION"`
-	Flags       string `xml:"FLAGS"`
-	Windows     struct {
-		Arch string `xml:"ARCH"`
-	} `xml:"WINDOWS"`
-}
 
-type imageListXML struct {
-	Images []imageInfoXML `xml:"IMAGE"`
-}
+	"golang.org/x/sys/windows"
+)
 
 func (i *Image) Close() error {
 	i.mu.Lock()
@@ -65,25 +52,49 @@ func (i *Image) Info() (ImageInfo, error) {
 		return ImageInfo{}, nil
 	}
 
-	var imgNode imageInfoXML
-	if err := xml.Unmarshal([]byte(xmlText), &imgNode); err != nil {
+	images, err := ParseImageInfoXML(xmlText)
+	if err != nil {
 		return ImageInfo{}, err
 	}
-	if imgNode.Index == 0 && imgNode.Name == "" {
-		var list imageListXML
-		if err := xml.Unmarshal([]byte(xmlText), &list); err != nil {
-			return ImageInfo{}, err
-		}
-		if len(list.Images) > 0 {
-			imgNode = list.Images[0]
+	if len(images) == 0 {
+		return ImageInfo{}, nil
+	}
+	return images[0], nil
+}
+
+// Mount mounts the image at dir, which must be an existing empty
+// directory. Unmount discards changes made through a read-write mount.
+func (i *Image) Mount(dir string, flags MountFlags) error {
+	if err := flags.Validate(); err != nil {
+		return err
+	}
+	dirPtr, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	r1, _, callErr := procWIMMountImageHandle.Call(
+		uintptr(i.handle),
+		uintptr(unsafe.Pointer(dirPtr)),
+		uintptr(flags),
+	)
+	if r1 == 0 {
+		code := codeFromCallErr(callErr)
+		if code == 0 {
+			code = lastErrorCode()
 		}
+		return winError("WIMMountImageHandle", code)
 	}
+	return nil
+}
 
-	return ImageInfo{
-		Index:        imgNode.Index,
-		Name:         imgNode.Name,
-		Description:  imgNode.Description,
-		Flags:        imgNode.Flags,
-		Architecture: imgNode.Windows.Arch,
-	}, nil
+func (i *Image) Unmount() error {
+	r1, _, callErr := procWIMUnmountImageHandle.Call(uintptr(i.handle), 0)
+	if r1 == 0 {
+		code := codeFromCallErr(callErr)
+		if code == 0 {
+			code = lastErrorCode()
+		}
+		return winError("WIMUnmountImageHandle", code)
+	}
+	return nil
 }
@@ -0,0 +1,147 @@
+package wimgapi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileTimeEpochDelta is 1601-01-01 to 1970-01-01 in 100ns intervals.
+const fileTimeEpochDelta = 116444736000000000
+
+type ImageInfo struct {
+	Index              int
+	Name               string
+	Description        string
+	Flags              string
+	Architecture       string
+	DisplayName        string
+	DisplayDescription string
+	EditionID          string
+	Version            string
+	DirCount           uint64
+	FileCount          uint64
+	TotalBytes         uint64
+	HardLinkBytes      uint64
+	CreationTime       time.Time
+	ModificationTime   time.Time
+}
+
+type fileTimeXML struct {
+	High string `xml:"HIGHPART"`
+	Low  string `xml:"LOWPART"`
+}
+
+type imageInfoXML struct {
+	Index              int         `xml:"INDEX,attr"`
+	Name               string      `xml:"NAME"`
+	Description        string      `xml:"DESCRIPTION"`
+	Flags              string      `xml:"FLAGS"`
+	DisplayName        string      `xml:"DISPLAYNAME"`
+	DisplayDescription string      `xml:"DISPLAYDESCRIPTION"`
+	DirCount           string      `xml:"DIRCOUNT"`
+	FileCount          string      `xml:"FILECOUNT"`
+	TotalBytes         string      `xml:"TOTALBYTES"`
+	HardLinkBytes      string      `xml:"HARDLINKBYTES"`
+	CreationTime       fileTimeXML `xml:"CREATIONTIME"`
+	ModificationTime   fileTimeXML `xml:"LASTMODIFICATIONTIME"`
+	Windows            struct {
+		Arch      string `xml:"ARCH"`
+		EditionID string `xml:"EDITIONID"`
+		Version   struct {
+			Major   string `xml:"MAJOR"`
+			Minor   string `xml:"MINOR"`
+			Build   string `xml:"BUILD"`
+			SPBuild string `xml:"SPBUILD"`
+		} `xml:"VERSION"`
+	} `xml:"WINDOWS"`
+}
+
+// ParseImageInfoXML parses either a single <IMAGE> element, as returned by
+// WIMGetImageInformation, or a whole <WIM> document with one <IMAGE> per image.
+func ParseImageInfoXML(text string) ([]ImageInfo, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+
+	var root struct {
+		XMLName xml.Name
+		Images  []imageInfoXML `xml:"IMAGE"`
+	}
+	if err := xml.Unmarshal([]byte(text), &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local == "IMAGE" {
+		var img imageInfoXML
+		if err := xml.Unmarshal([]byte(text), &img); err != nil {
+			return nil, err
+		}
+		return []ImageInfo{img.info()}, nil
+	}
+
+	images := make([]ImageInfo, 0, len(root.Images))
+	for _, img := range root.Images {
+		images = append(images, img.info())
+	}
+	return images, nil
+}
+
+func (x imageInfoXML) info() ImageInfo {
+	info := ImageInfo{
+		Index:              x.Index,
+		Name:               x.Name,
+		Description:        x.Description,
+		Flags:              x.Flags,
+		Architecture:       x.Windows.Arch,
+		DisplayName:        x.DisplayName,
+		DisplayDescription: x.DisplayDescription,
+		EditionID:          x.Windows.EditionID,
+		DirCount:           parseXMLUint(x.DirCount),
+		FileCount:          parseXMLUint(x.FileCount),
+		TotalBytes:         parseXMLUint(x.TotalBytes),
+		HardLinkBytes:      parseXMLUint(x.HardLinkBytes),
+		CreationTime:       x.CreationTime.time(),
+		ModificationTime:   x.ModificationTime.time(),
+	}
+	if v := x.Windows.Version; v.Major != "" {
+		info.Version = fmt.Sprintf("%s.%s.%s.%s", v.Major, v.Minor, v.Build, v.SPBuild)
+	}
+	return info
+}
+
+func (t fileTimeXML) time() time.Time {
+	if t.High == "" && t.Low == "" {
+		return time.Time{}
+	}
+	ft := parseXMLUint(t.High)<<32 | parseXMLUint(t.Low)&0xFFFFFFFF
+	return FileTimeToTime(ft)
+}
+
+func parseXMLUint(s string) uint64 {
+	v, err := strconv.ParseUint(strings.TrimSpace(s), 0, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// FileTimeToTime converts a Windows FILETIME (100ns intervals since
+// 1601-01-01 UTC) to time.Time. Zero maps to the zero Time.
+func FileTimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	d := int64(ft) - fileTimeEpochDelta
+	return time.Unix(d/1e7, (d%1e7)*100).UTC()
+}
+
+// TimeToFileTime is the inverse of FileTimeToTime.
+func TimeToFileTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.Unix()*1e7 + int64(t.Nanosecond()/100) + fileTimeEpochDelta)
+}
@@ -0,0 +1,61 @@
+package wimgapi
+
+import (
+	"testing"
+	"time"
+)
+
+const sampleWIMXML = `<WIM><TOTALBYTES>4096</TOTALBYTES>
+<IMAGE INDEX="1"><NAME>Base</NAME><DESCRIPTION>first</DESCRIPTION>
+<DIRCOUNT>3</DIRCOUNT><FILECOUNT>12</FILECOUNT><TOTALBYTES>1048576</TOTALBYTES><HARDLINKBYTES>0</HARDLINKBYTES>
+<CREATIONTIME><HIGHPART>0x01DA3F7A</HIGHPART><LOWPART>0x2B0C8000</LOWPART></CREATIONTIME>
+<WINDOWS><ARCH>9</ARCH><EDITIONID>Professional</EDITIONID>
+<VERSION><MAJOR>10</MAJOR><MINOR>0</MINOR><BUILD>22631</BUILD><SPBUILD>2861</SPBUILD></VERSION></WINDOWS>
+</IMAGE>
+<IMAGE INDEX="2"><NAME>Second</NAME></IMAGE></WIM>`
+
+func TestParseImageInfoXMLDocument(t *testing.T) {
+	images, err := ParseImageInfoXML(sampleWIMXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("got %d images, want 2", len(images))
+	}
+	img := images[0]
+	if img.Index != 1 || img.Name != "Base" || img.Architecture != "9" || img.EditionID != "Professional" {
+		t.Fatalf("image 1 = %+v", img)
+	}
+	if img.FileCount != 12 || img.DirCount != 3 || img.TotalBytes != 1048576 {
+		t.Fatalf("counts = %d/%d/%d", img.FileCount, img.DirCount, img.TotalBytes)
+	}
+	if img.Version != "10.0.22631.2861" {
+		t.Fatalf("version = %q", img.Version)
+	}
+	if img.CreationTime.IsZero() || img.CreationTime.Year() != 2024 {
+		t.Fatalf("creation time = %v", img.CreationTime)
+	}
+	if images[1].Index != 2 || images[1].Name != "Second" {
+		t.Fatalf("image 2 = %+v", images[1])
+	}
+}
+
+func TestParseImageInfoXMLSingleImage(t *testing.T) {
+	images, err := ParseImageInfoXML(`<IMAGE INDEX="3"><NAME>Only</NAME><FLAGS>Core</FLAGS></IMAGE>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Index != 3 || images[0].Flags != "Core" {
+		t.Fatalf("images = %+v", images)
+	}
+}
+
+func TestFileTimeRoundtrip(t *testing.T) {
+	want := time.Date(2026, 10, 19, 12, 30, 15, 123456700, time.UTC)
+	if got := FileTimeToTime(TimeToFileTime(want)); !got.Equal(want) {
+		t.Fatalf("roundtrip = %v want %v", got, want)
+	}
+	if !FileTimeToTime(0).IsZero() || TimeToFileTime(time.Time{}) != 0 {
+		t.Fatal("zero FILETIME should map to zero time")
+	}
+}
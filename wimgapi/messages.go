@@ -0,0 +1,54 @@
+package wimgapi
+
+const (
+	winMessageApp = 0x8000
+
+	WIMMessageBase     = winMessageApp + 0x1476
+	WIMMessageText     = WIMMessageBase + 1  //38007
+	WIMMessageProgress = WIMMessageBase + 2  //38008
+	WIMMessageProcess  = WIMMessageBase + 3  //38009
+	WIMMessageScanning = WIMMessageBase + 4  //38010
+	WIMMessageSetRange = WIMMessageBase + 5  //38011 将要 captured/applied 的文件总数
+	WIMMessageSetPos   = WIMMessageBase + 6  //38012 已经 captured/applied 的文件数量
+	WIMMessageStepIt   = WIMMessageBase + 7  //38013 有一个文件被 captured/applied
+	WIMMessageCompress = WIMMessageBase + 8  //38014
+	WIMMessageError    = WIMMessageBase + 9  //38015
+	WIMMessageAlign    = WIMMessageBase + 10 //38016
+	WIMMessageRetry    = WIMMessageBase + 11 //38017
+	WIMMessageSplit    = WIMMessageBase + 12 //38018
+	WIMMessageFileInfo = WIMMessageBase + 13 //38019
+	WIMMessageInfo     = WIMMessageBase + 14 //38020
+	WIMMessageWarning  = WIMMessageBase + 15 //38021
+	WIMMessageChkProc  = WIMMessageBase + 16 //38022
+
+	WIMMessageDone          = 0xFFFFFFF0
+	WIMInvalidCallbackValue = 0xFFFFFFFF
+	WIMCallbackAbortResult  = 0xFFFFFFFF
+	WIMCallbackSuccess      = 0
+)
+
+type ProgressEvent struct {
+	MessageID uint32
+	WParam    uintptr
+	LParam    uintptr
+	// Coalesced counts further SET_POS/STEP_IT messages folded into this one
+	// while the asynchronous progress queue was full. A coalesced STEP_IT
+	// stands for 1+Coalesced steps; a SET_POS already carries the final position.
+	Coalesced uint32
+	// Path is the file WParam points to for PROCESS, FILE_INFO, ERROR,
+	// WARNING and RETRY messages, copied while the callback runs so it stays
+	// valid after it returns.
+	Path string
+}
+
+type ProgressFunc func(evt ProgressEvent) (cancel bool)
+
+// messageHasPath reports whether WParam of id points to a NUL-terminated
+// UTF-16 file path.
+func messageHasPath(id uint32) bool {
+	switch id {
+	case WIMMessageProcess, WIMMessageFileInfo, WIMMessageError, WIMMessageWarning, WIMMessageRetry:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package wimgapi
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// progressQueue decouples WIMGAPI worker threads from the user ProgressFunc.
+// Events are buffered up to limit and delivered in order by a single goroutine.
+// When the buffer is full, SET_POS and STEP_IT are merged into the most recent
+// pending position update, SET_RANGE/ERROR/WARNING/DONE are always queued, and
+// any other message is dropped. Pointer-valued parameters are cleared before
+// an event is queued because they only live until the callback returns.
+type progressQueue struct {
+	fn     ProgressFunc
+	limit  int
+	mu     sync.Mutex
+	events []ProgressEvent
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+	cancel atomic.Bool
+}
+
+func newProgressQueue(fn ProgressFunc, limit int) *progressQueue {
+	q := &progressQueue{
+		fn:     fn,
+		limit:  limit,
+		events: make([]ProgressEvent, 0, limit),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// push enqueues evt without blocking and reports whether the consumer has
+// requested cancellation.
+func (q *progressQueue) push(evt ProgressEvent) (cancel bool) {
+	clearPointerParams(&evt)
+	q.mu.Lock()
+	if !q.closed {
+		q.enqueueLocked(evt)
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return q.cancel.Load()
+}
+
+func (q *progressQueue) enqueueLocked(evt ProgressEvent) {
+	if len(q.events) < q.limit || isCriticalMessage(evt.MessageID) {
+		q.events = append(q.events, evt)
+		return
+	}
+	if !isCoalescibleMessage(evt.MessageID) {
+		return
+	}
+	for i := len(q.events) - 1; i >= 0; i-- {
+		pending := &q.events[i]
+		if isCoalescibleMessage(pending.MessageID) {
+			coalesceProgress(pending, evt)
+			return
+		}
+		if isCriticalMessage(pending.MessageID) {
+			break
+		}
+	}
+	// Nothing to merge with since the last critical message; allow one slot
+	// past the limit so no step is lost.
+	q.events = append(q.events, evt)
+}
+
+func coalesceProgress(pending *ProgressEvent, evt ProgressEvent) {
+	merged := pending.Coalesced + 1 + evt.Coalesced
+	switch {
+	case evt.MessageID == WIMMessageSetPos:
+		// Positions are absolute, so the newest one supersedes pending steps.
+		*pending = evt
+	case pending.MessageID == WIMMessageSetPos:
+		pending.WParam += uintptr(1 + evt.Coalesced)
+	}
+	pending.Coalesced = merged
+}
+
+// close stops accepting events and waits until everything queued so far has
+// been delivered.
+func (q *progressQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.done
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	<-q.done
+}
+
+func (q *progressQueue) run() {
+	defer close(q.done)
+
+	var batch []ProgressEvent
+	for {
+		<-q.wake
+
+		for {
+			q.mu.Lock()
+			batch, q.events = q.events, batch[:0]
+			closed := q.closed
+			q.mu.Unlock()
+
+			if len(batch) == 0 {
+				if closed {
+					return
+				}
+				break
+			}
+			for _, evt := range batch {
+				if q.fn(evt) {
+					q.cancel.Store(true)
+				}
+			}
+		}
+	}
+}
+
+// clearPointerParams zeroes the WParam/LParam values that point into
+// WIMGAPI's memory: file paths, strings and the in/out values of PROCESS,
+// COMPRESS, ALIGN, SPLIT, FILE_INFO, INFO and CHK_PROCESS.
+func clearPointerParams(evt *ProgressEvent) {
+	switch evt.MessageID {
+	case WIMMessageText, WIMMessageError, WIMMessageWarning, WIMMessageRetry:
+		evt.WParam = 0
+	case WIMMessageProcess, WIMMessageCompress, WIMMessageAlign, WIMMessageSplit,
+		WIMMessageFileInfo, WIMMessageInfo, WIMMessageChkProc:
+		evt.WParam, evt.LParam = 0, 0
+	}
+}
+
+func isCriticalMessage(id uint32) bool {
+	switch id {
+	case WIMMessageSetRange, WIMMessageError, WIMMessageWarning, WIMMessageDone:
+		return true
+	default:
+		return false
+	}
+}
+
+func isCoalescibleMessage(id uint32) bool {
+	return id == WIMMessageSetPos || id == WIMMessageStepIt
+}
@@ -0,0 +1,86 @@
+package wimgapi
+
+import "testing"
+
+func TestProgressQueueCoalescesWhileConsumerIsBlocked(t *testing.T) {
+	release := make(chan struct{})
+	var got []ProgressEvent
+	q := newProgressQueue(func(evt ProgressEvent) bool {
+		if len(got) == 0 {
+			<-release
+		}
+		got = append(got, evt)
+		return false
+	}, 4)
+
+	q.push(ProgressEvent{MessageID: WIMMessageSetRange, LParam: 100})
+	for i := 1; i <= 100; i++ {
+		q.push(ProgressEvent{MessageID: WIMMessageStepIt})
+		q.push(ProgressEvent{MessageID: WIMMessageSetPos, WParam: uintptr(i)})
+		q.push(ProgressEvent{MessageID: WIMMessageText})
+	}
+	q.push(ProgressEvent{MessageID: WIMMessageWarning, WParam: 5})
+	q.push(ProgressEvent{MessageID: WIMMessageDone})
+	close(release)
+	q.close()
+
+	d := NewProgressDecoder()
+	var last DecodedProgressEvent
+	var warnings, done int
+	for _, evt := range got {
+		last = d.Decode(evt)
+		switch evt.MessageID {
+		case WIMMessageWarning:
+			warnings++
+		case WIMMessageDone:
+			done++
+		}
+	}
+	if len(got) > 10 {
+		t.Fatalf("delivered %d events, want coalesced delivery", len(got))
+	}
+	if warnings != 1 || done != 1 {
+		t.Fatalf("warnings=%d done=%d want 1/1", warnings, done)
+	}
+	if last.Current != 100 || last.Total != 100 {
+		t.Fatalf("final state current=%d total=%d want 100/100", last.Current, last.Total)
+	}
+}
+
+func TestProgressQueueCancel(t *testing.T) {
+	q := newProgressQueue(func(evt ProgressEvent) bool {
+		return evt.MessageID == WIMMessageError
+	}, 8)
+
+	q.push(ProgressEvent{MessageID: WIMMessageError})
+	q.close()
+	if !q.push(ProgressEvent{MessageID: WIMMessageStepIt}) {
+		t.Fatal("push after consumer cancelled should report cancel")
+	}
+}
+
+func TestProgressQueueClearsPointerParams(t *testing.T) {
+	var got []ProgressEvent
+	q := newProgressQueue(func(evt ProgressEvent) bool {
+		got = append(got, evt)
+		return false
+	}, 8)
+
+	q.push(ProgressEvent{MessageID: WIMMessageProcess, WParam: 0x1000, LParam: 0x2000})
+	q.push(ProgressEvent{MessageID: WIMMessageError, WParam: 0x1000, LParam: 5})
+	q.push(ProgressEvent{MessageID: WIMMessageSetRange, WParam: 0, LParam: 100})
+	q.close()
+
+	if len(got) != 3 {
+		t.Fatalf("delivered %d events, want 3", len(got))
+	}
+	if got[0].WParam != 0 || got[0].LParam != 0 {
+		t.Fatalf("PROCESS params = %#x/%#x, want zero", got[0].WParam, got[0].LParam)
+	}
+	if got[1].WParam != 0 || got[1].LParam != 5 {
+		t.Fatalf("ERROR params = %#x/%d, want 0/5", got[1].WParam, got[1].LParam)
+	}
+	if got[2].LParam != 100 {
+		t.Fatalf("SET_RANGE LParam = %d, want 100", got[2].LParam)
+	}
+}
@@ -0,0 +1,100 @@
+package wimgapi
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// loadTrace replays a trace from testdata. The traces there are synthetic:
+// written by hand in the TraceRecorder format to exercise the decoder and
+// tracker, not recorded from wimgapi.dll. They make no claim about the
+// order or parameters of the messages WIMGAPI actually sends.
+func loadTrace(t *testing.T, name string) *TraceReplayer {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := ReadTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTraceReplayer(records)
+}
+
+func TestReplayApplyTrace(t *testing.T) {
+	p := loadTrace(t, "apply.jsonl")
+	d := NewProgressDecoder()
+	tr := NewProgressTracker(TrackerOptions{Now: p.Now})
+
+	var ev DecodedProgressEvent
+	var noisy int
+	var paths []string
+	p.Run(func(evt ProgressEvent) bool {
+		ev = d.Decode(evt)
+		if ev.Noisy {
+			noisy++
+			paths = append(paths, evt.Path)
+		}
+		tr.Update(evt)
+		return false
+	})
+	if ev.Name != "DONE" || ev.Summary != "DONE" {
+		t.Fatalf("last event name=%q summary=%q", ev.Name, ev.Summary)
+	}
+	if ev.Current != 3 || ev.Total != 3 {
+		t.Fatalf("final state current=%d total=%d want 3/3", ev.Current, ev.Total)
+	}
+	if noisy != 3 || paths[2] != `C:\restore\nested\level1\big-random.bin` {
+		t.Fatalf("noisy=%d paths=%q want 3 PROCESS events", noisy, paths)
+	}
+	if s := tr.Snapshot(); s.Phase != PhaseDone || s.FilesDone != 3 || s.Elapsed != 3*time.Second {
+		t.Fatalf("tracker snapshot = %+v", s)
+	}
+}
+
+func TestReplayCaptureTrace(t *testing.T) {
+	p := loadTrace(t, "capture.jsonl")
+	d := NewProgressDecoder()
+	tr := NewProgressTracker(TrackerOptions{Now: p.Now})
+
+	var summaries []string
+	p.Run(func(evt ProgressEvent) bool {
+		ev := d.Decode(evt)
+		if !ev.Noisy {
+			summaries = append(summaries, ev.Summary)
+		}
+		if s := tr.Update(evt); evt.MessageID == WIMMessageScanning && s.Phase != PhaseScanning {
+			t.Fatalf("phase during scan = %v", s.Phase)
+		}
+		return false
+	})
+
+	want := []string{
+		"SCANNING: wParam=1 lParam=0",
+		"SCANNING: wParam=4 lParam=0",
+		"SET_RANGE: 0/4 (0.0%)",
+		"STEP_IT: 1/4 (25.0%)",
+		"STEP_IT: 2/4 (50.0%)",
+		"WARNING: code=32",
+		"STEP_IT: 3/4 (75.0%)",
+		"STEP_IT: 4/4 (100.0%)",
+		"SET_POS: 4/4 (100.0%)",
+		"DONE",
+	}
+	if len(summaries) != len(want) {
+		t.Fatalf("got %d summaries, want %d: %q", len(summaries), len(want), summaries)
+	}
+	for i := range want {
+		if summaries[i] != want[i] {
+			t.Fatalf("summary[%d]=%q want %q", i, summaries[i], want[i])
+		}
+	}
+	if s := tr.Snapshot(); s.Phase != PhaseDone || s.Warnings != 1 || s.FilesScanned != 4 {
+		t.Fatalf("tracker snapshot = %+v", s)
+	}
+}
@@ -0,0 +1,141 @@
+package wimgapi
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// TraceRecord is one line of a JSON Lines progress trace.
+type TraceRecord struct {
+	Time      time.Time `json:"time"`
+	MessageID uint32    `json:"msg"`
+	Name      string    `json:"name,omitempty"`
+	WParam    uint64    `json:"wparam"`
+	LParam    uint64    `json:"lparam"`
+	Coalesced uint32    `json:"coalesced,omitempty"`
+	Path      string    `json:"path,omitempty"`
+}
+
+func (r TraceRecord) Event() ProgressEvent {
+	return ProgressEvent{
+		MessageID: r.MessageID,
+		WParam:    uintptr(r.WParam),
+		LParam:    uintptr(r.LParam),
+		Coalesced: r.Coalesced,
+		Path:      r.Path,
+	}
+}
+
+// TraceRecorder writes progress events to w as JSON Lines. It is safe for
+// concurrent use.
+type TraceRecorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+	err error
+}
+
+func NewTraceRecorder(w io.Writer) *TraceRecorder {
+	return &TraceRecorder{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Record appends evt to the trace. After the first write error every call
+// returns that error.
+func (r *TraceRecorder) Record(evt ProgressEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.enc.Encode(TraceRecord{
+		Time:      r.now(),
+		MessageID: evt.MessageID,
+		Name:      messageName(evt.MessageID),
+		WParam:    uint64(evt.WParam),
+		LParam:    uint64(evt.LParam),
+		Coalesced: evt.Coalesced,
+		Path:      evt.Path,
+	})
+	return r.err
+}
+
+// Wrap returns a ProgressFunc that records every event before passing it to
+// next. next may be nil. Recording errors are reported by Err.
+func (r *TraceRecorder) Wrap(next ProgressFunc) ProgressFunc {
+	return func(evt ProgressEvent) bool {
+		_ = r.Record(evt)
+		if next == nil {
+			return false
+		}
+		return next(evt)
+	}
+}
+
+func (r *TraceRecorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// ReadTrace parses a JSON Lines trace written by TraceRecorder. Blank lines
+// are skipped.
+func ReadTrace(r io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec TraceRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// TraceReplayer feeds recorded events into a ProgressFunc. While an event is
+// being delivered Now reports its recorded time, so it can drive
+// TrackerOptions.Now and reproduce the original timing.
+type TraceReplayer struct {
+	records []TraceRecord
+	mu      sync.Mutex
+	now     time.Time
+}
+
+func NewTraceReplayer(records []TraceRecord) *TraceReplayer {
+	p := &TraceReplayer{records: records}
+	if len(records) > 0 {
+		p.now = records[0].Time
+	}
+	return p
+}
+
+func (p *TraceReplayer) Now() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now
+}
+
+// Run delivers every record in order and stops early if fn asks to cancel.
+func (p *TraceReplayer) Run(fn ProgressFunc) (cancelled bool) {
+	for _, rec := range p.records {
+		p.mu.Lock()
+		p.now = rec.Time
+		p.mu.Unlock()
+		if fn(rec.Event()) {
+			return true
+		}
+	}
+	return false
+}
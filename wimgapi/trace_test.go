@@ -0,0 +1,77 @@
+package wimgapi
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTraceRecordReplayRoundtrip(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewTraceRecorder(&buf)
+	clk := &fakeClock{t: time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)}
+	rec.now = clk.Now
+
+	in := []ProgressEvent{
+		{MessageID: WIMMessageSetRange, LParam: 2},
+		{MessageID: WIMMessageProcess, WParam: 0x1234, Path: `C:\img\a.txt`},
+		{MessageID: WIMMessageStepIt, Coalesced: 1},
+		{MessageID: WIMMessageDone},
+	}
+	var forwarded int
+	fn := rec.Wrap(func(ProgressEvent) bool {
+		forwarded++
+		return false
+	})
+	for _, evt := range in {
+		fn(evt)
+		clk.Advance(time.Second)
+	}
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded != len(in) {
+		t.Fatalf("forwarded=%d want %d", forwarded, len(in))
+	}
+
+	records, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(in) || records[3].Name != "DONE" {
+		t.Fatalf("records = %+v", records)
+	}
+
+	p := NewTraceReplayer(records)
+	var out []ProgressEvent
+	var times []time.Time
+	p.Run(func(evt ProgressEvent) bool {
+		out = append(out, evt)
+		times = append(times, p.Now())
+		return false
+	})
+	for i := range in {
+		if out[i] != in[i] {
+			t.Fatalf("event %d = %+v want %+v", i, out[i], in[i])
+		}
+	}
+	if got := times[3].Sub(times[0]); got != 3*time.Second {
+		t.Fatalf("replayed span = %v want 3s", got)
+	}
+}
+
+func TestTraceReplayerStopsOnCancel(t *testing.T) {
+	p := NewTraceReplayer([]TraceRecord{
+		{MessageID: WIMMessageStepIt},
+		{MessageID: WIMMessageStepIt},
+		{MessageID: WIMMessageDone},
+	})
+	var n int
+	cancelled := p.Run(func(ProgressEvent) bool {
+		n++
+		return n == 2
+	})
+	if !cancelled || n != 2 {
+		t.Fatalf("cancelled=%v delivered=%d want true/2", cancelled, n)
+	}
+}
@@ -0,0 +1,229 @@
+package wimgapi
+
+import (
+	"sync"
+	"time"
+)
+
+type Phase int
+
+const (
+	PhaseIdle Phase = iota
+	PhaseScanning
+	PhaseProcessing
+	PhaseWritingMetadata
+	PhaseVerifying
+	PhaseDone
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseIdle:
+		return "idle"
+	case PhaseScanning:
+		return "scanning"
+	case PhaseProcessing:
+		return "processing"
+	case PhaseWritingMetadata:
+		return "writing metadata"
+	case PhaseVerifying:
+		return "verifying"
+	case PhaseDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	defaultTrackerSmoothing = 0.3
+	trackerSampleInterval   = 500 * time.Millisecond
+)
+
+type TrackerOptions struct {
+	// Now returns the current time; tests can supply a fake clock.
+	// Defaults to time.Now.
+	Now func() time.Time
+	// TotalBytes is the expected payload size (for example TOTALBYTES from the
+	// image XML). When set, BytesDone and BytesPerSec are derived from progress.
+	TotalBytes uint64
+	// Smoothing is the weight of the newest rate sample in the exponential
+	// moving average, in (0, 1]. Defaults to 0.3.
+	Smoothing float64
+}
+
+type ProgressSnapshot struct {
+	Phase        Phase
+	Elapsed      time.Duration
+	PhaseElapsed time.Duration
+	FilesScanned uint64
+	FilesDone    uint64
+	FilesTotal   uint64
+	Percent      float64
+	BytesDone    uint64
+	BytesTotal   uint64
+	BytesPerSec  float64
+	// ETA is the smoothed estimate of the time left in the current phase.
+	// It falls back to NativeETA until enough samples have been taken.
+	ETA time.Duration
+	// NativeETA is the remaining time last reported by WIM_MSG_PROGRESS.
+	NativeETA time.Duration
+	Errors    int
+	Warnings  int
+}
+
+// ProgressTracker folds raw WIMGAPI messages into phases, throughput and ETA.
+type ProgressTracker struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	smoothing float64
+	decoder   *ProgressDecoder
+
+	snap         ProgressSnapshot
+	started      time.Time
+	phaseStarted time.Time
+	nativePct    bool
+
+	sampleAt   time.Time
+	sampleFrac float64
+	rate       float64 // fraction of the phase per second
+}
+
+func NewProgressTracker(opts TrackerOptions) *ProgressTracker {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Smoothing <= 0 || opts.Smoothing > 1 {
+		opts.Smoothing = defaultTrackerSmoothing
+	}
+	now := opts.Now()
+	return &ProgressTracker{
+		now:          opts.Now,
+		smoothing:    opts.Smoothing,
+		decoder:      NewProgressDecoder(),
+		snap:         ProgressSnapshot{BytesTotal: opts.TotalBytes},
+		started:      now,
+		phaseStarted: now,
+		sampleAt:     now,
+	}
+}
+
+// Update records evt and returns the resulting snapshot. It can be called
+// directly from a ProgressFunc.
+func (t *ProgressTracker) Update(evt ProgressEvent) ProgressSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if evt.MessageID == WIMMessageSetRange && t.snap.Phase >= PhaseWritingMetadata {
+		// A second range after all files were processed is the verify pass;
+		// count it from zero.
+		t.decoder = NewProgressDecoder()
+		t.enterPhase(PhaseVerifying, now)
+	}
+	decoded := t.decoder.Decode(evt)
+
+	switch evt.MessageID {
+	case WIMMessageScanning:
+		t.enterPhase(PhaseScanning, now)
+		t.snap.FilesScanned = uint64(evt.WParam)
+	case WIMMessageSetRange, WIMMessageSetPos, WIMMessageStepIt:
+		if t.snap.Phase < PhaseProcessing {
+			t.enterPhase(PhaseProcessing, now)
+		}
+	case WIMMessageProgress:
+		if t.snap.Phase < PhaseProcessing {
+			t.enterPhase(PhaseProcessing, now)
+		}
+		// WParam is percent complete, LParam the estimated milliseconds left.
+		t.nativePct = true
+		t.snap.Percent = min(float64(evt.WParam), 100)
+		t.snap.NativeETA = time.Duration(evt.LParam) * time.Millisecond
+	case WIMMessageChkProc:
+		t.enterPhase(PhaseVerifying, now)
+	case WIMMessageError:
+		t.snap.Errors++
+	case WIMMessageWarning:
+		t.snap.Warnings++
+	case WIMMessageDone:
+		t.enterPhase(PhaseDone, now)
+	}
+
+	t.snap.FilesDone = decoded.Current
+	t.snap.FilesTotal = decoded.Total
+	if !t.nativePct {
+		t.snap.Percent = decoded.Percent
+	}
+	if t.snap.Phase == PhaseDone {
+		t.snap.Percent = 100
+	}
+	if t.snap.Phase == PhaseProcessing && t.snap.Percent >= 100 {
+		t.enterPhase(PhaseWritingMetadata, now)
+	}
+
+	t.sample(now)
+	return t.snapshotLocked(now)
+}
+
+// Snapshot returns the current state without recording a new event.
+func (t *ProgressTracker) Snapshot() ProgressSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshotLocked(t.now())
+}
+
+func (t *ProgressTracker) enterPhase(p Phase, now time.Time) {
+	if t.snap.Phase == p {
+		return
+	}
+	t.snap.Phase = p
+	t.phaseStarted = now
+	t.nativePct = false
+	t.snap.NativeETA = 0
+	t.sampleAt = now
+	t.sampleFrac = 0
+	t.rate = 0
+}
+
+func (t *ProgressTracker) sample(now time.Time) {
+	dt := now.Sub(t.sampleAt)
+	if dt < trackerSampleInterval {
+		return
+	}
+	frac := t.snap.Percent / 100
+	r := (frac - t.sampleFrac) / dt.Seconds()
+	if r < 0 {
+		r = 0
+	}
+	if t.rate == 0 {
+		t.rate = r
+	} else {
+		t.rate = t.smoothing*r + (1-t.smoothing)*t.rate
+	}
+	t.sampleAt = now
+	t.sampleFrac = frac
+}
+
+func (t *ProgressTracker) snapshotLocked(now time.Time) ProgressSnapshot {
+	out := t.snap
+	out.Elapsed = now.Sub(t.started)
+	out.PhaseElapsed = now.Sub(t.phaseStarted)
+
+	frac := out.Percent / 100
+	if out.BytesTotal > 0 && out.Phase == PhaseProcessing {
+		out.BytesDone = uint64(frac * float64(out.BytesTotal))
+		out.BytesPerSec = t.rate * float64(out.BytesTotal)
+	} else if out.BytesTotal > 0 && out.Phase > PhaseProcessing {
+		out.BytesDone = out.BytesTotal
+	}
+
+	switch {
+	case out.Phase == PhaseDone:
+		out.ETA = 0
+	case t.rate > 0:
+		out.ETA = time.Duration((1 - frac) / t.rate * float64(time.Second))
+	default:
+		out.ETA = out.NativeETA
+	}
+	return out
+}
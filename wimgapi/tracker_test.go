@@ -0,0 +1,82 @@
+package wimgapi
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.t }
+
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestProgressTrackerPhases(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr := NewProgressTracker(TrackerOptions{Now: clk.Now})
+
+	s := tr.Update(ProgressEvent{MessageID: WIMMessageScanning, WParam: 7})
+	if s.Phase != PhaseScanning || s.FilesScanned != 7 {
+		t.Fatalf("scanning snapshot = %+v", s)
+	}
+
+	s = tr.Update(ProgressEvent{MessageID: WIMMessageSetRange, LParam: 4})
+	if s.Phase != PhaseProcessing || s.FilesTotal != 4 {
+		t.Fatalf("after SET_RANGE phase=%v total=%d", s.Phase, s.FilesTotal)
+	}
+
+	for i := 0; i < 4; i++ {
+		s = tr.Update(ProgressEvent{MessageID: WIMMessageStepIt})
+	}
+	if s.Phase != PhaseWritingMetadata {
+		t.Fatalf("after all steps phase=%v want %v", s.Phase, PhaseWritingMetadata)
+	}
+
+	s = tr.Update(ProgressEvent{MessageID: WIMMessageSetRange, LParam: 2})
+	if s.Phase != PhaseVerifying || s.FilesDone != 0 || s.FilesTotal != 2 {
+		t.Fatalf("verify snapshot = %+v", s)
+	}
+
+	tr.Update(ProgressEvent{MessageID: WIMMessageWarning, LParam: 32})
+	s = tr.Update(ProgressEvent{MessageID: WIMMessageDone})
+	if s.Phase != PhaseDone || s.Percent != 100 || s.Warnings != 1 || s.ETA != 0 {
+		t.Fatalf("done snapshot = %+v", s)
+	}
+}
+
+func TestProgressTrackerThroughputAndETA(t *testing.T) {
+	clk := &fakeClock{t: time.Unix(1700000000, 0)}
+	tr := NewProgressTracker(TrackerOptions{Now: clk.Now, TotalBytes: 1000})
+
+	s := tr.Update(ProgressEvent{MessageID: WIMMessageProgress, WParam: 0, LParam: 60000})
+	if s.ETA != time.Minute {
+		t.Fatalf("initial ETA=%v want native 1m", s.ETA)
+	}
+
+	// 10% per second is 100 bytes/s, leaving 5s at 50%.
+	for pct := uintptr(10); pct <= 50; pct += 10 {
+		clk.Advance(time.Second)
+		s = tr.Update(ProgressEvent{MessageID: WIMMessageProgress, WParam: pct, LParam: 1})
+	}
+	if s.BytesDone != 500 {
+		t.Fatalf("BytesDone=%d want 500", s.BytesDone)
+	}
+	if s.BytesPerSec < 99 || s.BytesPerSec > 101 {
+		t.Fatalf("BytesPerSec=%f want ~100", s.BytesPerSec)
+	}
+	if s.ETA < 4900*time.Millisecond || s.ETA > 5100*time.Millisecond {
+		t.Fatalf("ETA=%v want ~5s", s.ETA)
+	}
+
+	// A single stall is smoothed rather than dropping the rate to zero.
+	clk.Advance(time.Second)
+	s = tr.Update(ProgressEvent{MessageID: WIMMessageProgress, WParam: 50})
+	if s.BytesPerSec < 60 || s.BytesPerSec > 80 {
+		t.Fatalf("BytesPerSec after stall=%f want ~70", s.BytesPerSec)
+	}
+	if s.Elapsed != 6*time.Second {
+		t.Fatalf("Elapsed=%v want 6s", s.Elapsed)
+	}
+}
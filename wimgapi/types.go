@@ -9,62 +9,49 @@ import (
 )
 
 const (
-	winMessageApp = 0x8000
-
 	WIMCreateNew    = 1
 	WIMCreateAlways = 2
 	WIMOpenExisting = 3
 	WIMOpenAlways   = 4
-
-	WIMMessageBase     = winMessageApp + 0x1476
-	WIMMessageText     = WIMMessageBase + 1  //38007
-	WIMMessageProgress = WIMMessageBase + 2  //38008
-	WIMMessageProcess  = WIMMessageBase + 3  //38009
-	WIMMessageScanning = WIMMessageBase + 4  //38010
-	WIMMessageSetRange = WIMMessageBase + 5  //38011 将要 captured/applied 的文件总数
-	WIMMessageSetPos   = WIMMessageBase + 6  //38012 已经 captured/applied 的文件数量
-	WIMMessageStepIt   = WIMMessageBase + 7  //38013 有一个文件被 captured/applied
-	WIMMessageCompress = WIMMessageBase + 8  //38014
-	WIMMessageError    = WIMMessageBase + 9  //38015
-	WIMMessageAlign    = WIMMessageBase + 10 //38016
-	WIMMessageRetry    = WIMMessageBase + 11 //38017
-	WIMMessageSplit    = WIMMessageBase + 12 //38018
-	WIMMessageFileInfo = WIMMessageBase + 13 //38019
-	WIMMessageInfo     = WIMMessageBase + 14 //38020
-	WIMMessageWarning  = WIMMessageBase + 15 //38021
-	WIMMessageChkProc  = WIMMessageBase + 16 //38022
-
-	WIMMessageDone          = 0xFFFFFFF0
-	WIMInvalidCallbackValue = 0xFFFFFFFF
-	WIMCallbackAbortResult  = 0xFFFFFFFF
-	WIMCallbackSuccess      = 0
 )
 
 type OpenOptions struct {
 	DesiredAccess       uint32
 	CreationDisposition uint32
-	FlagsAndAttributes  uint32
-	CompressionType     uint32
+	FlagsAndAttributes  OpenFlags
+	// CompressionType applies when the WIM is created; WIMGAPI always
+	// uses its default chunk size.
+	CompressionType Compression
 }
 
 type ApplyOptions struct {
-	Flags    uint32
+	Flags    ApplyFlags
 	Progress ProgressFunc
+	// ProgressBuffer, when > 0, delivers Progress from a separate goroutine
+	// through a queue of this many events so a slow consumer never blocks
+	// WIMGAPI. See ProgressEvent.Coalesced. Queued events have their
+	// pointer-valued WParam/LParam zeroed, a buffered consumer cannot answer
+	// WIM_MSG_PROCESS, and while the queue is full only SET_RANGE, ERROR,
+	// WARNING and DONE are kept; positions are merged and the rest dropped.
+	ProgressBuffer int
 }
 
 type CaptureOptions struct {
-	Flags    uint32
+	Flags CaptureFlags
+	// Config, when set, leaves out the files its exclusion lists match by
+	// answering WIM_MSG_PROCESS. WIMGAPI has no per-file compression
+	// setting, so CompressionExclusionList is not applied.
+	Config   *CaptureConfig
 	Progress ProgressFunc
+	// ProgressBuffer, when > 0, delivers Progress from a separate goroutine
+	// through a queue of this many events so a slow consumer never blocks
+	// WIMGAPI. See ProgressEvent.Coalesced. Queued events have their
+	// pointer-valued WParam/LParam zeroed, a buffered consumer cannot answer
+	// WIM_MSG_PROCESS, and while the queue is full only SET_RANGE, ERROR,
+	// WARNING and DONE are kept; positions are merged and the rest dropped.
+	ProgressBuffer int
 }
 
-type ProgressEvent struct {
-	MessageID uint32
-	WParam    uintptr
-	LParam    uintptr
-}
-
-type ProgressFunc func(evt ProgressEvent) (cancel bool)
-
 type File struct {
 	handle windows.Handle
 	mu     sync.Mutex
@@ -78,12 +65,29 @@ type Image struct {
 	closed     bool
 }
 
-type ImageInfo struct {
-	Index        int
-	Name         string
-	Description  string
-	Flags        string
-	Architecture string
+// WIMInfo mirrors WIM_INFO as returned by WIMGetAttributes.
+type WIMInfo struct {
+	Path               string
+	GUID               windows.GUID
+	ImageCount         int
+	CompressionType    Compression
+	PartNumber         uint16
+	TotalParts         uint16
+	BootIndex          int
+	Attributes         uint32
+	FlagsAndAttributes uint32
+}
+
+type wimInfoRaw struct {
+	WimPath         [windows.MAX_PATH]uint16
+	Guid            windows.GUID
+	ImageCount      uint32
+	CompressionType uint32
+	PartNumber      uint16
+	TotalParts      uint16
+	BootIndex       uint32
+	WimAttributes   uint32
+	WimFlagsAndAttr uint32
 }
 
 func normalizeOpenOptions(opts OpenOptions) OpenOptions {